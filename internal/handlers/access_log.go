@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var infraEnvIDRegexp = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// accessLogWriter records the status and size of a response
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessLogWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying connection
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// WithAccessLog logs a line for each completed request with the method, path,
+// infra-env, status, bytes written and duration. The line is logged at debug
+// level and nothing is recorded when that level is disabled.
+func WithAccessLog(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !log.IsLevelEnabled(log.DebugLevel) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		lw := &accessLogWriter{ResponseWriter: w}
+		handler.ServeHTTP(lw, r)
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+
+		path, infraEnvID := accessLogPath(r.URL.Path)
+		log.WithFields(log.Fields{
+			"method":       r.Method,
+			"path":         path,
+			"infra_env_id": infraEnvID,
+			"status":       lw.status,
+			"bytes":        lw.bytes,
+			"duration_ms":  time.Since(start).Milliseconds(),
+		}).Debug("Request completed")
+	})
+}
+
+// accessLogPath returns the path with any token it contains redacted, and the
+// infra-env ID the path refers to if it can be found
+func accessLogPath(path string) (string, string) {
+	segments := strings.Split(path, "/")
+	if len(segments) > 2 && (segments[1] == "byapikey" || segments[1] == "bytoken") {
+		infraEnvID, err := idFromJWT(segments[2])
+		if err != nil {
+			infraEnvID = ""
+		}
+		segments[2] = "REDACTED"
+		return strings.Join(segments, "/"), infraEnvID
+	}
+	return path, infraEnvIDRegexp.FindString(path)
+}
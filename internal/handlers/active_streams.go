@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kinds of streams counted by ActiveStreams
+const (
+	streamKindImage        = "image"
+	streamKindBootArtifact = "boot-artifact"
+)
+
+// ActiveStreams counts the image and boot artifact downloads being streamed, so instances can
+// be left to drain before they are stopped
+type ActiveStreams struct {
+	streams *prometheus.GaugeVec
+}
+
+// NewActiveStreams creates the active streams gauge, registered with reg when it is not nil
+func NewActiveStreams(reg prometheus.Registerer) (*ActiveStreams, error) {
+	s := &ActiveStreams{
+		streams: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "assisted_image_service_active_streams",
+			Help: "Number of image and boot artifact downloads being streamed",
+		}, []string{"kind"}),
+	}
+	// report both kinds from the start rather than once the first download of each happens
+	s.streams.WithLabelValues(streamKindImage)
+	s.streams.WithLabelValues(streamKindBootArtifact)
+	if reg != nil {
+		if err := reg.Register(s.streams); err != nil {
+			return nil, fmt.Errorf("failed to register active streams metrics: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// WithActiveStreams counts the image downloads being streamed
+func WithActiveStreams(streams *ActiveStreams) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.activeStreams = streams
+	}
+}
+
+// start counts a stream of kind until the returned function is called
+func (s *ActiveStreams) start(kind string) func() {
+	if s == nil {
+		return func() {}
+	}
+	gauge := s.streams.WithLabelValues(kind)
+	gauge.Inc()
+	return gauge.Dec
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+)
+
+var _ = Describe("ActiveStreams", func() {
+	var (
+		reg            *prometheus.Registry
+		streams        *ActiveStreams
+		mockImageStore *imagestore.MockImageStore
+		server         *httptest.Server
+		imageFile      string
+		imageID        = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+	)
+
+	// activeStreams returns the number of active streams of each kind
+	activeStreams := func() map[string]float64 {
+		counts := map[string]float64{}
+		families, err := reg.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() != "assisted_image_service_active_streams" {
+				continue
+			}
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "kind" {
+						counts[label.GetValue()] = metric.GetGauge().GetValue()
+					}
+				}
+			}
+		}
+		return counts
+	}
+
+	// download starts downloading path without reading the body, which is far larger than
+	// the connection buffers so the stream stays active until it's read
+	download := func(path string) *http.Response {
+		resp, err := server.Client().Get(server.URL + path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		return resp
+	}
+
+	finish := func(resp *http.Response) {
+		n, err := io.Copy(io.Discard, resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(int64(64 * 1024 * 1024)))
+		Expect(resp.Body.Close()).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		reg = prometheus.NewRegistry()
+		var err error
+		streams, err = NewActiveStreams(reg)
+		Expect(err).NotTo(HaveOccurred())
+
+		mockImageStore = imagestore.NewMockImageStore(gomock.NewController(GinkgoT()))
+
+		f, err := os.CreateTemp("", "active_streams")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Truncate(64 * 1024 * 1024)).To(Succeed())
+		Expect(f.Close()).To(Succeed())
+		imageFile = f.Name()
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.Remove(imageFile)
+	})
+
+	It("reports no active streams initially", func() {
+		server = httptest.NewServer(http.NotFoundHandler())
+		Expect(activeStreams()).To(Equal(map[string]float64{streamKindImage: 0, streamKindBootArtifact: 0}))
+	})
+
+	It("counts the images being streamed", func() {
+		assistedServer := ghttp.NewServer()
+		defer assistedServer.Close()
+		assistedServer.RouteToHandler("GET", fmt.Sprintf(fileRouteFormat, imageID), ghttp.RespondWith(http.StatusOK, ""))
+		assistedServer.RouteToHandler("GET", fmt.Sprintf(infraEnvPathFormat, imageID), ghttp.RespondWith(http.StatusOK, "{}"))
+		u, err := url.Parse(assistedServer.URL())
+		Expect(err).NotTo(HaveOccurred())
+		asc, err := NewAssistedServiceClient(u.Scheme, u.Host, "")
+		Expect(err).NotTo(HaveOccurred())
+
+		mockImageStore.EXPECT().HaveVersion("4.8", "x86_64").Return(true).AnyTimes()
+		mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, "4.8", "x86_64").Return(imageFile).AnyTimes()
+		h := &isoHandler{
+			ImageStore: mockImageStore,
+			client:     asc,
+			urlParser:  parseShortURL,
+		}
+		WithActiveStreams(streams)(h)
+		server = httptest.NewServer((&ImageHandler{byID: h}).router(1))
+
+		resp := download(fmt.Sprintf("/byid/%s/4.8/x86_64/full.iso", imageID))
+		Eventually(activeStreams).Should(HaveKeyWithValue(streamKindImage, float64(1)))
+		finish(resp)
+		Eventually(activeStreams).Should(HaveKeyWithValue(streamKindImage, float64(0)))
+	})
+
+	It("counts the boot artifacts being streamed", func() {
+		mockImageStore.EXPECT().VersionDisabled("4.8", "x86_64").Return(false).AnyTimes()
+		mockImageStore.EXPECT().HaveVersion("4.8", "x86_64").Return(true).AnyTimes()
+		mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, "4.8", "x86_64").Return(imageFile).AnyTimes()
+		mockImageStore.EXPECT().ArtifactChecksum("4.8", "x86_64", gomock.Any()).Return(imagestore.ArtifactChecksum{}, false).AnyTimes()
+		server = httptest.NewServer(&BootArtifactsHandler{
+			ImageStore:    mockImageStore,
+			ActiveStreams: streams,
+			// the whole file stands in for the rootfs extent
+			isoFileInfo: func(filePath, isoPath string) (int64, int64, error) {
+				return 0, 64 * 1024 * 1024, nil
+			},
+		})
+
+		resp := download("/boot-artifacts/rootfs?version=4.8")
+		Eventually(activeStreams).Should(HaveKeyWithValue(streamKindBootArtifact, float64(1)))
+		Expect(activeStreams()).To(HaveKeyWithValue(streamKindImage, float64(0)))
+		finish(resp)
+		Eventually(activeStreams).Should(HaveKeyWithValue(streamKindBootArtifact, float64(0)))
+	})
+})
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// AssistedRequestDebugPath prefixes the image URLs whose assisted service request is reported
+const AssistedRequestDebugPath = "/admin/assisted-request"
+
+// redactedCredential replaces the credentials forwarded in the reported URL
+const redactedCredential = "REDACTED"
+
+// AssistedRequestDebugHandler reports the assisted service ignition request an image URL
+// would result in, including the authentication mechanism selected, without making it.
+// The image URL is passed as the path after AssistedRequestDebugPath, with the same query
+// parameters and headers as the image request.
+type AssistedRequestDebugHandler struct {
+	client *AssistedServiceClient
+	secret string
+	router *chi.Mux
+}
+
+var _ http.Handler = &AssistedRequestDebugHandler{}
+
+type assistedRequestDebugResponse struct {
+	URL           string `json:"url"`
+	AuthMechanism string `json:"auth_mechanism"`
+	// header the credentials are forwarded in, empty when they are passed in the URL
+	AuthHeader string `json:"auth_header,omitempty"`
+}
+
+func NewAssistedRequestDebugHandler(client *AssistedServiceClient, secret string) *AssistedRequestDebugHandler {
+	h := &AssistedRequestDebugHandler{
+		client: client,
+		secret: secret,
+	}
+
+	long := h.report(parseLongURL)
+	short := h.report(parseShortURL)
+	router := chi.NewRouter()
+	router.Route(AssistedRequestDebugPath, func(r chi.Router) {
+		r.Handle("/images/{image_id:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}", long)
+		r.Handle("/byid/{image_id:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}/{version}/{arch}/{filename}", short)
+		r.Handle("/byapikey/{api_key}/{version}/{arch}/{filename}", short)
+		r.Handle("/bytoken/{token}/{version}/{arch}/{filename}", short)
+		r.Handle("/byid/{image_id:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}/{version}/{filename}", short)
+		r.Handle("/byapikey/{api_key}/{version}/{filename}", short)
+		r.Handle("/bytoken/{token}/{version}/{filename}", short)
+	})
+	router.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		httpErrorf(w, http.StatusNotFound, ErrorCodeNotFound, "Unrecognized image URL %s", r.URL.Path)
+	})
+	h.router = router
+	return h
+}
+
+func (h *AssistedRequestDebugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		httpErrorf(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Only GET is supported with this endpoint.")
+		return
+	}
+
+	if !adminAuthorized(r, h.secret) {
+		httpErrorf(w, http.StatusUnauthorized, ErrorCodeUnauthorized, "Missing or invalid %s header", AdminSecretHeader)
+		return
+	}
+
+	h.router.ServeHTTP(w, r)
+}
+
+// report returns a handler reporting the ignition request for the image URLs parsed by urlParser
+func (h *AssistedRequestDebugHandler) report(urlParser urlParserFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params, statusCode, err := urlParser(r)
+		if err != nil {
+			writeParseError(w, statusCode, err)
+			return
+		}
+
+		u := h.client.ignitionURL(params.imageID, params.imageType)
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, u.String(), nil)
+		if err != nil {
+			httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "Failed to build assisted service request: %v", err)
+			return
+		}
+		resp := assistedRequestDebugResponse{AuthMechanism: setRequestAuth(r, req)}
+		h.client.forwardQueryParams(r, req)
+
+		switch resp.AuthMechanism {
+		case AuthMechanismAPIKeyPath, AuthMechanismAPIKeyQuery:
+			query := req.URL.Query()
+			query.Set("api_key", redactedCredential)
+			req.URL.RawQuery = query.Encode()
+		case AuthMechanismImageTokenPath, AuthMechanismImageTokenQuery:
+			resp.AuthHeader = "Image-Token"
+		case AuthMechanismAuthorizationHeader:
+			resp.AuthHeader = "Authorization"
+		}
+		resp.URL = req.URL.String()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Errorf("Failed to write response: %v", err)
+		}
+	})
+}
@@ -1,80 +1,345 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
 
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
 	"github.com/openshift/assisted-image-service/pkg/isoeditor"
 )
 
 type AssistedServiceClient struct {
 	assistedServiceScheme string
 	assistedServiceHost   string
-	client                *http.Client
+	// prepended to all request paths when assisted service is served under a prefix
+	basePath string
+	client   *http.Client
+	// when set, calls fail fast while assisted service is unavailable
+	breaker *CircuitBreaker
+	// number of redirects followed before a request fails
+	maxRedirects int
+	// number of times failed requests are retried, and the delay before the first retry
+	retries      int
+	retryBackoff time.Duration
+	// base64 encoded SHA-256 hashes of the public keys accepted from assisted service
+	spkiPins []string
+	// path of the minimal ISO initrd with an {image_id} placeholder, minimalInitrdPathFormat when empty
+	minimalInitrdPath string
+	// names of the image request query parameters copied onto the assisted service requests
+	forwardedQueryParams []string
+	// when set, InfraEnv kernel arguments with operations other than append are skipped instead of failing requests
+	skipUnsupportedKargs bool
 }
 
+// AssistedServiceClientOption configures optional behavior of the assisted service client
+type AssistedServiceClientOption func(*AssistedServiceClient)
+
+// WithBasePath prefixes all assisted service request paths with basePath
+func WithBasePath(basePath string) AssistedServiceClientOption {
+	return func(c *AssistedServiceClient) {
+		c.basePath = strings.TrimSuffix(basePath, "/")
+		if c.basePath != "" && !strings.HasPrefix(c.basePath, "/") {
+			c.basePath = "/" + c.basePath
+		}
+	}
+}
+
+// WithCircuitBreaker rejects assisted service calls while the circuit breaker is open
+func WithCircuitBreaker(breaker *CircuitBreaker) AssistedServiceClientOption {
+	return func(c *AssistedServiceClient) {
+		c.breaker = breaker
+	}
+}
+
+// WithMaxRedirects sets the number of requests a single assisted service request may be redirected
+// through, counted like the default http.Client does: the original request and the redirects
+// followed. 0 or 1 makes any redirect fail the request.
+func WithMaxRedirects(maxRedirects int) AssistedServiceClientOption {
+	return func(c *AssistedServiceClient) {
+		c.maxRedirects = maxRedirects
+	}
+}
+
+// WithRetries retries requests failing with a network error, a 429 or 5xx status, or a
+// response body that can't be read in full. The delay before each retry starts at backoff
+// and doubles with every attempt.
+func WithRetries(retries int, backoff time.Duration) AssistedServiceClientOption {
+	return func(c *AssistedServiceClient) {
+		c.retries = retries
+		c.retryBackoff = backoff
+	}
+}
+
+// WithSPKIPins only accepts TLS connections to assisted service when the SHA-256 hash of the
+// SubjectPublicKeyInfo of the leaf certificate is one of pins, in standard base64 encoding.
+// This applies on top of the usual certificate verification.
+func WithSPKIPins(pins []string) AssistedServiceClientOption {
+	return func(c *AssistedServiceClient) {
+		c.spkiPins = pins
+	}
+}
+
+// WithMinimalInitrdPath requests the minimal ISO initrd from path instead of the default
+// assisted service route, for deployments that proxy or rename it. The {image_id} placeholder is
+// replaced with the InfraEnv ID, and the path is prefixed with the base path like the others.
+func WithMinimalInitrdPath(path string) AssistedServiceClientOption {
+	return func(c *AssistedServiceClient) {
+		c.minimalInitrdPath = path
+	}
+}
+
+// WithForwardedQueryParams copies the query parameters named in names from the image requests
+// onto the assisted service requests made for them, such as a tenant selector. Other query
+// parameters are never forwarded.
+func WithForwardedQueryParams(names []string) AssistedServiceClientOption {
+	return func(c *AssistedServiceClient) {
+		c.forwardedQueryParams = names
+	}
+}
+
+// WithUnsupportedKargsSkipped skips the InfraEnv kernel arguments with operations other than
+// append, logging a warning, rather than failing the requests for the InfraEnv with a 502
+func WithUnsupportedKargsSkipped() AssistedServiceClientOption {
+	return func(c *AssistedServiceClient) {
+		c.skipUnsupportedKargs = true
+	}
+}
+
+// DefaultMaxRedirects matches the number of redirects followed by the default http.Client
+const DefaultMaxRedirects = 10
+
+// authHeaders are the headers set by setRequestAuth
+var authHeaders = []string{"Authorization", "Image-Token"}
+
 const fileRouteFormat = "/api/assisted-install/v2/infra-envs/%s/downloads/files"
+const minimalInitrdPathFormat = "/api/assisted-install/v2/infra-envs/%s/downloads/minimal-initrd"
+
+// imageIDPlaceholder is replaced with the InfraEnv ID in the minimal initrd path
+const imageIDPlaceholder = "{image_id}"
 
-func NewAssistedServiceClient(assistedServiceScheme, assistedServiceHost, caCertFile string) (*AssistedServiceClient, error) {
+func NewAssistedServiceClient(assistedServiceScheme, assistedServiceHost, caCertFile string, opts ...AssistedServiceClientOption) (*AssistedServiceClient, error) {
 	if len(assistedServiceHost) == 0 {
 		return nil, fmt.Errorf("ASSISTED_SERVICE_HOST is not set")
 	}
-	client := &http.Client{}
+	var tlsConfig *tls.Config
 	if caCertFile != "" {
-		caCert, err := os.ReadFile(caCertFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open cert file %s, %s", caCertFile, err)
-		}
 		caCertPool := x509.NewCertPool()
-		if !caCertPool.AppendCertsFromPEM(caCert) {
-			return nil, fmt.Errorf("failed to append cert %s, %s", caCertFile, err)
+		if err := imagestore.AppendCACerts(caCertPool, caCertFile); err != nil {
+			return nil, err
 		}
 
-		t := &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs:    caCertPool,
-				MinVersion: tls.VersionTLS12,
-			},
+		tlsConfig = &tls.Config{
+			RootCAs:    caCertPool,
+			MinVersion: tls.VersionTLS12,
 		}
-		client.Transport = t
 	}
 
-	return &AssistedServiceClient{
+	client := &http.Client{}
+	c := &AssistedServiceClient{
 		assistedServiceScheme: assistedServiceScheme,
 		assistedServiceHost:   assistedServiceHost,
 		client:                client,
+		maxRedirects:          DefaultMaxRedirects,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if err := validateMinimalInitrdPath(c.minimalInitrdPath); err != nil {
+		return nil, err
+	}
+	if err := validateForwardedQueryParams(c.forwardedQueryParams); err != nil {
+		return nil, err
+	}
+
+	if len(c.spkiPins) > 0 {
+		verify, err := verifySPKIPins(c.spkiPins)
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		tlsConfig.VerifyPeerCertificate = verify
+	}
+	if tlsConfig != nil {
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	client.CheckRedirect = c.checkRedirect
+	return c, nil
+}
+
+// verifySPKIPins returns a tls.Config VerifyPeerCertificate callback rejecting leaf certificates
+// whose public key hash isn't one of pins
+func verifySPKIPins(pins []string) (func([][]byte, [][]*x509.Certificate) error, error) {
+	accepted := map[[sha256.Size]byte]bool{}
+	for _, pin := range pins {
+		pin = strings.TrimSpace(pin)
+		if pin == "" {
+			continue
+		}
+		hash, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid SPKI pin %q, expected a base64 encoded SHA-256 hash", pin)
+		}
+		accepted[[sha256.Size]byte(hash)] = true
+	}
+	if len(accepted) == 0 {
+		return nil, fmt.Errorf("no valid SPKI pin in %v", pins)
+	}
+
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("no certificate presented by assisted service")
+		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse assisted service certificate: %w", err)
+		}
+		if !accepted[sha256.Sum256(leaf.RawSubjectPublicKeyInfo)] {
+			return fmt.Errorf("assisted service certificate public key doesn't match any SPKI pin")
+		}
+		return nil
 	}, nil
 }
 
-// ignitionContent returns the ramdisk data on success and the error and the corresponding http status code
-// The code is also returned to ensure issues with authentication from the assisted service request are communicated back to the image service user
-// The returned code should only be used if an error is also returned
-func (c *AssistedServiceClient) ramdiskContent(imageServiceRequest *http.Request, imageID string) ([]byte, int, error) {
-	var ramdiskBytes []byte
+// checkRedirect limits the redirects followed and makes sure the credentials
+// set by setRequestAuth are only ever sent to the assisted service host
+func (c *AssistedServiceClient) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= c.maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", c.maxRedirects)
+	}
+	if !strings.EqualFold(req.URL.Host, c.assistedServiceHost) {
+		for _, header := range authHeaders {
+			req.Header.Del(header)
+		}
+	}
+	return nil
+}
+
+// doErrorStatus returns the status to report for an error returned by get
+func doErrorStatus(err error) int {
+	if errors.Is(err, ErrCircuitOpen) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// get sends a GET request to u with the auth of imageServiceRequest through the circuit breaker,
+// if any, which records a single outcome for the request whatever the number of attempts
+func (c *AssistedServiceClient) get(imageServiceRequest *http.Request, u url.URL) (*http.Response, []byte, error) {
+	if c.breaker == nil {
+		return c.getWithRetries(imageServiceRequest, u)
+	}
+	if err := c.breaker.allow(); err != nil {
+		return nil, nil, err
+	}
+	resp, body, err := c.getWithRetries(imageServiceRequest, u)
+	c.breaker.record(resp, err)
+	return resp, body, err
+}
+
+// getWithRetries sends a GET request to u with the auth of imageServiceRequest and reads the whole
+// response body. The request is rebuilt for every attempt. When all attempts fail with a
+// retryable status the last response is returned so the caller can report its status.
+func (c *AssistedServiceClient) getWithRetries(imageServiceRequest *http.Request, u url.URL) (*http.Response, []byte, error) {
+	ctx := imageServiceRequest.Context()
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, nil, ctx.Err()
+			case <-timer.C:
+			}
+			backoff *= 2
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		setRequestAuth(imageServiceRequest, req)
+		c.forwardQueryParams(imageServiceRequest, req)
+
+		resp, err := c.client.Do(req)
+		var body []byte
+		if err == nil {
+			body, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				err = fmt.Errorf("failed to read response body: %w", err)
+			}
+		}
+
+		retryable := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		if !retryable || attempt >= c.retries || ctx.Err() != nil {
+			return resp, body, err
+		}
+		if err != nil {
+			log.WithError(err).Warnf("Request to %s failed, retrying", u.Path)
+		} else {
+			log.Warnf("Request to %s returned status %d, retrying", u.Path, resp.StatusCode)
+		}
+	}
+}
 
-	u := url.URL{
+func (c *AssistedServiceClient) requestURL(path string) url.URL {
+	return url.URL{
 		Scheme: c.assistedServiceScheme,
 		Host:   c.assistedServiceHost,
-		Path:   fmt.Sprintf("/api/assisted-install/v2/infra-envs/%s/downloads/minimal-initrd", imageID),
+		Path:   c.basePath + path,
 	}
-	req, err := http.NewRequestWithContext(imageServiceRequest.Context(), "GET", u.String(), nil)
+}
+
+// assistedServiceHealthPath is the lightweight assisted service endpoint pinged by ping
+const assistedServiceHealthPath = "/health"
+
+// ping checks that assisted service responds to its health endpoint. It bypasses the circuit
+// breaker and retries so it reports the current state of assisted service.
+func (c *AssistedServiceClient) ping(ctx context.Context) error {
+	u := c.requestURL(assistedServiceHealthPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
-		return nil, http.StatusInternalServerError, err
+		return err
 	}
-	setRequestAuth(imageServiceRequest, req)
-
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, http.StatusInternalServerError, err
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("assisted service health check returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ramdiskContent returns the minimal ISO initrd data on success, nil when assisted service has none,
+// and the error and the corresponding http status code otherwise
+// The code is also returned to ensure issues with authentication from the assisted service request are communicated back to the image service user
+// The returned code should only be used if an error is also returned
+func (c *AssistedServiceClient) ramdiskContent(imageServiceRequest *http.Request, imageID string) ([]byte, int, error) {
+	u := c.requestURL(c.minimalInitrdRequestPath(imageID))
+	resp, ramdiskBytes, err := c.get(imageServiceRequest, u)
+	if err != nil {
+		return nil, doErrorStatus(err), err
 	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return nil, resp.StatusCode, fmt.Errorf("request to %s returned status %d", u.String(), resp.StatusCode)
@@ -84,47 +349,102 @@ func (c *AssistedServiceClient) ramdiskContent(imageServiceRequest *http.Request
 		return nil, 0, nil
 	}
 
-	ramdiskBytes, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, http.StatusInternalServerError, fmt.Errorf("failed to read response body: %v", err)
+	return ramdiskBytes, 0, nil
+}
+
+// minimalInitrdRequestPath returns the path the minimal ISO initrd of imageID is requested from
+func (c *AssistedServiceClient) minimalInitrdRequestPath(imageID string) string {
+	if c.minimalInitrdPath == "" {
+		return fmt.Sprintf(minimalInitrdPathFormat, imageID)
 	}
+	return strings.ReplaceAll(c.minimalInitrdPath, imageIDPlaceholder, imageID)
+}
 
-	return ramdiskBytes, 0, nil
+// validateMinimalInitrdPath checks that a configured minimal initrd path is absolute and depends
+// on the requested image
+func validateMinimalInitrdPath(path string) error {
+	if path == "" {
+		return nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("invalid minimal initrd path %q, it must start with '/'", path)
+	}
+	if !strings.Contains(path, imageIDPlaceholder) {
+		return fmt.Errorf("invalid minimal initrd path %q, it must contain the %s placeholder", path, imageIDPlaceholder)
+	}
+	return nil
 }
 
-// ignitionContent returns the ignition data on success and the error and the corresponding http status code
-// The code is also returned to ensure issues with authentication from the assisted service request are communicated back to the image service user
-// The returned code should only be used if an error is also returned
-func (c *AssistedServiceClient) ignitionContent(imageServiceRequest *http.Request, imageID string, imageType string) (*isoeditor.IgnitionContent, string, int, error) {
+// reservedQueryParams are set on the assisted service requests by the client itself, so they can't be forwarded
+var reservedQueryParams = []string{"api_key", "image_token", "discovery_iso_type", "file_name"}
 
-	u := url.URL{
-		Scheme: c.assistedServiceScheme,
-		Host:   c.assistedServiceHost,
-		Path:   fmt.Sprintf(fileRouteFormat, imageID),
+// validateForwardedQueryParams checks that the forwarded query parameters are named and don't
+// override the ones set by the client
+func validateForwardedQueryParams(names []string) error {
+	for _, name := range names {
+		if name == "" {
+			return fmt.Errorf("invalid forwarded query parameter, the name can't be empty")
+		}
+		for _, reserved := range reservedQueryParams {
+			if name == reserved {
+				return fmt.Errorf("invalid forwarded query parameter %q, it's set by the image service", name)
+			}
+		}
 	}
+	return nil
+}
+
+// forwardedQuery returns the query parameters of imageServiceRequest that are forwarded to assisted service
+func (c *AssistedServiceClient) forwardedQuery(imageServiceRequest *http.Request) url.Values {
+	forwarded := url.Values{}
+	if c == nil || len(c.forwardedQueryParams) == 0 {
+		return forwarded
+	}
+	queryValues := imageServiceRequest.URL.Query()
+	for _, name := range c.forwardedQueryParams {
+		if values, ok := queryValues[name]; ok {
+			forwarded[name] = values
+		}
+	}
+	return forwarded
+}
+
+// forwardQueryParams copies the forwarded query parameters of imageServiceRequest onto assistedRequest
+func (c *AssistedServiceClient) forwardQueryParams(imageServiceRequest, assistedRequest *http.Request) {
+	forwarded := c.forwardedQuery(imageServiceRequest)
+	if len(forwarded) == 0 {
+		return
+	}
+	params := assistedRequest.URL.Query()
+	for name, values := range forwarded {
+		params[name] = values
+	}
+	assistedRequest.URL.RawQuery = params.Encode()
+}
+
+// ignitionURL returns the assisted service URL of the discovery ignition of imageID
+func (c *AssistedServiceClient) ignitionURL(imageID, imageType string) url.URL {
+	u := c.requestURL(fmt.Sprintf(fileRouteFormat, imageID))
 	queryValues := url.Values{}
 	queryValues.Set("file_name", "discovery.ign")
 	if imageType != "" {
 		queryValues.Set("discovery_iso_type", imageType)
 	}
 	u.RawQuery = queryValues.Encode()
+	return u
+}
 
-	req, err := http.NewRequestWithContext(imageServiceRequest.Context(), "GET", u.String(), nil)
-	if err != nil {
-		return nil, "", http.StatusInternalServerError, err
-	}
-	setRequestAuth(imageServiceRequest, req)
-
-	resp, err := c.client.Do(req)
+// ignitionContent returns the ignition data on success and the error and the corresponding http status code
+// The code is also returned to ensure issues with authentication from the assisted service request are communicated back to the image service user
+// The returned code should only be used if an error is also returned
+func (c *AssistedServiceClient) ignitionContent(imageServiceRequest *http.Request, imageID string, imageType string) (*isoeditor.IgnitionContent, string, int, error) {
+	u := c.ignitionURL(imageID, imageType)
+	resp, ignitionBytes, err := c.get(imageServiceRequest, u)
 	if err != nil {
-		return nil, "", http.StatusInternalServerError, err
+		return nil, "", doErrorStatus(err), err
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", resp.StatusCode, fmt.Errorf("ignition request to %s returned status %d", req.URL.String(), resp.StatusCode)
-	}
-	ignitionBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, "", http.StatusInternalServerError, fmt.Errorf("failed to read response body: %v", err)
+		return nil, "", resp.StatusCode, fmt.Errorf("ignition request to %s returned status %d", u.String(), resp.StatusCode)
 	}
 
 	return &isoeditor.IgnitionContent{Config: ignitionBytes}, resp.Header.Get("Last-Modified"), 0, nil
@@ -137,44 +457,69 @@ const infraEnvPathFormat = "/api/assisted-install/v2/infra-envs/%s"
 // The returned code should only be used if an error is also returned
 func (c *AssistedServiceClient) discoveryKernelArguments(imageServiceRequest *http.Request, infraEnvID string) ([]byte, int, error) {
 
-	u := url.URL{
-		Scheme: c.assistedServiceScheme,
-		Host:   c.assistedServiceHost,
-		Path:   fmt.Sprintf(infraEnvPathFormat, infraEnvID),
-	}
+	u := c.requestURL(fmt.Sprintf(infraEnvPathFormat, infraEnvID))
 
-	req, err := http.NewRequestWithContext(imageServiceRequest.Context(), "GET", u.String(), nil)
-	if err != nil {
-		return nil, http.StatusInternalServerError, err
-	}
-	setRequestAuth(imageServiceRequest, req)
-
-	resp, err := c.client.Do(req)
+	resp, body, err := c.get(imageServiceRequest, u)
 	if err != nil {
-		return nil, http.StatusInternalServerError, err
+		return nil, doErrorStatus(err), err
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, resp.StatusCode, fmt.Errorf("infra-env request to %s returned status %d", req.URL.String(), resp.StatusCode)
+		return nil, resp.StatusCode, fmt.Errorf("infra-env request to %s returned status %d", u.String(), resp.StatusCode)
 	}
-	d := json.NewDecoder(resp.Body)
 	var infraEnv struct {
 		// JSON formatted string array representing the discovery image kernel arguments.
 		KernelArguments *string `json:"kernel_arguments,omitempty"`
 	}
-	if err = d.Decode(&infraEnv); err != nil {
+	if err = json.Unmarshal(body, &infraEnv); err != nil {
 		return nil, http.StatusInternalServerError, fmt.Errorf("failed to decode infra-env input: %v", err)
 	}
 	if infraEnv.KernelArguments != nil {
-		kargs, err := isoeditor.StrToKargs(*infraEnv.KernelArguments)
+		kargs, err := c.parseKernelArguments(infraEnvID, *infraEnv.KernelArguments)
 		if err != nil {
-			return nil, http.StatusInternalServerError, err
+			return nil, http.StatusBadGateway, err
 		}
-		return []byte(" " + strings.Join(kargs, " ") + "\n"), 0, nil
+		return kargs, 0, nil
 	}
 	return nil, 0, nil
 }
 
-func setRequestAuth(imageRequest, assistedRequest *http.Request) {
+// parseKernelArguments returns the kernel arguments data appended by the InfraEnv kernel_arguments
+// value, nil when all of them were skipped, or a 502 error when assisted service returned
+// kernel arguments that can't be used
+func (c *AssistedServiceClient) parseKernelArguments(infraEnvID, kernelArguments string) ([]byte, error) {
+	var kargs []string
+	var err error
+	if c.skipUnsupportedKargs {
+		var unsupported []*isoeditor.UnsupportedKargsOperationError
+		kargs, unsupported, err = isoeditor.StrToAppendKargs(kernelArguments)
+		for _, arg := range unsupported {
+			log.Warnf("Skipping kernel argument %q of infra-env %s with unsupported operation %s", arg.Value, infraEnvID, arg.Operation)
+		}
+		if err == nil && len(kargs) == 0 && len(unsupported) > 0 {
+			return nil, nil
+		}
+	} else {
+		kargs, err = isoeditor.StrToKargs(kernelArguments)
+	}
+	if err != nil {
+		return nil, NewHTTPError(http.StatusBadGateway, ErrorCodeInvalidKargs, "Invalid kernel arguments for infra-env %s: %v", infraEnvID, err)
+	}
+	return []byte(" " + strings.Join(kargs, " ") + "\n"), nil
+}
+
+// Authentication mechanisms setRequestAuth can select, in order of precedence
+const (
+	AuthMechanismAPIKeyPath          = "api_key_path"
+	AuthMechanismAPIKeyQuery         = "api_key_query"
+	AuthMechanismImageTokenPath      = "image_token_path"
+	AuthMechanismImageTokenQuery     = "image_token_query"
+	AuthMechanismAuthorizationHeader = "authorization_header"
+	AuthMechanismNone                = "none"
+)
+
+// setRequestAuth forwards the credentials of imageRequest to assistedRequest and returns
+// the mechanism they were found with
+func setRequestAuth(imageRequest, assistedRequest *http.Request) string {
 	queryValues := imageRequest.URL.Query()
 	authHeader := imageRequest.Header.Get("Authorization")
 	api_key := chi.URLParam(imageRequest, "api_key")
@@ -185,15 +530,21 @@ func setRequestAuth(imageRequest, assistedRequest *http.Request) {
 		params := assistedRequest.URL.Query()
 		params.Set("api_key", api_key)
 		assistedRequest.URL.RawQuery = params.Encode()
+		return AuthMechanismAPIKeyPath
 	case queryValues.Get("api_key") != "":
 		params := assistedRequest.URL.Query()
 		params.Set("api_key", queryValues.Get("api_key"))
 		assistedRequest.URL.RawQuery = params.Encode()
+		return AuthMechanismAPIKeyQuery
 	case token != "":
 		assistedRequest.Header.Set("Image-Token", token)
+		return AuthMechanismImageTokenPath
 	case queryValues.Get("image_token") != "":
 		assistedRequest.Header.Set("Image-Token", queryValues.Get("image_token"))
+		return AuthMechanismImageTokenQuery
 	case authHeader != "":
 		assistedRequest.Header.Set("Authorization", authHeader)
+		return AuthMechanismAuthorizationHeader
 	}
+	return AuthMechanismNone
 }
@@ -1,8 +1,23 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
 )
 
 var _ = Describe("AssistedServiceClient", func() {
@@ -13,4 +28,581 @@ var _ = Describe("AssistedServiceClient", func() {
 		Expect(err.Error()).To(Equal("ASSISTED_SERVICE_HOST is not set"))
 	})
 
+	Context("with a base path", func() {
+		var (
+			server  *ghttp.Server
+			imageID = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+			request = httptest.NewRequest(http.MethodGet, "/images/"+imageID, nil)
+		)
+
+		BeforeEach(func() {
+			server = ghttp.NewServer()
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		newClient := func(basePath string) *AssistedServiceClient {
+			u, err := url.Parse(server.URL())
+			Expect(err).NotTo(HaveOccurred())
+			c, err := NewAssistedServiceClient(u.Scheme, u.Host, "", WithBasePath(basePath))
+			Expect(err).NotTo(HaveOccurred())
+			return c
+		}
+
+		for _, basePath := range []string{"/assisted", "/assisted/", "assisted"} {
+			basePath := basePath
+			It(fmt.Sprintf("prefixes all request paths with %q", basePath), func() {
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/assisted"+fmt.Sprintf(fileRouteFormat, imageID)),
+						ghttp.RespondWith(http.StatusOK, "ignition"),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/assisted"+fmt.Sprintf(minimalInitrdPathFormat, imageID)),
+						ghttp.RespondWith(http.StatusOK, "ramdisk"),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/assisted"+fmt.Sprintf(infraEnvPathFormat, imageID)),
+						ghttp.RespondWith(http.StatusOK, `{"kernel_arguments": "[{\"operation\": \"append\", \"value\": \"p1\"}]"}`),
+					),
+				)
+				c := newClient(basePath)
+
+				ignition, _, _, err := c.ignitionContent(request, imageID, "")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(ignition.Config)).To(Equal("ignition"))
+
+				ramdisk, _, err := c.ramdiskContent(request, imageID)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(ramdisk)).To(Equal("ramdisk"))
+
+				kargs, _, err := c.discoveryKernelArguments(request, imageID)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(kargs)).To(Equal(" p1\n"))
+				Expect(server.ReceivedRequests()).To(HaveLen(3))
+			})
+		}
+
+		It("uses the unprefixed paths by default", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf(infraEnvPathFormat, imageID)),
+					ghttp.RespondWith(http.StatusOK, "{}"),
+				),
+			)
+			kargs, _, err := newClient("").discoveryKernelArguments(request, imageID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kargs).To(BeNil())
+		})
+	})
+
+	Context("with a minimal initrd path", func() {
+		var (
+			server  *ghttp.Server
+			imageID = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+			request = httptest.NewRequest(http.MethodGet, "/images/"+imageID, nil)
+		)
+
+		BeforeEach(func() {
+			server = ghttp.NewServer()
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		newClient := func(opts ...AssistedServiceClientOption) (*AssistedServiceClient, error) {
+			u, err := url.Parse(server.URL())
+			Expect(err).NotTo(HaveOccurred())
+			return NewAssistedServiceClient(u.Scheme, u.Host, "", opts...)
+		}
+
+		It("requests the initrd from the path with the base path prefix", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/assisted/initrds/%s/minimal", imageID)),
+					ghttp.RespondWith(http.StatusOK, "ramdisk"),
+				),
+			)
+			c, err := newClient(WithBasePath("/assisted"), WithMinimalInitrdPath("/initrds/{image_id}/minimal"))
+			Expect(err).NotTo(HaveOccurred())
+
+			ramdisk, _, err := c.ramdiskContent(request, imageID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(ramdisk)).To(Equal("ramdisk"))
+		})
+
+		It("requests the initrd from the assisted service route by default", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf(minimalInitrdPathFormat, imageID)),
+					ghttp.RespondWith(http.StatusOK, "ramdisk"),
+				),
+			)
+			c, err := newClient(WithMinimalInitrdPath(""))
+			Expect(err).NotTo(HaveOccurred())
+
+			ramdisk, _, err := c.ramdiskContent(request, imageID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(ramdisk)).To(Equal("ramdisk"))
+		})
+
+		for _, path := range []string{"initrds/{image_id}/minimal", "/initrds/minimal"} {
+			path := path
+			It(fmt.Sprintf("rejects %q", path), func() {
+				_, err := newClient(WithMinimalInitrdPath(path))
+				Expect(err).To(MatchError(ContainSubstring("invalid minimal initrd path")))
+			})
+		}
+	})
+
+	Context("with forwarded query parameters", func() {
+		var (
+			server  *ghttp.Server
+			imageID = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+			request = httptest.NewRequest(http.MethodGet, "/images/"+imageID+"?version=4.8&type=full-iso&tenant=acme&api_key=secret&debug=true", nil)
+		)
+
+		BeforeEach(func() {
+			server = ghttp.NewServer()
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		newClient := func(opts ...AssistedServiceClientOption) (*AssistedServiceClient, error) {
+			u, err := url.Parse(server.URL())
+			Expect(err).NotTo(HaveOccurred())
+			return NewAssistedServiceClient(u.Scheme, u.Host, "", opts...)
+		}
+
+		It("forwards the allowed parameters only", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf(fileRouteFormat, imageID), "api_key=secret&discovery_iso_type=full-iso&file_name=discovery.ign&tenant=acme"),
+					ghttp.RespondWith(http.StatusOK, "ignition"),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf(infraEnvPathFormat, imageID), "api_key=secret&tenant=acme"),
+					ghttp.RespondWith(http.StatusOK, "{}"),
+				),
+			)
+			c, err := newClient(WithForwardedQueryParams([]string{"tenant", "region"}))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, _, _, err = c.ignitionContent(request, imageID, "full-iso")
+			Expect(err).NotTo(HaveOccurred())
+			_, _, err = c.discoveryKernelArguments(request, imageID)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("forwards no parameters by default", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf(infraEnvPathFormat, imageID), "api_key=secret"),
+					ghttp.RespondWith(http.StatusOK, "{}"),
+				),
+			)
+			c, err := newClient()
+			Expect(err).NotTo(HaveOccurred())
+
+			_, _, err = c.discoveryKernelArguments(request, imageID)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		for _, name := range []string{"api_key", "file_name", ""} {
+			name := name
+			It(fmt.Sprintf("rejects %q", name), func() {
+				_, err := newClient(WithForwardedQueryParams([]string{"tenant", name}))
+				Expect(err).To(MatchError(ContainSubstring("invalid forwarded query parameter")))
+			})
+		}
+	})
+
+	Context("with unsupported kernel argument operations", func() {
+		var (
+			server  *ghttp.Server
+			imageID = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+			request = httptest.NewRequest(http.MethodGet, "/images/"+imageID, nil)
+		)
+
+		BeforeEach(func() {
+			server = ghttp.NewServer()
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		kernelArguments := func(kargs string, opts ...AssistedServiceClientOption) ([]byte, int, error) {
+			body, err := json.Marshal(map[string]string{"kernel_arguments": kargs})
+			Expect(err).NotTo(HaveOccurred())
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", fmt.Sprintf(infraEnvPathFormat, imageID)),
+				ghttp.RespondWith(http.StatusOK, body),
+			))
+			u, err := url.Parse(server.URL())
+			Expect(err).NotTo(HaveOccurred())
+			c, err := NewAssistedServiceClient(u.Scheme, u.Host, "", opts...)
+			Expect(err).NotTo(HaveOccurred())
+			return c.discoveryKernelArguments(request, imageID)
+		}
+
+		const replaceKargs = `[{"operation": "append", "value": "p1"}, {"operation": "replace", "value": "p2=v2"}]`
+
+		It("fails with a 502 naming the operation by default", func() {
+			_, statusCode, err := kernelArguments(replaceKargs)
+			Expect(statusCode).To(Equal(http.StatusBadGateway))
+			var httpErr *HTTPError
+			Expect(errors.As(err, &httpErr)).To(BeTrue())
+			Expect(httpErr.Code).To(Equal(ErrorCodeInvalidKargs))
+			Expect(httpErr.Message).To(ContainSubstring(`got replace for "p2=v2"`))
+		})
+
+		It("fails with a 502 for kernel arguments that aren't valid JSON", func() {
+			_, statusCode, err := kernelArguments(`[{"operation": `, WithUnsupportedKargsSkipped())
+			Expect(statusCode).To(Equal(http.StatusBadGateway))
+			Expect(err).To(MatchError(ContainSubstring("failed to unmarshal kernel arguments")))
+		})
+
+		It("skips the unsupported operations when configured to", func() {
+			kargs, _, err := kernelArguments(replaceKargs, WithUnsupportedKargsSkipped())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(kargs)).To(Equal(" p1\n"))
+		})
+
+		It("returns no kernel arguments when all of them are skipped", func() {
+			kargs, _, err := kernelArguments(`[{"operation": "delete", "value": "p1"}]`, WithUnsupportedKargsSkipped())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kargs).To(BeNil())
+		})
+	})
+
+	Context("with redirects", func() {
+		var (
+			server      *ghttp.Server
+			otherServer *ghttp.Server
+			imageID     = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+			path        = fmt.Sprintf(infraEnvPathFormat, imageID)
+		)
+
+		BeforeEach(func() {
+			server = ghttp.NewServer()
+			otherServer = ghttp.NewServer()
+		})
+
+		AfterEach(func() {
+			server.Close()
+			otherServer.Close()
+		})
+
+		newClient := func(opts ...AssistedServiceClientOption) *AssistedServiceClient {
+			u, err := url.Parse(server.URL())
+			Expect(err).NotTo(HaveOccurred())
+			c, err := NewAssistedServiceClient(u.Scheme, u.Host, "", opts...)
+			Expect(err).NotTo(HaveOccurred())
+			return c
+		}
+
+		redirectTo := func(location string) http.HandlerFunc {
+			return ghttp.RespondWith(http.StatusFound, "", http.Header{"Location": {location}})
+		}
+
+		verifyNoAuth := func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Header.Get("Authorization")).To(BeEmpty())
+			Expect(r.Header.Get("Image-Token")).To(BeEmpty())
+		}
+
+		It("doesn't forward the auth headers to another host", func() {
+			server.AppendHandlers(redirectTo(otherServer.URL()+path), redirectTo(otherServer.URL()+path))
+			otherServer.AppendHandlers(
+				ghttp.CombineHandlers(verifyNoAuth, ghttp.RespondWith(http.StatusOK, "{}")),
+				ghttp.CombineHandlers(verifyNoAuth, ghttp.RespondWith(http.StatusOK, "{}")),
+			)
+			c := newClient()
+
+			request := httptest.NewRequest(http.MethodGet, "/images/"+imageID, nil)
+			request.Header.Set("Authorization", "Bearer mytoken")
+			_, _, err := c.discoveryKernelArguments(request, imageID)
+			Expect(err).NotTo(HaveOccurred())
+
+			request = httptest.NewRequest(http.MethodGet, "/images/"+imageID+"?image_token=mytoken", nil)
+			_, _, err = c.discoveryKernelArguments(request, imageID)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+			Expect(otherServer.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("keeps the auth headers when redirected on the same host", func() {
+			server.AppendHandlers(
+				redirectTo("/moved"+path),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/moved"+path),
+					ghttp.VerifyHeaderKV("Authorization", "Bearer mytoken"),
+					ghttp.RespondWith(http.StatusOK, "{}"),
+				),
+			)
+
+			request := httptest.NewRequest(http.MethodGet, "/images/"+imageID, nil)
+			request.Header.Set("Authorization", "Bearer mytoken")
+			_, _, err := newClient().discoveryKernelArguments(request, imageID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("follows redirects up to the limit", func() {
+			server.AppendHandlers(redirectTo(server.URL()+path), redirectTo(server.URL()+path), ghttp.RespondWith(http.StatusOK, "{}"))
+
+			request := httptest.NewRequest(http.MethodGet, "/images/"+imageID, nil)
+			_, _, err := newClient(WithMaxRedirects(3)).discoveryKernelArguments(request, imageID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("stops following redirects at the limit", func() {
+			server.AppendHandlers(redirectTo(server.URL()+path), redirectTo(server.URL()+path), redirectTo(server.URL()+path))
+
+			request := httptest.NewRequest(http.MethodGet, "/images/"+imageID, nil)
+			_, _, err := newClient(WithMaxRedirects(3)).discoveryKernelArguments(request, imageID)
+			Expect(err).To(MatchError(ContainSubstring("stopped after 3 redirects")))
+			Expect(server.ReceivedRequests()).To(HaveLen(3))
+
+			server.AppendHandlers(redirectTo(server.URL() + path))
+			_, _, err = newClient(WithMaxRedirects(0)).discoveryKernelArguments(request, imageID)
+			Expect(err).To(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(4))
+		})
+	})
+
+	Context("with retries", func() {
+		var (
+			server  *ghttp.Server
+			imageID = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+			request *http.Request
+		)
+
+		BeforeEach(func() {
+			server = ghttp.NewServer()
+			request = httptest.NewRequest(http.MethodGet, "/images/"+imageID+"?image_token=mytoken", nil)
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		newClient := func(retries int) *AssistedServiceClient {
+			u, err := url.Parse(server.URL())
+			Expect(err).NotTo(HaveOccurred())
+			c, err := NewAssistedServiceClient(u.Scheme, u.Host, "", WithRetries(retries, time.Millisecond))
+			Expect(err).NotTo(HaveOccurred())
+			return c
+		}
+
+		respond := func(path string, status int, body string) http.HandlerFunc {
+			return ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", path),
+				// the auth is set again on every attempt
+				ghttp.VerifyHeaderKV("Image-Token", "mytoken"),
+				ghttp.RespondWith(status, body),
+			)
+		}
+
+		It("retries the ignition request until it succeeds", func() {
+			path := fmt.Sprintf(fileRouteFormat, imageID)
+			server.AppendHandlers(
+				respond(path, http.StatusServiceUnavailable, ""),
+				respond(path, http.StatusTooManyRequests, ""),
+				respond(path, http.StatusOK, "ignition"),
+			)
+
+			ignition, _, _, err := newClient(2).ignitionContent(request, imageID, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(ignition.Config)).To(Equal("ignition"))
+			Expect(server.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("retries the ramdisk request until it succeeds", func() {
+			path := fmt.Sprintf(minimalInitrdPathFormat, imageID)
+			server.AppendHandlers(
+				respond(path, http.StatusInternalServerError, ""),
+				respond(path, http.StatusBadGateway, ""),
+				respond(path, http.StatusOK, "ramdisk"),
+			)
+
+			ramdisk, _, err := newClient(2).ramdiskContent(request, imageID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(ramdisk)).To(Equal("ramdisk"))
+			Expect(server.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("retries responses that are cut short", func() {
+			path := fmt.Sprintf(minimalInitrdPathFormat, imageID)
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", path),
+					func(w http.ResponseWriter, r *http.Request) {
+						w.Header().Set("Content-Length", "100")
+						_, _ = w.Write([]byte("ram"))
+					},
+				),
+				respond(path, http.StatusOK, "ramdisk"),
+			)
+
+			ramdisk, _, err := newClient(1).ramdiskContent(request, imageID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(ramdisk)).To(Equal("ramdisk"))
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("returns the last status once the retries are exhausted", func() {
+			path := fmt.Sprintf(fileRouteFormat, imageID)
+			server.AppendHandlers(
+				respond(path, http.StatusServiceUnavailable, ""),
+				respond(path, http.StatusServiceUnavailable, ""),
+			)
+
+			_, _, status, err := newClient(1).ignitionContent(request, imageID, "")
+			Expect(err).To(HaveOccurred())
+			Expect(status).To(Equal(http.StatusServiceUnavailable))
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("doesn't retry client errors", func() {
+			server.AppendHandlers(respond(fmt.Sprintf(fileRouteFormat, imageID), http.StatusUnauthorized, ""))
+
+			_, _, status, err := newClient(2).ignitionContent(request, imageID, "")
+			Expect(err).To(HaveOccurred())
+			Expect(status).To(Equal(http.StatusUnauthorized))
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("stops retrying when the request is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			server.AppendHandlers(func(w http.ResponseWriter, r *http.Request) {
+				cancel()
+				w.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			_, _, _, err := newClient(5).ignitionContent(request.WithContext(ctx), imageID, "")
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+	Context("with a trusted CA directory", func() {
+		var (
+			server  *ghttp.Server
+			caDir   string
+			imageID = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+			request = httptest.NewRequest(http.MethodGet, "/images/"+imageID, nil)
+		)
+
+		BeforeEach(func() {
+			server = ghttp.NewTLSServer()
+
+			var err error
+			caDir, err = os.MkdirTemp("", "assisted-cas")
+			Expect(err).NotTo(HaveOccurred())
+			serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.HTTPTestServer.Certificate().Raw})
+			Expect(os.WriteFile(filepath.Join(caDir, "server.pem"), serverCA, 0o600)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			server.Close()
+			os.RemoveAll(caDir)
+		})
+
+		It("trusts the CAs of the directory", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusOK, "{}"))
+			u, err := url.Parse(server.URL())
+			Expect(err).NotTo(HaveOccurred())
+			c, err := NewAssistedServiceClient(u.Scheme, u.Host, caDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, _, err = c.discoveryKernelArguments(request, imageID)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("fails for a malformed CA file", func() {
+			Expect(os.WriteFile(filepath.Join(caDir, "bad.pem"), []byte("garbage"), 0o600)).To(Succeed())
+			_, err := NewAssistedServiceClient("https", "example.com", caDir)
+			Expect(err).To(MatchError(ContainSubstring("bad.pem")))
+		})
+	})
+	Context("with SPKI pins", func() {
+		var (
+			server     *ghttp.Server
+			caCertFile string
+			imageID    = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+			request    = httptest.NewRequest(http.MethodGet, "/images/"+imageID, nil)
+		)
+
+		BeforeEach(func() {
+			server = ghttp.NewTLSServer()
+
+			f, err := os.CreateTemp("", "assisted-ca")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: server.HTTPTestServer.Certificate().Raw})).To(Succeed())
+			Expect(f.Close()).To(Succeed())
+			caCertFile = f.Name()
+		})
+
+		AfterEach(func() {
+			server.Close()
+			os.Remove(caCertFile)
+		})
+
+		serverPin := func() string {
+			sum := sha256.Sum256(server.HTTPTestServer.Certificate().RawSubjectPublicKeyInfo)
+			return base64.StdEncoding.EncodeToString(sum[:])
+		}
+
+		newClient := func(pins ...string) *AssistedServiceClient {
+			u, err := url.Parse(server.URL())
+			Expect(err).NotTo(HaveOccurred())
+			c, err := NewAssistedServiceClient(u.Scheme, u.Host, caCertFile, WithSPKIPins(pins))
+			Expect(err).NotTo(HaveOccurred())
+			return c
+		}
+
+		It("accepts a server whose public key matches a pin", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusOK, "{}"))
+			otherPin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+			_, _, err := newClient(otherPin, serverPin()).discoveryKernelArguments(request, imageID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("rejects a server whose public key doesn't match any pin", func() {
+			otherPin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+			_, _, err := newClient(otherPin).discoveryKernelArguments(request, imageID)
+			Expect(err).To(MatchError(ContainSubstring("doesn't match any SPKI pin")))
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("still verifies the certificate chain", func() {
+			u, err := url.Parse(server.URL())
+			Expect(err).NotTo(HaveOccurred())
+			c, err := NewAssistedServiceClient(u.Scheme, u.Host, "", WithSPKIPins([]string{serverPin()}))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, _, err = c.discoveryKernelArguments(request, imageID)
+			Expect(err).To(MatchError(ContainSubstring("certificate")))
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("fails for an invalid pin", func() {
+			_, err := NewAssistedServiceClient("https", "example.com", "", WithSPKIPins([]string{"not a pin"}))
+			Expect(err).To(MatchError(ContainSubstring("invalid SPKI pin")))
+
+			_, err = NewAssistedServiceClient("https", "example.com", "", WithSPKIPins([]string{base64.StdEncoding.EncodeToString([]byte("short"))}))
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
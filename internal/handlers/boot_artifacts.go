@@ -1,12 +1,17 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/openshift/assisted-image-service/pkg/imagestore"
 	"github.com/openshift/assisted-image-service/pkg/isoeditor"
@@ -15,16 +20,57 @@ import (
 
 type BootArtifactsHandler struct {
 	ImageStore imagestore.ImageStore
+	// DefaultArch is used when the request doesn't specify an architecture, x86_64 when empty
+	DefaultArch string
+	// CacheControl is the Cache-Control header of the artifacts, not set when empty
+	CacheControl string
+	// InsFileName is the filename the s390x ins-file is downloaded as, generic.ins when empty
+	InsFileName string
+	// InsFileContentType is the Content-Type of the ins-file, plain text when empty
+	InsFileContentType string
+	// ActiveStreams counts the artifact downloads being streamed when set
+	ActiveStreams *ActiveStreams
+	// ExtractionTimeout bounds the time locating an artifact in the ISO and reading it until the
+	// response status is sent may take, no bound when 0
+	ExtractionTimeout time.Duration
+	// AdminSecret must be sent in the AdminSecretHeader to download the nmstate archive, which is
+	// unavailable when empty
+	AdminSecret string
+	// Kargs locates the kernel arguments files the command line and boot configuration are read
+	// from, discovered from the ISO when nil
+	Kargs *isoeditor.Kargs
+
+	// locates artifacts in the ISO, isoeditor.GetISOFileInfo when not set
+	isoFileInfo func(filePath, isoPath string) (int64, int64, error)
 }
 
 var _ http.Handler = &BootArtifactsHandler{}
 
 var bootpathRegexp = regexp.MustCompile(`^/boot-artifacts/(.+)`)
 
-func parseArtifact(path, arch string) (string, error) {
+// cmdlineArtifact is generated from the ISO boot configuration rather than read from a file
+const cmdlineArtifact = "cmdline"
+
+// the boot configuration files are found among the ISO kernel arguments files rather than at a fixed path
+const (
+	grubConfigArtifact     = isoeditor.GrubConfigFileName
+	isolinuxConfigArtifact = isoeditor.IsolinuxConfigFileName
+)
+
+// nmstateArtifact is the nmstatectl ram disk cached by the image store rather than a file in the ISO
+const nmstateArtifact = "nmstate"
+
+const (
+	insFileArtifact           = "generic.ins"
+	defaultInsFileContentType = "text/plain; charset=utf-8"
+)
+
+// parseArtifact returns the name of the requested artifact in the ISO and the filename it's
+// downloaded as, which differ only for the ins-file when insFileName is set
+func parseArtifact(path, arch, insFileName string) (string, string, error) {
 	match := bootpathRegexp.FindStringSubmatch(path)
 	if len(match) < 1 {
-		return "", fmt.Errorf("malformed download path: %s", path)
+		return "", "", fmt.Errorf("malformed download path: %s", path)
 	}
 
 	var artifact string
@@ -37,60 +83,383 @@ func parseArtifact(path, arch string) (string, error) {
 		} else {
 			artifact = "vmlinuz"
 		}
+	case cmdlineArtifact:
+		artifact = cmdlineArtifact
+	case "grub-cfg":
+		artifact = grubConfigArtifact
+	case "isolinux-cfg":
+		// isolinux only boots x86 machines
+		if arch == "ppc64le" || arch == "s390x" {
+			return "", "", fmt.Errorf("isolinux-cfg is not available for the %s architecture", arch)
+		}
+		artifact = isolinuxConfigArtifact
+	case nmstateArtifact:
+		artifact = nmstateArtifact
 	case "ins-file":
-		if arch == "s390x" {
-			artifact = "generic.ins"
-		} else {
-			return "", fmt.Errorf("ins-file is only available for the s390x architecture. Current arch: %s", arch)
+		if arch != "s390x" {
+			return "", "", fmt.Errorf("ins-file is only available for the s390x architecture. Current arch: %s", arch)
+		}
+		if insFileName == "" {
+			insFileName = insFileArtifact
 		}
+		return insFileArtifact, insFileName, nil
 	default:
-		return "", fmt.Errorf("malformed download path: %s", path)
+		return "", "", fmt.Errorf("malformed download path: %s", path)
 	}
-	return artifact, nil
+	return artifact, artifact, nil
 }
 
 func (b *BootArtifactsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet && r.Method != http.MethodHead {
-		log.Error("Only GET and HEAD methods are supported with this endpoint.")
-		w.WriteHeader(http.StatusMethodNotAllowed)
 		w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodHead}, ", "))
+		httpErrorf(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Only GET and HEAD methods are supported with this endpoint.")
+		return
+	}
+
+	values := r.URL.Query()
+	if b.ImageStore.VersionDisabled(values.Get("version"), b.archParam(values)) {
+		httpErrorf(w, http.StatusNotFound, ErrorCodeVersionNotFound, "version for %s %s is disabled", values.Get("version"), b.archParam(values))
 		return
 	}
 
-	version, arch, err := b.parseQueryParams(r.URL.Query())
+	version, arch, err := b.parseQueryParams(values)
 	if err != nil {
-		httpErrorf(w, http.StatusBadRequest, "Failed to parse query parameters: %v", err)
+		httpErrorf(w, http.StatusBadRequest, ErrorCodeInvalidParameter, "Failed to parse query parameters: %v", err)
 		return
 	}
 
-	artifact, err := parseArtifact(r.URL.Path, arch)
+	artifact, fileName, err := parseArtifact(r.URL.Path, arch, b.InsFileName)
 	if err != nil {
-		httpErrorf(w, http.StatusNotFound, "Failed to parse artifact: %v", err)
+		httpErrorf(w, http.StatusNotFound, ErrorCodeNotFound, "Failed to parse artifact: %v", err)
+		return
+	}
+
+	if artifact == nmstateArtifact {
+		if !adminAuthorized(r, b.AdminSecret) {
+			httpErrorf(w, http.StatusUnauthorized, ErrorCodeUnauthorized, "Missing or invalid %s header", AdminSecretHeader)
+			return
+		}
+		b.serveNmstateArchive(w, r, version, arch)
 		return
 	}
 
 	isoFileName := b.ImageStore.PathForParams(imagestore.ImageTypeFull, version, arch)
+	if b.CacheControl != "" {
+		w.Header().Set("Cache-Control", b.CacheControl)
+	}
+	if artifact == cmdlineArtifact {
+		serveKernelCmdline(w, r, b.Kargs, isoFileName, values.Get("rootfs_url"))
+		return
+	}
+	if artifact == grubConfigArtifact || artifact == isolinuxConfigArtifact {
+		serveBootConfig(w, r, b.Kargs, isoFileName, artifact)
+		return
+	}
+
 	file_path := fmt.Sprintf("/images/pxeboot/%s", artifact)
-	if artifact == "generic.ins" {
+	if artifact == insFileArtifact {
 		// s390x only, unlike other artifacts this one is at the root of the ISO
 		file_path = fmt.Sprintf("/%s", artifact)
 	}
 
-	fileReader, err := isoeditor.GetFileFromISO(isoFileName, file_path)
+	ctx := r.Context()
+	if b.ExtractionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.ExtractionTimeout)
+		defer cancel()
+	}
+	content, modTime, closeContent, err := b.artifactContentWithDeadline(ctx, file_path, isoFileName)
 	if err != nil {
-		httpErrorf(w, http.StatusInternalServerError, "Error creating file reader stream: %v", err)
+		b.writeExtractionError(w, file_path, err)
+		return
+	}
+	defer closeContent()
+
+	// ServeContent answers If-None-Match with a 304 once the ETag is set
+	if sum, ok := b.ImageStore.ArtifactChecksum(version, arch, file_path); ok {
+		w.Header().Set("ETag", fmt.Sprintf("%q", sum.SHA256))
+		// the digest only describes the full content, not a range of it
+		if r.Header.Get("Range") == "" {
+			w.Header().Set("Content-MD5", sum.MD5)
+		}
+	}
+
+	if artifact == insFileArtifact {
+		contentType := b.InsFileContentType
+		if contentType == "" {
+			contentType = defaultInsFileContentType
+		}
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
+	w.Header().Set("Accept-Ranges", "bytes")
+	defer b.ActiveStreams.start(streamKindBootArtifact)()
+	if err := serveArtifactContent(ctx, w, r, fileName, modTime, content); err != nil {
+		b.writeExtractionError(w, file_path, err)
+	}
+}
+
+// writeExtractionError reports the failure to extract the artifact at filePath
+func (b *BootArtifactsHandler) writeExtractionError(w http.ResponseWriter, filePath string, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		httpErrorf(w, http.StatusGatewayTimeout, ErrorCodeTimeout, "Timed out extracting %s after %s", filePath, b.ExtractionTimeout)
+	case errors.Is(err, context.Canceled):
+		log.Debugf("Request for %s canceled while extracting it", filePath)
+	default:
+		httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "%v", err)
+	}
+}
+
+// pendingArtifact bounds the reads of an artifact made before its response status is sent by ctx
+type pendingArtifact struct {
+	io.ReadSeeker
+	ctx context.Context
+	// the response status was sent, reads are no longer bounded
+	sent bool
+	// ctx was done before the response status was sent
+	err error
+}
+
+type artifactRead struct {
+	n   int
+	err error
+}
+
+func (a *pendingArtifact) Read(p []byte) (int, error) {
+	if a.err != nil {
+		return 0, a.err
+	}
+	if a.sent {
+		return a.ReadSeeker.Read(p)
+	}
+
+	// the read can't be interrupted, so it's left to complete in the background with its own buffer
+	buf := make([]byte, len(p))
+	read := make(chan artifactRead, 1)
+	go func() {
+		n, err := a.ReadSeeker.Read(buf)
+		read <- artifactRead{n: n, err: err}
+	}()
+	select {
+	case res := <-read:
+		return copy(p, buf[:res.n]), res.err
+	case <-a.ctx.Done():
+		a.err = a.ctx.Err()
+		return 0, a.err
+	}
+}
+
+func (a *pendingArtifact) Seek(offset int64, whence int) (int64, error) {
+	if a.err != nil {
+		return 0, a.err
+	}
+	return a.ReadSeeker.Seek(offset, whence)
+}
+
+// pendingArtifactWriter holds the response status back until the first byte of the artifact is
+// written, and drops the response once the artifact failed to be read in time
+type pendingArtifactWriter struct {
+	http.ResponseWriter
+	artifact   *pendingArtifact
+	statusCode int
+}
+
+func (w *pendingArtifactWriter) WriteHeader(statusCode int) {
+	if w.artifact.sent || w.statusCode != 0 {
+		return
+	}
+	w.statusCode = statusCode
+}
+
+func (w *pendingArtifactWriter) Write(p []byte) (int, error) {
+	if w.artifact.err != nil {
+		return 0, w.artifact.err
+	}
+	w.send()
+	return w.ResponseWriter.Write(p)
+}
+
+// send sends the response status unless it was already
+func (w *pendingArtifactWriter) send() {
+	if w.artifact.sent {
+		return
+	}
+	w.artifact.sent = true
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.statusCode)
+}
+
+func (w *pendingArtifactWriter) Flush() {
+	if w.artifact.err != nil {
+		return
+	}
+	w.send()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying connection
+func (w *pendingArtifactWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// serveArtifactContent serves content, returning the error of ctx without sending a response when
+// it's done before content is read up to the first byte written. A stalled read then fails the
+// request rather than holding it once the artifact is located.
+func serveArtifactContent(ctx context.Context, w http.ResponseWriter, r *http.Request, fileName string, modTime time.Time, content io.ReadSeeker) error {
+	artifact := &pendingArtifact{ReadSeeker: content, ctx: ctx}
+	writer := &pendingArtifactWriter{ResponseWriter: w, artifact: artifact}
+	http.ServeContent(writer, r, fileName, modTime, artifact)
+	if artifact.err != nil && !artifact.sent {
+		for _, header := range []string{"Content-Length", "Content-Range", "Content-Disposition", "Content-Type", "Content-MD5", "ETag", "Last-Modified"} {
+			w.Header().Del(header)
+		}
+		return artifact.err
+	}
+	writer.send()
+	return nil
+}
+
+// serveNmstateArchive serves the nmstatectl cpio archive appended to the minimal ISOs of a version,
+// as cached by the image store, so it can be inspected when nmstate fails at boot
+func (b *BootArtifactsHandler) serveNmstateArchive(w http.ResponseWriter, r *http.Request, version, arch string) {
+	archivePath, ok := b.ImageStore.NmstatectlPathForParams(version, arch)
+	if !ok {
+		httpErrorf(w, http.StatusNotFound, ErrorCodeNotFound, "minimal ISOs for %s %s don't include nmstatectl", version, arch)
+		return
+	}
+
+	f, err := os.Open(archivePath)
+	if os.IsNotExist(err) {
+		httpErrorf(w, http.StatusNotFound, ErrorCodeNotFound, "nmstatectl for %s %s hasn't been extracted", version, arch)
+		return
+	}
+	if err != nil {
+		httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "Error opening %s: %v", archivePath, err)
+		return
+	}
+	defer f.Close()
+
+	fileInfo, err := f.Stat()
+	if err != nil {
+		httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "Error reading file info for %s", archivePath)
+		return
+	}
+
+	fileName := fmt.Sprintf("nmstate-%s-%s.img", version, arch)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
+	http.ServeContent(w, r, fileName, fileInfo.ModTime(), f)
+}
+
+type extractedArtifact struct {
+	content      io.ReadSeeker
+	modTime      time.Time
+	closeContent func()
+	err          error
+}
+
+// artifactContentWithDeadline returns the result of artifactContent unless ctx is done first.
+// The extraction can't be interrupted, so it's left to complete in the background and its content
+// is closed then.
+func (b *BootArtifactsHandler) artifactContentWithDeadline(ctx context.Context, filePath, isoFileName string) (io.ReadSeeker, time.Time, func(), error) {
+	// unbuffered so the content is either received here or closed by the extraction
+	extracted := make(chan extractedArtifact)
+	go func() {
+		var a extractedArtifact
+		a.content, a.modTime, a.closeContent, a.err = b.artifactContent(filePath, isoFileName)
+		select {
+		case extracted <- a:
+		case <-ctx.Done():
+			if a.err == nil {
+				a.closeContent()
+			}
+		}
+	}()
+
+	select {
+	case a := <-extracted:
+		return a.content, a.modTime, a.closeContent, a.err
+	case <-ctx.Done():
+		return nil, time.Time{}, nil, ctx.Err()
+	}
+}
+
+// artifactContent returns the content of the file at filePath within the full ISO
+func (b *BootArtifactsHandler) artifactContent(filePath, isoFileName string) (io.ReadSeeker, time.Time, func(), error) {
+	// the artifact is served straight from its extent in the ISO so the size is
+	// known without reading it, and seeking for range requests is cheap
+	isoFileInfo := b.isoFileInfo
+	if isoFileInfo == nil {
+		isoFileInfo = isoeditor.GetISOFileInfo
+	}
+	offset, size, err := isoFileInfo(filePath, isoFileName)
+	if err != nil {
+		return nil, time.Time{}, nil, fmt.Errorf("Error finding %s in %s: %v", filePath, isoFileName, err)
+	}
+
+	isoFile, err := os.Open(isoFileName)
+	if err != nil {
+		return nil, time.Time{}, nil, fmt.Errorf("Error creating file reader stream: %v", err)
+	}
+
+	fileInfo, err := isoFile.Stat()
+	if err != nil {
+		isoFile.Close()
+		return nil, time.Time{}, nil, fmt.Errorf("Error reading file info for %s", isoFileName)
+	}
+	return io.NewSectionReader(isoFile, offset, size), fileInfo.ModTime(), func() { isoFile.Close() }, nil
+}
+
+func serveKernelCmdline(w http.ResponseWriter, r *http.Request, kargs *isoeditor.Kargs, isoFileName, rootFSURL string) {
+	if rootFSURL != "" {
+		u, err := url.Parse(rootFSURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.ContainsAny(rootFSURL, " \t\r\n") {
+			httpErrorf(w, http.StatusBadRequest, ErrorCodeInvalidParameter, "'rootfs_url' must be an absolute http or https URL")
+			return
+		}
+	}
+
+	cmdline, err := kargs.KernelCmdline(isoFileName, rootFSURL)
+	if err != nil {
+		httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "Error reading kernel command line: %v", err)
 		return
 	}
-	defer fileReader.Close()
 
 	fileInfo, err := os.Stat(isoFileName)
 	if err != nil {
-		httpErrorf(w, http.StatusInternalServerError, "Error reading file info for %s", isoFileName)
+		httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "Error reading file info for %s", isoFileName)
 		return
 	}
 
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", artifact))
-	http.ServeContent(w, r, artifact, fileInfo.ModTime(), fileReader)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeContent(w, r, cmdlineArtifact, fileInfo.ModTime(), strings.NewReader(cmdline+"\n"))
+}
+
+// serveBootConfig serves the boot configuration file of the ISO named fileName as text
+func serveBootConfig(w http.ResponseWriter, r *http.Request, kargs *isoeditor.Kargs, isoFileName, fileName string) {
+	_, config, err := kargs.BootConfig(isoFileName, fileName)
+	if errors.Is(err, isoeditor.ErrBootConfigNotFound) {
+		httpErrorf(w, http.StatusNotFound, ErrorCodeNotFound, "%v", err)
+		return
+	}
+	if err != nil {
+		httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "Error reading %s: %v", fileName, err)
+		return
+	}
+
+	fileInfo, err := os.Stat(isoFileName)
+	if err != nil {
+		httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "Error reading file info for %s", isoFileName)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeContent(w, r, fileName, fileInfo.ModTime(), bytes.NewReader(config))
 }
 
 func (b *BootArtifactsHandler) parseQueryParams(values url.Values) (string, string, error) {
@@ -98,10 +467,7 @@ func (b *BootArtifactsHandler) parseQueryParams(values url.Values) (string, stri
 	if version == "" {
 		return "", "", fmt.Errorf("'version' parameter required")
 	}
-	arch := values.Get("arch")
-	if arch == "" {
-		arch = defaultArch
-	}
+	arch := b.archParam(values)
 
 	if !b.ImageStore.HaveVersion(version, arch) {
 		return "", "", fmt.Errorf("version for %s %s, not found", version, arch)
@@ -109,3 +475,7 @@ func (b *BootArtifactsHandler) parseQueryParams(values url.Values) (string, stri
 
 	return version, arch, nil
 }
+
+func (b *BootArtifactsHandler) archParam(values url.Values) string {
+	return resolveArch(values.Get("arch"), b.DefaultArch)
+}
@@ -1,18 +1,22 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	"github.com/openshift/assisted-image-service/pkg/imagestore"
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
 )
 
 var _ = Describe("ServeHTTP", func() {
@@ -33,6 +37,7 @@ var _ = Describe("ServeHTTP", func() {
 		BeforeEach(func() {
 			ctrl = gomock.NewController(GinkgoT())
 			mockImageStore = imagestore.NewMockImageStore(ctrl)
+			mockImageStore.EXPECT().VersionDisabled(gomock.Any(), gomock.Any()).Return(false).AnyTimes()
 
 			fullImageFilename = createTestISO()
 			handler := &BootArtifactsHandler{
@@ -51,6 +56,7 @@ var _ = Describe("ServeHTTP", func() {
 			mockImageStore.EXPECT().HaveVersion(version, arch).Return(true).AnyTimes()
 			imageFile := fullImageFilename
 			mockImageStore.EXPECT().PathForParams(imageType, version, arch).Return(imageFile).AnyTimes()
+			mockImageStore.EXPECT().ArtifactChecksum(version, arch, gomock.Any()).Return(imagestore.ArtifactChecksum{}, false).AnyTimes()
 		}
 
 		expectSuccessfulResponse := func(resp *http.Response, content []byte, artifact string) {
@@ -77,6 +83,15 @@ var _ = Describe("ServeHTTP", func() {
 			expectSuccessfulResponse(resp, []byte("this is rootfs"), "rootfs.img")
 		})
 
+		It("uses the configured default architecture", func() {
+			mockImage("4.8", imagestore.ImageTypeFull, "arm64")
+			armServer := httptest.NewServer(&BootArtifactsHandler{ImageStore: mockImageStore, DefaultArch: "arm64"})
+			defer armServer.Close()
+			resp, err := armServer.Client().Get(armServer.URL + "/boot-artifacts/rootfs?version=4.8")
+			Expect(err).NotTo(HaveOccurred())
+			expectSuccessfulResponse(resp, []byte("this is rootfs"), "rootfs.img")
+		})
+
 		It("returns a rootfs artifact", func() {
 			mockImage("4.8", imagestore.ImageTypeFull, defaultArch)
 			path := fmt.Sprintf("/boot-artifacts/%s?version=4.8", rootfsArtifact)
@@ -91,6 +106,81 @@ var _ = Describe("ServeHTTP", func() {
 			resp, err := client.Get(server.URL + path)
 			Expect(err).NotTo(HaveOccurred())
 			expectSuccessfulResponse(resp, []byte("this is generic.ins"), "generic.ins")
+			Expect(resp.Header.Get("Content-Type")).To(Equal("text/plain; charset=utf-8"))
+		})
+
+		It("returns a ins-file artifact with the configured filename and content type", func() {
+			mockImage("4.15", imagestore.ImageTypeFull, s390xArch)
+			insServer := httptest.NewServer(&BootArtifactsHandler{
+				ImageStore:         mockImageStore,
+				InsFileName:        "zvm.ins",
+				InsFileContentType: "application/octet-stream",
+			})
+			defer insServer.Close()
+			resp, err := insServer.Client().Get(insServer.URL + "/boot-artifacts/ins-file?version=4.15&arch=s390x")
+			Expect(err).NotTo(HaveOccurred())
+			expectSuccessfulResponse(resp, []byte("this is generic.ins"), "zvm.ins")
+			Expect(resp.Header.Get("Content-Type")).To(Equal("application/octet-stream"))
+		})
+
+		It("returns the kernel command line", func() {
+			mockImage("4.8", imagestore.ImageTypeFull, defaultArch)
+			resp, err := client.Get(server.URL + "/boot-artifacts/cmdline?version=4.8")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Content-Type")).To(Equal("text/plain; charset=utf-8"))
+			content, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("coreos.liveiso=rhcos-48 ignition.firstboot\n"))
+		})
+
+		It("returns the kernel command line with the rootfs url", func() {
+			mockImage("4.8", imagestore.ImageTypeFull, defaultArch)
+			resp, err := client.Get(server.URL + "/boot-artifacts/cmdline?version=4.8&rootfs_url=" + url.QueryEscape("https://images.example.com/rootfs.img"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			content, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("coreos.liveiso=rhcos-48 ignition.firstboot coreos.live.rootfs_url=https://images.example.com/rootfs.img\n"))
+		})
+
+		It("fails for an invalid rootfs url", func() {
+			mockImage("4.8", imagestore.ImageTypeFull, defaultArch)
+			resp, err := client.Get(server.URL + "/boot-artifacts/cmdline?version=4.8&rootfs_url=" + url.QueryEscape("https://images.example.com/rootfs.img quiet"))
+			Expect(err).NotTo(HaveOccurred())
+			expectJSONError(resp, http.StatusBadRequest, ErrorCodeInvalidParameter)
+		})
+
+		It("returns the grub config", func() {
+			mockImage("4.8", imagestore.ImageTypeFull, defaultArch)
+			resp, err := client.Get(server.URL + "/boot-artifacts/grub-cfg?version=4.8")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Content-Type")).To(Equal("text/plain; charset=utf-8"))
+			content, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(ContainSubstring("linux /images/pxeboot/vmlinuz coreos.liveiso=rhcos-48 ignition.firstboot"))
+		})
+
+		It("fails for an isolinux config missing from the ISO", func() {
+			mockImage("4.8", imagestore.ImageTypeFull, defaultArch)
+			resp, err := client.Get(server.URL + "/boot-artifacts/isolinux-cfg?version=4.8")
+			Expect(err).NotTo(HaveOccurred())
+			expectJSONError(resp, http.StatusNotFound, ErrorCodeNotFound)
+		})
+
+		It("fails for the isolinux config of an s390x ISO", func() {
+			mockImage("4.15", imagestore.ImageTypeFull, s390xArch)
+			resp, err := client.Get(server.URL + "/boot-artifacts/isolinux-cfg?version=4.15&arch=s390x")
+			Expect(err).NotTo(HaveOccurred())
+			expectJSONError(resp, http.StatusNotFound, ErrorCodeNotFound)
+		})
+
+		It("fails for the boot configs of an unknown version", func() {
+			mockImageStore.EXPECT().HaveVersion("4.7", defaultArch).Return(false)
+			resp, err := client.Get(server.URL + "/boot-artifacts/grub-cfg?version=4.7")
+			Expect(err).NotTo(HaveOccurred())
+			expectJSONError(resp, http.StatusBadRequest, ErrorCodeInvalidParameter)
 		})
 
 		It("Error: returns a ins-file artifact", func() {
@@ -98,7 +188,124 @@ var _ = Describe("ServeHTTP", func() {
 			path := fmt.Sprintf("/boot-artifacts/%s?version=4.8&arch=x86_64", insfileArtifact)
 			resp, err := client.Get(server.URL + path)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			expectJSONError(resp, http.StatusNotFound, ErrorCodeNotFound)
+		})
+
+		Context("with checksums", func() {
+			checksum := imagestore.ArtifactChecksum{
+				SHA256: "3a0b7a8f4b0cbb3c1f6c0bd0e7c1a6c0d5d1a0a4e5b0a1c6f6d4b1e0c9a8b7c6",
+				MD5:    "c29tZW1kNWNoZWNrc3VtIQ==",
+			}
+
+			BeforeEach(func() {
+				mockImageStore.EXPECT().ArtifactChecksum("4.8", defaultArch, "/images/pxeboot/rootfs.img").Return(checksum, true).AnyTimes()
+				mockImage("4.8", imagestore.ImageTypeFull, defaultArch)
+			})
+
+			It("sets the ETag and Content-MD5 headers", func() {
+				for i := 0; i < 2; i++ {
+					resp, err := client.Get(server.URL + "/boot-artifacts/rootfs?version=4.8")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.Header.Get("ETag")).To(Equal(`"` + checksum.SHA256 + `"`))
+					Expect(resp.Header.Get("Content-MD5")).To(Equal(checksum.MD5))
+					// the artifacts are served as-is so the response doesn't depend on request headers
+					Expect(resp.Header.Get("Vary")).To(BeEmpty())
+					expectSuccessfulResponse(resp, []byte("this is rootfs"), "rootfs.img")
+				}
+			})
+
+			It("returns not modified for a matching If-None-Match", func() {
+				req, err := http.NewRequest(http.MethodGet, server.URL+"/boot-artifacts/rootfs?version=4.8", nil)
+				Expect(err).NotTo(HaveOccurred())
+				req.Header.Set("If-None-Match", `"`+checksum.SHA256+`"`)
+				resp, err := client.Do(req)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusNotModified))
+			})
+
+			It("omits Content-MD5 for range requests", func() {
+				req, err := http.NewRequest(http.MethodGet, server.URL+"/boot-artifacts/rootfs?version=4.8", nil)
+				Expect(err).NotTo(HaveOccurred())
+				req.Header.Set("Range", "bytes=0-3")
+				resp, err := client.Do(req)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusPartialContent))
+				Expect(resp.Header.Get("ETag")).To(Equal(`"` + checksum.SHA256 + `"`))
+				Expect(resp.Header.Get("Content-MD5")).To(BeEmpty())
+			})
+
+			It("doesn't set the headers for artifacts without a checksum", func() {
+				resp, err := client.Get(server.URL + "/boot-artifacts/kernel?version=4.8")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Header.Get("ETag")).To(BeEmpty())
+				Expect(resp.Header.Get("Content-MD5")).To(BeEmpty())
+			})
+		})
+
+		Context("with the nmstate archive", func() {
+			var archivePath string
+
+			getNmstate := func(version, secret string) *http.Response {
+				req, err := http.NewRequest(http.MethodGet, server.URL+"/boot-artifacts/nmstate?version="+version, nil)
+				Expect(err).NotTo(HaveOccurred())
+				if secret != "" {
+					req.Header.Set(AdminSecretHeader, secret)
+				}
+				resp, err := client.Do(req)
+				Expect(err).NotTo(HaveOccurred())
+				return resp
+			}
+
+			BeforeEach(func() {
+				f, err := os.CreateTemp("", "nmstate.img")
+				Expect(err).NotTo(HaveOccurred())
+				_, err = f.WriteString("this is the nmstate archive")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+				archivePath = f.Name()
+
+				server.Close()
+				server = httptest.NewServer(&BootArtifactsHandler{ImageStore: mockImageStore, AdminSecret: "supersecret"})
+				client = server.Client()
+
+				mockImage("4.18", imagestore.ImageTypeFull, defaultArch)
+				mockImage("4.8", imagestore.ImageTypeFull, defaultArch)
+				mockImageStore.EXPECT().NmstatectlPathForParams("4.18", defaultArch).Return(archivePath, true).AnyTimes()
+				mockImageStore.EXPECT().NmstatectlPathForParams("4.8", defaultArch).Return("", false).AnyTimes()
+			})
+
+			AfterEach(func() {
+				os.Remove(archivePath)
+			})
+
+			It("returns the archive of a version including nmstatectl", func() {
+				resp := getNmstate("4.18", "supersecret")
+				defer resp.Body.Close()
+				expectSuccessfulResponse(resp, []byte("this is the nmstate archive"), "nmstate-4.18-x86_64.img")
+			})
+
+			It("returns not found for a version predating nmstatectl", func() {
+				resp := getNmstate("4.8", "supersecret")
+				defer resp.Body.Close()
+				expectJSONError(resp, http.StatusNotFound, ErrorCodeNotFound)
+			})
+
+			It("returns not found until nmstatectl is extracted", func() {
+				Expect(os.Remove(archivePath)).To(Succeed())
+				resp := getNmstate("4.18", "supersecret")
+				defer resp.Body.Close()
+				expectJSONError(resp, http.StatusNotFound, ErrorCodeNotFound)
+			})
+
+			It("requires the admin secret", func() {
+				resp := getNmstate("4.18", "")
+				defer resp.Body.Close()
+				expectJSONError(resp, http.StatusUnauthorized, ErrorCodeUnauthorized)
+
+				resp = getNmstate("4.18", "wrong")
+				defer resp.Body.Close()
+				expectJSONError(resp, http.StatusUnauthorized, ErrorCodeUnauthorized)
+			})
 		})
 
 		It("supports HEAD requests", func() {
@@ -110,6 +317,59 @@ var _ = Describe("ServeHTTP", func() {
 			Expect(resp.Header.Get("Content-Disposition")).To(Equal("attachment; filename=rootfs.img"))
 		})
 
+		DescribeTable("returns the exact artifact size for HEAD requests",
+			func(arch, artifact, content string) {
+				mockImage("4.15", imagestore.ImageTypeFull, arch)
+				resp, err := client.Head(server.URL + fmt.Sprintf("/boot-artifacts/%s?version=4.15&arch=%s", artifact, arch))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.ContentLength).To(Equal(int64(len(content))))
+				Expect(resp.Header.Get("Content-Length")).To(Equal(fmt.Sprint(len(content))))
+				Expect(resp.Header.Get("Accept-Ranges")).To(Equal("bytes"))
+			},
+			Entry("kernel", defaultArch, kernelArtifact, "this is kernel"),
+			Entry("rootfs", defaultArch, rootfsArtifact, "this is rootfs"),
+			Entry("ins-file", s390xArch, insfileArtifact, "this is generic.ins"),
+		)
+
+		It("returns a range of an artifact", func() {
+			mockImage("4.8", imagestore.ImageTypeFull, defaultArch)
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/boot-artifacts/rootfs?version=4.8", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Range", "bytes=8-")
+			resp, err := client.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusPartialContent))
+			Expect(resp.Header.Get("Content-Range")).To(Equal("bytes 8-13/14"))
+			content, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("rootfs"))
+		})
+
+		It("returns a range of the kernel", func() {
+			mockImage("4.8", imagestore.ImageTypeFull, defaultArch)
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/boot-artifacts/kernel?version=4.8", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Range", "bytes=0-3")
+			resp, err := client.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusPartialContent))
+			Expect(resp.Header.Get("Content-Range")).To(Equal("bytes 0-3/14"))
+			content, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("this"))
+		})
+
+		It("fails for an unsatisfiable range", func() {
+			mockImage("4.8", imagestore.ImageTypeFull, defaultArch)
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/boot-artifacts/rootfs?version=4.8", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Range", "bytes=100-")
+			resp, err := client.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusRequestedRangeNotSatisfiable))
+		})
+
 		It("fails for a non-existent version", func() {
 			mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, "4.7", defaultArch).Return("").AnyTimes()
 			mockImageStore.EXPECT().HaveVersion("4.7", defaultArch).Return(false)
@@ -119,11 +379,161 @@ var _ = Describe("ServeHTTP", func() {
 			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
 		})
 
+		Context("with cache control", func() {
+			var cachedServer *httptest.Server
+
+			BeforeEach(func() {
+				mockImage("4.8", imagestore.ImageTypeFull, defaultArch)
+				cachedServer = httptest.NewServer(&BootArtifactsHandler{
+					ImageStore:   mockImageStore,
+					CacheControl: "public, max-age=3600, immutable",
+				})
+			})
+
+			AfterEach(func() {
+				cachedServer.Close()
+			})
+
+			It("sets the configured Cache-Control header", func() {
+				for _, artifact := range []string{rootfsArtifact, kernelArtifact, "cmdline"} {
+					resp, err := cachedServer.Client().Get(fmt.Sprintf("%s/boot-artifacts/%s?version=4.8", cachedServer.URL, artifact))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+					Expect(resp.Header.Get("Cache-Control")).To(Equal("public, max-age=3600, immutable"))
+				}
+			})
+
+			It("doesn't set the header on errors", func() {
+				resp, err := cachedServer.Client().Get(cachedServer.URL + "/boot-artifacts/cmdline?version=4.8&rootfs_url=ftp://example.com/rootfs.img")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Header.Get("Cache-Control")).To(BeEmpty())
+				expectJSONError(resp, http.StatusBadRequest, ErrorCodeInvalidParameter)
+			})
+
+			It("doesn't set the header by default", func() {
+				resp, err := client.Get(server.URL + "/boot-artifacts/rootfs?version=4.8")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Header.Get("Cache-Control")).To(BeEmpty())
+			})
+		})
+
+		Context("with an extraction timeout", func() {
+			var (
+				handler   *BootArtifactsHandler
+				release   chan struct{}
+				extracted chan struct{}
+			)
+
+			// openFiles returns the number of files open by the process
+			openFiles := func() int {
+				entries, err := os.ReadDir("/proc/self/fd")
+				Expect(err).NotTo(HaveOccurred())
+				return len(entries)
+			}
+
+			BeforeEach(func() {
+				mockImage("4.8", imagestore.ImageTypeFull, defaultArch)
+				release = make(chan struct{})
+				extracted = make(chan struct{})
+				handler = &BootArtifactsHandler{
+					ImageStore:        mockImageStore,
+					ExtractionTimeout: 100 * time.Millisecond,
+					isoFileInfo: func(filePath, isoPath string) (int64, int64, error) {
+						<-release
+						defer close(extracted)
+						return isoeditor.GetISOFileInfo(filePath, isoPath)
+					},
+				}
+			})
+
+			It("returns the artifact extracted in time", func() {
+				close(release)
+				timedServer := httptest.NewServer(handler)
+				defer timedServer.Close()
+
+				resp, err := timedServer.Client().Get(timedServer.URL + "/boot-artifacts/kernel?version=4.8")
+				Expect(err).NotTo(HaveOccurred())
+				expectSuccessfulResponse(resp, []byte("this is kernel"), "vmlinuz")
+			})
+
+			It("fails with a gateway timeout when the extraction takes too long", func() {
+				defer close(release)
+				timedServer := httptest.NewServer(handler)
+				defer timedServer.Close()
+
+				start := time.Now()
+				resp, err := timedServer.Client().Get(timedServer.URL + "/boot-artifacts/rootfs?version=4.8")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+				expectJSONError(resp, http.StatusGatewayTimeout, ErrorCodeTimeout)
+			})
+
+			It("stops waiting for the extraction when the request is canceled", func() {
+				handler.ExtractionTimeout = 0
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				before := openFiles()
+
+				_, _, _, err := handler.artifactContentWithDeadline(ctx, "/images/pxeboot/rootfs.img", fullImageFilename)
+				Expect(err).To(MatchError(context.Canceled))
+
+				// the abandoned extraction releases the ISO it opened once it completes
+				close(release)
+				Eventually(extracted).Should(BeClosed())
+				Eventually(openFiles).Should(BeNumerically("<=", before))
+				Consistently(openFiles, 200*time.Millisecond).Should(BeNumerically("<=", before))
+			})
+
+			It("fails with a gateway timeout when reading the artifact takes too long", func() {
+				defer close(release)
+				ctx, cancel := context.WithTimeout(context.Background(), handler.ExtractionTimeout)
+				defer cancel()
+				content := &blockingReadSeeker{ReadSeeker: strings.NewReader("this is kernel"), release: release}
+				rec := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, "/boot-artifacts/kernel?version=4.8", nil)
+
+				err := serveArtifactContent(ctx, rec, req, "vmlinuz", time.Now(), content)
+				Expect(err).To(MatchError(context.DeadlineExceeded))
+				Expect(rec.Body.Len()).To(BeZero())
+				Expect(rec.Header().Get("Content-Length")).To(BeEmpty())
+
+				handler.writeExtractionError(rec, "/images/pxeboot/vmlinuz", err)
+				expectJSONError(rec.Result(), http.StatusGatewayTimeout, ErrorCodeTimeout)
+			})
+
+			It("serves the artifact read in time", func() {
+				close(release)
+				ctx, cancel := context.WithTimeout(context.Background(), handler.ExtractionTimeout)
+				defer cancel()
+				content := &blockingReadSeeker{ReadSeeker: strings.NewReader("this is kernel"), release: release}
+				rec := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, "/boot-artifacts/kernel?version=4.8", nil)
+
+				Expect(serveArtifactContent(ctx, rec, req, "vmlinuz", time.Now(), content)).To(Succeed())
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				Expect(rec.Body.String()).To(Equal("this is kernel"))
+			})
+		})
+
+		It("returns not found for a disabled version", func() {
+			ctrl = gomock.NewController(GinkgoT())
+			mockImageStore = imagestore.NewMockImageStore(ctrl)
+			mockImageStore.EXPECT().VersionDisabled("4.8", defaultArch).Return(true)
+			disabledServer := httptest.NewServer(&BootArtifactsHandler{ImageStore: mockImageStore})
+			defer disabledServer.Close()
+
+			path := fmt.Sprintf("/boot-artifacts/%s?version=4.8", rootfsArtifact)
+			resp, err := disabledServer.Client().Get(disabledServer.URL + path)
+			Expect(err).NotTo(HaveOccurred())
+			expectJSONError(resp, http.StatusNotFound, ErrorCodeVersionNotFound)
+		})
+
 		It("fails when no version is supplied", func() {
 			path := fmt.Sprintf("/boot-artifacts/%s", rootfsArtifact)
 			resp, err := client.Get(server.URL + path)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			expectJSONError(resp, http.StatusBadRequest, ErrorCodeInvalidParameter)
 		})
 
 		It("fails when no artifact is supplied", func() {
@@ -144,26 +554,47 @@ var _ = Describe("ServeHTTP", func() {
 			reader := strings.NewReader(`{"stuff": "data"}`)
 			resp, err := client.Post(server.URL+"/boot-artifacts/", "application/json", reader)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(resp.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+			Expect(resp.Header.Get("Allow")).To(Equal("GET, HEAD"))
+			expectJSONError(resp, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed)
 		})
 	})
 })
 
 var _ = DescribeTable("parseArtifact",
-	func(path, arch, artifact string, success bool) {
-		a, err := parseArtifact(path, arch)
+	func(path, arch, insFileName, artifact, fileName string, success bool) {
+		a, f, err := parseArtifact(path, arch, insFileName)
 		if success {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(a).To(Equal(artifact))
+			Expect(f).To(Equal(fileName))
 		} else {
 			Expect(err).To(HaveOccurred())
 		}
 	},
-	Entry("returns rootfs correctly", "/boot-artifacts/rootfs", "x86_64", "rootfs.img", true),
-	Entry("returns kernel correctly", "/boot-artifacts/kernel", "x86_64", "vmlinuz", true),
-	Entry("returns s390x kernel correctly", "/boot-artifacts/kernel", "s390x", "kernel.img", true),
-	Entry("fails for an invalid artifact", "/boot-artifacts/asdf", "x86_64", "", false),
-	Entry("fails for an incorrect path", "/wrong-path/rootfs", "x86_64", "", false),
-	Entry("returns generic.ins correctly", "/boot-artifacts/ins-file", "s390x", "generic.ins", true),
-	Entry("fails generic.ins incorrect arch", "/boot-artifacts/ins-file", "x86_64", "", false),
+	Entry("returns rootfs correctly", "/boot-artifacts/rootfs", "x86_64", "", "rootfs.img", "rootfs.img", true),
+	Entry("returns kernel correctly", "/boot-artifacts/kernel", "x86_64", "", "vmlinuz", "vmlinuz", true),
+	Entry("returns s390x kernel correctly", "/boot-artifacts/kernel", "s390x", "", "kernel.img", "kernel.img", true),
+	Entry("fails for an invalid artifact", "/boot-artifacts/asdf", "x86_64", "", "", "", false),
+	Entry("fails for an incorrect path", "/wrong-path/rootfs", "x86_64", "", "", "", false),
+	Entry("returns generic.ins correctly", "/boot-artifacts/ins-file", "s390x", "", "generic.ins", "generic.ins", true),
+	Entry("fails generic.ins incorrect arch", "/boot-artifacts/ins-file", "x86_64", "", "", "", false),
+	Entry("returns the configured ins-file name", "/boot-artifacts/ins-file", "s390x", "zvm.ins", "generic.ins", "zvm.ins", true),
+	Entry("fails the configured ins-file incorrect arch", "/boot-artifacts/ins-file", "x86_64", "zvm.ins", "", "", false),
+	Entry("returns grub.cfg correctly", "/boot-artifacts/grub-cfg", "s390x", "", "grub.cfg", "grub.cfg", true),
+	Entry("returns isolinux.cfg correctly", "/boot-artifacts/isolinux-cfg", "x86_64", "", "isolinux.cfg", "isolinux.cfg", true),
+	Entry("fails isolinux.cfg for ppc64le", "/boot-artifacts/isolinux-cfg", "ppc64le", "", "", "", false),
+	Entry("fails isolinux.cfg for s390x", "/boot-artifacts/isolinux-cfg", "s390x", "", "", "", false),
+	Entry("returns nmstate correctly", "/boot-artifacts/nmstate", "x86_64", "", "nmstate", "nmstate", true),
+	Entry("doesn't rename other artifacts", "/boot-artifacts/kernel", "s390x", "zvm.ins", "kernel.img", "kernel.img", true),
 )
+
+// blockingReadSeeker blocks reads until release is closed
+type blockingReadSeeker struct {
+	io.ReadSeeker
+	release chan struct{}
+}
+
+func (b *blockingReadSeeker) Read(p []byte) (int, error) {
+	<-b.release
+	return b.ReadSeeker.Read(p)
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrCircuitOpen is returned instead of calling assisted service while the circuit breaker is open
+var ErrCircuitOpen = errors.New("assisted service is unavailable, circuit breaker is open")
+
+type circuitState int
+
+// the values are exported as the circuit breaker state metric
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// CircuitBreaker stops calling assisted service after repeated failures so requests fail
+// fast during an outage. After threshold consecutive failures within window the circuit
+// opens and calls are rejected for cooldown. A single probe call is then let through,
+// closing the circuit when it succeeds and opening it again when it fails.
+type CircuitBreaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	now       func() time.Time
+
+	lock         sync.Mutex
+	state        circuitState
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	probing      bool
+
+	stateGauge prometheus.Gauge
+}
+
+// NewCircuitBreaker creates a circuit breaker for assisted service calls.
+// The state gauge is registered with reg when it is not nil.
+func NewCircuitBreaker(threshold int, window, cooldown time.Duration, reg prometheus.Registerer) (*CircuitBreaker, error) {
+	if threshold <= 0 {
+		return nil, fmt.Errorf("invalid circuit breaker threshold %d: must be positive", threshold)
+	}
+	if window <= 0 || cooldown <= 0 {
+		return nil, fmt.Errorf("invalid circuit breaker window %s or cooldown %s: must be positive", window, cooldown)
+	}
+	cb := &CircuitBreaker{
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+		now:       time.Now,
+		stateGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "assisted_image_service_assisted_service_circuit_state",
+			Help: "State of the assisted service circuit breaker: 0 closed, 1 open, 2 half-open",
+		}),
+	}
+	if reg != nil {
+		if err := reg.Register(cb.stateGauge); err != nil {
+			return nil, fmt.Errorf("failed to register circuit breaker metrics: %w", err)
+		}
+	}
+	return cb, nil
+}
+
+// allow returns ErrCircuitOpen when a call must not be made
+func (cb *CircuitBreaker) allow() error {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	switch cb.state {
+	case circuitOpen:
+		if cb.now().Sub(cb.openedAt) < cb.cooldown {
+			return ErrCircuitOpen
+		}
+		cb.setState(circuitHalfOpen)
+		cb.probing = true
+		return nil
+	case circuitHalfOpen:
+		if cb.probing {
+			return ErrCircuitOpen
+		}
+		cb.probing = true
+	}
+	return nil
+}
+
+// record updates the state with the outcome of an allowed call
+func (cb *CircuitBreaker) record(resp *http.Response, err error) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if cb.state == circuitOpen {
+		// a call made before the circuit opened
+		return
+	}
+	cb.probing = false
+	switch {
+	case errors.Is(err, context.Canceled):
+		// the client went away, this says nothing about assisted service
+	case err != nil || resp.StatusCode >= http.StatusInternalServerError:
+		cb.recordFailure()
+	default:
+		cb.failures = 0
+		cb.setState(circuitClosed)
+	}
+}
+
+func (cb *CircuitBreaker) recordFailure() {
+	now := cb.now()
+	if cb.state == circuitHalfOpen {
+		cb.openedAt = now
+		cb.setState(circuitOpen)
+		return
+	}
+
+	if cb.failures == 0 || now.Sub(cb.firstFailure) > cb.window {
+		cb.failures = 0
+		cb.firstFailure = now
+	}
+	cb.failures++
+	if cb.failures >= cb.threshold {
+		cb.failures = 0
+		cb.openedAt = now
+		cb.setState(circuitOpen)
+	}
+}
+
+func (cb *CircuitBreaker) setState(state circuitState) {
+	if cb.state != state {
+		log.Infof("Assisted service circuit breaker is %s", state)
+	}
+	cb.state = state
+	cb.stateGauge.Set(float64(state))
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("CircuitBreaker", func() {
+	var (
+		reg     *prometheus.Registry
+		breaker *CircuitBreaker
+		now     time.Time
+		failure = errors.New("connection refused")
+		ok      = &http.Response{StatusCode: http.StatusOK}
+	)
+
+	stateMetric := func() float64 {
+		families, err := reg.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() == "assisted_image_service_assisted_service_circuit_state" {
+				return family.GetMetric()[0].GetGauge().GetValue()
+			}
+		}
+		return -1
+	}
+
+	fail := func(times int) {
+		for i := 0; i < times; i++ {
+			Expect(breaker.allow()).To(Succeed())
+			breaker.record(nil, failure)
+		}
+	}
+
+	BeforeEach(func() {
+		reg = prometheus.NewRegistry()
+		var err error
+		breaker, err = NewCircuitBreaker(3, time.Minute, 30*time.Second, reg)
+		Expect(err).NotTo(HaveOccurred())
+		now = time.Now()
+		breaker.now = func() time.Time { return now }
+	})
+
+	It("rejects invalid settings", func() {
+		_, err := NewCircuitBreaker(0, time.Minute, time.Minute, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = NewCircuitBreaker(1, 0, time.Minute, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("goes through closed, open, half-open and closed", func() {
+		Expect(stateMetric()).To(Equal(float64(circuitClosed)))
+		fail(2)
+		Expect(breaker.state).To(Equal(circuitClosed))
+
+		fail(1)
+		Expect(breaker.state).To(Equal(circuitOpen))
+		Expect(stateMetric()).To(Equal(float64(circuitOpen)))
+		Expect(breaker.allow()).To(MatchError(ErrCircuitOpen))
+
+		now = now.Add(30 * time.Second)
+		Expect(breaker.allow()).To(Succeed())
+		Expect(breaker.state).To(Equal(circuitHalfOpen))
+		Expect(stateMetric()).To(Equal(float64(circuitHalfOpen)))
+		// only one probe at a time
+		Expect(breaker.allow()).To(MatchError(ErrCircuitOpen))
+
+		breaker.record(ok, nil)
+		Expect(breaker.state).To(Equal(circuitClosed))
+		Expect(stateMetric()).To(Equal(float64(circuitClosed)))
+		Expect(breaker.allow()).To(Succeed())
+	})
+
+	It("opens again when the probe fails", func() {
+		fail(3)
+		now = now.Add(30 * time.Second)
+		fail(1)
+		Expect(breaker.state).To(Equal(circuitOpen))
+		Expect(breaker.allow()).To(MatchError(ErrCircuitOpen))
+
+		now = now.Add(29 * time.Second)
+		Expect(breaker.allow()).To(MatchError(ErrCircuitOpen))
+		now = now.Add(time.Second)
+		Expect(breaker.allow()).To(Succeed())
+	})
+
+	It("only counts failures within the window", func() {
+		fail(2)
+		now = now.Add(61 * time.Second)
+		fail(2)
+		Expect(breaker.state).To(Equal(circuitClosed))
+		fail(1)
+		Expect(breaker.state).To(Equal(circuitOpen))
+	})
+
+	It("resets the failures after a success", func() {
+		fail(2)
+		Expect(breaker.allow()).To(Succeed())
+		breaker.record(ok, nil)
+		fail(2)
+		Expect(breaker.state).To(Equal(circuitClosed))
+	})
+
+	It("counts server errors but not client errors or cancellations", func() {
+		for i := 0; i < 3; i++ {
+			Expect(breaker.allow()).To(Succeed())
+			breaker.record(&http.Response{StatusCode: http.StatusUnauthorized}, nil)
+			Expect(breaker.allow()).To(Succeed())
+			breaker.record(nil, context.Canceled)
+		}
+		Expect(breaker.state).To(Equal(circuitClosed))
+
+		for i := 0; i < 3; i++ {
+			Expect(breaker.allow()).To(Succeed())
+			breaker.record(&http.Response{StatusCode: http.StatusBadGateway}, nil)
+		}
+		Expect(breaker.state).To(Equal(circuitOpen))
+	})
+
+	It("fails assisted service calls fast while open", func() {
+		server := ghttp.NewServer()
+		defer server.Close()
+		server.RouteToHandler("GET", "/api/assisted-install/v2/infra-envs/bf25292a-dddd-49dc-ab9c-3fb4c1f07071", ghttp.RespondWith(http.StatusServiceUnavailable, ""))
+
+		u, err := url.Parse(server.URL())
+		Expect(err).NotTo(HaveOccurred())
+		c, err := NewAssistedServiceClient(u.Scheme, u.Host, "", WithCircuitBreaker(breaker))
+		Expect(err).NotTo(HaveOccurred())
+		request := httptest.NewRequest(http.MethodGet, "/images/bf25292a-dddd-49dc-ab9c-3fb4c1f07071", nil)
+
+		for i := 0; i < 3; i++ {
+			_, status, err := c.discoveryKernelArguments(request, "bf25292a-dddd-49dc-ab9c-3fb4c1f07071")
+			Expect(err).To(HaveOccurred())
+			Expect(status).To(Equal(http.StatusServiceUnavailable))
+		}
+		Expect(server.ReceivedRequests()).To(HaveLen(3))
+
+		_, status, err := c.discoveryKernelArguments(request, "bf25292a-dddd-49dc-ab9c-3fb4c1f07071")
+		Expect(err).To(MatchError(ErrCircuitOpen))
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+		Expect(server.ReceivedRequests()).To(HaveLen(3))
+
+		server.RouteToHandler("GET", "/api/assisted-install/v2/infra-envs/bf25292a-dddd-49dc-ab9c-3fb4c1f07071", ghttp.RespondWith(http.StatusOK, "{}"))
+		now = now.Add(30 * time.Second)
+		_, _, err = c.discoveryKernelArguments(request, "bf25292a-dddd-49dc-ab9c-3fb4c1f07071")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(breaker.state).To(Equal(circuitClosed))
+		Expect(server.ReceivedRequests()).To(HaveLen(4))
+	})
+
+	It("records one outcome per request whatever its retries", func() {
+		server := ghttp.NewServer()
+		defer server.Close()
+		server.RouteToHandler("GET", "/api/assisted-install/v2/infra-envs/bf25292a-dddd-49dc-ab9c-3fb4c1f07071", ghttp.RespondWith(http.StatusServiceUnavailable, ""))
+
+		u, err := url.Parse(server.URL())
+		Expect(err).NotTo(HaveOccurred())
+		c, err := NewAssistedServiceClient(u.Scheme, u.Host, "", WithCircuitBreaker(breaker), WithRetries(2, time.Millisecond))
+		Expect(err).NotTo(HaveOccurred())
+		request := httptest.NewRequest(http.MethodGet, "/images/bf25292a-dddd-49dc-ab9c-3fb4c1f07071", nil)
+
+		for i := 0; i < 2; i++ {
+			_, status, err := c.discoveryKernelArguments(request, "bf25292a-dddd-49dc-ab9c-3fb4c1f07071")
+			Expect(err).To(HaveOccurred())
+			Expect(status).To(Equal(http.StatusServiceUnavailable))
+		}
+		Expect(server.ReceivedRequests()).To(HaveLen(6))
+		Expect(breaker.state).To(Equal(circuitClosed))
+		Expect(breaker.failures).To(Equal(2))
+	})
+})
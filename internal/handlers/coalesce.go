@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+// fetchCoalescer shares the result of identical concurrent assisted service requests, such as
+// when many hosts of an infra-env boot at once, so assisted service is queried once for all of
+// them. Only the fetched content is shared, each image is still streamed per request.
+type fetchCoalescer struct {
+	group singleflight.Group
+}
+
+type coalescedFetch struct {
+	value      interface{}
+	statusCode int
+	err        error
+}
+
+// do returns the result of fetch, shared with the concurrent calls for the same key and
+// authentication. A nil coalescer calls fetch directly. The shared fetch isn't canceled when
+// the request that started it is, as other requests may be waiting for it.
+func (c *fetchCoalescer) do(r *http.Request, key string, fetch func(*http.Request) (interface{}, int, error)) (interface{}, int, error) {
+	if c == nil {
+		return fetch(r)
+	}
+
+	result := c.group.DoChan(key+"|"+requestAuthKey(r), func() (interface{}, error) {
+		value, statusCode, err := fetch(r.WithContext(context.WithoutCancel(r.Context())))
+		return coalescedFetch{value: value, statusCode: statusCode, err: err}, nil
+	})
+	select {
+	case res := <-result:
+		fetched := res.Val.(coalescedFetch)
+		return fetched.value, fetched.statusCode, fetched.err
+	case <-r.Context().Done():
+		return nil, http.StatusInternalServerError, r.Context().Err()
+	}
+}
+
+// requestAuthKey identifies the authentication setRequestAuth forwards to assisted service so
+// only requests with the same credentials share a fetch
+func requestAuthKey(r *http.Request) string {
+	queryValues := r.URL.Query()
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		chi.URLParam(r, "api_key"),
+		queryValues.Get("api_key"),
+		chi.URLParam(r, "token"),
+		queryValues.Get("image_token"),
+		r.Header.Get("Authorization"),
+	}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+)
+
+var _ = Describe("fetchCoalescer", func() {
+	var (
+		imageID          = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+		assistedServer   *ghttp.Server
+		server           *httptest.Server
+		imageFile        string
+		ignitionRequests int32
+		release          chan struct{}
+	)
+
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		mockImageStore := imagestore.NewMockImageStore(ctrl)
+
+		f, err := os.CreateTemp("", "coalesce")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = f.WriteString("someisocontent")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+		imageFile = f.Name()
+		mockImageStore.EXPECT().HaveVersion("4.8", "x86_64").Return(true).AnyTimes()
+		mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, "4.8", "x86_64").Return(imageFile).AnyTimes()
+
+		atomic.StoreInt32(&ignitionRequests, 0)
+		release = make(chan struct{})
+		assistedServer = ghttp.NewServer()
+		assistedServer.RouteToHandler("GET", fmt.Sprintf(fileRouteFormat, imageID), func(w http.ResponseWriter, _ *http.Request) {
+			atomic.AddInt32(&ignitionRequests, 1)
+			<-release
+			_, _ = w.Write([]byte("someignitioncontent"))
+		})
+		assistedServer.RouteToHandler("GET", fmt.Sprintf(infraEnvPathFormat, imageID), ghttp.RespondWith(http.StatusOK, "{}"))
+
+		u, err := url.Parse(assistedServer.URL())
+		Expect(err).NotTo(HaveOccurred())
+		asc, err := NewAssistedServiceClient(u.Scheme, u.Host, "")
+		Expect(err).NotTo(HaveOccurred())
+
+		handler := &ImageHandler{
+			long: &isoHandler{
+				ImageStore: mockImageStore,
+				GenerateImageStream: func(isoPath string, _ *isoeditor.IgnitionContent, _, _ []byte) (isoeditor.ImageReader, error) {
+					return os.Open(isoPath)
+				},
+				client:    asc,
+				urlParser: parseLongURL,
+				fetches:   &fetchCoalescer{},
+			},
+		}
+		server = httptest.NewServer(handler.router(100))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		assistedServer.Close()
+		os.Remove(imageFile)
+	})
+
+	// download requests the image concurrently with each of the given authorization headers
+	download := func(authorizations ...string) {
+		var wg sync.WaitGroup
+		for _, authorization := range authorizations {
+			wg.Add(1)
+			go func(authorization string) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/images/%s?version=4.8&type=full-iso", server.URL, imageID), nil)
+				Expect(err).NotTo(HaveOccurred())
+				req.Header.Set("Authorization", authorization)
+				resp, err := server.Client().Do(req)
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				body, err := io.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(body).To(Equal([]byte("someisocontent")))
+			}(authorization)
+		}
+		// let all the requests reach assisted service or wait for the one in flight
+		time.Sleep(200 * time.Millisecond)
+		close(release)
+		wg.Wait()
+	}
+
+	It("retrieves the ignition once for concurrent identical requests", func() {
+		download("Bearer token", "Bearer token", "Bearer token", "Bearer token", "Bearer token")
+		Expect(atomic.LoadInt32(&ignitionRequests)).To(Equal(int32(1)))
+	})
+
+	It("doesn't share the ignition between requests with different authentication", func() {
+		download("Bearer token", "Bearer token", "Bearer other")
+		Expect(atomic.LoadInt32(&ignitionRequests)).To(Equal(int32(2)))
+	})
+
+	It("retrieves the ignition again once the previous requests completed", func() {
+		download("Bearer token", "Bearer token")
+		release = make(chan struct{})
+		download("Bearer token")
+		Expect(atomic.LoadInt32(&ignitionRequests)).To(Equal(int32(2)))
+	})
+})
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WithGzipStreams compresses the images streamed to clients accepting gzip encoding, trading
+// CPU for bandwidth. Range requests are always served uncompressed.
+func WithGzipStreams() ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.gzipStreams = true
+	}
+}
+
+// acceptsGzip reports if the request accepts a gzip encoded response
+func acceptsGzip(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(accept, ",") {
+			// the encodings take the same parameters as media types
+			coding, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil || coding != "gzip" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the content of successful responses, leaving the other
+// responses such as 304s as they are
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz        *gzip.Writer
+	committed bool
+	compress  bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(statusCode int) {
+	if w.committed {
+		return
+	}
+	w.committed = true
+	if statusCode == http.StatusOK {
+		w.compress = true
+		// the compressed size isn't known until the image is streamed
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if !w.compress {
+		return w.ResponseWriter.Write(p)
+	}
+	// created with the first write so bodyless responses such as HEAD ones stay empty
+	if w.gz == nil {
+		w.gz, _ = gzip.NewWriterLevel(w.ResponseWriter, gzip.BestSpeed)
+	}
+	return w.gz.Write(p)
+}
+
+// Close writes the end of the compressed content
+func (w *gzipResponseWriter) Close() error {
+	if w.gz == nil {
+		return nil
+	}
+	return w.gz.Close()
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying connection
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// compressedWriter returns the writer the image is served to, compressing it when the handler
+// and the request allow it, and the function ending the compressed content once it's served
+func (h *isoHandler) compressedWriter(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	if !h.gzipStreams {
+		return w, func() {}
+	}
+	addVary(w.Header(), "Accept-Encoding")
+	// the ranges are of the image, not of its compressed content
+	if r.Header.Get("Range") != "" || !acceptsGzip(r) {
+		return w, func() {}
+	}
+	gw := &gzipResponseWriter{ResponseWriter: w}
+	return gw, func() {
+		if err := gw.Close(); err != nil {
+			log.WithError(err).Debug("Failed to end the compressed image stream")
+		}
+	}
+}
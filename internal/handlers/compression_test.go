@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+)
+
+var _ = Describe("gzip image streams", func() {
+	var (
+		server         *httptest.Server
+		assistedServer *ghttp.Server
+		imageFile      string
+		imageContent   = strings.Repeat("someisocontent", 1024)
+		imageID        = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+	)
+
+	get := func(method string, headers map[string]string) *http.Response {
+		req, err := http.NewRequest(method, fmt.Sprintf("%s/byid/%s/4.8/x86_64/full.iso", server.URL, imageID), nil)
+		Expect(err).NotTo(HaveOccurred())
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		// the transport only decompresses the responses to requests it added Accept-Encoding to
+		resp, err := server.Client().Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		return resp
+	}
+
+	BeforeEach(func() {
+		f, err := os.CreateTemp("", "compression")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = f.WriteString(imageContent)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+		imageFile = f.Name()
+
+		assistedServer = ghttp.NewServer()
+		assistedServer.RouteToHandler("GET", fmt.Sprintf(fileRouteFormat, imageID), ghttp.RespondWith(http.StatusOK, ""))
+		assistedServer.RouteToHandler("GET", fmt.Sprintf(infraEnvPathFormat, imageID), ghttp.RespondWith(http.StatusOK, "{}"))
+		u, err := url.Parse(assistedServer.URL())
+		Expect(err).NotTo(HaveOccurred())
+		asc, err := NewAssistedServiceClient(u.Scheme, u.Host, "")
+		Expect(err).NotTo(HaveOccurred())
+
+		mockImageStore := imagestore.NewMockImageStore(gomock.NewController(GinkgoT()))
+		mockImageStore.EXPECT().HaveVersion("4.8", "x86_64").Return(true).AnyTimes()
+		mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, "4.8", "x86_64").Return(imageFile).AnyTimes()
+		h := &isoHandler{
+			ImageStore: mockImageStore,
+			client:     asc,
+			urlParser:  parseShortURL,
+		}
+		WithGzipStreams()(h)
+		server = httptest.NewServer((&ImageHandler{byID: h}).router(1))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		assistedServer.Close()
+		os.Remove(imageFile)
+	})
+
+	It("compresses the image for clients accepting gzip", func() {
+		resp := get(http.MethodGet, map[string]string{"Accept-Encoding": "gzip, deflate"})
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Encoding")).To(Equal("gzip"))
+		Expect(resp.Header.Values("Vary")).To(ContainElement("Accept-Encoding"))
+		Expect(resp.ContentLength).NotTo(Equal(int64(len(imageContent))))
+
+		gz, err := gzip.NewReader(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		content, err := io.ReadAll(gz)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal(imageContent))
+	})
+
+	It("serves ranges uncompressed", func() {
+		resp := get(http.MethodGet, map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=4-6"})
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusPartialContent))
+		Expect(resp.Header.Get("Content-Encoding")).To(BeEmpty())
+		Expect(resp.Header.Get("Content-Range")).To(Equal(fmt.Sprintf("bytes 4-6/%d", len(imageContent))))
+		content, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("iso"))
+	})
+
+	It("doesn't write compressed content in HEAD responses", func() {
+		resp := get(http.MethodHead, map[string]string{"Accept-Encoding": "gzip"})
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Encoding")).To(Equal("gzip"))
+	})
+
+	DescribeTable("serves the image uncompressed",
+		func(acceptEncoding string) {
+			resp := get(http.MethodGet, map[string]string{"Accept-Encoding": acceptEncoding})
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Content-Encoding")).To(BeEmpty())
+			Expect(resp.ContentLength).To(Equal(int64(len(imageContent))))
+			content, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(imageContent))
+		},
+		Entry("for clients only accepting the identity", "identity"),
+		Entry("for clients refusing gzip", "gzip;q=0, identity"),
+		Entry("for clients accepting other encodings", "br, deflate"),
+	)
+})
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Machine readable error codes returned in the body of failed requests
+const (
+	ErrorCodeInvalidParameter    = "invalid_parameter"
+	ErrorCodeNotFound            = "not_found"
+	ErrorCodeVersionNotFound     = "version_not_found"
+	ErrorCodeImageUnavailable    = "image_unavailable"
+	ErrorCodeMethodNotAllowed    = "method_not_allowed"
+	ErrorCodeUnauthorized        = "unauthorized"
+	ErrorCodeForbidden           = "forbidden"
+	ErrorCodeUnsupportedKargs    = "unsupported_kargs"
+	ErrorCodeIgnitionTooLarge    = "ignition_too_large"
+	ErrorCodeInvalidIgnition     = "invalid_ignition"
+	ErrorCodeInvalidKargs        = "invalid_kargs"
+	ErrorCodeImageTooLarge       = "image_too_large"
+	ErrorCodeRedirectUnsupported = "redirect_unsupported"
+	ErrorCodeUpstreamFailure     = "upstream_failure"
+	ErrorCodeTimeout             = "timeout"
+	ErrorCodeQuotaExceeded       = "quota_exceeded"
+	ErrorCodeInternal            = "internal_error"
+)
+
+// HTTPError is written to clients as a JSON object containing the code and message
+type HTTPError struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	// optional machine readable details of the error, depending on the code
+	Details interface{} `json:"details,omitempty"`
+}
+
+// embedAreaDetails are the details of errors for content that doesn't fit in an image embed area
+type embedAreaDetails struct {
+	// what didn't fit, "ignition" or "kernel_arguments"
+	Content       string `json:"content"`
+	ContentSize   int64  `json:"content_size"`
+	EmbedAreaSize int64  `json:"embed_area_size"`
+}
+
+// parameterDetails are the details of errors for a missing or invalid request parameter
+type parameterDetails struct {
+	Parameter string `json:"parameter"`
+	// "missing" or "invalid"
+	Reason string `json:"reason"`
+}
+
+const (
+	parameterMissing = "missing"
+	parameterInvalid = "invalid"
+)
+
+// parameterError returns the 400 error for a missing or invalid request parameter
+func parameterError(parameter, reason, format string, a ...interface{}) *HTTPError {
+	httpErr := NewHTTPError(http.StatusBadRequest, ErrorCodeInvalidParameter, format, a...)
+	httpErr.Details = parameterDetails{Parameter: parameter, Reason: reason}
+	return httpErr
+}
+
+var _ error = &HTTPError{}
+
+func (e *HTTPError) Error() string {
+	return e.Message
+}
+
+func NewHTTPError(statusCode int, code, format string, a ...interface{}) *HTTPError {
+	return &HTTPError{
+		StatusCode: statusCode,
+		Code:       code,
+		Message:    fmt.Sprintf(format, a...),
+	}
+}
+
+// errorCodeForStatus returns a generic error code for cases where the status
+// is not known ahead of time, such as when it is passed through from assisted-service
+func errorCodeForStatus(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return ErrorCodeInvalidParameter
+	case http.StatusUnauthorized:
+		return ErrorCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrorCodeForbidden
+	case http.StatusNotFound:
+		return ErrorCodeNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrorCodeMethodNotAllowed
+	case http.StatusInternalServerError:
+		return ErrorCodeInternal
+	}
+	return ErrorCodeUpstreamFailure
+}
+
+func writeHTTPError(w http.ResponseWriter, httpErr *HTTPError) {
+	// errors must not be cached with the caching policy of the content
+	w.Header().Del("Cache-Control")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(httpErr.StatusCode)
+	if err := json.NewEncoder(w).Encode(httpErr); err != nil {
+		log.Errorf("Failed to write error response: %v", err)
+	}
+}
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"encoding/json"
 	"io"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -18,7 +20,40 @@ func TestHandlers(t *testing.T) {
 	RunSpecs(t, "handlers")
 }
 
+func expectJSONError(resp *http.Response, statusCode int, code string) {
+	Expect(resp.StatusCode).To(Equal(statusCode))
+	Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+	var httpErr struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	Expect(json.NewDecoder(resp.Body).Decode(&httpErr)).To(Succeed())
+	Expect(httpErr.Code).To(Equal(code))
+	Expect(httpErr.Message).NotTo(BeEmpty())
+}
+
+// expectParameterError checks that resp is the 400 error for a missing or invalid request parameter
+func expectParameterError(resp *http.Response, parameter, reason, message string) {
+	Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+	Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+	var httpErr struct {
+		Code    string           `json:"code"`
+		Message string           `json:"message"`
+		Details parameterDetails `json:"details"`
+	}
+	Expect(json.NewDecoder(resp.Body).Decode(&httpErr)).To(Succeed())
+	Expect(httpErr.Code).To(Equal(ErrorCodeInvalidParameter))
+	Expect(httpErr.Message).To(Equal(message))
+	Expect(httpErr.Details).To(Equal(parameterDetails{Parameter: parameter, Reason: reason}))
+}
+
 func createTestISO() string {
+	return createTestISOWithFiles(nil)
+}
+
+// createTestISOWithFiles creates the test ISO with extra files added or overriding the defaults,
+// keyed by their path in the ISO
+func createTestISOWithFiles(files map[string]string) string {
 	filesDir, err := os.MkdirTemp("", "isotest")
 	Expect(err).ToNot(HaveOccurred())
 	defer os.RemoveAll(filesDir)
@@ -32,8 +67,14 @@ func createTestISO() string {
 	Expect(os.WriteFile(filepath.Join(filesDir, "images/pxeboot/vmlinuz"), []byte("this is kernel"), 0600)).To(Succeed())
 	Expect(os.WriteFile(filepath.Join(filesDir, "images/pxeboot/initrd.img"), []byte("this is initrd"), 0600)).To(Succeed())
 	Expect(os.WriteFile(filepath.Join(filesDir, "generic.ins"), []byte("this is generic.ins"), 0600)).To(Succeed())
+	Expect(os.MkdirAll(filepath.Join(filesDir, "EFI/redhat"), 0755)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(filesDir, "EFI/redhat/grub.cfg"), []byte("menuentry 'RHEL CoreOS (Live)' {\n\tlinux /images/pxeboot/vmlinuz coreos.liveiso=rhcos-48 ignition.firstboot\n\tinitrd /images/pxeboot/initrd.img /images/ignition.img\n}\n"), 0600)).To(Succeed())
 	Expect(os.WriteFile(filepath.Join(filesDir, "images/initrd.addrsize"), []byte{
 		1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4, 5, 6, 7, 8}, 0600)).To(Succeed())
+	for path, content := range files {
+		Expect(os.MkdirAll(filepath.Join(filesDir, filepath.Dir(path)), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(filesDir, path), []byte(content), 0600)).To(Succeed())
+	}
 
 	cmd := exec.Command("genisoimage", "-rational-rock", "-J", "-joliet-long", "-o", isoFile, filesDir)
 	Expect(cmd.Run()).To(Succeed())
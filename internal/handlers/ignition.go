@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+)
+
+// ignitionHandler serves the ignition that would be embedded in an image, either as the raw
+// config or as the compressed cpio archive appended to the image initrd. It is meant for debugging.
+type ignitionHandler struct {
+	client *AssistedServiceClient
+	// the endpoint is not found unless enabled
+	enabled bool
+	// must be sent in the AdminSecretHeader, requests are rejected when empty
+	secret string
+	// applied when the ignition is archived
+	ignitionArchiveOpts []isoeditor.ArchiveOption
+}
+
+var _ http.Handler = &ignitionHandler{}
+
+func (h *ignitionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.enabled {
+		httpErrorf(w, http.StatusNotFound, ErrorCodeNotFound, "Unrecognized image URL %s", r.URL.Path)
+		return
+	}
+	if !adminAuthorized(r, h.secret) {
+		httpErrorf(w, http.StatusUnauthorized, ErrorCodeUnauthorized, "Missing or invalid %s header", AdminSecretHeader)
+		return
+	}
+
+	imageID := chi.URLParam(r, "image_id")
+	values := r.URL.Query()
+
+	imageType := values.Get("type")
+	if imageType != "" && imageType != imagestore.ImageTypeFull && imageType != imagestore.ImageTypeMinimal {
+		httpErrorf(w, http.StatusBadRequest, ErrorCodeInvalidParameter, "invalid value '%s' for parameter 'type'", imageType)
+		return
+	}
+
+	archived := false
+	if value := values.Get("archived"); value != "" {
+		var err error
+		archived, err = strconv.ParseBool(value)
+		if err != nil {
+			httpErrorf(w, http.StatusBadRequest, ErrorCodeInvalidParameter, "invalid value '%s' for parameter 'archived'", value)
+			return
+		}
+	}
+
+	ignition, lastModified, code, err := h.client.ignitionContent(r, imageID, imageType)
+	if err != nil {
+		httpErrorf(w, code, errorCodeForStatus(code), "error retrieving ignition content: %v", err)
+		return
+	}
+
+	modTime, err := http.ParseTime(lastModified)
+	if err != nil {
+		log.Warnf("Error parsing last modified time %s: %v", lastModified, err)
+		modTime = time.Now()
+	}
+
+	if !archived {
+		fileName := fmt.Sprintf("%s.ign", imageID)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
+		http.ServeContent(w, r, fileName, modTime, bytes.NewReader(ignition.Config))
+		return
+	}
+
+	archive, err := ignition.Archive(h.ignitionArchiveOpts...)
+	if err != nil {
+		httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "failed to archive ignition content: %v", err)
+		return
+	}
+	fileName := fmt.Sprintf("%s-ignition.img", imageID)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
+	http.ServeContent(w, r, fileName, modTime, archive)
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/cavaliercoder/go-cpio"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+)
+
+var _ = Describe("ignitionHandler", func() {
+	var (
+		imageID         = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+		ignitionContent = []byte(`{"ignition":{"version":"3.1.0"}}`)
+		assistedServer  *ghttp.Server
+		server          *httptest.Server
+		client          *http.Client
+		header          = http.Header{}
+		adminSecret     = "admin"
+	)
+
+	BeforeEach(func() {
+		header.Set("Last-Modified", "Fri, 22 Apr 2022 18:11:09 GMT")
+		assistedServer = ghttp.NewServer()
+		u, err := url.Parse(assistedServer.URL())
+		Expect(err).NotTo(HaveOccurred())
+
+		asc, err := NewAssistedServiceClient(u.Scheme, u.Host, "")
+		Expect(err).NotTo(HaveOccurred())
+
+		handler := &ImageHandler{
+			ignition: &ignitionHandler{client: asc, enabled: true, secret: adminSecret},
+		}
+		server = httptest.NewServer(handler.router(1))
+		client = server.Client()
+	})
+
+	AfterEach(func() {
+		assistedServer.Close()
+		server.Close()
+	})
+
+	getFrom := func(srv *httptest.Server, query string) *http.Response {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/images/%s/ignition?%s", srv.URL, imageID, query), nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set(AdminSecretHeader, adminSecret)
+		resp, err := srv.Client().Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		return resp
+	}
+
+	get := func(query string) *http.Response {
+		return getFrom(server, query)
+	}
+
+	It("returns the raw ignition", func() {
+		assistedServer.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", fmt.Sprintf(fileRouteFormat, imageID), "discovery_iso_type=minimal-iso&file_name=discovery.ign"),
+				ghttp.VerifyHeaderKV("Authorization", "Bearer mytoken"),
+				ghttp.RespondWith(http.StatusOK, ignitionContent, header),
+			),
+		)
+
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/images/%s/ignition?version=4.11&type=minimal-iso", server.URL, imageID), nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Authorization", "Bearer mytoken")
+		req.Header.Set(AdminSecretHeader, adminSecret)
+		resp, err := client.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+		Expect(resp.Header.Get("Content-Disposition")).To(Equal(fmt.Sprintf("attachment; filename=%s.ign", imageID)))
+		Expect(resp.Header.Get("Last-Modified")).To(Equal("Fri, 22 Apr 2022 18:11:09 GMT"))
+		body, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(body).To(Equal(ignitionContent))
+	})
+
+	It("returns the archived ignition", func() {
+		assistedServer.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", fmt.Sprintf(fileRouteFormat, imageID), "discovery_iso_type=full-iso&file_name=discovery.ign"),
+				ghttp.RespondWith(http.StatusOK, ignitionContent, header),
+			),
+		)
+
+		resp := get("version=4.11&type=full-iso&archived=true")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).To(Equal("application/octet-stream"))
+		Expect(resp.Header.Get("Content-Disposition")).To(Equal(fmt.Sprintf("attachment; filename=%s-ignition.img", imageID)))
+
+		gz, err := gzip.NewReader(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		archive := cpio.NewReader(gz)
+		hdr, err := archive.Next()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hdr.Name).To(Equal("config.ign"))
+		content, err := io.ReadAll(archive)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(content).To(Equal(ignitionContent))
+	})
+
+	It("archives the ignition under the configured name", func() {
+		assistedServer.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", fmt.Sprintf(fileRouteFormat, imageID)),
+				ghttp.RespondWith(http.StatusOK, ignitionContent, header),
+			),
+		)
+		u, err := url.Parse(assistedServer.URL())
+		Expect(err).NotTo(HaveOccurred())
+		asc, err := NewAssistedServiceClient(u.Scheme, u.Host, "")
+		Expect(err).NotTo(HaveOccurred())
+		handler := &ImageHandler{
+			ignition: &ignitionHandler{
+				client:              asc,
+				enabled:             true,
+				secret:              adminSecret,
+				ignitionArchiveOpts: []isoeditor.ArchiveOption{isoeditor.WithConfigFileName("custom.ign")},
+			},
+		}
+		customServer := httptest.NewServer(handler.router(1))
+		defer customServer.Close()
+
+		resp := getFrom(customServer, "archived=true")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		gz, err := gzip.NewReader(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		archive := cpio.NewReader(gz)
+		hdr, err := archive.Next()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hdr.Name).To(Equal("custom.ign"))
+	})
+
+	It("adds the configured extra files to the archive", func() {
+		assistedServer.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", fmt.Sprintf(fileRouteFormat, imageID)),
+				ghttp.RespondWith(http.StatusOK, ignitionContent, header),
+			),
+		)
+		u, err := url.Parse(assistedServer.URL())
+		Expect(err).NotTo(HaveOccurred())
+		asc, err := NewAssistedServiceClient(u.Scheme, u.Host, "")
+		Expect(err).NotTo(HaveOccurred())
+		handler := &ImageHandler{
+			ignition: &ignitionHandler{
+				client:              asc,
+				enabled:             true,
+				secret:              adminSecret,
+				ignitionArchiveOpts: []isoeditor.ArchiveOption{isoeditor.WithExtraFile("etc/issue.d/50-branding.issue", []byte("Welcome to ACME"))},
+			},
+		}
+		brandingServer := httptest.NewServer(handler.router(1))
+		defer brandingServer.Close()
+
+		resp := getFrom(brandingServer, "archived=true")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		gz, err := gzip.NewReader(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		archive := cpio.NewReader(gz)
+		hdr, err := archive.Next()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hdr.Name).To(Equal("config.ign"))
+		hdr, err = archive.Next()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hdr.Name).To(Equal("etc/issue.d/50-branding.issue"))
+		content, err := io.ReadAll(archive)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("Welcome to ACME"))
+	})
+
+	It("passes through assisted service errors", func() {
+		assistedServer.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", fmt.Sprintf(fileRouteFormat, imageID)),
+				ghttp.RespondWith(http.StatusUnauthorized, "unauthorized"),
+			),
+		)
+
+		expectJSONError(get("type=full-iso"), http.StatusUnauthorized, ErrorCodeUnauthorized)
+	})
+
+	It("rejects requests without the admin secret", func() {
+		resp, err := client.Get(fmt.Sprintf("%s/images/%s/ignition", server.URL, imageID))
+		Expect(err).NotTo(HaveOccurred())
+		expectJSONError(resp, http.StatusUnauthorized, ErrorCodeUnauthorized)
+		Expect(assistedServer.ReceivedRequests()).To(BeEmpty())
+	})
+
+	It("is not found unless enabled", func() {
+		handler := &ImageHandler{
+			ignition: &ignitionHandler{client: &AssistedServiceClient{}, secret: adminSecret},
+		}
+		disabledServer := httptest.NewServer(handler.router(1))
+		defer disabledServer.Close()
+
+		expectJSONError(getFrom(disabledServer, ""), http.StatusNotFound, ErrorCodeNotFound)
+	})
+
+	It("rejects an invalid type", func() {
+		expectJSONError(get("type=bogus"), http.StatusBadRequest, ErrorCodeInvalidParameter)
+		Expect(assistedServer.ReceivedRequests()).To(BeEmpty())
+	})
+
+	It("rejects an invalid archived value", func() {
+		expectJSONError(get("archived=maybe"), http.StatusBadRequest, ErrorCodeInvalidParameter)
+		Expect(assistedServer.ReceivedRequests()).To(BeEmpty())
+	})
+})
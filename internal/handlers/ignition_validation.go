@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// ignitionVersionRegexp matches the ignition spec versions, such as 3.2.0 or 3.5.0-experimental
+var ignitionVersionRegexp = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+(-experimental)?$`)
+
+// WithIgnitionValidation checks that the ignition returned by assisted service is a JSON object
+// with an ignition version before embedding it, failing the request with a 502 instead of
+// serving an image that can't boot. Only the structure is checked, not the ignition spec.
+func WithIgnitionValidation() ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.validateIgnition = true
+	}
+}
+
+// validateIgnitionConfig returns why config isn't an ignition config, an empty config isn't embedded
+func validateIgnitionConfig(config []byte) error {
+	if len(config) == 0 {
+		return nil
+	}
+	var content struct {
+		Ignition *struct {
+			Version *string `json:"version"`
+		} `json:"ignition"`
+	}
+	if !json.Valid(config) {
+		return fmt.Errorf("not valid JSON")
+	}
+	if err := json.Unmarshal(config, &content); err != nil {
+		return fmt.Errorf("not a JSON object")
+	}
+	if content.Ignition == nil {
+		return fmt.Errorf("'ignition' section missing")
+	}
+	if content.Ignition.Version == nil {
+		return fmt.Errorf("'ignition.version' missing")
+	}
+	if !ignitionVersionRegexp.MatchString(*content.Ignition.Version) {
+		return fmt.Errorf("invalid 'ignition.version' %q", *content.Ignition.Version)
+	}
+	return nil
+}
+
+// invalidIgnitionError returns the 502 error for the invalid ignition assisted service returned for imageID
+func invalidIgnitionError(imageID string, err error) *HTTPError {
+	return NewHTTPError(http.StatusBadGateway, ErrorCodeInvalidIgnition, "Invalid ignition content for image %s: %v", imageID, err)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+)
+
+var _ = Describe("Ignition validation", func() {
+	DescribeTable("validateIgnitionConfig accepts",
+		func(config string) {
+			Expect(validateIgnitionConfig([]byte(config))).To(Succeed())
+		},
+		Entry("a config with a version", `{"ignition": {"version": "3.2.0"}}`),
+		Entry("an experimental version", `{"ignition": {"version": "3.5.0-experimental"}, "storage": {}}`),
+		Entry("an empty config", ``),
+	)
+
+	DescribeTable("validateIgnitionConfig rejects",
+		func(config, reason string) {
+			Expect(validateIgnitionConfig([]byte(config))).To(MatchError(ContainSubstring(reason)))
+		},
+		Entry("invalid JSON", `{"ignition": {"version": "3.2.0"}`, "not valid JSON"),
+		Entry("a JSON array", `[]`, "not a JSON object"),
+		Entry("a config without ignition section", `{"storage": {}}`, "'ignition' section missing"),
+		Entry("a config without version", `{"ignition": {}}`, "'ignition.version' missing"),
+		Entry("a malformed version", `{"ignition": {"version": "three"}}`, `invalid 'ignition.version' "three"`),
+	)
+
+	Context("serving images", func() {
+		var (
+			server         *httptest.Server
+			assistedServer *ghttp.Server
+			imageFile      string
+			imageID        = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+		)
+
+		startServer := func(ignition string, opts ...ImageHandlerOption) {
+			assistedServer.RouteToHandler("GET", fmt.Sprintf(fileRouteFormat, imageID), ghttp.RespondWith(http.StatusOK, ignition))
+			u, err := url.Parse(assistedServer.URL())
+			Expect(err).NotTo(HaveOccurred())
+			asc, err := NewAssistedServiceClient(u.Scheme, u.Host, "")
+			Expect(err).NotTo(HaveOccurred())
+
+			mockImageStore := imagestore.NewMockImageStore(gomock.NewController(GinkgoT()))
+			mockImageStore.EXPECT().HaveVersion("4.8", "x86_64").Return(true).AnyTimes()
+			mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, "4.8", "x86_64").Return(imageFile).AnyTimes()
+			h := &isoHandler{
+				ImageStore: mockImageStore,
+				GenerateImageStream: func(isoPath string, _ *isoeditor.IgnitionContent, _, _ []byte) (isoeditor.ImageReader, error) {
+					return os.Open(isoPath)
+				},
+				client:    asc,
+				urlParser: parseShortURL,
+			}
+			for _, opt := range opts {
+				opt(h)
+			}
+			server = httptest.NewServer((&ImageHandler{byID: h}).router(1))
+		}
+
+		get := func() *http.Response {
+			resp, err := server.Client().Get(fmt.Sprintf("%s/byid/%s/4.8/x86_64/full.iso", server.URL, imageID))
+			Expect(err).NotTo(HaveOccurred())
+			return resp
+		}
+
+		BeforeEach(func() {
+			f, err := os.CreateTemp("", "ignition_validation")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = f.WriteString("someisocontent")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(f.Close()).To(Succeed())
+			imageFile = f.Name()
+
+			assistedServer = ghttp.NewServer()
+			assistedServer.RouteToHandler("GET", fmt.Sprintf(infraEnvPathFormat, imageID), ghttp.RespondWith(http.StatusOK, "{}"))
+		})
+
+		AfterEach(func() {
+			server.Close()
+			assistedServer.Close()
+			os.Remove(imageFile)
+		})
+
+		It("serves images embedding a valid ignition", func() {
+			startServer(`{"ignition": {"version": "3.2.0"}}`, WithIgnitionValidation())
+			resp := get()
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			content, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("someisocontent"))
+		})
+
+		It("fails the requests for images embedding a malformed ignition", func() {
+			startServer(`{"ignition": {"version": `, WithIgnitionValidation())
+			resp := get()
+			defer resp.Body.Close()
+			expectJSONError(resp, http.StatusBadGateway, ErrorCodeInvalidIgnition)
+		})
+
+		It("serves images embedding a malformed ignition without validation", func() {
+			startServer(`{"ignition": {"version": `)
+			resp := get()
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+})
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+)
+
+// ImageSizeLimit rejects the images larger than a sanity bound before they are streamed or
+// checksummed, so a misconfigured base ISO, such as the wrong or a huge file, doesn't waste
+// bandwidth
+type ImageSizeLimit struct {
+	maxSize  int64
+	rejected prometheus.Counter
+}
+
+// NewImageSizeLimit creates a limit rejecting images larger than maxSize bytes, with the
+// rejections counter registered with reg when it is not nil
+func NewImageSizeLimit(maxSize int64, reg prometheus.Registerer) (*ImageSizeLimit, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid maximum image size %d: must be positive", maxSize)
+	}
+	l := &ImageSizeLimit{
+		maxSize: maxSize,
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "assisted_image_service_oversized_images_total",
+			Help: "Number of image requests rejected because the image exceeds the maximum image size",
+		}),
+	}
+	if reg != nil {
+		if err := reg.Register(l.rejected); err != nil {
+			return nil, fmt.Errorf("failed to register image size limit metrics: %w", err)
+		}
+	}
+	return l, nil
+}
+
+// WithImageSizeLimit rejects the image downloads and checksums of images exceeding the limit
+func WithImageSizeLimit(limit *ImageSizeLimit) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.imageSizeLimit = limit
+	}
+}
+
+// rejectOversized writes a 500 error when the image read by isoReader exceeds the limit,
+// leaving isoReader at its start otherwise
+func (l *ImageSizeLimit) rejectOversized(w http.ResponseWriter, params *imageDownloadParams, isoReader isoeditor.ImageReader) bool {
+	if l == nil {
+		return false
+	}
+	size, err := isoReader.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = isoReader.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "Error sizing image: %v", err)
+		return true
+	}
+	if size <= l.maxSize {
+		return false
+	}
+	l.rejected.Inc()
+	log.Errorf("Image %s %s %s %s of %d bytes is larger than the configured maximum of %d bytes, check the base ISO",
+		params.imageID, params.imageType, params.version, params.arch, size, l.maxSize)
+	httpErrorf(w, http.StatusInternalServerError, ErrorCodeImageTooLarge, "image larger than configured maximum: %d bytes exceeds %d bytes", size, l.maxSize)
+	return true
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+)
+
+var _ = Describe("ImageSizeLimit", func() {
+	var (
+		server         *httptest.Server
+		assistedServer *ghttp.Server
+		imageFile      string
+		reg            *prometheus.Registry
+		imageID        = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+	)
+
+	startServer := func(maxSize int64) {
+		reg = prometheus.NewRegistry()
+		limit, err := NewImageSizeLimit(maxSize, reg)
+		Expect(err).NotTo(HaveOccurred())
+
+		u, err := url.Parse(assistedServer.URL())
+		Expect(err).NotTo(HaveOccurred())
+		asc, err := NewAssistedServiceClient(u.Scheme, u.Host, "")
+		Expect(err).NotTo(HaveOccurred())
+
+		mockImageStore := imagestore.NewMockImageStore(gomock.NewController(GinkgoT()))
+		mockImageStore.EXPECT().HaveVersion("4.8", "x86_64").Return(true).AnyTimes()
+		mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, "4.8", "x86_64").Return(imageFile).AnyTimes()
+		newHandler := func(checksumOnly bool) *isoHandler {
+			h := &isoHandler{
+				ImageStore:   mockImageStore,
+				client:       asc,
+				urlParser:    parseLongURL,
+				checksumOnly: checksumOnly,
+			}
+			WithImageSizeLimit(limit)(h)
+			return h
+		}
+		server = httptest.NewServer((&ImageHandler{long: newHandler(false), checksum: newHandler(true)}).router(1))
+	}
+
+	get := func(path string) *http.Response {
+		resp, err := server.Client().Get(fmt.Sprintf("%s/images/%s%s?version=4.8&type=full-iso", server.URL, imageID, path))
+		Expect(err).NotTo(HaveOccurred())
+		return resp
+	}
+
+	rejected := func() float64 {
+		families, err := reg.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() == "assisted_image_service_oversized_images_total" {
+				return family.GetMetric()[0].GetCounter().GetValue()
+			}
+		}
+		Fail("oversized images metric not found")
+		return 0
+	}
+
+	BeforeEach(func() {
+		f, err := os.CreateTemp("", "image_size_limit")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = f.WriteString(strings.Repeat("x", 4096))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+		imageFile = f.Name()
+
+		assistedServer = ghttp.NewServer()
+		assistedServer.RouteToHandler("GET", fmt.Sprintf(fileRouteFormat, imageID), ghttp.RespondWith(http.StatusOK, ""))
+		assistedServer.RouteToHandler("GET", fmt.Sprintf(infraEnvPathFormat, imageID), ghttp.RespondWith(http.StatusOK, "{}"))
+	})
+
+	AfterEach(func() {
+		if server != nil {
+			server.Close()
+			server = nil
+		}
+		assistedServer.Close()
+		os.Remove(imageFile)
+	})
+
+	It("rejects invalid limits", func() {
+		_, err := NewImageSizeLimit(0, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = NewImageSizeLimit(-1, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects images larger than the limit", func() {
+		startServer(1024)
+		resp := get("")
+		defer resp.Body.Close()
+		expectJSONError(resp, http.StatusInternalServerError, ErrorCodeImageTooLarge)
+		Expect(rejected()).To(Equal(float64(1)))
+	})
+
+	It("rejects the checksums of images larger than the limit", func() {
+		startServer(1024)
+		resp := get("/checksum")
+		defer resp.Body.Close()
+		expectJSONError(resp, http.StatusInternalServerError, ErrorCodeImageTooLarge)
+		Expect(rejected()).To(Equal(float64(1)))
+	})
+
+	It("serves images within the limit", func() {
+		startServer(4096)
+		resp := get("")
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		content, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(content).To(HaveLen(4096))
+		Expect(rejected()).To(BeZero())
+	})
+})
@@ -4,6 +4,8 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	goversion "github.com/hashicorp/go-version"
+	log "github.com/sirupsen/logrus"
 	metricsmiddleware "github.com/slok/go-http-metrics/middleware"
 	stdmiddleware "github.com/slok/go-http-metrics/middleware/std"
 
@@ -11,61 +13,229 @@ import (
 	"github.com/openshift/assisted-image-service/pkg/isoeditor"
 )
 
+// defaultArch is used for requests that don't specify an architecture unless another default is configured
 const defaultArch = "x86_64"
 
+// resolveArch returns arch, or the default architecture when arch is empty
+func resolveArch(arch, configuredDefault string) string {
+	if arch != "" {
+		return arch
+	}
+	if configuredDefault != "" {
+		return configuredDefault
+	}
+	return defaultArch
+}
+
+// latestVersion is the version alias resolved to the highest available version of an architecture
+const latestVersion = "latest"
+
+// resolveVersion returns version, or the highest available version for arch when version is
+// the latest alias. Versions are ordered as by common.VersionGreaterOrEqual, so a pre-release
+// sorts before its release. The alias is returned as is when no version is available for arch.
+func resolveVersion(is imagestore.ImageStore, version, arch string) string {
+	if version != latestVersion {
+		return version
+	}
+	var latest *goversion.Version
+	resolved := version
+	for _, info := range is.AvailableVersions() {
+		if info.CPUArchitecture != arch {
+			continue
+		}
+		v, err := goversion.NewVersion(info.OpenshiftVersion)
+		if err != nil {
+			log.Warnf("Ignoring version %s with an invalid format when resolving %s: %v", info.OpenshiftVersion, latestVersion, err)
+			continue
+		}
+		if latest == nil || v.GreaterThan(latest) {
+			latest = v
+			resolved = info.OpenshiftVersion
+		}
+	}
+	return resolved
+}
+
 type ImageHandler struct {
 	long                http.Handler
+	checksum            http.Handler
 	byAPIKey            http.Handler
 	byID                http.Handler
 	byToken             http.Handler
 	initrd              http.Handler
 	s390xInitrdAddrsize http.Handler
+	ignition            http.Handler
+	kargsInfo           http.Handler
 }
 
-func NewImageHandler(is imagestore.ImageStore, assistedServiceClient *AssistedServiceClient, maxRequests int64, mdw metricsmiddleware.Middleware) http.Handler {
+// ImageHandlerOption configures the ISO download handlers
+type ImageHandlerOption func(*isoHandler)
+
+// WithISORedirect redirects full ISO downloads to the given location instead of streaming them
+func WithISORedirect(redirect *ISORedirect) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.redirect = redirect
+	}
+}
+
+// WithMinimalISOCache serves repeated minimal ISO downloads with identical content from the given cache
+func WithMinimalISOCache(cache *ISOCache) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.minimalISOCache = cache
+	}
+}
+
+// WithKargsOptional serves images that have no room to embed kernel arguments without them
+// instead of failing the request
+func WithKargsOptional() ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.kargsOptional = true
+	}
+}
+
+// WithDefaultArch sets the architecture used for requests that don't specify one
+func WithDefaultArch(arch string) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.defaultArch = arch
+	}
+}
+
+// WithDefaultImageType sets the image type used for requests that don't specify one, which
+// are rejected when it's not set
+func WithDefaultImageType(imageType string) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.defaultImageType = imageType
+	}
+}
+
+// WithIgnitionOverride lets POST requests supply the ignition to embed in their body instead
+// of retrieving it from assisted service. This is meant for development and is unsafe in production.
+func WithIgnitionOverride() ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.ignitionOverride = true
+	}
+}
+
+// WithIgnitionEndpoint serves the ignition embedded in images at /images/{image_id}/ignition to the
+// requests authorized by the admin secret
+func WithIgnitionEndpoint() ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.ignitionEndpoint = true
+	}
+}
+
+// WithAdminSecret sets the secret the debugging endpoints require in the AdminSecretHeader, they
+// reject all requests when it's not set
+func WithAdminSecret(secret string) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.adminSecret = secret
+	}
+}
+
+// WithCacheControl sets the Cache-Control header of image downloads
+func WithCacheControl(value string) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.cacheControl = value
+	}
+}
+
+// WithFallbackIgnition embeds ignition in images whose ignition can't be retrieved because
+// assisted service is failing or unreachable, so hosts can still boot into a recovery
+// environment. Authentication and not found errors are still returned to the client.
+func WithFallbackIgnition(ignition []byte) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.fallbackIgnition = ignition
+	}
+}
+
+// WithMappedBaseFiles reads the base ISOs that images are served from through files, which
+// keeps them mapped in memory
+func WithMappedBaseFiles(files *isoeditor.MappedFiles) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.openBaseFile = files.Open
+	}
+}
+
+// WithKargs embeds the kernel arguments into the files of the images located by kargs
+func WithKargs(kargs *isoeditor.Kargs) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.kargs = kargs
+	}
+}
+
+// WithSlowRequestTracker reports image requests that take longer than the tracker threshold
+func WithSlowRequestTracker(tracker *SlowRequestTracker) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.slowRequests = tracker
+	}
+}
+
+// WithIgnitionArchiveOptions sets how the ignition is archived when it's embedded in images and initrds,
+// in addition to the options set by earlier calls
+func WithIgnitionArchiveOptions(opts ...isoeditor.ArchiveOption) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.ignitionArchiveOpts = append(h.ignitionArchiveOpts, opts...)
+	}
+}
+
+func NewImageHandler(is imagestore.ImageStore, assistedServiceClient *AssistedServiceClient, maxRequests int64, mdw metricsmiddleware.Middleware, opts ...ImageHandlerOption) http.Handler {
+	// shared by all the image handlers as their requests fetch the same content
+	fetches := &fetchCoalescer{}
+	newISOHandler := func(newURLParser func(defaultImageType string) urlParserFunc) *isoHandler {
+		h := &isoHandler{
+			ImageStore: is,
+			client:     assistedServiceClient,
+			fetches:    fetches,
+		}
+		for _, opt := range opts {
+			opt(h)
+		}
+		h.GenerateImageStream = isoeditor.NewRHCOSStreamGenerator(h.openBaseFile, isoeditor.WithKargs(h.kargs))
+		h.urlParser = newURLParser(h.defaultImageType)
+		return h
+	}
+
+	long := newISOHandler(longURLParser)
+	checksum := newISOHandler(longURLParser)
+	checksum.checksumOnly = true
 	h := ImageHandler{
-		long: stdmiddleware.Handler("/images/:imageID", mdw,
-			&isoHandler{
-				ImageStore:          is,
-				GenerateImageStream: isoeditor.NewRHCOSStreamReader,
-				client:              assistedServiceClient,
-				urlParser:           parseLongURL,
-			},
-		),
-		byAPIKey: stdmiddleware.Handler("/byapikey/:token", mdw,
-			&isoHandler{
+		long:     stdmiddleware.Handler("/images/:imageID", mdw, long),
+		checksum: stdmiddleware.Handler("/images/:imageID/checksum", mdw, checksum),
+		byAPIKey: stdmiddleware.Handler("/byapikey/:token", mdw, newISOHandler(shortURLParser)),
+		byID:     stdmiddleware.Handler("/byid/:token", mdw, newISOHandler(shortURLParser)),
+		byToken:  stdmiddleware.Handler("/bytoken/:token", mdw, newISOHandler(shortURLParser)),
+		initrd: stdmiddleware.Handler("/images/:imageID/pxe-initrd", mdw,
+			&initrdHandler{
 				ImageStore:          is,
-				GenerateImageStream: isoeditor.NewRHCOSStreamReader,
 				client:              assistedServiceClient,
-				urlParser:           parseShortURL,
+				defaultArch:         long.defaultArch,
+				ignitionArchiveOpts: long.ignitionArchiveOpts,
 			},
 		),
-		byID: stdmiddleware.Handler("/byid/:token", mdw,
-			&isoHandler{
+		s390xInitrdAddrsize: stdmiddleware.Handler("/images/:imageID/s390x-initrd-addrsize", mdw,
+			&initrdAddrSizeHandler{
 				ImageStore:          is,
-				GenerateImageStream: isoeditor.NewRHCOSStreamReader,
 				client:              assistedServiceClient,
-				urlParser:           parseShortURL,
+				ignitionArchiveOpts: long.ignitionArchiveOpts,
+				initrdAddress:       long.s390xInitrdAddress,
 			},
 		),
-		byToken: stdmiddleware.Handler("/bytoken/:token", mdw,
-			&isoHandler{
-				ImageStore:          is,
-				GenerateImageStream: isoeditor.NewRHCOSStreamReader,
+		ignition: stdmiddleware.Handler("/images/:imageID/ignition", mdw,
+			&ignitionHandler{
 				client:              assistedServiceClient,
-				urlParser:           parseShortURL,
-			},
-		),
-		initrd: stdmiddleware.Handler("/images/:imageID/pxe-initrd", mdw,
-			&initrdHandler{
-				ImageStore: is,
-				client:     assistedServiceClient,
+				enabled:             long.ignitionEndpoint,
+				secret:              long.adminSecret,
+				ignitionArchiveOpts: long.ignitionArchiveOpts,
 			},
 		),
-		s390xInitrdAddrsize: stdmiddleware.Handler("/images/:imageID/s390x-initrd-addrsize", mdw,
-			&initrdAddrSizeHandler{
-				ImageStore: is,
-				client:     assistedServiceClient,
+		kargsInfo: stdmiddleware.Handler("/images/:imageID/kargs-info", mdw,
+			&kargsInfoHandler{
+				ImageStore:  is,
+				client:      assistedServiceClient,
+				defaultArch: long.defaultArch,
+				globalKargs: long.globalKargs,
+				kargs:       long.kargs,
+				secret:      long.adminSecret,
 			},
 		),
 	}
@@ -78,10 +248,17 @@ func (h *ImageHandler) router(maxRequests int64) *chi.Mux {
 	router.Use(WithRequestLimit(maxRequests))
 	router.Handle("/images/{image_id:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}/pxe-initrd", h.initrd)
 	router.Handle("/images/{image_id:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}/s390x-initrd-addrsize", h.s390xInitrdAddrsize)
+	router.Handle("/images/{image_id:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}/checksum", h.checksum)
+	router.Handle("/images/{image_id:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}/ignition", h.ignition)
+	router.Handle("/images/{image_id:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}/kargs-info", h.kargsInfo)
 	router.Handle("/images/{image_id:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}", h.long)
 	router.Handle("/byid/{image_id:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}/{version}/{arch}/{filename}", h.byID)
 	router.Handle("/byapikey/{api_key}/{version}/{arch}/{filename}", h.byAPIKey)
 	router.Handle("/bytoken/{token}/{version}/{arch}/{filename}", h.byToken)
+	// the architecture can be left out of short URLs to use the default one
+	router.Handle("/byid/{image_id:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}/{version}/{filename}", h.byID)
+	router.Handle("/byapikey/{api_key}/{version}/{filename}", h.byAPIKey)
+	router.Handle("/bytoken/{token}/{version}/{filename}", h.byToken)
 
 	return router
 }
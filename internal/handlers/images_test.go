@@ -8,8 +8,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
 )
 
 var _ = Describe("ServeHTTP", func() {
@@ -93,3 +96,48 @@ var _ = Describe("ServeHTTP", func() {
 		Expect(respContent).To(Equal([]byte("initrdaddrcontent")))
 	})
 })
+
+var _ = Describe("resolveVersion", func() {
+	versions := []imagestore.VersionInfo{
+		{OpenshiftVersion: "4.17", CPUArchitecture: "x86_64"},
+		{OpenshiftVersion: "4.18.0-ec.0", CPUArchitecture: "x86_64"},
+		{OpenshiftVersion: "4.18", CPUArchitecture: "x86_64"},
+		{OpenshiftVersion: "4.19.0-ec.1", CPUArchitecture: "x86_64"},
+		{OpenshiftVersion: "4.19.0-ec.0", CPUArchitecture: "x86_64"},
+		{OpenshiftVersion: "4.18.0-ec.2", CPUArchitecture: "arm64"},
+		{OpenshiftVersion: "4.17.3", CPUArchitecture: "arm64"},
+		{OpenshiftVersion: "4.10", CPUArchitecture: "s390x"},
+		{OpenshiftVersion: "4.9", CPUArchitecture: "s390x"},
+		{OpenshiftVersion: "4.18.0-ec.0", CPUArchitecture: "ppc64le"},
+		{OpenshiftVersion: "4.18.0-ec.1", CPUArchitecture: "ppc64le"},
+		{OpenshiftVersion: "4.18.0-rc.0", CPUArchitecture: "ppc64le"},
+		{OpenshiftVersion: "4.18.0", CPUArchitecture: "ppc64le"},
+	}
+
+	DescribeTable("resolves the latest alias per architecture",
+		func(arch, expected string) {
+			mockImageStore := imagestore.NewMockImageStore(gomock.NewController(GinkgoT()))
+			mockImageStore.EXPECT().AvailableVersions().Return(versions)
+			Expect(resolveVersion(mockImageStore, "latest", arch)).To(Equal(expected))
+		},
+		Entry("with a pre-release of the next version", "x86_64", "4.19.0-ec.1"),
+		Entry("with a pre-release above a GA version", "arm64", "4.18.0-ec.2"),
+		Entry("comparing minor versions numerically", "s390x", "4.10"),
+		Entry("with a GA version above its pre-releases", "ppc64le", "4.18.0"),
+		Entry("with no version for the architecture", "aarch64", "latest"),
+	)
+
+	It("skips versions with an invalid format", func() {
+		mockImageStore := imagestore.NewMockImageStore(gomock.NewController(GinkgoT()))
+		mockImageStore.EXPECT().AvailableVersions().Return([]imagestore.VersionInfo{
+			{OpenshiftVersion: "not-a-version", CPUArchitecture: "x86_64"},
+			{OpenshiftVersion: "4.16", CPUArchitecture: "x86_64"},
+		})
+		Expect(resolveVersion(mockImageStore, "latest", "x86_64")).To(Equal("4.16"))
+	})
+
+	It("leaves other versions alone", func() {
+		mockImageStore := imagestore.NewMockImageStore(gomock.NewController(GinkgoT()))
+		Expect(resolveVersion(mockImageStore, "4.16", "x86_64")).To(Equal("4.16"))
+	})
+})
@@ -17,6 +17,10 @@ import (
 type initrdHandler struct {
 	ImageStore imagestore.ImageStore
 	client     *AssistedServiceClient
+	// used when the request doesn't specify an architecture
+	defaultArch string
+	// applied when the ignition is archived to be appended
+	ignitionArchiveOpts []isoeditor.ArchiveOption
 }
 
 var _ http.Handler = &initrdHandler{}
@@ -26,18 +30,15 @@ func (h *initrdHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	version := r.URL.Query().Get("version")
 	if version == "" {
-		httpErrorf(w, http.StatusBadRequest, "'version' parameter required for initrd download")
+		httpErrorf(w, http.StatusBadRequest, ErrorCodeInvalidParameter, "'version' parameter required for initrd download")
 		return
 	}
 
-	arch := r.URL.Query().Get("arch")
-	if arch == "" {
-		arch = defaultArch
-	}
+	arch := resolveArch(r.URL.Query().Get("arch"), h.defaultArch)
 
-	initrdReader, lastModified, code, err := initrdOverlayReader(h.ImageStore, h.client, r, arch)
+	initrdReader, lastModified, code, err := initrdOverlayReader(h.ImageStore, h.client, r, arch, h.ignitionArchiveOpts)
 	if err != nil {
-		httpErrorf(w, code, err.Error())
+		httpErrorf(w, code, errorCodeForStatus(code), "%s", err.Error())
 		return
 	}
 	defer initrdReader.Close()
@@ -52,7 +53,7 @@ func (h *initrdHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	http.ServeContent(w, r, fileName, modTime, initrdReader)
 }
 
-func initrdOverlayReader(imageStore imagestore.ImageStore, client *AssistedServiceClient, r *http.Request, arch string) (overlay.OverlayReader, string, int, error) {
+func initrdOverlayReader(imageStore imagestore.ImageStore, client *AssistedServiceClient, r *http.Request, arch string, archiveOpts []isoeditor.ArchiveOption) (overlay.OverlayReader, string, int, error) {
 	imageID := chi.URLParam(r, "image_id")
 
 	version := r.URL.Query().Get("version")
@@ -72,7 +73,7 @@ func initrdOverlayReader(imageStore imagestore.ImageStore, client *AssistedServi
 		return nil, "", code, fmt.Errorf("error retrieving ignition content: %v", err)
 	}
 
-	initrdReader, err := isoeditor.NewInitRamFSStreamReaderFromISO(isoPath, ignition)
+	initrdReader, err := isoeditor.NewInitRamFSStreamReaderFromISO(isoPath, withArchiveOptions(ignition, archiveOpts))
 	if err != nil {
 		return nil, "", http.StatusInternalServerError, fmt.Errorf("failed to get initrd: %v", err)
 	}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"bytes"
 	"fmt"
 	"net/http"
 	"time"
@@ -15,6 +16,37 @@ import (
 type initrdAddrSizeHandler struct {
 	ImageStore imagestore.ImageStore
 	client     *AssistedServiceClient
+	// applied when the ignition is archived to be appended
+	ignitionArchiveOpts []isoeditor.ArchiveOption
+	// address the initrd is loaded at, the address in the base ISO initrd.addrsize when 0
+	initrdAddress uint64
+}
+
+// Bounds of the s390x initrd load addresses, above the kernel and within the storage of small guests
+const (
+	minS390xInitrdAddress = 0x100000
+	maxS390xInitrdAddress = 0x80000000
+	s390xPageSize         = 0x1000
+)
+
+// ValidateS390xInitrdAddress returns an error when address isn't a plausible s390x initrd load address:
+// a page aligned address of at least 1MiB and below 2GiB
+func ValidateS390xInitrdAddress(address uint64) error {
+	if address < minS390xInitrdAddress || address >= maxS390xInitrdAddress {
+		return fmt.Errorf("initrd address %#x out of bounds, must be at least %#x and below %#x", address, minS390xInitrdAddress, maxS390xInitrdAddress)
+	}
+	if address%s390xPageSize != 0 {
+		return fmt.Errorf("initrd address %#x must be aligned to %#x", address, s390xPageSize)
+	}
+	return nil
+}
+
+// WithS390xInitrdAddress sets the address s390x initrds are loaded at in the initrd.addrsize,
+// instead of the address in the base ISO
+func WithS390xInitrdAddress(address uint64) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.s390xInitrdAddress = address
+	}
 }
 
 var _ http.Handler = &initrdAddrSizeHandler{}
@@ -25,24 +57,29 @@ func (h *initrdAddrSizeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 
 	version := r.URL.Query().Get("version")
 	if version == "" {
-		httpErrorf(w, http.StatusBadRequest, "'version' parameter required for initrd download")
+		httpErrorf(w, http.StatusBadRequest, ErrorCodeInvalidParameter, "'version' parameter required for initrd download")
 		return
 	}
 
 	isoPath := h.ImageStore.PathForParams(imagestore.ImageTypeFull, version, "s390x")
 
-	initrdReader, lastModified, code, err := initrdOverlayReader(h.ImageStore, h.client, r, "s390x")
+	initrdReader, lastModified, code, err := initrdOverlayReader(h.ImageStore, h.client, r, "s390x", h.ignitionArchiveOpts)
 	if err != nil {
-		httpErrorf(w, code, err.Error())
+		httpErrorf(w, code, errorCodeForStatus(code), "%s", err.Error())
 		return
 	}
 	defer initrdReader.Close()
 
 	fileName := fmt.Sprintf("%s-initrd.addrsize", imageID)
-	newAddrsizeFile, err := isoeditor.NewInitrdAddrsizeReaderFromISO(isoPath, initrdReader)
+	var newAddrsizeFile *bytes.Reader
+	if h.initrdAddress != 0 {
+		newAddrsizeFile, err = isoeditor.NewInitrdAddrsizeReaderWithAddress(h.initrdAddress, initrdReader)
+	} else {
+		newAddrsizeFile, err = isoeditor.NewInitrdAddrsizeReaderFromISO(isoPath, initrdReader)
+	}
 	if err != nil {
 		log.Errorf("Error calculate initrd.addsize file: %v, isoPath; %s\n", err, isoPath)
-		httpErrorf(w, http.StatusInternalServerError, "Failed to get initrd.addrsize: %v", err)
+		httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "Failed to get initrd.addrsize: %v", err)
 		return
 	}
 
@@ -10,6 +10,7 @@ import (
 
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
 	"github.com/openshift/assisted-image-service/pkg/imagestore"
@@ -25,10 +26,11 @@ var _ = Describe("ServeHTTP", func() {
 		ignitionContent = []byte("someignitioncontent")
 		initrdAddrsize  = []byte{
 			1, 2, 3, 4, 5, 6, 7, 8, 0, 0, 0, 0, 0, 0, 0, 122}
-		server       *httptest.Server
-		client       *http.Client
-		lastModified string
-		header       = http.Header{}
+		server          *httptest.Server
+		client          *http.Client
+		addrsizeHandler *initrdAddrSizeHandler
+		lastModified    string
+		header          = http.Header{}
 	)
 
 	BeforeEach(func() {
@@ -51,11 +53,12 @@ var _ = Describe("ServeHTTP", func() {
 		asc, err := NewAssistedServiceClient(u.Scheme, u.Host, "")
 		Expect(err).NotTo(HaveOccurred())
 
+		addrsizeHandler = &initrdAddrSizeHandler{
+			ImageStore: mockImageStore,
+			client:     asc,
+		}
 		handler := &ImageHandler{
-			s390xInitrdAddrsize: &initrdAddrSizeHandler{
-				ImageStore: mockImageStore,
-				client:     asc,
-			},
+			s390xInitrdAddrsize: addrsizeHandler,
 		}
 		server = httptest.NewServer(handler.router(1))
 
@@ -111,4 +114,44 @@ var _ = Describe("ServeHTTP", func() {
 		Expect(err).NotTo(HaveOccurred())
 		expectSuccessfulResponse(resp, initrdAddrsize)
 	})
+
+	It("returns initrd.addrsize loading the initrd at the configured address", func() {
+		addrsizeHandler.initrdAddress = 0x04000000
+		mockImage("4.11", "s390x")
+		withNoMinimalInitrd()
+		resp, err := client.Get(fmt.Sprintf("%s/images/%s/s390x-initrd-addrsize?version=4.11", server.URL, imageID))
+		Expect(err).NotTo(HaveOccurred())
+		expectSuccessfulResponse(resp, append([]byte{0, 0, 0, 0, 4, 0, 0, 0}, initrdAddrsize[8:]...))
+	})
+
+	It("returns a JSON error when no version is supplied", func() {
+		resp, err := client.Get(fmt.Sprintf("%s/images/%s/s390x-initrd-addrsize", server.URL, imageID))
+		Expect(err).NotTo(HaveOccurred())
+		expectJSONError(resp, http.StatusBadRequest, ErrorCodeInvalidParameter)
+	})
+
+	It("returns a JSON error when the version is not available", func() {
+		mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, "4.7", "s390x").Return("").AnyTimes()
+		mockImageStore.EXPECT().HaveVersion("4.7", "s390x").Return(false)
+		resp, err := client.Get(fmt.Sprintf("%s/images/%s/s390x-initrd-addrsize?version=4.7", server.URL, imageID))
+		Expect(err).NotTo(HaveOccurred())
+		expectJSONError(resp, http.StatusBadRequest, ErrorCodeInvalidParameter)
+	})
 })
+
+var _ = DescribeTable("ValidateS390xInitrdAddress",
+	func(address uint64, valid bool) {
+		err := ValidateS390xInitrdAddress(address)
+		if valid {
+			Expect(err).NotTo(HaveOccurred())
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	},
+	Entry("the usual address", uint64(0x02000000), true),
+	Entry("the lowest address", uint64(0x100000), true),
+	Entry("the highest address", uint64(0x7ffff000), true),
+	Entry("an address overlapping the kernel", uint64(0x10000), false),
+	Entry("an address past 2GiB", uint64(0x80000000), false),
+	Entry("an unaligned address", uint64(0x02000800), false),
+)
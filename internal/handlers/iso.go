@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/openshift/assisted-image-service/pkg/imagestore"
@@ -15,11 +20,77 @@ type isoHandler struct {
 	GenerateImageStream isoeditor.StreamGeneratorFunc
 	client              *AssistedServiceClient
 	// second arg is an HTTP response code to use when the error != nil
-	urlParser func(*http.Request) (*imageDownloadParams, int, error)
+	urlParser urlParserFunc
+	// used by the URL parser when the request doesn't specify an image type
+	defaultImageType string
+	// when set, full ISO downloads are redirected instead of streamed
+	redirect *ISORedirect
+	// when set, generated minimal ISOs are kept in memory
+	minimalISOCache *ISOCache
+	// when set, images without a kernel arguments embed area are served without the kernel arguments
+	kargsOptional bool
+	// embedded ahead of the InfraEnv kernel arguments
+	globalKargs []string
+	// used when the request doesn't specify an architecture
+	defaultArch string
+	// when set, POST requests embed the ignition in the request body instead of the assisted service one
+	ignitionOverride bool
+	// when set, the ignition of images can be downloaded by the requests carrying adminSecret
+	ignitionEndpoint bool
+	// must be sent in the AdminSecretHeader to the debugging endpoints, which reject requests when empty
+	adminSecret string
+	// when set, the checksum of the generated image is returned instead of the image
+	checksumOnly bool
+	// size of the buffer images are copied to the response with, the default when 0
+	streamBufferSize int
+	// when set, caps the combined rate of the downloads sharing it
+	throttle *Throttle
+	// caps the rate of each download, no cap when 0
+	downloadBytesPerSec int64
+	// Cache-Control header of image downloads, not set when empty
+	cacheControl string
+	// when set, embedded instead of the assisted service ignition when it can't be retrieved
+	fallbackIgnition []byte
+	// when set, identical concurrent assisted service requests are sent once
+	fetches *fetchCoalescer
+	// when set, requests taking longer than its threshold are reported
+	slowRequests *SlowRequestTracker
+	// when set, requests are counted by tenant
+	tenantMetrics *TenantMetrics
+	// when set, the downloads being streamed are counted
+	activeStreams *ActiveStreams
+	// when set, the downloads aborted after the image failed to be read are counted
+	abortedStreams *AbortedStreams
+	// applied when the ignition is archived to be embedded
+	ignitionArchiveOpts []isoeditor.ArchiveOption
+	// opens the base ISOs served as is, os.Open when not set
+	openBaseFile isoeditor.BaseFileOpener
+	// locates the files the kernel arguments are embedded in, discovered from the ISO when not set
+	kargs *isoeditor.Kargs
+	// when set, images are compressed for the clients accepting gzip encoding
+	gzipStreams bool
+	// when set, image downloads are rejected once it's reached
+	byteQuota *ByteQuota
+	// when set, the assisted service ignition is checked before it's embedded
+	validateIgnition bool
+	// address s390x initrds are loaded at, the address in the base ISO when 0
+	s390xInitrdAddress uint64
+	// when set, images exceeding it are rejected before they are streamed or checksummed
+	imageSizeLimit *ImageSizeLimit
 }
 
+// FallbackIgnitionHeader is set on image responses that embed the fallback ignition
+const FallbackIgnitionHeader = "X-Fallback-Ignition"
+
+// maxIgnitionOverrideSize limits the ignition accepted in the body of POST requests
+const maxIgnitionOverrideSize = 10 * 1024 * 1024
+
 var _ http.Handler = &isoHandler{}
 
+// urlParserFunc parses the image download parameters of a request, returning the HTTP response
+// code to use along with the error
+type urlParserFunc func(*http.Request) (*imageDownloadParams, int, error)
+
 type imageDownloadParams struct {
 	imageID   string
 	version   string
@@ -28,67 +99,315 @@ type imageDownloadParams struct {
 }
 
 func (h *isoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	phases := h.slowRequests.begin()
+
+	// both the image and checksum responses are negotiated with the Accept header, errors included
+	// so a cached error isn't returned for the other representation
+	addVary(w.Header(), "Accept")
+
+	if r.Method == http.MethodPost && !h.ignitionOverride {
+		w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodHead}, ", "))
+		httpErrorf(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "POST requests require ENABLE_IGNITION_OVERRIDE")
+		return
+	}
+
 	params, statusCode, err := h.urlParser(r)
 
 	if err != nil {
-		w.WriteHeader(statusCode)
-		_, err = w.Write([]byte(err.Error()))
-		if err != nil {
-			log.Errorf("Failed to write response: %v\n", err)
-		}
+		writeParseError(w, statusCode, err)
 		return
 	}
+	params.arch = resolveArch(params.arch, h.defaultArch)
+	params.version = resolveVersion(h.ImageStore, params.version, params.arch)
+	defer phases.done(params)
+	h.tenantMetrics.observe(r, params)
 
 	if !h.ImageStore.HaveVersion(params.version, params.arch) {
-		log.Errorf("version for %s %s, not found", params.version, params.arch)
-		http.NotFound(w, r)
+		httpErrorf(w, http.StatusNotFound, ErrorCodeVersionNotFound, "version for %s %s, not found", params.version, params.arch)
+		return
+	}
+	if params.imageType == imagestore.ImageTypeMinimal && !h.ImageStore.HaveMinimalISO(params.version, params.arch) {
+		if reason := h.ImageStore.MinimalISOError(params.version, params.arch); reason != "" {
+			httpErrorf(w, http.StatusServiceUnavailable, ErrorCodeImageUnavailable, "minimal ISO for %s %s is unavailable as it failed to be created: %s, the full ISO can be requested instead",
+				params.version, params.arch, reason)
+			return
+		}
+		httpErrorf(w, http.StatusServiceUnavailable, ErrorCodeImageUnavailable, "minimal ISO for %s %s is unavailable, the full ISO can be requested instead", params.version, params.arch)
 		return
 	}
 
-	ignition, lastModified, statusCode, err := h.client.ignitionContent(r, params.imageID, params.imageType)
+	extraKargs, err := parseExtraKargs(r.URL.Query())
 	if err != nil {
-		log.Errorf("Error retrieving ignition content: %v\n", err)
-		w.WriteHeader(statusCode)
+		httpErrorf(w, http.StatusBadRequest, ErrorCodeInvalidParameter, "%s", err.Error())
+		return
+	}
+
+	// clients asking for JSON get the image metadata instead of the image
+	metadataOnly := acceptsJSON(r) && !h.checksumOnly
+	// only the image download itself can be redirected
+	redirect := h.redirect
+	if metadataOnly || h.checksumOnly {
+		redirect = nil
+	}
+	// only the images streamed by the service count against the quota, HEAD requests stream nothing
+	if !metadataOnly && !h.checksumOnly && redirect == nil && r.Method != http.MethodHead && h.rejectOverQuota(w, params.imageID) {
+		return
+	}
+
+	var ignition *isoeditor.IgnitionContent
+	var lastModified string
+	// nothing else is requested from assisted service once it failed to return the ignition
+	usingFallback := false
+	if r.Method == http.MethodPost {
+		ignition, err = ignitionOverride(w, r)
+		if err != nil {
+			httpErrorf(w, http.StatusBadRequest, ErrorCodeInvalidParameter, "Invalid ignition override: %v", err)
+			return
+		}
+		log.Warnf("Embedding the ignition from the request body in image %s instead of the assisted service one", params.imageID)
+		lastModified = time.Now().UTC().Format(http.TimeFormat)
+	} else {
+		phases.enter(phaseIgnition)
+		ignition, lastModified, statusCode, err = h.ignitionContent(r, params.imageID, params.imageType)
+		if err != nil && h.fallbackIgnition != nil && statusCode >= http.StatusInternalServerError {
+			log.Warnf("Error retrieving ignition content, embedding the fallback ignition in image %s: %v", params.imageID, err)
+			ignition = &isoeditor.IgnitionContent{Config: h.fallbackIgnition}
+			lastModified = time.Now().UTC().Format(http.TimeFormat)
+			usingFallback = true
+			w.Header().Set(FallbackIgnitionHeader, "true")
+		} else if err != nil {
+			log.Errorf("Error retrieving ignition content: %v", err)
+			writeHTTPError(w, NewHTTPError(statusCode, errorCodeForStatus(statusCode), "Failed to retrieve ignition content"))
+			return
+		}
+		if h.validateIgnition && !usingFallback {
+			if err = validateIgnitionConfig(ignition.Config); err != nil {
+				log.Errorf("Invalid ignition content for image %s: %v", params.imageID, err)
+				writeHTTPError(w, invalidIgnitionError(params.imageID, err))
+				return
+			}
+		}
+	}
+	ignition = withArchiveOptions(ignition, h.ignitionArchiveOpts)
+
+	if redirect != nil && params.imageType == imagestore.ImageTypeMinimal {
+		httpErrorf(w, http.StatusUnprocessableEntity, ErrorCodeRedirectUnsupported, "minimal ISOs can't be served by redirect")
 		return
 	}
 
 	var ramdisk []byte
-	if params.imageType == imagestore.ImageTypeMinimal {
-		ramdisk, statusCode, err = h.client.ramdiskContent(r, params.imageID)
+	if params.imageType == imagestore.ImageTypeMinimal && !usingFallback {
+		phases.enter(phaseRamdisk)
+		ramdisk, statusCode, err = h.ramdiskContent(r, params.imageID)
 		if err != nil {
-			log.Errorf("Error retrieving ramdisk content: %v\n", err)
-			w.WriteHeader(statusCode)
+			log.Errorf("Error retrieving ramdisk content: %v", err)
+			writeHTTPError(w, NewHTTPError(statusCode, errorCodeForStatus(statusCode), "Failed to retrieve ramdisk content"))
 			return
 		}
 	}
 
 	var kargs []byte
-	kargs, statusCode, err = h.client.discoveryKernelArguments(r, params.imageID)
-	if err != nil {
-		log.Errorf("Error retrieving kernel arguments content: %v\n", err)
-		w.WriteHeader(statusCode)
-		return
+	if !usingFallback {
+		phases.enter(phaseKargs)
+		kargs, statusCode, err = h.discoveryKernelArguments(r, params.imageID)
+		if err != nil {
+			log.Errorf("Error retrieving kernel arguments content: %v", err)
+			writeHTTPError(w, kernelArgumentsError(statusCode, err))
+			return
+		}
 	}
+	kargs = mergeKargs(globalKargsFor(h.globalKargs, params.arch), kargs, extraKargs)
 
 	if kargs != nil && params.arch == "s390x" {
-		httpErrorf(w, http.StatusBadRequest, "kargs cannot be modified in s390x architecture ISOs")
+		httpErrorf(w, http.StatusBadRequest, ErrorCodeUnsupportedKargs, "kargs cannot be modified in s390x architecture ISOs")
 		return
 	}
 
-	isoReader, err := h.GenerateImageStream(h.ImageStore.PathForParams(params.imageType, params.version, params.arch), ignition, ramdisk, kargs)
+	modTime, err := http.ParseTime(lastModified)
 	if err != nil {
-		log.Errorf("Error creating image stream: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		log.Warnf("Error parsing last modified time %s: %v", lastModified, err)
+		modTime = time.Now()
+	}
+
+	if redirect != nil {
+		// the redirect target serves the base ISO as-is so nothing can be embedded
+		if kargs != nil {
+			httpErrorf(w, http.StatusUnprocessableEntity, ErrorCodeRedirectUnsupported, "ISOs with kernel arguments can't be served by redirect")
+			return
+		}
+		if !ignition.Empty() {
+			httpErrorf(w, http.StatusUnprocessableEntity, ErrorCodeRedirectUnsupported, "ISOs with an embedded ignition can't be served by redirect")
+			return
+		}
+		http.Redirect(w, r, redirect.URL(params), http.StatusTemporaryRedirect)
+		return
+	}
+
+	phases.enter(phaseStream)
+	isoPath := h.ImageStore.PathForParams(params.imageType, params.version, params.arch)
+	var isoReader isoeditor.ImageReader
+	if params.imageType == imagestore.ImageTypeFull && ramdisk == nil && kargs == nil && ignition.Empty() {
+		// nothing to embed, the base ISO is served as is
+		isoReader, err = h.openBaseISO(isoPath)
+	} else {
+		isoReader, err = h.generateImageStream(params, isoPath, ignition, ramdisk, kargs)
+	}
+	if err != nil {
+		writeHTTPError(w, imageStreamError(err))
 		return
 	}
 	defer isoReader.Close()
 
+	if metadataOnly {
+		volumeID, _ := h.ImageStore.VolumeID(params.version, params.arch)
+		serveImageMetadata(w, r, params, isoReader, modTime, volumeID)
+		return
+	}
+
+	if h.imageSizeLimit.rejectOversized(w, params, isoReader) {
+		return
+	}
+
 	fileName := fmt.Sprintf("%s-discovery.iso", params.imageID)
+	if h.checksumOnly {
+		serveImageChecksum(w, r, fileName, isoReader)
+		return
+	}
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
-	modTime, err := http.ParseTime(lastModified)
+	if h.cacheControl != "" {
+		w.Header().Set("Cache-Control", h.cacheControl)
+	}
+	if r.Method == http.MethodHead {
+		// the image is sized without being read, so HEAD requests are a cheap probe of the
+		// credentials and the image availability once the ignition was retrieved
+		http.ServeContent(w, r, fileName, modTime, isoReader)
+		return
+	}
+	defer h.activeStreams.start(streamKindImage)()
+	h.serveGuardedContent(w, r, params, fileName, modTime, isoReader)
+}
+
+// openBaseISO opens the base ISO at isoPath to serve it as is
+func (h *isoHandler) openBaseISO(isoPath string) (isoeditor.ImageReader, error) {
+	if h.openBaseFile != nil {
+		return h.openBaseFile(isoPath)
+	}
+	return os.Open(isoPath)
+}
+
+// generateImageStream returns the image at isoPath with the content embedded
+func (h *isoHandler) generateImageStream(params *imageDownloadParams, isoPath string, ignition *isoeditor.IgnitionContent, ramdisk, kargs []byte) (isoeditor.ImageReader, error) {
+	generateImageStream := h.GenerateImageStream
+	if h.minimalISOCache != nil && params.imageType == imagestore.ImageTypeMinimal {
+		generateImageStream = h.minimalISOCache.Generator(generateImageStream)
+	}
+	isoReader, err := generateImageStream(isoPath, ignition, ramdisk, kargs)
+	if errors.Is(err, isoeditor.ErrKargsEmbedAreaNotFound) && h.kargsOptional {
+		log.Warnf("Image %s has no kernel arguments embed area, serving it without kernel arguments", isoPath)
+		isoReader, err = generateImageStream(isoPath, ignition, ramdisk, nil)
+	}
+	return isoReader, err
+}
+
+// imageStreamError returns the error to report when the image stream can't be created
+func imageStreamError(err error) *HTTPError {
+	switch {
+	case errors.Is(err, isoeditor.ErrKargsEmbedAreaNotFound):
+		return NewHTTPError(http.StatusUnprocessableEntity, ErrorCodeUnsupportedKargs, "kernel arguments not supported for this image")
+	case errors.Is(err, isoeditor.ErrKargsTooLong):
+		return embedAreaError(err, ErrorCodeUnsupportedKargs, "kernel_arguments")
+	case errors.Is(err, isoeditor.ErrIgnitionTooLarge):
+		return embedAreaError(err, ErrorCodeIgnitionTooLarge, "ignition")
+	default:
+		return NewHTTPError(http.StatusInternalServerError, ErrorCodeInternal, "Error creating image stream: %v", err)
+	}
+}
+
+type fetchedIgnition struct {
+	content      *isoeditor.IgnitionContent
+	lastModified string
+}
+
+// fetchKey identifies an assisted service fetch made for r, only requests forwarding the same
+// query parameters share it
+func (h *isoHandler) fetchKey(r *http.Request, parts ...string) string {
+	return strings.Join(append(parts, h.client.forwardedQuery(r).Encode()), "|")
+}
+
+// ignitionContent retrieves the image ignition, coalesced with identical concurrent requests
+func (h *isoHandler) ignitionContent(r *http.Request, imageID, imageType string) (*isoeditor.IgnitionContent, string, int, error) {
+	key := h.fetchKey(r, "ignition", imageID, imageType)
+	value, statusCode, err := h.fetches.do(r, key, func(r *http.Request) (interface{}, int, error) {
+		content, lastModified, statusCode, err := h.client.ignitionContent(r, imageID, imageType)
+		return fetchedIgnition{content: content, lastModified: lastModified}, statusCode, err
+	})
 	if err != nil {
-		log.Warnf("Error parsing last modified time %s: %v", lastModified, err)
-		modTime = time.Now()
+		return nil, "", statusCode, err
+	}
+	ignition := value.(fetchedIgnition)
+	return ignition.content, ignition.lastModified, 0, nil
+}
+
+// ramdiskContent retrieves the initrd appended to the minimal ISO of imageID from assisted service,
+// coalesced with identical concurrent requests for the same image
+func (h *isoHandler) ramdiskContent(r *http.Request, imageID string) ([]byte, int, error) {
+	value, statusCode, err := h.fetches.do(r, h.fetchKey(r, "ramdisk", imageID), func(r *http.Request) (interface{}, int, error) {
+		return h.client.ramdiskContent(r, imageID)
+	})
+	if err != nil {
+		return nil, statusCode, err
+	}
+	return value.([]byte), 0, nil
+}
+
+// discoveryKernelArguments retrieves the InfraEnv kernel arguments, coalesced with identical concurrent requests
+func (h *isoHandler) discoveryKernelArguments(r *http.Request, imageID string) ([]byte, int, error) {
+	value, statusCode, err := h.fetches.do(r, h.fetchKey(r, "kargs", imageID), func(r *http.Request) (interface{}, int, error) {
+		return h.client.discoveryKernelArguments(r, imageID)
+	})
+	if err != nil {
+		return nil, statusCode, err
+	}
+	return value.([]byte), 0, nil
+}
+
+// withArchiveOptions returns a copy of ignition that is archived with opts, ignition itself may
+// be shared with concurrent requests
+func withArchiveOptions(ignition *isoeditor.IgnitionContent, opts []isoeditor.ArchiveOption) *isoeditor.IgnitionContent {
+	if len(opts) == 0 {
+		return ignition
+	}
+	content := *ignition
+	content.ArchiveOptions = append(append([]isoeditor.ArchiveOption{}, ignition.ArchiveOptions...), opts...)
+	return &content
+}
+
+// ignitionOverride reads the ignition supplied in the body of a POST request
+func ignitionOverride(w http.ResponseWriter, r *http.Request) (*isoeditor.IgnitionContent, error) {
+	config, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIgnitionOverrideSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	if len(config) == 0 {
+		return nil, fmt.Errorf("request body is empty")
+	}
+	if !json.Valid(config) {
+		return nil, fmt.Errorf("request body is not valid JSON")
+	}
+	return &isoeditor.IgnitionContent{Config: config}, nil
+}
+
+// embedAreaError returns the 422 error for content that doesn't fit in an image embed area,
+// with the sizes when err reports them
+func embedAreaError(err error, code, content string) *HTTPError {
+	httpErr := NewHTTPError(http.StatusUnprocessableEntity, code, "%s", err.Error())
+	var tooSmall *isoeditor.EmbedAreaTooSmallError
+	if errors.As(err, &tooSmall) {
+		httpErr.Details = embedAreaDetails{
+			Content:       content,
+			ContentSize:   tooSmall.ContentLength,
+			EmbedAreaSize: tooSmall.AreaLength,
+		}
 	}
-	http.ServeContent(w, r, fileName, modTime, isoReader)
+	return httpErr
 }
@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+)
+
+// ISOCache keeps generated images in memory so repeated downloads of the same content
+// don't rebuild the overlay. Entries are keyed by a hash of the base image and everything
+// embedded into it so an image is only ever shared between requests with identical content.
+type ISOCache struct {
+	maxBytes int64
+
+	lock    sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+	// images being generated, shared by the requests for them until they're added to entries
+	filling map[string]*isoFill
+	// bytes of the images being generated, counted against maxBytes along with size
+	reserved int64
+	group    singleflight.Group
+
+	hits   prometheus.Counter
+	misses prometheus.Counter
+}
+
+type isoCacheEntry struct {
+	key  string
+	data []byte
+}
+
+// NewISOCache creates a cache holding at most maxBytes of image content.
+// The hit and miss counters are registered with reg when it is not nil.
+func NewISOCache(maxBytes int64, reg prometheus.Registerer) (*ISOCache, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("invalid ISO cache size %d: must be positive", maxBytes)
+	}
+	c := &ISOCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+		filling:  map[string]*isoFill{},
+		hits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "assisted_image_service_iso_cache_hits_total",
+			Help: "Number of image downloads served from the in-memory ISO cache",
+		}),
+		misses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "assisted_image_service_iso_cache_misses_total",
+			Help: "Number of image downloads that were not found in the in-memory ISO cache",
+		}),
+	}
+	if reg != nil {
+		for _, collector := range []prometheus.Collector{c.hits, c.misses} {
+			if err := reg.Register(collector); err != nil {
+				return nil, fmt.Errorf("failed to register ISO cache metrics: %w", err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// Generator wraps next so generated images are served from and added to the cache. An image
+// missing from the cache is streamed to the request while it's generated, and concurrent
+// requests for it share that generation rather than each generating it.
+func (c *ISOCache) Generator(next isoeditor.StreamGeneratorFunc) isoeditor.StreamGeneratorFunc {
+	return func(isoPath string, ignitionContent *isoeditor.IgnitionContent, ramdiskContent, kargs []byte) (isoeditor.ImageReader, error) {
+		key, err := isoCacheKey(isoPath, ignitionContent, ramdiskContent, kargs)
+		if err != nil {
+			return nil, err
+		}
+		if data, ok := c.get(key); ok {
+			c.hits.Inc()
+			return bytesImageReader{bytes.NewReader(data)}, nil
+		}
+		if fill, ok := c.getFill(key); ok {
+			c.hits.Inc()
+			return newISOFillReader(fill), nil
+		}
+		c.misses.Inc()
+
+		res, err, _ := c.group.Do(key, func() (interface{}, error) {
+			// the image may have been generated since this request missed
+			if data, ok := c.get(key); ok {
+				return &isoCacheMiss{data: data}, nil
+			}
+			if fill, ok := c.lookupFill(key); ok {
+				return &isoCacheMiss{fill: fill}, nil
+			}
+			r, err := next(isoPath, ignitionContent, ramdiskContent, kargs)
+			if err != nil {
+				return nil, err
+			}
+			size, err := r.Seek(0, io.SeekEnd)
+			if err == nil {
+				_, err = r.Seek(0, io.SeekStart)
+			}
+			if err != nil {
+				r.Close()
+				return nil, err
+			}
+			// images that don't fit alongside the ones being generated are streamed without being materialized
+			if !c.reserve(size) {
+				return &isoCacheMiss{oversized: r}, nil
+			}
+			return &isoCacheMiss{fill: c.startFill(key, r, size)}, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		miss := res.(*isoCacheMiss)
+		if miss.data != nil {
+			return bytesImageReader{bytes.NewReader(miss.data)}, nil
+		}
+		// the generation is given up once all its readers are closed, the image is then generated again
+		if miss.fill != nil && miss.fill.acquire() {
+			return newISOFillReader(miss.fill), nil
+		}
+		if miss.fill != nil {
+			return next(isoPath, ignitionContent, ramdiskContent, kargs)
+		}
+		// the generated stream can't be shared, only one of the concurrent requests gets it
+		if miss.claimed.CompareAndSwap(false, true) {
+			return miss.oversized, nil
+		}
+		return next(isoPath, ignitionContent, ramdiskContent, kargs)
+	}
+}
+
+// isoCacheMiss is the result of a generation shared between concurrent misses for an image
+type isoCacheMiss struct {
+	data      []byte
+	fill      *isoFill
+	oversized isoeditor.ImageReader
+	claimed   atomic.Bool
+}
+
+// isoFill is an image being read from its generated stream into memory, which is added to the
+// cache once complete. Its readers are served the part read so far as soon as it's available.
+type isoFill struct {
+	data []byte
+
+	lock   sync.Mutex
+	cond   *sync.Cond
+	filled int64
+	err    error
+	// readers open, the fill is abandoned when the last one is closed before it completes
+	readers   int
+	abandoned bool
+}
+
+// errISOFillAbandoned stops the generation of an image that is no longer read
+var errISOFillAbandoned = errors.New("all the readers of the image were closed")
+
+// acquire registers a reader of the fill, failing once it's abandoned
+func (f *isoFill) acquire() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.abandoned {
+		return false
+	}
+	f.readers++
+	return true
+}
+
+func (f *isoFill) release() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.readers--
+	if f.readers == 0 && f.filled < int64(len(f.data)) && f.err == nil {
+		f.abandoned = true
+	}
+}
+
+// reserve counts size bytes of an image about to be generated against maxBytes, evicting the
+// least recently used images to make room. It fails when the image doesn't fit alongside the
+// images already being generated.
+func (c *ISOCache) reserve(size int64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.reserved+size > c.maxBytes {
+		return false
+	}
+	for c.size+c.reserved+size > c.maxBytes {
+		entry := c.lru.Remove(c.lru.Back()).(*isoCacheEntry)
+		delete(c.entries, entry.key)
+		c.size -= int64(len(entry.data))
+	}
+	c.reserved += size
+	return true
+}
+
+// startFill reads the image of size bytes, reserved with reserve, from r in the background,
+// serving the requests for key from it until it is added to the cache
+func (c *ISOCache) startFill(key string, r isoeditor.ImageReader, size int64) *isoFill {
+	fill := &isoFill{data: make([]byte, size)}
+	fill.cond = sync.NewCond(&fill.lock)
+
+	c.lock.Lock()
+	c.filling[key] = fill
+	c.lock.Unlock()
+
+	go func() {
+		defer r.Close()
+		var read int64
+		var err error
+		for read < size && err == nil {
+			var n int
+			n, err = r.Read(fill.data[read:])
+			read += int64(n)
+			// the end is published once the image is cached, so it's found there by later requests
+			if read < size {
+				fill.lock.Lock()
+				fill.filled = read
+				abandoned := fill.abandoned
+				fill.cond.Broadcast()
+				fill.lock.Unlock()
+				if abandoned && err == nil {
+					err = errISOFillAbandoned
+				}
+			}
+		}
+		if read == size {
+			err = nil
+		} else if err == nil || errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		c.lock.Lock()
+		c.reserved -= size
+		if err == nil {
+			c.addLocked(key, fill.data)
+		}
+		delete(c.filling, key)
+		c.lock.Unlock()
+
+		fill.lock.Lock()
+		if err == nil {
+			fill.filled = read
+		} else {
+			fill.err = fmt.Errorf("failed to generate image: %w", err)
+		}
+		fill.cond.Broadcast()
+		fill.lock.Unlock()
+	}()
+	return fill
+}
+
+// isoFillReader reads an image as it's filled, waiting for the part it reads to be available
+type isoFillReader struct {
+	fill   *isoFill
+	offset int64
+	closed bool
+}
+
+// newISOFillReader returns a reader of fill, which must have been acquired for it
+func newISOFillReader(fill *isoFill) *isoFillReader {
+	return &isoFillReader{fill: fill}
+}
+
+func (r *isoFillReader) Read(p []byte) (int, error) {
+	size := int64(len(r.fill.data))
+	if r.offset >= size {
+		return 0, io.EOF
+	}
+	r.fill.lock.Lock()
+	for r.fill.filled <= r.offset && r.fill.err == nil {
+		r.fill.cond.Wait()
+	}
+	filled, err := r.fill.filled, r.fill.err
+	r.fill.lock.Unlock()
+	if filled <= r.offset {
+		return 0, err
+	}
+	n := copy(p, r.fill.data[r.offset:filled])
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *isoFillReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += int64(len(r.fill.data))
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *isoFillReader) Close() error {
+	if !r.closed {
+		r.closed = true
+		r.fill.release()
+	}
+	return nil
+}
+
+// getFill returns the image being generated for key, acquired for a new reader
+func (c *ISOCache) getFill(key string) (*isoFill, bool) {
+	fill, ok := c.lookupFill(key)
+	if !ok || !fill.acquire() {
+		return nil, false
+	}
+	return fill, true
+}
+
+func (c *ISOCache) lookupFill(key string) (*isoFill, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	fill, ok := c.filling[key]
+	return fill, ok
+}
+
+func (c *ISOCache) get(key string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*isoCacheEntry).data, true
+}
+
+func (c *ISOCache) addLocked(key string, data []byte) {
+	// a previous generation of the same content may have added it already
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	for c.size+int64(len(data)) > c.maxBytes {
+		oldest := c.lru.Back()
+		if oldest == nil {
+			return
+		}
+		entry := c.lru.Remove(oldest).(*isoCacheEntry)
+		delete(c.entries, entry.key)
+		c.size -= int64(len(entry.data))
+	}
+	c.entries[key] = c.lru.PushFront(&isoCacheEntry{key: key, data: data})
+	c.size += int64(len(data))
+}
+
+// isoCacheKey hashes the base image identity along with the embedded content. The base
+// image size and modification time are included since images are recreated on populate.
+func isoCacheKey(isoPath string, ignitionContent *isoeditor.IgnitionContent, ramdiskContent, kargs []byte) (string, error) {
+	info, err := os.Stat(isoPath)
+	if err != nil {
+		return "", err
+	}
+	archive, err := ignitionContent.Archive()
+	if err != nil {
+		return "", err
+	}
+	ignition, err := io.ReadAll(archive)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, part := range [][]byte{[]byte(isoPath), []byte(info.ModTime().String()), ignition, ramdiskContent, kargs} {
+		// length prefixes keep the boundaries between parts unambiguous
+		if err := binary.Write(h, binary.BigEndian, int64(len(part))); err != nil {
+			return "", err
+		}
+		h.Write(part)
+	}
+	if err := binary.Write(h, binary.BigEndian, info.Size()); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type bytesImageReader struct {
+	*bytes.Reader
+}
+
+func (bytesImageReader) Close() error {
+	return nil
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("ISOCache", func() {
+	var (
+		isoPath   string
+		generated []string
+		reg       *prometheus.Registry
+	)
+
+	// the fake image is the ignition config repeated to the requested size
+	generator := func(size int) isoeditor.StreamGeneratorFunc {
+		return func(_ string, ignition *isoeditor.IgnitionContent, _, _ []byte) (isoeditor.ImageReader, error) {
+			generated = append(generated, string(ignition.Config))
+			return bytesImageReader{bytes.NewReader(bytes.Repeat(ignition.Config, size/len(ignition.Config)))}, nil
+		}
+	}
+
+	fetch := func(generate isoeditor.StreamGeneratorFunc, ignition string) string {
+		r, err := generate(isoPath, &isoeditor.IgnitionContent{Config: []byte(ignition)}, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		defer r.Close()
+		content, err := io.ReadAll(r)
+		Expect(err).NotTo(HaveOccurred())
+		return string(content)
+	}
+
+	counter := func(name string) float64 {
+		families, err := reg.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() == name {
+				return family.GetMetric()[0].GetCounter().GetValue()
+			}
+		}
+		return 0
+	}
+
+	BeforeEach(func() {
+		f, err := os.CreateTemp("", "iso_cache_test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+		isoPath = f.Name()
+		generated = nil
+		reg = prometheus.NewRegistry()
+	})
+
+	AfterEach(func() {
+		os.Remove(isoPath)
+	})
+
+	It("serves repeated requests for the same content from memory", func() {
+		cache, err := NewISOCache(100, reg)
+		Expect(err).NotTo(HaveOccurred())
+		generate := cache.Generator(generator(10))
+
+		Expect(fetch(generate, "a")).To(Equal("aaaaaaaaaa"))
+		Expect(fetch(generate, "a")).To(Equal("aaaaaaaaaa"))
+		Expect(generated).To(Equal([]string{"a"}))
+		Expect(counter("assisted_image_service_iso_cache_hits_total")).To(Equal(float64(1)))
+		Expect(counter("assisted_image_service_iso_cache_misses_total")).To(Equal(float64(1)))
+	})
+
+	It("doesn't share images with different content", func() {
+		cache, err := NewISOCache(100, reg)
+		Expect(err).NotTo(HaveOccurred())
+		generate := cache.Generator(generator(10))
+
+		Expect(fetch(generate, "a")).To(Equal("aaaaaaaaaa"))
+		Expect(fetch(generate, "b")).To(Equal("bbbbbbbbbb"))
+		Expect(generated).To(Equal([]string{"a", "b"}))
+	})
+
+	It("evicts the least recently used image to stay within its size", func() {
+		cache, err := NewISOCache(25, reg)
+		Expect(err).NotTo(HaveOccurred())
+		generate := cache.Generator(generator(10))
+
+		fetch(generate, "a")
+		fetch(generate, "b")
+		// using a makes b the least recently used
+		fetch(generate, "a")
+		fetch(generate, "c")
+		Expect(generated).To(Equal([]string{"a", "b", "c"}))
+
+		fetch(generate, "a")
+		fetch(generate, "b")
+		Expect(generated).To(Equal([]string{"a", "b", "c", "b"}))
+		Expect(cache.size).To(BeNumerically("<=", 25))
+	})
+
+	It("streams images larger than the cache without keeping them", func() {
+		cache, err := NewISOCache(5, reg)
+		Expect(err).NotTo(HaveOccurred())
+		generate := cache.Generator(generator(10))
+
+		Expect(fetch(generate, "a")).To(Equal("aaaaaaaaaa"))
+		Expect(fetch(generate, "a")).To(Equal("aaaaaaaaaa"))
+		Expect(generated).To(Equal([]string{"a", "a"}))
+		Expect(cache.size).To(BeZero())
+	})
+
+	It("misses when the base image changes", func() {
+		cache, err := NewISOCache(100, reg)
+		Expect(err).NotTo(HaveOccurred())
+		generate := cache.Generator(generator(10))
+
+		fetch(generate, "a")
+		Expect(os.WriteFile(isoPath, []byte("new base image"), 0600)).To(Succeed())
+		fetch(generate, "a")
+		Expect(generated).To(Equal([]string{"a", "a"}))
+	})
+
+	Context("while an image is generated", func() {
+		var (
+			release  chan struct{}
+			genLock  sync.Mutex
+			genCount int
+		)
+
+		// the fake image is the ignition config, of which only the first half is read
+		// until release is closed
+		gatedGenerator := func(_ string, ignition *isoeditor.IgnitionContent, _, _ []byte) (isoeditor.ImageReader, error) {
+			genLock.Lock()
+			genCount++
+			genLock.Unlock()
+			// concurrent requests are still waiting to share this generation
+			time.Sleep(50 * time.Millisecond)
+			return &gatedReader{Reader: bytes.NewReader(ignition.Config), gate: int64(len(ignition.Config) / 2), release: release}, nil
+		}
+
+		BeforeEach(func() {
+			release = make(chan struct{})
+			genCount = 0
+		})
+
+		It("streams the part generated so far", func() {
+			cache, err := NewISOCache(100, reg)
+			Expect(err).NotTo(HaveOccurred())
+			r, err := cache.Generator(gatedGenerator)(isoPath, &isoeditor.IgnitionContent{Config: []byte("aaaabbbb")}, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer r.Close()
+
+			size, err := r.Seek(0, io.SeekEnd)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(int64(8)))
+			_, err = r.Seek(0, io.SeekStart)
+			Expect(err).NotTo(HaveOccurred())
+			head := make([]byte, 4)
+			_, err = io.ReadFull(r, head)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(head)).To(Equal("aaaa"))
+
+			close(release)
+			rest, err := io.ReadAll(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(rest)).To(Equal("bbbb"))
+			Expect(fetch(cache.Generator(gatedGenerator), "aaaabbbb")).To(Equal("aaaabbbb"))
+			Expect(genCount).To(Equal(1))
+		})
+
+		It("generates the image once for concurrent requests", func() {
+			cache, err := NewISOCache(100, reg)
+			Expect(err).NotTo(HaveOccurred())
+			generate := cache.Generator(gatedGenerator)
+
+			var wg sync.WaitGroup
+			contents := make([]string, 5)
+			for i := range contents {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					contents[i] = fetch(generate, "aaaabbbb")
+				}(i)
+			}
+			close(release)
+			wg.Wait()
+
+			Expect(contents).To(HaveEach("aaaabbbb"))
+			Expect(genCount).To(Equal(1))
+			Expect(counter("assisted_image_service_iso_cache_misses_total") + counter("assisted_image_service_iso_cache_hits_total")).To(Equal(float64(5)))
+		})
+
+		It("streams images that don't fit alongside the ones being generated without keeping them", func() {
+			cache, err := NewISOCache(10, reg)
+			Expect(err).NotTo(HaveOccurred())
+			r, err := cache.Generator(gatedGenerator)(isoPath, &isoeditor.IgnitionContent{Config: []byte("aaaabbbb")}, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer r.Close()
+
+			generate := cache.Generator(generator(8))
+			Expect(fetch(generate, "c")).To(Equal("cccccccc"))
+			Expect(fetch(generate, "c")).To(Equal("cccccccc"))
+			Expect(generated).To(Equal([]string{"c", "c"}))
+
+			close(release)
+			content, err := io.ReadAll(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("aaaabbbb"))
+			Eventually(func() int64 {
+				cache.lock.Lock()
+				defer cache.lock.Unlock()
+				return cache.reserved
+			}).Should(BeZero())
+			Expect(cache.size).To(Equal(int64(8)))
+		})
+
+		It("stops generating an image once all its readers are closed", func() {
+			cache, err := NewISOCache(100, reg)
+			Expect(err).NotTo(HaveOccurred())
+			generate := cache.Generator(func(_ string, ignition *isoeditor.IgnitionContent, _, _ []byte) (isoeditor.ImageReader, error) {
+				genLock.Lock()
+				genCount++
+				genLock.Unlock()
+				return &gatedReader{Reader: bytes.NewReader(ignition.Config), gate: 4, release: release, chunk: 1}, nil
+			})
+			r, err := generate(isoPath, &isoeditor.IgnitionContent{Config: []byte("aaaabbbb")}, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			head := make([]byte, 4)
+			_, err = io.ReadFull(r, head)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Close()).To(Succeed())
+
+			close(release)
+			Eventually(func() int {
+				cache.lock.Lock()
+				defer cache.lock.Unlock()
+				return len(cache.filling)
+			}).Should(BeZero())
+			Expect(cache.size).To(BeZero())
+			Expect(cache.reserved).To(BeZero())
+
+			Expect(fetch(generate, "aaaabbbb")).To(Equal("aaaabbbb"))
+			Expect(genCount).To(Equal(2))
+		})
+	})
+
+	It("rejects an invalid size", func() {
+		_, err := NewISOCache(0, nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+// gatedReader doesn't read past gate until release is closed, then reads at most chunk bytes at
+// a time when it's set
+type gatedReader struct {
+	*bytes.Reader
+	gate    int64
+	release chan struct{}
+	chunk   int
+}
+
+func (r *gatedReader) Read(p []byte) (int, error) {
+	pos, err := r.Reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if pos >= r.gate {
+		<-r.release
+		if r.chunk > 0 && len(p) > r.chunk {
+			p = p[:r.chunk]
+		}
+	} else if int64(len(p)) > r.gate-pos {
+		p = p[:r.gate-pos]
+	}
+	return r.Reader.Read(p)
+}
+
+func (r *gatedReader) Close() error {
+	return nil
+}
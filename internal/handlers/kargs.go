@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const extraKargsParam = "extra_kargs"
+
+// deniedExtraKargs can't be set at request time as the discovery image depends on their values
+var deniedExtraKargs = []string{
+	"coreos.live.rootfs_url",
+	"coreos.liveiso",
+	"ignition.config.url",
+	"ignition.firstboot",
+	"ignition.platform.id",
+}
+
+// extraKargRegexp excludes quotes, whitespace and other characters the boot loaders would interpret
+var extraKargRegexp = regexp.MustCompile(`^[A-Za-z0-9._,:=/+@%-]+$`)
+
+// kernelArgumentsError returns the error written when the InfraEnv kernel arguments can't be
+// retrieved, which explains why when assisted service returned invalid ones
+func kernelArgumentsError(statusCode int, err error) *HTTPError {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr
+	}
+	return NewHTTPError(statusCode, errorCodeForStatus(statusCode), "Failed to retrieve kernel arguments content")
+}
+
+// parseExtraKargs returns the kernel arguments requested with the extra_kargs query parameter,
+// which may be repeated and may contain several whitespace separated arguments
+func parseExtraKargs(values url.Values) ([]string, error) {
+	var kargs []string
+	for _, value := range values[extraKargsParam] {
+		for _, karg := range strings.Fields(value) {
+			if err := validateExtraKarg(karg, "'"+extraKargsParam+"'"); err != nil {
+				return nil, err
+			}
+			kargs = append(kargs, karg)
+		}
+	}
+	return kargs, nil
+}
+
+// ParseGlobalKargs parses the JSON array of kernel arguments embedded in every image, which
+// are validated like the extra_kargs ones
+func ParseGlobalKargs(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var kargs []string
+	if err := json.Unmarshal([]byte(value), &kargs); err != nil {
+		return nil, fmt.Errorf("global kernel arguments must be a JSON array of strings: %w", err)
+	}
+	for _, karg := range kargs {
+		if err := validateExtraKarg(karg, "global kernel arguments"); err != nil {
+			return nil, err
+		}
+	}
+	return kargs, nil
+}
+
+// validateExtraKarg checks that karg is a single kernel argument that may be set from source
+func validateExtraKarg(karg, source string) error {
+	if !extraKargRegexp.MatchString(karg) {
+		return fmt.Errorf("invalid kernel argument '%s' in %s", karg, source)
+	}
+	name, _, _ := strings.Cut(karg, "=")
+	for _, denied := range deniedExtraKargs {
+		if name == denied {
+			return fmt.Errorf("kernel argument '%s' can't be set with %s", name, source)
+		}
+	}
+	return nil
+}
+
+// mergeKargs returns the kernel arguments data returned by discoveryKernelArguments with global
+// prepended and extra appended
+func mergeKargs(global []string, kargs []byte, extra []string) []byte {
+	if len(global) == 0 && len(extra) == 0 {
+		return kargs
+	}
+	merged := append(append(append([]string{}, global...), strings.Fields(string(kargs))...), extra...)
+	return []byte(" " + strings.Join(merged, " ") + "\n")
+}
+
+// WithGlobalKargs embeds kargs ahead of the InfraEnv kernel arguments in every image but s390x ones
+func WithGlobalKargs(kargs []string) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.globalKargs = kargs
+	}
+}
+
+// globalKargsFor returns the global kernel arguments that apply to images of arch
+func globalKargsFor(global []string, arch string) []string {
+	if arch == "s390x" {
+		return nil
+	}
+	return global
+}
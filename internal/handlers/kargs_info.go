@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+)
+
+// kargsInfoHandler reports where the kernel arguments of an image are embedded and how much
+// room is left for them, to debug kernel arguments that don't fit
+type kargsInfoHandler struct {
+	ImageStore imagestore.ImageStore
+	client     *AssistedServiceClient
+	// used when the request doesn't specify an architecture
+	defaultArch string
+	// embedded ahead of the InfraEnv kernel arguments
+	globalKargs []string
+	// locates the files the kernel arguments are embedded in, discovered from the ISO when not set
+	kargs *isoeditor.Kargs
+	// must be sent in the AdminSecretHeader, requests are rejected when empty
+	secret string
+}
+
+var _ http.Handler = &kargsInfoHandler{}
+
+type kargsFileInfo struct {
+	isoeditor.KargsFileInfo
+	// bytes left in the embed area once the global and InfraEnv kernel arguments are appended,
+	// negative when they don't fit
+	Available int64 `json:"available"`
+}
+
+type kargsInfoResponse struct {
+	Version             string          `json:"version"`
+	Arch                string          `json:"arch"`
+	Type                string          `json:"type"`
+	DefaultKargs        string          `json:"default_kargs"`
+	DefaultKargsLength  int             `json:"default_kargs_length"`
+	InfraEnvKargsLength int             `json:"infra_env_kargs_length"`
+	GlobalKargsLength   int             `json:"global_kargs_length"`
+	Files               []kargsFileInfo `json:"files"`
+}
+
+func (h *kargsInfoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(r, h.secret) {
+		httpErrorf(w, http.StatusUnauthorized, ErrorCodeUnauthorized, "Missing or invalid %s header", AdminSecretHeader)
+		return
+	}
+
+	imageID := chi.URLParam(r, "image_id")
+	values := r.URL.Query()
+
+	version := values.Get("version")
+	if version == "" {
+		httpErrorf(w, http.StatusBadRequest, ErrorCodeInvalidParameter, "'version' parameter required")
+		return
+	}
+	imageType := values.Get("type")
+	if imageType == "" {
+		imageType = imagestore.ImageTypeFull
+	} else if imageType != imagestore.ImageTypeFull && imageType != imagestore.ImageTypeMinimal {
+		httpErrorf(w, http.StatusBadRequest, ErrorCodeInvalidParameter, "invalid value '%s' for parameter 'type'", imageType)
+		return
+	}
+	arch := resolveArch(values.Get("arch"), h.defaultArch)
+
+	if !h.ImageStore.HaveVersion(version, arch) {
+		httpErrorf(w, http.StatusNotFound, ErrorCodeVersionNotFound, "version for %s %s, not found", version, arch)
+		return
+	}
+
+	// the InfraEnv kernel arguments are what gets appended, retrieving them also authenticates the request
+	kargs, statusCode, err := h.client.discoveryKernelArguments(r, imageID)
+	if err != nil {
+		log.Errorf("Error retrieving kernel arguments content: %v", err)
+		writeHTTPError(w, kernelArgumentsError(statusCode, err))
+		return
+	}
+
+	isoPath := h.ImageStore.PathForParams(imageType, version, arch)
+	info, err := h.kargs.Info(isoPath)
+	if err != nil {
+		httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "Failed to read kernel arguments info: %v", err)
+		return
+	}
+
+	// the global kernel arguments are embedded along with the InfraEnv ones
+	embedded := mergeKargs(globalKargsFor(h.globalKargs, arch), kargs, nil)
+	resp := kargsInfoResponse{
+		Version:             version,
+		Arch:                arch,
+		Type:                imageType,
+		DefaultKargs:        info.DefaultKargs,
+		DefaultKargsLength:  len(info.DefaultKargs),
+		InfraEnvKargsLength: len(kargs),
+		GlobalKargsLength:   len(embedded) - len(kargs),
+		Files:               []kargsFileInfo{},
+	}
+	for _, file := range info.Files {
+		resp.Files = append(resp.Files, kargsFileInfo{
+			KargsFileInfo: file,
+			Available:     file.EmbedAreaSize - int64(len(embedded)),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Errorf("Failed to write kernel arguments info response: %v", err)
+	}
+}
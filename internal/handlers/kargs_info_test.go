@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+)
+
+var _ = Describe("kargsInfoHandler", func() {
+	var (
+		ctrl           *gomock.Controller
+		mockImageStore *imagestore.MockImageStore
+		imageFilename  string
+		imageID        = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+		assistedServer *ghttp.Server
+		server         *httptest.Server
+		client         *http.Client
+		adminSecret    = "admin"
+	)
+
+	const defaultKargs = "coreos.liveiso=rhcos-411 ignition.firstboot ignition.platform.id=metal"
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		mockImageStore = imagestore.NewMockImageStore(ctrl)
+		// the embed area is the newline and the '#' padding before the marker's last one
+		imageFilename = createTestISOWithFiles(map[string]string{
+			"coreos/kargs.json": fmt.Sprintf(`{"default": "%s", "files": [{"path": "EFI/redhat/grub.cfg"}, {"path": "isolinux/isolinux.cfg"}]}`, defaultKargs),
+			"EFI/redhat/grub.cfg": "menuentry 'RHEL CoreOS (Live)' {\n\tlinux /images/pxeboot/vmlinuz " + defaultKargs +
+				"\n" + strings.Repeat("#", 200) + " COREOS_KARG_EMBED_AREA\n\tinitrd /images/pxeboot/initrd.img /images/ignition.img\n}\n",
+			"isolinux/isolinux.cfg": "label linux\n  kernel /images/pxeboot/vmlinuz\n  append " + defaultKargs + "\n",
+		})
+
+		assistedServer = ghttp.NewServer()
+		u, err := url.Parse(assistedServer.URL())
+		Expect(err).NotTo(HaveOccurred())
+
+		asc, err := NewAssistedServiceClient(u.Scheme, u.Host, "")
+		Expect(err).NotTo(HaveOccurred())
+
+		handler := &ImageHandler{
+			kargsInfo: &kargsInfoHandler{
+				ImageStore:  mockImageStore,
+				client:      asc,
+				defaultArch: "x86_64",
+				secret:      adminSecret,
+			},
+		}
+		server = httptest.NewServer(handler.router(1))
+		client = server.Client()
+	})
+
+	AfterEach(func() {
+		assistedServer.Close()
+		server.Close()
+		os.Remove(imageFilename)
+	})
+
+	get := func(query string) *http.Response {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/images/%s/kargs-info?%s", server.URL, imageID, query), nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set(AdminSecretHeader, adminSecret)
+		resp, err := client.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		return resp
+	}
+
+	withInfraEnv := func(body string) {
+		assistedServer.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", fmt.Sprintf(infraEnvPathFormat, imageID)),
+				ghttp.RespondWith(http.StatusOK, body),
+			),
+		)
+	}
+
+	It("reports the embed areas and the kernel arguments lengths", func() {
+		mockImageStore.EXPECT().HaveVersion("4.11", "x86_64").Return(true)
+		mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, "4.11", "x86_64").Return(imageFilename)
+		withInfraEnv(`{"kernel_arguments": "[{\"operation\": \"append\", \"value\": \"p1\"}]"}`)
+
+		resp := get("version=4.11")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+
+		var info kargsInfoResponse
+		Expect(json.NewDecoder(resp.Body).Decode(&info)).To(Succeed())
+		Expect(info.Version).To(Equal("4.11"))
+		Expect(info.Arch).To(Equal("x86_64"))
+		Expect(info.Type).To(Equal(imagestore.ImageTypeFull))
+		Expect(info.DefaultKargs).To(Equal(defaultKargs))
+		Expect(info.DefaultKargsLength).To(Equal(len(defaultKargs)))
+		// " p1\n"
+		Expect(info.InfraEnvKargsLength).To(Equal(4))
+		Expect(info.Files).To(HaveLen(2))
+		Expect(info.Files[0].Path).To(Equal("EFI/redhat/grub.cfg"))
+		Expect(info.Files[0].EmbedAreaSize).To(Equal(int64(200)))
+		Expect(info.Files[0].Available).To(Equal(int64(196)))
+		Expect(info.Files[1].Path).To(Equal("isolinux/isolinux.cfg"))
+		Expect(info.Files[1].EmbedAreaSize).To(Equal(int64(0)))
+		Expect(info.Files[1].Available).To(Equal(int64(-4)))
+	})
+
+	It("accounts for the global kernel arguments", func() {
+		u, err := url.Parse(assistedServer.URL())
+		Expect(err).NotTo(HaveOccurred())
+		asc, err := NewAssistedServiceClient(u.Scheme, u.Host, "")
+		Expect(err).NotTo(HaveOccurred())
+		server.Close()
+		server = httptest.NewServer((&ImageHandler{
+			kargsInfo: &kargsInfoHandler{
+				ImageStore:  mockImageStore,
+				client:      asc,
+				defaultArch: "x86_64",
+				secret:      adminSecret,
+				globalKargs: []string{"quiet"},
+			},
+		}).router(1))
+		client = server.Client()
+
+		mockImageStore.EXPECT().HaveVersion("4.11", "x86_64").Return(true)
+		mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, "4.11", "x86_64").Return(imageFilename)
+		withInfraEnv(`{"kernel_arguments": "[{\"operation\": \"append\", \"value\": \"p1\"}]"}`)
+
+		resp := get("version=4.11")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		var info kargsInfoResponse
+		Expect(json.NewDecoder(resp.Body).Decode(&info)).To(Succeed())
+		// " quiet p1\n"
+		Expect(info.InfraEnvKargsLength).To(Equal(4))
+		Expect(info.GlobalKargsLength).To(Equal(6))
+		Expect(info.Files[0].Available).To(Equal(int64(190)))
+	})
+
+	It("uses the requested image type and architecture", func() {
+		mockImageStore.EXPECT().HaveVersion("4.11", "arm64").Return(true)
+		mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeMinimal, "4.11", "arm64").Return(imageFilename)
+		withInfraEnv("{}")
+
+		resp := get("version=4.11&arch=arm64&type=minimal-iso")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		var info kargsInfoResponse
+		Expect(json.NewDecoder(resp.Body).Decode(&info)).To(Succeed())
+		Expect(info.Arch).To(Equal("arm64"))
+		Expect(info.Type).To(Equal(imagestore.ImageTypeMinimal))
+		Expect(info.InfraEnvKargsLength).To(Equal(0))
+		Expect(info.Files[0].Available).To(Equal(int64(200)))
+	})
+
+	It("rejects requests without the admin secret", func() {
+		resp, err := client.Get(fmt.Sprintf("%s/images/%s/kargs-info?version=4.11", server.URL, imageID))
+		Expect(err).NotTo(HaveOccurred())
+		expectJSONError(resp, http.StatusUnauthorized, ErrorCodeUnauthorized)
+		Expect(assistedServer.ReceivedRequests()).To(BeEmpty())
+	})
+
+	It("fails without a version", func() {
+		resp := get("")
+		expectJSONError(resp, http.StatusBadRequest, ErrorCodeInvalidParameter)
+	})
+
+	It("fails for an invalid image type", func() {
+		resp := get("version=4.11&type=full")
+		expectJSONError(resp, http.StatusBadRequest, ErrorCodeInvalidParameter)
+	})
+
+	It("fails for an unknown version", func() {
+		mockImageStore.EXPECT().HaveVersion("4.7", "x86_64").Return(false)
+		resp := get("version=4.7")
+		expectJSONError(resp, http.StatusNotFound, ErrorCodeVersionNotFound)
+	})
+
+	It("fails with a 502 for an infra-env with unsupported kernel argument operations", func() {
+		mockImageStore.EXPECT().HaveVersion("4.11", "x86_64").Return(true)
+		withInfraEnv(`{"kernel_arguments": "[{\"operation\": \"replace\", \"value\": \"p1\"}]"}`)
+		resp := get("version=4.11")
+		expectJSONError(resp, http.StatusBadGateway, ErrorCodeInvalidKargs)
+	})
+
+	It("passes through authentication failures", func() {
+		mockImageStore.EXPECT().HaveVersion("4.11", "x86_64").Return(true)
+		assistedServer.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", fmt.Sprintf(infraEnvPathFormat, imageID)),
+				ghttp.RespondWith(http.StatusUnauthorized, nil),
+			),
+		)
+		resp := get("version=4.11")
+		expectJSONError(resp, http.StatusUnauthorized, errorCodeForStatus(http.StatusUnauthorized))
+	})
+})
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/url"
+
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("parseExtraKargs",
+	func(query string, expected []string, success bool) {
+		values, err := url.ParseQuery(query)
+		Expect(err).NotTo(HaveOccurred())
+		kargs, err := parseExtraKargs(values)
+		if success {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kargs).To(Equal(expected))
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	},
+	Entry("returns nothing without the parameter", "version=4.8", nil, true),
+	Entry("parses a single argument", "extra_kargs=console%3DttyS0%2C115200n8", []string{"console=ttyS0,115200n8"}, true),
+	Entry("splits whitespace separated arguments", "extra_kargs=quiet+rd.net.timeout.carrier%3D60", []string{"quiet", "rd.net.timeout.carrier=60"}, true),
+	Entry("accepts the parameter several times", "extra_kargs=quiet&extra_kargs=isolcpus%3D1%2C2%2C10-20%2C100-2000%3A2%2F25", []string{"quiet", "isolcpus=1,2,10-20,100-2000:2/25"}, true),
+	Entry("rejects quotes", "extra_kargs=console%3D%22ttyS0%22", nil, false),
+	Entry("rejects grub syntax", "extra_kargs=a%3B%24b", nil, false),
+	Entry("rejects denied arguments", "extra_kargs=ignition.firstboot", nil, false),
+	Entry("rejects denied arguments with a value", "extra_kargs=coreos.live.rootfs_url%3Dhttp%3A%2F%2Fexample.com", nil, false),
+)
+
+var _ = DescribeTable("mergeKargs",
+	func(kargs string, extra []string, expected string) {
+		var kargsData []byte
+		if kargs != "" {
+			kargsData = []byte(kargs)
+		}
+		merged := mergeKargs(nil, kargsData, extra)
+		if expected == "" {
+			Expect(merged).To(BeNil())
+		} else {
+			Expect(string(merged)).To(Equal(expected))
+		}
+	},
+	Entry("keeps missing kargs", "", nil, ""),
+	Entry("keeps infra-env kargs", " p1 p2\n", nil, " p1 p2\n"),
+	Entry("uses the extra kargs alone", "", []string{"quiet"}, " quiet\n"),
+	Entry("appends the extra kargs", " p1 p2\n", []string{"quiet", "console=ttyS0"}, " p1 p2 quiet console=ttyS0\n"),
+)
+
+var _ = DescribeTable("mergeKargs with global kargs",
+	func(global []string, kargs string, extra []string, expected string) {
+		var kargsData []byte
+		if kargs != "" {
+			kargsData = []byte(kargs)
+		}
+		Expect(string(mergeKargs(global, kargsData, extra))).To(Equal(expected))
+	},
+	Entry("uses the global kargs alone", []string{"console=ttyS0"}, "", nil, " console=ttyS0\n"),
+	Entry("puts the global kargs first", []string{"console=ttyS0"}, " p1 p2\n", []string{"quiet"}, " console=ttyS0 p1 p2 quiet\n"),
+)
+
+var _ = DescribeTable("ParseGlobalKargs",
+	func(value string, expected []string, success bool) {
+		kargs, err := ParseGlobalKargs(value)
+		if success {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kargs).To(Equal(expected))
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	},
+	Entry("returns nothing when unset", "", nil, true),
+	Entry("parses the arguments in order", `["console=ttyS0,115200n8", "quiet"]`, []string{"console=ttyS0,115200n8", "quiet"}, true),
+	Entry("rejects values that aren't an array of strings", `{"console": "ttyS0"}`, nil, false),
+	Entry("rejects several arguments in one string", `["console=ttyS0 quiet"]`, nil, false),
+	Entry("rejects quotes", `["console=\"ttyS0\""]`, nil, false),
+	Entry("rejects denied arguments", `["ignition.platform.id=metal"]`, nil, false),
+)
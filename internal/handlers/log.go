@@ -1,14 +1,26 @@
 package handlers
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func httpErrorf(w http.ResponseWriter, code int, format string, a ...interface{}) {
-	msg := fmt.Sprintf(format, a...)
-	log.Error(msg)
-	http.Error(w, msg, code)
+func httpErrorf(w http.ResponseWriter, statusCode int, code, format string, a ...interface{}) {
+	httpErr := NewHTTPError(statusCode, code, format, a...)
+	log.Error(httpErr.Message)
+	writeHTTPError(w, httpErr)
+}
+
+// writeParseError writes the error of a URL parser, keeping the details of the errors
+// returned as an HTTPError
+func writeParseError(w http.ResponseWriter, statusCode int, err error) {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		log.Error(httpErr.Message)
+		writeHTTPError(w, httpErr)
+		return
+	}
+	httpErrorf(w, statusCode, errorCodeForStatus(statusCode), "%s", err.Error())
 }
@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"fmt"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -11,31 +10,42 @@ import (
 
 // parseLongURL parses the long-style URLs that use query parameters to identify
 // the desired resource. This style of URL is deprecated in favor of short URLs.
-func parseLongURL(r *http.Request) (*imageDownloadParams, int, error) {
+var parseLongURL = longURLParser("")
+
+// longURLParser returns a parser for long-style URLs that resolves the requests without a
+// type to defaultImageType, or rejects them when it's empty
+func longURLParser(defaultImageType string) urlParserFunc {
+	return func(r *http.Request) (*imageDownloadParams, int, error) {
+		return parseLongURLWithDefault(r, defaultImageType)
+	}
+}
+
+func parseLongURLWithDefault(r *http.Request, defaultImageType string) (*imageDownloadParams, int, error) {
 	imageID := chi.URLParam(r, "image_id")
+	if !imageIDRegexp.MatchString(imageID) {
+		return nil, http.StatusBadRequest, parameterError("image_id", parameterInvalid, "invalid value '%s' for parameter 'image_id'", imageID)
+	}
 
 	values := r.URL.Query()
 	version := values.Get("version")
 	if version == "" {
-		return nil, http.StatusBadRequest, fmt.Errorf("'version' parameter required")
-	}
-
-	arch := values.Get("arch")
-	if arch == "" {
-		arch = defaultArch
+		return nil, http.StatusBadRequest, parameterError("version", parameterMissing, "'version' parameter required")
 	}
 
 	imageType := values.Get("type")
 	if imageType == "" {
-		return nil, http.StatusBadRequest, fmt.Errorf("'type' parameter required")
+		imageType = defaultImageType
+	}
+	if imageType == "" {
+		return nil, http.StatusBadRequest, parameterError("type", parameterMissing, "'type' parameter required")
 	} else if imageType != imagestore.ImageTypeFull && imageType != imagestore.ImageTypeMinimal {
-		return nil, http.StatusBadRequest, fmt.Errorf("invalid value '%s' for parameter 'type'", imageType)
+		return nil, http.StatusBadRequest, parameterError("type", parameterInvalid, "invalid value '%s' for parameter 'type'", imageType)
 	}
 
 	return &imageDownloadParams{
 		version:   version,
 		imageType: imageType,
-		arch:      arch,
+		arch:      values.Get("arch"),
 		imageID:   imageID,
 	}, 0, nil
 }
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imageMetadata describes an image without transferring it
+type imageMetadata struct {
+	ImageID      string `json:"image_id"`
+	Type         string `json:"type"`
+	Version      string `json:"version"`
+	Arch         string `json:"arch"`
+	Size         int64  `json:"size"`
+	LastModified string `json:"last_modified"`
+	ETag         string `json:"etag"`
+	// volume identifier of the OS image the image is generated from, naming its build
+	VolumeID string `json:"volume_id,omitempty"`
+}
+
+// imageChecksum is the checksum of the exact bytes served for an image request
+type imageChecksum struct {
+	FileName string `json:"file_name"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+}
+
+// acceptsJSON reports whether the client explicitly asked for JSON rather than the image itself
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil || mediaType != "application/json" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// addVary adds the request headers a response depends on to its Vary header, keeping those
+// already listed by other handlers such as the CORS middleware
+func addVary(header http.Header, names ...string) {
+	listed := map[string]bool{}
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			listed[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+	for _, name := range names {
+		if !listed[http.CanonicalHeaderKey(name)] {
+			header.Add("Vary", name)
+			listed[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+}
+
+// imageETag identifies the generated image by its inputs so it changes whenever the content does.
+// It's weak as the inputs don't guarantee the image is byte for byte identical, the ignition may
+// change without its last modified time changing.
+func imageETag(params *imageDownloadParams, size int64, modTime time.Time) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%s:%s:%s:%d:%d", params.imageID, params.imageType, params.version, params.arch, size, modTime.Unix())))
+	return fmt.Sprintf("W/%q", hex.EncodeToString(sum[:16]))
+}
+
+func serveImageMetadata(w http.ResponseWriter, r *http.Request, params *imageDownloadParams, image io.Seeker, modTime time.Time, volumeID string) {
+	size, err := image.Seek(0, io.SeekEnd)
+	if err != nil {
+		httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "Error determining image size: %v", err)
+		return
+	}
+
+	metadata := imageMetadata{
+		ImageID:      params.imageID,
+		Type:         params.imageType,
+		Version:      params.version,
+		Arch:         params.arch,
+		Size:         size,
+		LastModified: modTime.UTC().Format(http.TimeFormat),
+		ETag:         imageETag(params, size, modTime),
+		VolumeID:     volumeID,
+	}
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "Error encoding image metadata: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("Last-Modified", metadata.LastModified)
+	w.Header().Set("ETag", metadata.ETag)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write(body)
+}
+
+// serveImageChecksum hashes the generated image without storing it. The result is returned as JSON
+// when requested, and otherwise as a line in the format of sha256sum.
+func serveImageChecksum(w http.ResponseWriter, r *http.Request, fileName string, image io.Reader) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, image)
+	if err != nil {
+		httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "Error computing image checksum: %v", err)
+		return
+	}
+	checksum := imageChecksum{
+		FileName: fileName,
+		SHA256:   hex.EncodeToString(hash.Sum(nil)),
+		Size:     size,
+	}
+
+	var body []byte
+	if acceptsJSON(r) {
+		body, err = json.Marshal(checksum)
+		if err != nil {
+			httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "Error encoding image checksum: %v", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		body = []byte(fmt.Sprintf("%s  %s\n", checksum.SHA256, checksum.FileName))
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write(body)
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
@@ -63,3 +64,42 @@ func WithRequestLimit(maxRequests int64) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// SecurityHeaders are the headers set on HTTPS responses by WithSecurityHeaders
+type SecurityHeaders struct {
+	// max-age of the Strict-Transport-Security header, the header is not set when 0
+	HSTSMaxAge time.Duration
+	// value of the X-Frame-Options header, DENY or SAMEORIGIN, not set when empty
+	FrameOptions string
+}
+
+// Validate checks that the headers have values browsers understand
+func (s SecurityHeaders) Validate() error {
+	if s.HSTSMaxAge < 0 {
+		return fmt.Errorf("invalid HSTS max-age %s: must not be negative", s.HSTSMaxAge)
+	}
+	switch s.FrameOptions {
+	case "", "DENY", "SAMEORIGIN":
+	default:
+		return fmt.Errorf("invalid X-Frame-Options value %q: must be DENY or SAMEORIGIN", s.FrameOptions)
+	}
+	return nil
+}
+
+// WithSecurityHeaders sets the security headers scanners expect on responses to HTTPS requests.
+// Plain HTTP responses are left alone as browsers ignore HSTS received over plain HTTP.
+func WithSecurityHeaders(handler http.Handler, headers SecurityHeaders) http.Handler {
+	hsts := fmt.Sprintf("max-age=%d", int64(headers.HSTSMaxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			if headers.HSTSMaxAge > 0 {
+				w.Header().Set("Strict-Transport-Security", hsts)
+			}
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			if headers.FrameOptions != "" {
+				w.Header().Set("X-Frame-Options", headers.FrameOptions)
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -125,3 +126,60 @@ var _ = Describe("WithInitrdViaHTTPMiddleware", func() {
 		Expect(respStatus).To(Equal(404))
 	})
 })
+
+var _ = Describe("WithSecurityHeaders", func() {
+	var server *httptest.Server
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	baseHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hello!")
+	})
+
+	get := func() http.Header {
+		resp, err := server.Client().Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		return resp.Header
+	}
+
+	It("sets the headers on HTTPS responses", func() {
+		server = httptest.NewTLSServer(WithSecurityHeaders(baseHandler, SecurityHeaders{HSTSMaxAge: 24 * time.Hour, FrameOptions: "DENY"}))
+		header := get()
+		Expect(header.Get("Strict-Transport-Security")).To(Equal("max-age=86400"))
+		Expect(header.Get("X-Content-Type-Options")).To(Equal("nosniff"))
+		Expect(header.Get("X-Frame-Options")).To(Equal("DENY"))
+	})
+
+	It("leaves out the headers that are disabled", func() {
+		server = httptest.NewTLSServer(WithSecurityHeaders(baseHandler, SecurityHeaders{}))
+		header := get()
+		Expect(header).NotTo(HaveKey("Strict-Transport-Security"))
+		Expect(header).NotTo(HaveKey("X-Frame-Options"))
+		Expect(header.Get("X-Content-Type-Options")).To(Equal("nosniff"))
+	})
+
+	It("doesn't set the headers on plain HTTP responses", func() {
+		server = httptest.NewServer(WithSecurityHeaders(baseHandler, SecurityHeaders{HSTSMaxAge: 24 * time.Hour, FrameOptions: "DENY"}))
+		header := get()
+		Expect(header).NotTo(HaveKey("Strict-Transport-Security"))
+		Expect(header).NotTo(HaveKey("X-Content-Type-Options"))
+		Expect(header).NotTo(HaveKey("X-Frame-Options"))
+	})
+
+	It("doesn't set the headers when it isn't used", func() {
+		server = httptest.NewTLSServer(baseHandler)
+		header := get()
+		Expect(header).NotTo(HaveKey("Strict-Transport-Security"))
+		Expect(header).NotTo(HaveKey("X-Frame-Options"))
+	})
+
+	It("validates the header values", func() {
+		Expect(SecurityHeaders{HSTSMaxAge: time.Hour, FrameOptions: "SAMEORIGIN"}.Validate()).To(Succeed())
+		Expect(SecurityHeaders{FrameOptions: "ALLOW-FROM https://example.com"}.Validate()).NotTo(Succeed())
+		Expect(SecurityHeaders{HSTSMaxAge: -time.Hour}.Validate()).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ByteQuota caps the bytes of the images served within each window, in total and for each
+// infra-env. New image downloads are rejected once a cap is reached until the window ends,
+// while the downloads already started are served in full.
+type ByteQuota struct {
+	globalLimit   int64
+	infraEnvLimit int64
+	window        time.Duration
+	now           func() time.Time
+
+	lock        sync.Mutex
+	windowStart time.Time
+	served      int64
+	infraEnvs   map[string]int64
+
+	rejected prometheus.Counter
+}
+
+// NewByteQuota creates a quota allowing globalLimit bytes in total and infraEnvLimit bytes for
+// each infra-env in every window, a limit of 0 disables it. The usage metrics are registered
+// with reg when it is not nil.
+func NewByteQuota(globalLimit, infraEnvLimit int64, window time.Duration, reg prometheus.Registerer) (*ByteQuota, error) {
+	if globalLimit < 0 || infraEnvLimit < 0 {
+		return nil, fmt.Errorf("invalid byte quota %d, %d for each infra-env: must not be negative", globalLimit, infraEnvLimit)
+	}
+	if globalLimit == 0 && infraEnvLimit == 0 {
+		return nil, fmt.Errorf("invalid byte quota: either the total or the infra-env limit must be set")
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("invalid byte quota window %s: must be positive", window)
+	}
+	q := &ByteQuota{
+		globalLimit:   globalLimit,
+		infraEnvLimit: infraEnvLimit,
+		window:        window,
+		now:           time.Now,
+		infraEnvs:     map[string]int64{},
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "assisted_image_service_quota_rejected_requests_total",
+			Help: "Number of image downloads rejected because the byte quota was reached",
+		}),
+	}
+	if reg != nil {
+		usage := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "assisted_image_service_quota_served_bytes",
+			Help: "Number of image bytes served in the current byte quota window",
+		}, func() float64 {
+			return float64(q.usage())
+		})
+		for _, c := range []prometheus.Collector{usage, q.rejected} {
+			if err := reg.Register(c); err != nil {
+				return nil, fmt.Errorf("failed to register byte quota metrics: %w", err)
+			}
+		}
+	}
+	return q, nil
+}
+
+// WithByteQuota rejects the image downloads once the quota is reached
+func WithByteQuota(quota *ByteQuota) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.byteQuota = quota
+	}
+}
+
+// resetExpired starts a new window once the current one ended, called with the lock held
+func (q *ByteQuota) resetExpired(now time.Time) {
+	if now.Sub(q.windowStart) < q.window {
+		return
+	}
+	q.windowStart = now
+	q.served = 0
+	q.infraEnvs = map[string]int64{}
+}
+
+// admit reports if a download of the image of infraEnvID may start, and otherwise the time
+// until the window ends
+func (q *ByteQuota) admit(infraEnvID string) (bool, time.Duration) {
+	if q == nil {
+		return true, 0
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	now := q.now()
+	q.resetExpired(now)
+	if (q.globalLimit > 0 && q.served >= q.globalLimit) || (q.infraEnvLimit > 0 && q.infraEnvs[infraEnvID] >= q.infraEnvLimit) {
+		q.rejected.Inc()
+		return false, q.windowStart.Add(q.window).Sub(now)
+	}
+	return true, 0
+}
+
+// add counts n bytes served for infraEnvID
+func (q *ByteQuota) add(infraEnvID string, n int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.resetExpired(q.now())
+	q.served += int64(n)
+	if q.infraEnvLimit > 0 {
+		q.infraEnvs[infraEnvID] += int64(n)
+	}
+}
+
+// usage returns the bytes served in the current window
+func (q *ByteQuota) usage() int64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.resetExpired(q.now())
+	return q.served
+}
+
+// rejectOverQuota writes a 429 error when the quota doesn't admit a download of the image
+// of infraEnvID, reporting when it does again
+func (h *isoHandler) rejectOverQuota(w http.ResponseWriter, infraEnvID string) bool {
+	ok, retryAfter := h.byteQuota.admit(infraEnvID)
+	if ok {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	httpErrorf(w, http.StatusTooManyRequests, ErrorCodeQuotaExceeded, "byte quota exceeded for image %s, retry in %s", infraEnvID, retryAfter.Round(time.Second))
+	return true
+}
+
+// quotaResponseWriter counts the bytes written against a byte quota
+type quotaResponseWriter struct {
+	http.ResponseWriter
+	quota      *ByteQuota
+	infraEnvID string
+}
+
+func (w *quotaResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.quota.add(w.infraEnvID, n)
+	return n, err
+}
+
+func (w *quotaResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying connection
+func (w *quotaResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// quotaWriter wraps w to count the image bytes against the byte quota of the handler, if any
+func (h *isoHandler) quotaWriter(w http.ResponseWriter, infraEnvID string) http.ResponseWriter {
+	if h.byteQuota == nil {
+		return w
+	}
+	return &quotaResponseWriter{ResponseWriter: w, quota: h.byteQuota, infraEnvID: infraEnvID}
+}
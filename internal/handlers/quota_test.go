@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+)
+
+var _ = Describe("ByteQuota", func() {
+	var (
+		reg     *prometheus.Registry
+		now     time.Time
+		imageID = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+		otherID = "c1b3f2a4-eeee-49dc-ab9c-3fb4c1f07071"
+	)
+
+	newQuota := func(globalLimit, infraEnvLimit int64) *ByteQuota {
+		q, err := NewByteQuota(globalLimit, infraEnvLimit, time.Hour, reg)
+		Expect(err).NotTo(HaveOccurred())
+		q.now = func() time.Time { return now }
+		return q
+	}
+
+	metric := func(name string) float64 {
+		families, err := reg.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() == name {
+				m := family.GetMetric()[0]
+				if m.GetGauge() != nil {
+					return m.GetGauge().GetValue()
+				}
+				return m.GetCounter().GetValue()
+			}
+		}
+		Fail(fmt.Sprintf("metric %s not found", name))
+		return 0
+	}
+
+	BeforeEach(func() {
+		reg = prometheus.NewRegistry()
+		now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	})
+
+	It("rejects invalid quotas", func() {
+		_, err := NewByteQuota(0, 0, time.Hour, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = NewByteQuota(-1, 10, time.Hour, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = NewByteQuota(10, 0, 0, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("admits downloads until the total quota is reached and again in the next window", func() {
+		q := newQuota(100, 0)
+		Expect(q.admit(imageID)).To(BeTrue())
+		q.add(imageID, 60)
+		Expect(q.admit(otherID)).To(BeTrue())
+		q.add(otherID, 60)
+		Expect(metric("assisted_image_service_quota_served_bytes")).To(Equal(float64(120)))
+
+		now = now.Add(20 * time.Minute)
+		ok, retryAfter := q.admit(imageID)
+		Expect(ok).To(BeFalse())
+		Expect(retryAfter).To(Equal(40 * time.Minute))
+		Expect(metric("assisted_image_service_quota_rejected_requests_total")).To(Equal(float64(1)))
+
+		now = now.Add(40 * time.Minute)
+		Expect(q.admit(imageID)).To(BeTrue())
+		Expect(metric("assisted_image_service_quota_served_bytes")).To(Equal(float64(0)))
+	})
+
+	It("admits the downloads of other infra-envs once one reaches its quota", func() {
+		q := newQuota(0, 100)
+		q.add(imageID, 100)
+		ok, _ := q.admit(imageID)
+		Expect(ok).To(BeFalse())
+		Expect(q.admit(otherID)).To(BeTrue())
+	})
+
+	It("admits every download without a quota", func() {
+		var q *ByteQuota
+		Expect(q.admit(imageID)).To(BeTrue())
+	})
+
+	Context("serving images", func() {
+		var (
+			server         *httptest.Server
+			assistedServer *ghttp.Server
+			imageFile      string
+		)
+
+		get := func(id string) *http.Response {
+			resp, err := server.Client().Get(fmt.Sprintf("%s/byid/%s/4.8/x86_64/full.iso", server.URL, id))
+			Expect(err).NotTo(HaveOccurred())
+			return resp
+		}
+
+		expectImage := func(resp *http.Response) {
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			content, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("someisocontent"))
+		}
+
+		BeforeEach(func() {
+			f, err := os.CreateTemp("", "quota")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = f.WriteString("someisocontent")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(f.Close()).To(Succeed())
+			imageFile = f.Name()
+
+			assistedServer = ghttp.NewServer()
+			for _, id := range []string{imageID, otherID} {
+				assistedServer.RouteToHandler("GET", fmt.Sprintf(fileRouteFormat, id), ghttp.RespondWith(http.StatusOK, ""))
+				assistedServer.RouteToHandler("GET", fmt.Sprintf(infraEnvPathFormat, id), ghttp.RespondWith(http.StatusOK, "{}"))
+			}
+			u, err := url.Parse(assistedServer.URL())
+			Expect(err).NotTo(HaveOccurred())
+			asc, err := NewAssistedServiceClient(u.Scheme, u.Host, "")
+			Expect(err).NotTo(HaveOccurred())
+
+			mockImageStore := imagestore.NewMockImageStore(gomock.NewController(GinkgoT()))
+			mockImageStore.EXPECT().HaveVersion("4.8", "x86_64").Return(true).AnyTimes()
+			mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, "4.8", "x86_64").Return(imageFile).AnyTimes()
+			h := &isoHandler{
+				ImageStore: mockImageStore,
+				client:     asc,
+				urlParser:  parseShortURL,
+			}
+			WithByteQuota(newQuota(0, int64(len("someisocontent"))))(h)
+			server = httptest.NewServer((&ImageHandler{byID: h}).router(1))
+		})
+
+		AfterEach(func() {
+			server.Close()
+			assistedServer.Close()
+			os.Remove(imageFile)
+		})
+
+		It("rejects downloads past the quota until the window ends", func() {
+			expectImage(get(imageID))
+			Expect(metric("assisted_image_service_quota_served_bytes")).To(Equal(float64(len("someisocontent"))))
+
+			now = now.Add(30 * time.Minute)
+			resp := get(imageID)
+			defer resp.Body.Close()
+			expectJSONError(resp, http.StatusTooManyRequests, ErrorCodeQuotaExceeded)
+			Expect(resp.Header.Get("Retry-After")).To(Equal("1800"))
+			expectImage(get(otherID))
+
+			now = now.Add(30 * time.Minute)
+			expectImage(get(imageID))
+		})
+	})
+})
@@ -1,24 +1,155 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+)
+
+const (
+	readinessHeader   = "X-Readiness-Status"
+	readinessReady    = "ready"
+	readinessDegraded = "degraded"
 )
 
 type ReadinessHandler struct {
-	isEnabled bool
+	isEnabled atomic.Bool
+	// reports partially available versions as degraded when set
+	degradedStore imagestore.ImageStore
+	// when set, readiness fails while assisted service can't be reached
+	assistedService     *AssistedServiceClient
+	assistedCheckPeriod time.Duration
+	assistedUnreachable atomic.Bool
+}
+
+// ReadinessHandlerOption configures optional behavior of the readiness handler
+type ReadinessHandlerOption func(*ReadinessHandler)
+
+// WithDegradedReadiness makes the readiness endpoint tell apart a service that can serve all
+// its versions from one that can only serve some of them. A version can be served once its
+// full ISO is in the data directory. When only some can, or the minimal ISO of a version couldn't
+// be created, readiness still succeeds but reports the service as degraded, and fails when no
+// version can be served.
+func WithDegradedReadiness(is imagestore.ImageStore) ReadinessHandlerOption {
+	return func(a *ReadinessHandler) {
+		a.degradedStore = is
+	}
+}
+
+// WithAssistedServiceCheck makes readiness fail while assisted service can't be reached, so a
+// load balancer stops sending requests that would fail anyway. Assisted service is pinged every
+// interval by RunDependencyChecks, with the scheme, host and CA of client.
+func WithAssistedServiceCheck(client *AssistedServiceClient, interval time.Duration) ReadinessHandlerOption {
+	return func(a *ReadinessHandler) {
+		a.assistedService = client
+		a.assistedCheckPeriod = interval
+		// not ready until the first check succeeds
+		a.assistedUnreachable.Store(true)
+	}
 }
 
-func NewReadinessHandler() *ReadinessHandler {
-	return &ReadinessHandler{
-		isEnabled: false,
+func NewReadinessHandler(opts ...ReadinessHandlerOption) *ReadinessHandler {
+	a := &ReadinessHandler{}
+	for _, opt := range opts {
+		opt(a)
 	}
+	return a
+}
+
+type readinessResponse struct {
+	Status string `json:"status"`
+	// versions whose images aren't available, as <openshift_version>-<cpu_architecture>
+	UnavailableVersions []string `json:"unavailable_versions"`
+	// why the minimal ISO of a version couldn't be created, by <openshift_version>-<cpu_architecture>
+	MinimalISOErrors map[string]string `json:"minimal_iso_errors,omitempty"`
 }
 
 func (a *ReadinessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
-	a.runIfReady(http.HandlerFunc(ok), w, r)
+	if a.degradedStore != nil {
+		ok = a.serveStatus
+	}
+	a.runIfReady(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.assistedUnreachable.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		ok(w, r)
+	}), w, r)
+}
+
+// RunDependencyChecks pings the dependencies readiness is configured to check until ctx is done
+func (a *ReadinessHandler) RunDependencyChecks(ctx context.Context) {
+	if a.assistedService == nil {
+		return
+	}
+	ticker := time.NewTicker(a.assistedCheckPeriod)
+	defer ticker.Stop()
+	for {
+		a.checkAssistedService(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *ReadinessHandler) checkAssistedService(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, a.assistedCheckPeriod)
+	defer cancel()
+	err := a.assistedService.ping(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		// shutting down, the result says nothing about assisted service
+		return
+	}
+	wasUnreachable := a.assistedUnreachable.Swap(err != nil)
+	if err != nil && !wasUnreachable {
+		log.WithError(err).Warn("Assisted service is unreachable, reporting the service as not ready")
+	} else if err == nil && wasUnreachable {
+		log.Info("Assisted service is reachable")
+	}
+}
+
+func (a *ReadinessHandler) serveStatus(w http.ResponseWriter, r *http.Request) {
+	versions := a.degradedStore.AvailableVersions()
+	resp := readinessResponse{Status: readinessReady, UnavailableVersions: []string{}}
+	for _, v := range versions {
+		name := fmt.Sprintf("%s-%s", v.OpenshiftVersion, v.CPUArchitecture)
+		path := a.degradedStore.PathForParams(imagestore.ImageTypeFull, v.OpenshiftVersion, v.CPUArchitecture)
+		if _, err := os.Stat(path); err != nil {
+			resp.UnavailableVersions = append(resp.UnavailableVersions, name)
+		} else if v.MinimalISOError != "" {
+			if resp.MinimalISOErrors == nil {
+				resp.MinimalISOErrors = map[string]string{}
+			}
+			resp.MinimalISOErrors[name] = v.MinimalISOError
+		}
+	}
+
+	status := http.StatusOK
+	if len(resp.UnavailableVersions) > 0 || len(resp.MinimalISOErrors) > 0 {
+		resp.Status = readinessDegraded
+		if len(resp.UnavailableVersions) == len(versions) {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(readinessHeader, resp.Status)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Errorf("Failed to write readiness response: %v", err)
+	}
 }
 
 func (a *ReadinessHandler) WithMiddleware(next http.Handler) http.Handler {
@@ -28,7 +159,7 @@ func (a *ReadinessHandler) WithMiddleware(next http.Handler) http.Handler {
 }
 
 func (a *ReadinessHandler) runIfReady(next http.Handler, w http.ResponseWriter, r *http.Request) {
-	if !a.isEnabled {
+	if !a.isEnabled.Load() {
 		w.WriteHeader(http.StatusServiceUnavailable)
 		return
 	}
@@ -36,6 +167,11 @@ func (a *ReadinessHandler) runIfReady(next http.Handler, w http.ResponseWriter,
 }
 
 func (a *ReadinessHandler) Enable() {
-	a.isEnabled = true
+	a.isEnabled.Store(true)
 	log.Info("API is enabled")
 }
+
+func (a *ReadinessHandler) Disable() {
+	a.isEnabled.Store(false)
+	log.Info("API is disabled")
+}
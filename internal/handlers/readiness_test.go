@@ -1,12 +1,22 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
 
+	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
 )
 
 var _ = Describe("ServeHTTP", func() {
@@ -38,6 +48,108 @@ var _ = Describe("ServeHTTP", func() {
 		Expect(err).NotTo(HaveOccurred())
 		Expect(resp.StatusCode).To(Equal(http.StatusOK))
 	})
+
+	It("returns 503 after being disabled", func() {
+		handler.Enable()
+		handler.Disable()
+		resp, err := client.Get(fmt.Sprintf("%s/whatever", server.URL))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+	})
+})
+
+var _ = Describe("ServeHTTP with degraded readiness", func() {
+	var (
+		ctrl           *gomock.Controller
+		mockImageStore *imagestore.MockImageStore
+		dataDir        string
+		handler        *ReadinessHandler
+		server         *httptest.Server
+		client         *http.Client
+		versions       []imagestore.VersionInfo
+	)
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		mockImageStore = imagestore.NewMockImageStore(ctrl)
+		var err error
+		dataDir, err = os.MkdirTemp("", "readinesstest")
+		Expect(err).NotTo(HaveOccurred())
+
+		versions = []imagestore.VersionInfo{
+			{OpenshiftVersion: "4.8", CPUArchitecture: "x86_64"},
+			{OpenshiftVersion: "4.9", CPUArchitecture: "x86_64"},
+		}
+		mockImageStore.EXPECT().AvailableVersions().DoAndReturn(func() []imagestore.VersionInfo { return versions }).AnyTimes()
+		mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, version, arch string) string {
+				return filepath.Join(dataDir, version+"-"+arch+".iso")
+			}).AnyTimes()
+
+		handler = NewReadinessHandler(WithDegradedReadiness(mockImageStore))
+		server = httptest.NewServer(handler)
+		client = server.Client()
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dataDir)
+	})
+
+	withISO := func(name string) {
+		Expect(os.WriteFile(filepath.Join(dataDir, name+".iso"), []byte("iso"), 0600)).To(Succeed())
+	}
+
+	getStatus := func(expectedCode int) readinessResponse {
+		resp, err := client.Get(fmt.Sprintf("%s/health", server.URL))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(expectedCode))
+		var status readinessResponse
+		Expect(json.NewDecoder(resp.Body).Decode(&status)).To(Succeed())
+		Expect(resp.Header.Get(readinessHeader)).To(Equal(status.Status))
+		return status
+	}
+
+	It("returns 503 when not ready", func() {
+		resp, err := client.Get(fmt.Sprintf("%s/health", server.URL))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("reports ready when all versions are available", func() {
+		withISO("4.8-x86_64")
+		withISO("4.9-x86_64")
+		handler.Enable()
+		status := getStatus(http.StatusOK)
+		Expect(status.Status).To(Equal(readinessReady))
+		Expect(status.UnavailableVersions).To(BeEmpty())
+	})
+
+	It("reports degraded when some versions are available", func() {
+		withISO("4.8-x86_64")
+		handler.Enable()
+		status := getStatus(http.StatusOK)
+		Expect(status.Status).To(Equal(readinessDegraded))
+		Expect(status.UnavailableVersions).To(Equal([]string{"4.9-x86_64"}))
+	})
+
+	It("returns 503 when no version is available", func() {
+		handler.Enable()
+		status := getStatus(http.StatusServiceUnavailable)
+		Expect(status.Status).To(Equal(readinessDegraded))
+		Expect(status.UnavailableVersions).To(Equal([]string{"4.8-x86_64", "4.9-x86_64"}))
+	})
+
+	It("reports degraded with the errors of the minimal ISOs that couldn't be created", func() {
+		withISO("4.8-x86_64")
+		withISO("4.9-x86_64")
+		versions[1].MinimalISOError = "failed to create minimal iso template"
+		handler.Enable()
+		status := getStatus(http.StatusOK)
+		Expect(status.Status).To(Equal(readinessDegraded))
+		Expect(status.UnavailableVersions).To(BeEmpty())
+		Expect(status.MinimalISOErrors).To(Equal(map[string]string{"4.9-x86_64": "failed to create minimal iso template"}))
+	})
 })
 
 var _ = Describe("WithMiddleware", func() {
@@ -72,3 +184,92 @@ var _ = Describe("WithMiddleware", func() {
 		Expect(resp.StatusCode).To(Equal(http.StatusTeapot))
 	})
 })
+
+var _ = Describe("ServeHTTP with the assisted service check", func() {
+	var (
+		assistedServer *ghttp.Server
+		assistedUp     atomic.Bool
+		caFile         string
+		handler        *ReadinessHandler
+		server         *httptest.Server
+		cancel         context.CancelFunc
+	)
+
+	status := func() int {
+		resp, err := server.Client().Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	BeforeEach(func() {
+		assistedServer = ghttp.NewTLSServer()
+		assistedServer.AllowUnhandledRequests = true
+		assistedServer.RouteToHandler("GET", "/assisted/health", func(w http.ResponseWriter, r *http.Request) {
+			if assistedUp.Load() {
+				w.WriteHeader(http.StatusOK)
+			} else {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		})
+		assistedUp.Store(true)
+
+		f, err := os.CreateTemp("", "readiness-ca")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: assistedServer.HTTPTestServer.Certificate().Raw})).To(Succeed())
+		Expect(f.Close()).To(Succeed())
+		caFile = f.Name()
+
+		asc, err := NewAssistedServiceClient("https", assistedServer.Addr(), caFile, WithBasePath("/assisted"))
+		Expect(err).NotTo(HaveOccurred())
+		handler = NewReadinessHandler(WithAssistedServiceCheck(asc, 10*time.Millisecond))
+		handler.Enable()
+		server = httptest.NewServer(handler)
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		go handler.RunDependencyChecks(ctx)
+	})
+
+	AfterEach(func() {
+		cancel()
+		server.Close()
+		assistedServer.Close()
+		os.Remove(caFile)
+	})
+
+	It("follows the reachability of assisted service", func() {
+		Eventually(status).Should(Equal(http.StatusOK))
+
+		assistedUp.Store(false)
+		Eventually(status).Should(Equal(http.StatusServiceUnavailable))
+
+		assistedUp.Store(true)
+		Eventually(status).Should(Equal(http.StatusOK))
+	})
+
+	It("reports the service as not ready when assisted service is stopped", func() {
+		Eventually(status).Should(Equal(http.StatusOK))
+		assistedServer.Close()
+		Eventually(status).Should(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("reports the service as not ready before assisted service is checked", func() {
+		cancel()
+		other := NewReadinessHandler(WithAssistedServiceCheck(nil, time.Second))
+		other.Enable()
+		recorder := httptest.NewRecorder()
+		other.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("doesn't gate the API on assisted service", func() {
+		assistedUp.Store(false)
+		Eventually(status).Should(Equal(http.StatusServiceUnavailable))
+
+		teapot := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+		recorder := httptest.NewRecorder()
+		handler.WithMiddleware(teapot).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/images", nil))
+		Expect(recorder.Code).To(Equal(http.StatusTeapot))
+	})
+})
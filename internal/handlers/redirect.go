@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ISORedirect builds the URL full ISO downloads are redirected to from a template.
+// The template may contain the {image_id}, {version}, {arch}, {type}, {expires}
+// and {signature} placeholders.
+type ISORedirect struct {
+	template   string
+	signingKey []byte
+	ttl        time.Duration
+	now        func() time.Time
+}
+
+func NewISORedirect(template, signingKey string, ttl time.Duration) (*ISORedirect, error) {
+	if _, err := url.Parse(template); err != nil {
+		return nil, fmt.Errorf("invalid redirect URL template %s: %w", template, err)
+	}
+	if strings.Contains(template, "{signature}") && signingKey == "" {
+		return nil, fmt.Errorf("redirect URL template %s requires a signing key", template)
+	}
+	return &ISORedirect{
+		template:   template,
+		signingKey: []byte(signingKey),
+		ttl:        ttl,
+		now:        time.Now,
+	}, nil
+}
+
+// URL returns the redirect location for the given download
+func (r *ISORedirect) URL(params *imageDownloadParams) string {
+	expires := strconv.FormatInt(r.now().Add(r.ttl).Unix(), 10)
+	replacer := strings.NewReplacer(
+		"{image_id}", url.PathEscape(params.imageID),
+		"{version}", url.PathEscape(params.version),
+		"{arch}", url.PathEscape(params.arch),
+		"{type}", url.PathEscape(params.imageType),
+		"{expires}", expires,
+		"{signature}", r.signature(params, expires),
+	)
+	return replacer.Replace(r.template)
+}
+
+// signature is the hex encoded HMAC-SHA256 of the download parameters and expiry
+func (r *ISORedirect) signature(params *imageDownloadParams, expires string) string {
+	if len(r.signingKey) == 0 {
+		return ""
+	}
+	mac := hmac.New(sha256.New, r.signingKey)
+	mac.Write([]byte(strings.Join([]string{params.imageID, params.imageType, params.version, params.arch, expires}, ":")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
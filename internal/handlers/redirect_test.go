@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ISORedirect", func() {
+	var (
+		params = &imageDownloadParams{
+			imageID:   "bf25292a-dddd-49dc-ab9c-3fb4c1f07071",
+			version:   "4.16",
+			arch:      "x86_64",
+			imageType: "full-iso",
+		}
+		now = time.Unix(1700000000, 0)
+	)
+
+	It("substitutes the download parameters", func() {
+		redirect, err := NewISORedirect("https://cdn.example.com/{version}/{arch}/{type}/{image_id}.iso", "", time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(redirect.URL(params)).To(Equal("https://cdn.example.com/4.16/x86_64/full-iso/bf25292a-dddd-49dc-ab9c-3fb4c1f07071.iso"))
+	})
+
+	It("signs the URL", func() {
+		redirect, err := NewISORedirect("https://cdn.example.com/{version}/{arch}.iso?expires={expires}&sig={signature}", "secret", time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		redirect.now = func() time.Time { return now }
+
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte("bf25292a-dddd-49dc-ab9c-3fb4c1f07071:full-iso:4.16:x86_64:1700003600"))
+		expected := "https://cdn.example.com/4.16/x86_64.iso?expires=1700003600&sig=" + hex.EncodeToString(mac.Sum(nil))
+		Expect(redirect.URL(params)).To(Equal(expected))
+	})
+
+	It("escapes the substituted values", func() {
+		redirect, err := NewISORedirect("https://cdn.example.com/{version}.iso", "", time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(redirect.URL(&imageDownloadParams{version: "../4.16"})).To(Equal("https://cdn.example.com/..%2F4.16.iso"))
+	})
+
+	It("requires a signing key when the template contains a signature", func() {
+		_, err := NewISORedirect("https://cdn.example.com/{version}.iso?sig={signature}", "", time.Hour)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails for an invalid template", func() {
+		_, err := NewISORedirect("://cdn.example.com/{version}.iso", "", time.Hour)
+		Expect(err).To(HaveOccurred())
+	})
+})
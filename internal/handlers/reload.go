@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+)
+
+// AdminSecretHeader must contain the configured shared secret for admin requests
+const AdminSecretHeader = "X-Admin-Secret"
+
+// adminAuthorized returns whether r carries secret in its AdminSecretHeader, never when secret is empty
+func adminAuthorized(r *http.Request, secret string) bool {
+	return secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(AdminSecretHeader)), []byte(secret)) == 1
+}
+
+// ReloadHandler re-reads the configured versions and populates the image store
+// with them in the background. The service is not ready while images are fetched.
+type ReloadHandler struct {
+	imageStore   imagestore.ImageStore
+	readiness    *ReadinessHandler
+	loadVersions func() ([]map[string]string, error)
+	secret       string
+
+	// populateLock serializes populate runs, generation is used to skip
+	// queued reloads that were superseded by a newer request
+	populateLock sync.Mutex
+	generation   atomic.Int64
+
+	// the image store is warmed up before the service is marked ready when set
+	warmupTimeout time.Duration
+	// when set, the mappings of the images replaced or removed by populate are dropped
+	mappedFiles *isoeditor.MappedFiles
+	// when set, run after the image store is populated at startup
+	selfTest *SelfTest
+	// reloads are canceled when it's done
+	ctx context.Context
+}
+
+// ReloadHandlerOption configures the ReloadHandler
+type ReloadHandlerOption func(*ReloadHandler)
+
+// WithWarmup warms up the image store after it's populated and before the service is marked
+// ready, so the first requests to a new instance aren't slowed down by cold reads. The service
+// is marked ready anyway once timeout elapses. A zero timeout disables the warmup.
+func WithWarmup(timeout time.Duration) ReloadHandlerOption {
+	return func(h *ReloadHandler) {
+		h.warmupTimeout = timeout
+	}
+}
+
+// WithMappedFilesPrune drops the mappings of the images replaced or removed once the image
+// store is populated, rather than when they are next requested
+func WithMappedFilesPrune(files *isoeditor.MappedFiles) ReloadHandlerOption {
+	return func(h *ReloadHandler) {
+		h.mappedFiles = files
+	}
+}
+
+// WithReloadContext cancels the reloads in progress when ctx is done, such as on shutdown
+func WithReloadContext(ctx context.Context) ReloadHandlerOption {
+	return func(h *ReloadHandler) {
+		h.ctx = ctx
+	}
+}
+
+var _ http.Handler = &ReloadHandler{}
+
+type reloadResponse struct {
+	Versions []map[string]string `json:"versions"`
+}
+
+func NewReloadHandler(is imagestore.ImageStore, readiness *ReadinessHandler, loadVersions func() ([]map[string]string, error), secret string, opts ...ReloadHandlerOption) *ReloadHandler {
+	h := &ReloadHandler{
+		imageStore:   is,
+		readiness:    readiness,
+		loadVersions: loadVersions,
+		secret:       secret,
+		ctx:          context.Background(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Populate fills the image store with the current versions and marks the service ready
+func (h *ReloadHandler) Populate(ctx context.Context) error {
+	h.populateLock.Lock()
+	defer h.populateLock.Unlock()
+	return h.populateLocked(ctx, h.selfTest)
+}
+
+// populateLocked populates the image store with populateLock held, running selfTest when set
+func (h *ReloadHandler) populateLocked(ctx context.Context, selfTest *SelfTest) error {
+	if err := h.imageStore.Populate(ctx); err != nil {
+		return err
+	}
+	if h.mappedFiles != nil {
+		h.mappedFiles.Prune()
+	}
+	h.warmup(ctx)
+	if err := selfTest.run(ctx, h.imageStore); err != nil {
+		if selfTest.blockReadiness {
+			log.WithError(err).Error("Image self-test failed, the service won't be marked ready")
+			return nil
+		}
+		log.WithError(err).Warn("Image self-test failed")
+	}
+	h.readiness.Enable()
+	return nil
+}
+
+// warmup warms up the image store when enabled, failures only delay readiness until the timeout
+func (h *ReloadHandler) warmup(ctx context.Context) {
+	if h.warmupTimeout <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.warmupTimeout)
+	defer cancel()
+	log.Info("Warming up the image store")
+	if err := h.imageStore.Warmup(ctx); errors.Is(err, context.DeadlineExceeded) {
+		log.Warnf("Image store warmup didn't finish within %s, marking the service ready anyway", h.warmupTimeout)
+	} else if err != nil {
+		log.WithError(err).Warn("Failed to warm up the image store")
+	}
+}
+
+// PopulateAfterJitter waits for a random duration up to maxJitter before calling Populate so
+// replicas started together don't all download from the mirrors at once. The service stays
+// not ready while waiting, and the wait ends early with the context error when ctx is done.
+func (h *ReloadHandler) PopulateAfterJitter(ctx context.Context, maxJitter time.Duration) error {
+	if maxJitter > 0 {
+		delay := time.Duration(rand.Int63n(int64(maxJitter)))
+		log.Infof("Waiting %s before populating the image store", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return h.Populate(ctx)
+}
+
+func (h *ReloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		httpErrorf(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Only POST is supported with this endpoint.")
+		return
+	}
+
+	if !adminAuthorized(r, h.secret) {
+		httpErrorf(w, http.StatusUnauthorized, ErrorCodeUnauthorized, "Missing or invalid %s header", AdminSecretHeader)
+		return
+	}
+
+	versions, err := h.loadVersions()
+	if err != nil {
+		httpErrorf(w, http.StatusInternalServerError, ErrorCodeInternal, "Failed to load versions: %v", err)
+		return
+	}
+
+	generation := h.generation.Add(1)
+	go h.reload(h.ctx, generation, versions)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(reloadResponse{Versions: versions}); err != nil {
+		log.Errorf("Failed to write response: %v", err)
+	}
+}
+
+func (h *ReloadHandler) reload(ctx context.Context, generation int64, versions []map[string]string) {
+	h.populateLock.Lock()
+	defer h.populateLock.Unlock()
+
+	if generation != h.generation.Load() {
+		log.Infof("Skipping reload %d as a newer reload was requested", generation)
+		return
+	}
+
+	if err := h.imageStore.SetVersions(versions); err != nil {
+		log.WithError(err).Error("Failed to set reloaded versions")
+		return
+	}
+
+	log.Info("Reloading image store")
+	h.readiness.Disable()
+	// the self-test only runs at startup
+	if err := h.populateLocked(ctx, nil); err != nil {
+		log.WithError(err).Error("Failed to populate image store after reload")
+		// the versions whose images are in place are still served rather than none
+		if ctx.Err() == nil {
+			h.readiness.Enable()
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+)
+
+var _ = Describe("ReloadHandler", func() {
+	var (
+		ctrl           *gomock.Controller
+		mockImageStore *imagestore.MockImageStore
+		readiness      *ReadinessHandler
+		handler        *ReloadHandler
+		server         *httptest.Server
+		versions       []map[string]string
+		loadErr        error
+		secret         = "supersecret"
+	)
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		mockImageStore = imagestore.NewMockImageStore(ctrl)
+		readiness = NewReadinessHandler()
+		readiness.Enable()
+		versions = []map[string]string{{
+			"openshift_version": "4.16",
+			"cpu_architecture":  "x86_64",
+			"url":               "http://example.com/rhcos-4.16.iso",
+			"version":           "416.94.202405291527-0",
+		}}
+		loadErr = nil
+		loadVersions := func() ([]map[string]string, error) {
+			return versions, loadErr
+		}
+		handler = NewReloadHandler(mockImageStore, readiness, loadVersions, secret)
+		server = httptest.NewServer(handler)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	reload := func(secret string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/admin/reload", nil)
+		Expect(err).NotTo(HaveOccurred())
+		if secret != "" {
+			req.Header.Set(AdminSecretHeader, secret)
+		}
+		resp, err := server.Client().Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		return resp
+	}
+
+	It("sets the new versions and populates the image store", func() {
+		populated := make(chan struct{})
+		release := make(chan struct{})
+		mockImageStore.EXPECT().SetVersions(versions).Return(nil)
+		mockImageStore.EXPECT().Populate(gomock.Any()).DoAndReturn(func(_ context.Context) error {
+			close(populated)
+			<-release
+			return nil
+		})
+
+		resp := reload(secret)
+		Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+		var body struct {
+			Versions []map[string]string `json:"versions"`
+		}
+		Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+		Expect(body.Versions).To(Equal(versions))
+
+		Eventually(populated).Should(BeClosed())
+		Expect(readiness.isEnabled.Load()).To(BeFalse())
+		close(release)
+		Eventually(readiness.isEnabled.Load).Should(BeTrue())
+	})
+
+	It("marks the service ready again when populating fails", func() {
+		populated := make(chan struct{})
+		mockImageStore.EXPECT().SetVersions(versions).Return(nil)
+		mockImageStore.EXPECT().Populate(gomock.Any()).DoAndReturn(func(_ context.Context) error {
+			defer close(populated)
+			Expect(readiness.isEnabled.Load()).To(BeFalse())
+			return errors.New("download failed")
+		})
+
+		resp := reload(secret)
+		Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+		Eventually(populated).Should(BeClosed())
+		Eventually(readiness.isEnabled.Load).Should(BeTrue())
+	})
+
+	It("cancels the reload with its context", func() {
+		server.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		handler = NewReloadHandler(mockImageStore, readiness, func() ([]map[string]string, error) { return versions, nil }, secret, WithReloadContext(ctx))
+		server = httptest.NewServer(handler)
+		populated := make(chan struct{})
+		mockImageStore.EXPECT().SetVersions(versions).Return(nil)
+		mockImageStore.EXPECT().Populate(gomock.Any()).DoAndReturn(func(ctx context.Context) error {
+			defer close(populated)
+			cancel()
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		Expect(reload(secret).StatusCode).To(Equal(http.StatusAccepted))
+		Eventually(populated).Should(BeClosed())
+		Consistently(readiness.isEnabled.Load).Should(BeFalse())
+	})
+
+	It("serializes concurrent reloads", func() {
+		release := make(chan struct{})
+		running := make(chan struct{}, 2)
+		mockImageStore.EXPECT().SetVersions(gomock.Any()).Return(nil).AnyTimes()
+		mockImageStore.EXPECT().Populate(gomock.Any()).DoAndReturn(func(_ context.Context) error {
+			running <- struct{}{}
+			<-release
+			return nil
+		}).MinTimes(1).MaxTimes(2)
+
+		Expect(reload(secret).StatusCode).To(Equal(http.StatusAccepted))
+		Eventually(running).Should(HaveLen(1))
+		Expect(reload(secret).StatusCode).To(Equal(http.StatusAccepted))
+		Consistently(running).Should(HaveLen(1))
+		close(release)
+		Eventually(readiness.isEnabled.Load).Should(BeTrue())
+	})
+
+	It("rejects requests without the shared secret", func() {
+		expectJSONError(reload(""), http.StatusUnauthorized, ErrorCodeUnauthorized)
+	})
+
+	It("rejects requests with the wrong shared secret", func() {
+		expectJSONError(reload("wrong"), http.StatusUnauthorized, ErrorCodeUnauthorized)
+	})
+
+	It("rejects methods other than POST", func() {
+		resp, err := server.Client().Get(server.URL + "/admin/reload")
+		Expect(err).NotTo(HaveOccurred())
+		expectJSONError(resp, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed)
+	})
+
+	It("fails when the versions can't be loaded", func() {
+		loadErr = errors.New("invalid versions")
+		expectJSONError(reload(secret), http.StatusInternalServerError, ErrorCodeInternal)
+		Expect(readiness.isEnabled.Load()).To(BeTrue())
+	})
+
+	It("marks the service ready after the initial populate", func() {
+		readiness.Disable()
+		mockImageStore.EXPECT().Populate(gomock.Any()).Return(nil)
+		Expect(handler.Populate(context.Background())).To(Succeed())
+		Expect(readiness.isEnabled.Load()).To(BeTrue())
+	})
+
+	It("populates within the start jitter window", func() {
+		readiness.Disable()
+		mockImageStore.EXPECT().Populate(gomock.Any()).Return(nil)
+		start := time.Now()
+		Expect(handler.PopulateAfterJitter(context.Background(), 200*time.Millisecond)).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(readiness.isEnabled.Load()).To(BeTrue())
+	})
+
+	It("stops waiting for the start jitter when cancelled", func() {
+		readiness.Disable()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- handler.PopulateAfterJitter(ctx, time.Hour)
+		}()
+
+		Consistently(readiness.isEnabled.Load, 100*time.Millisecond).Should(BeFalse())
+		cancel()
+		var err error
+		Eventually(done).Should(Receive(&err))
+		Expect(err).To(MatchError(context.Canceled))
+		Expect(readiness.isEnabled.Load()).To(BeFalse())
+	})
+
+	Context("with a warmup", func() {
+		BeforeEach(func() {
+			readiness.Disable()
+		})
+
+		It("stays not ready until the warmup finishes", func() {
+			handler = NewReloadHandler(mockImageStore, readiness, nil, secret, WithWarmup(time.Minute))
+			warming := make(chan struct{})
+			release := make(chan struct{})
+			populate := mockImageStore.EXPECT().Populate(gomock.Any()).Return(nil)
+			mockImageStore.EXPECT().Warmup(gomock.Any()).DoAndReturn(func(_ context.Context) error {
+				close(warming)
+				<-release
+				return nil
+			}).After(populate)
+
+			done := make(chan error, 1)
+			go func() {
+				done <- handler.Populate(context.Background())
+			}()
+			Eventually(warming).Should(BeClosed())
+			Consistently(readiness.isEnabled.Load, 100*time.Millisecond).Should(BeFalse())
+			close(release)
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(readiness.isEnabled.Load()).To(BeTrue())
+		})
+
+		It("marks the service ready when the warmup times out", func() {
+			handler = NewReloadHandler(mockImageStore, readiness, nil, secret, WithWarmup(100*time.Millisecond))
+			mockImageStore.EXPECT().Populate(gomock.Any()).Return(nil)
+			mockImageStore.EXPECT().Warmup(gomock.Any()).DoAndReturn(func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			})
+
+			Expect(handler.Populate(context.Background())).To(Succeed())
+			Expect(readiness.isEnabled.Load()).To(BeTrue())
+		})
+
+		It("marks the service ready when the warmup fails", func() {
+			handler = NewReloadHandler(mockImageStore, readiness, nil, secret, WithWarmup(time.Minute))
+			mockImageStore.EXPECT().Populate(gomock.Any()).Return(nil)
+			mockImageStore.EXPECT().Warmup(gomock.Any()).Return(errors.New("read failed"))
+
+			Expect(handler.Populate(context.Background())).To(Succeed())
+			Expect(readiness.isEnabled.Load()).To(BeTrue())
+		})
+
+		It("warms up after a reload", func() {
+			server.Close()
+			handler = NewReloadHandler(mockImageStore, readiness, func() ([]map[string]string, error) { return versions, nil }, secret, WithWarmup(time.Minute))
+			server = httptest.NewServer(handler)
+			mockImageStore.EXPECT().SetVersions(versions).Return(nil)
+			populate := mockImageStore.EXPECT().Populate(gomock.Any()).Return(nil)
+			mockImageStore.EXPECT().Warmup(gomock.Any()).Return(nil).After(populate)
+
+			Expect(reload(secret).StatusCode).To(Equal(http.StatusAccepted))
+			Eventually(readiness.isEnabled.Load).Should(BeTrue())
+		})
+	})
+})
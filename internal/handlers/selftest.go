@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+)
+
+// selfTestIgnition and selfTestKargs are embedded in the images generated by the self-test
+var (
+	selfTestIgnition = []byte(`{"ignition":{"version":"3.1.0"}}`)
+	selfTestKargs    = []byte(" selftest\n")
+)
+
+// SelfTest generates an image of each type of every available version once the image store is
+// populated at startup, so versions that can't be served, such as those with a corrupted base
+// ISO or no kernel arguments embed area, are found before they are requested
+type SelfTest struct {
+	generate isoeditor.StreamGeneratorFunc
+	// when set, the service isn't marked ready while a version fails
+	blockReadiness bool
+}
+
+// NewSelfTest creates a self-test generating the images with generate. When blockReadiness is
+// set the service isn't marked ready when a version fails, otherwise the failures are only logged.
+func NewSelfTest(generate isoeditor.StreamGeneratorFunc, blockReadiness bool) *SelfTest {
+	return &SelfTest{generate: generate, blockReadiness: blockReadiness}
+}
+
+// WithSelfTest runs the self-test after the image store is populated at startup
+func WithSelfTest(selfTest *SelfTest) ReloadHandlerOption {
+	return func(h *ReloadHandler) {
+		h.selfTest = selfTest
+	}
+}
+
+// run generates and discards the images of the versions of is, logging the result of each
+// version, and returns the errors of the versions that failed
+func (t *SelfTest) run(ctx context.Context, is imagestore.ImageStore) error {
+	if t == nil {
+		return nil
+	}
+	log.Info("Running the image self-test")
+	var errs []error
+	for _, v := range is.AvailableVersions() {
+		imageTypes := []string{imagestore.ImageTypeFull}
+		if v.SupportsMinimal {
+			imageTypes = append(imageTypes, imagestore.ImageTypeMinimal)
+		}
+		for _, imageType := range imageTypes {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			err := t.generateImage(is.PathForParams(imageType, v.OpenshiftVersion, v.CPUArchitecture), v.CPUArchitecture)
+			if err != nil {
+				log.WithError(err).Errorf("Self-test of the %s of %s-%s failed", imageType, v.OpenshiftVersion, v.CPUArchitecture)
+				errs = append(errs, fmt.Errorf("%s of %s-%s: %w", imageType, v.OpenshiftVersion, v.CPUArchitecture, err))
+				continue
+			}
+			log.Infof("Self-test of the %s of %s-%s passed", imageType, v.OpenshiftVersion, v.CPUArchitecture)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// generateImage reads the whole image generated from isoPath, as it would be streamed
+func (t *SelfTest) generateImage(isoPath, arch string) error {
+	kargs := selfTestKargs
+	if arch == "s390x" {
+		// kernel arguments can't be embedded in s390x images
+		kargs = nil
+	}
+	r, err := t.generate(isoPath, &isoeditor.IgnitionContent{Config: selfTestIgnition}, nil, kargs)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// a truncated base ISO leaves the image shorter than its size
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("read %d bytes of an image of %d bytes", n, size)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+)
+
+var _ = Describe("SelfTest", func() {
+	var (
+		mockImageStore *imagestore.MockImageStore
+		readiness      *ReadinessHandler
+		validISO       string
+		corruptedISO   string
+	)
+
+	BeforeEach(func() {
+		mockImageStore = imagestore.NewMockImageStore(gomock.NewController(GinkgoT()))
+		readiness = NewReadinessHandler()
+
+		validISO = createTestISOWithFiles(map[string]string{
+			"images/ignition.img": strings.Repeat("\x00", 256*1024),
+			"coreos/kargs.json":   `{"default": "coreos.liveiso=rhcos-48", "files": [{"path": "EFI/redhat/grub.cfg"}]}`,
+			"EFI/redhat/grub.cfg": "menuentry 'RHEL CoreOS (Live)' {\n\tlinux /images/pxeboot/vmlinuz coreos.liveiso=rhcos-48" +
+				"\n" + strings.Repeat("#", 200) + " COREOS_KARG_EMBED_AREA\n\tinitrd /images/pxeboot/initrd.img /images/ignition.img\n}\n",
+		})
+		f, err := os.CreateTemp("", "selftest")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = f.WriteString("this isn't an iso")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+		corruptedISO = f.Name()
+
+		mockImageStore.EXPECT().Populate(gomock.Any()).Return(nil).AnyTimes()
+		mockImageStore.EXPECT().AvailableVersions().Return([]imagestore.VersionInfo{
+			{OpenshiftVersion: "4.8", CPUArchitecture: "x86_64", SupportsMinimal: true},
+			{OpenshiftVersion: "4.9", CPUArchitecture: "x86_64"},
+		}).AnyTimes()
+		mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, "4.8", "x86_64").Return(validISO).AnyTimes()
+		mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeMinimal, "4.8", "x86_64").Return(validISO).AnyTimes()
+		mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, "4.9", "x86_64").Return(corruptedISO).AnyTimes()
+	})
+
+	AfterEach(func() {
+		os.Remove(validISO)
+		os.Remove(corruptedISO)
+	})
+
+	It("detects the versions whose images can't be generated", func() {
+		err := NewSelfTest(isoeditor.NewRHCOSStreamReader, false).run(context.Background(), mockImageStore)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("full-iso of 4.9-x86_64"))
+		Expect(err.Error()).NotTo(ContainSubstring("4.8-x86_64"))
+	})
+
+	It("passes when every image can be generated", func() {
+		is := imagestore.NewMockImageStore(gomock.NewController(GinkgoT()))
+		is.EXPECT().AvailableVersions().Return([]imagestore.VersionInfo{{OpenshiftVersion: "4.8", CPUArchitecture: "x86_64", SupportsMinimal: true}})
+		is.EXPECT().PathForParams(gomock.Any(), "4.8", "x86_64").Return(validISO).Times(2)
+		Expect(NewSelfTest(isoeditor.NewRHCOSStreamReader, true).run(context.Background(), is)).To(Succeed())
+	})
+
+	It("keeps the service not ready when a version fails with blocking readiness", func() {
+		handler := NewReloadHandler(mockImageStore, readiness, nil, "", WithSelfTest(NewSelfTest(isoeditor.NewRHCOSStreamReader, true)))
+		Expect(handler.Populate(context.Background())).To(Succeed())
+		Expect(readiness.isEnabled.Load()).To(BeFalse())
+	})
+
+	It("marks the service ready when a version fails without blocking readiness", func() {
+		handler := NewReloadHandler(mockImageStore, readiness, nil, "", WithSelfTest(NewSelfTest(isoeditor.NewRHCOSStreamReader, false)))
+		Expect(handler.Populate(context.Background())).To(Succeed())
+		Expect(readiness.isEnabled.Load()).To(BeTrue())
+	})
+})
@@ -6,12 +6,16 @@ import (
 	"fmt"
 	"net/http"
 	"regexp"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 )
 
 var jwtPayloadRegexp = regexp.MustCompile(`^.+\.(.+)\..+`)
 
+// imageIDRegexp matches the InfraEnv IDs that identify images, as accepted by the router
+var imageIDRegexp = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
 type payload struct {
 	Sub        string `json:"sub"`          // used by OCM tokens
 	InfraEnvID string `json:"infra_env_id"` // used by local auth tokens
@@ -20,7 +24,18 @@ type payload struct {
 // parseShortURL parses short-style URLs, where URL path segments are used to
 // hierarchically identify the desired resource. The URL ordering and structure
 // is defined on the router.
-func parseShortURL(r *http.Request) (*imageDownloadParams, int, error) {
+var parseShortURL = shortURLParser("")
+
+// shortURLParser returns a parser for short-style URLs that resolves the requests for an ISO
+// file name that doesn't name a type, such as image.iso, to defaultImageType, or rejects them
+// when it's empty
+func shortURLParser(defaultImageType string) urlParserFunc {
+	return func(r *http.Request) (*imageDownloadParams, int, error) {
+		return parseShortURLWithDefault(r, defaultImageType)
+	}
+}
+
+func parseShortURLWithDefault(r *http.Request, defaultImageType string) (*imageDownloadParams, int, error) {
 	imageID := chi.URLParam(r, "image_id")
 	token := chi.URLParam(r, "token")
 	if token == "" {
@@ -39,6 +54,9 @@ func parseShortURL(r *http.Request) (*imageDownloadParams, int, error) {
 			return nil, http.StatusNotFound, err
 		}
 	}
+	if !imageIDRegexp.MatchString(imageID) {
+		return nil, http.StatusBadRequest, parameterError("image_id", parameterInvalid, "invalid value '%s' for parameter 'image_id'", imageID)
+	}
 
 	params := imageDownloadParams{
 		imageID: imageID,
@@ -52,6 +70,10 @@ func parseShortURL(r *http.Request) (*imageDownloadParams, int, error) {
 	case "full.iso":
 		params.imageType = "full-iso"
 	default:
+		if defaultImageType != "" && strings.HasSuffix(filename, ".iso") {
+			params.imageType = defaultImageType
+			break
+		}
 		return nil, http.StatusNotFound, fmt.Errorf("unrecognized file name %s", filename)
 	}
 
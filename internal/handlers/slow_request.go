@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Phases of an image request reported for slow requests
+const (
+	phaseSetup    = "setup"
+	phaseIgnition = "ignition"
+	phaseRamdisk  = "ramdisk"
+	phaseKargs    = "kargs"
+	phaseStream   = "stream"
+)
+
+// SlowRequestTracker reports image requests that take longer than a threshold, with the phase
+// of the request that took the most time, as aggregate latencies hide individual offenders
+type SlowRequestTracker struct {
+	threshold time.Duration
+	requests  *prometheus.CounterVec
+}
+
+// NewSlowRequestTracker creates a tracker for requests taking longer than threshold.
+// The slow request counter is registered with reg when it is not nil.
+func NewSlowRequestTracker(threshold time.Duration, reg prometheus.Registerer) (*SlowRequestTracker, error) {
+	if threshold <= 0 {
+		return nil, fmt.Errorf("invalid slow request threshold %s: must be positive", threshold)
+	}
+	t := &SlowRequestTracker{
+		threshold: threshold,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "assisted_image_service_slow_image_requests_total",
+			Help: "Number of image requests that took longer than the slow request threshold, by the phase that took the most time",
+		}, []string{"phase"}),
+	}
+	if reg != nil {
+		if err := reg.Register(t.requests); err != nil {
+			return nil, fmt.Errorf("failed to register slow request metrics: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// requestPhases times the phases of a single request, it is nil when no tracker is configured
+type requestPhases struct {
+	tracker    *SlowRequestTracker
+	start      time.Time
+	phase      string
+	phaseStart time.Time
+	durations  map[string]time.Duration
+}
+
+func (t *SlowRequestTracker) begin() *requestPhases {
+	if t == nil {
+		return nil
+	}
+	now := time.Now()
+	return &requestPhases{
+		tracker:    t,
+		start:      now,
+		phase:      phaseSetup,
+		phaseStart: now,
+		durations:  map[string]time.Duration{},
+	}
+}
+
+// enter ends the current phase and starts the given one
+func (p *requestPhases) enter(phase string) {
+	if p == nil {
+		return
+	}
+	now := time.Now()
+	p.durations[p.phase] += now.Sub(p.phaseStart)
+	p.phase = phase
+	p.phaseStart = now
+}
+
+// done ends the request and reports it when it was slow
+func (p *requestPhases) done(params *imageDownloadParams) {
+	if p == nil {
+		return
+	}
+	p.enter("")
+	total := time.Since(p.start)
+	if total <= p.tracker.threshold {
+		return
+	}
+
+	dominant := phaseSetup
+	fields := log.Fields{"duration": total.String()}
+	for phase, duration := range p.durations {
+		fields[phase+"_duration"] = duration.String()
+		if duration > p.durations[dominant] {
+			dominant = phase
+		}
+	}
+	fields["infra_env_id"] = params.imageID
+	fields["version"] = params.version
+	fields["type"] = params.imageType
+	fields["arch"] = params.arch
+	fields["phase"] = dominant
+
+	p.tracker.requests.WithLabelValues(dominant).Inc()
+	log.WithFields(fields).Warnf("Image request took longer than %s, mostly in the %s phase", p.tracker.threshold, dominant)
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+)
+
+var _ = Describe("SlowRequestTracker", func() {
+	var (
+		imageID        = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+		assistedServer *ghttp.Server
+		server         *httptest.Server
+		imageFile      string
+		reg            *prometheus.Registry
+		ignitionDelay  time.Duration
+	)
+
+	slowRequests := func(phase string) float64 {
+		families, err := reg.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() != "assisted_image_service_slow_image_requests_total" {
+				continue
+			}
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "phase" && label.GetValue() == phase {
+						return metric.GetCounter().GetValue()
+					}
+				}
+			}
+		}
+		return 0
+	}
+
+	BeforeEach(func() {
+		ctrl := gomock.NewController(GinkgoT())
+		mockImageStore := imagestore.NewMockImageStore(ctrl)
+
+		f, err := os.CreateTemp("", "slow_request")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = f.WriteString("someisocontent")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+		imageFile = f.Name()
+		mockImageStore.EXPECT().HaveVersion("4.8", "x86_64").Return(true).AnyTimes()
+		mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, "4.8", "x86_64").Return(imageFile).AnyTimes()
+
+		ignitionDelay = 0
+		assistedServer = ghttp.NewServer()
+		assistedServer.RouteToHandler("GET", fmt.Sprintf(fileRouteFormat, imageID), func(w http.ResponseWriter, _ *http.Request) {
+			time.Sleep(ignitionDelay)
+			_, _ = w.Write([]byte("someignitioncontent"))
+		})
+		assistedServer.RouteToHandler("GET", fmt.Sprintf(infraEnvPathFormat, imageID), ghttp.RespondWith(http.StatusOK, "{}"))
+
+		u, err := url.Parse(assistedServer.URL())
+		Expect(err).NotTo(HaveOccurred())
+		asc, err := NewAssistedServiceClient(u.Scheme, u.Host, "")
+		Expect(err).NotTo(HaveOccurred())
+
+		reg = prometheus.NewRegistry()
+		tracker, err := NewSlowRequestTracker(100*time.Millisecond, reg)
+		Expect(err).NotTo(HaveOccurred())
+
+		h := &isoHandler{
+			ImageStore: mockImageStore,
+			GenerateImageStream: func(isoPath string, _ *isoeditor.IgnitionContent, _, _ []byte) (isoeditor.ImageReader, error) {
+				return os.Open(isoPath)
+			},
+			client:    asc,
+			urlParser: parseLongURL,
+		}
+		WithSlowRequestTracker(tracker)(h)
+		handler := &ImageHandler{long: h}
+		server = httptest.NewServer(handler.router(1))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		assistedServer.Close()
+		os.Remove(imageFile)
+	})
+
+	download := func() {
+		resp, err := server.Client().Get(fmt.Sprintf("%s/images/%s?version=4.8&type=full-iso", server.URL, imageID))
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	}
+
+	It("counts requests slowed down by the ignition fetch", func() {
+		ignitionDelay = 200 * time.Millisecond
+		download()
+		// the request is reported once the handler returns, which may be after the response is read
+		Eventually(func() float64 { return slowRequests(phaseIgnition) }).Should(Equal(float64(1)))
+		Expect(slowRequests(phaseStream)).To(BeZero())
+	})
+
+	It("doesn't count fast requests", func() {
+		download()
+		Expect(slowRequests(phaseIgnition)).To(BeZero())
+		Expect(slowRequests(phaseStream)).To(BeZero())
+	})
+
+	It("fails for an invalid threshold", func() {
+		_, err := NewSlowRequestTracker(0, nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+)
+
+// WithStreamBufferSize copies generated images to the response through a buffer of the given
+// size rather than the default 32KiB one
+func WithStreamBufferSize(size int) ImageHandlerOption {
+	return func(h *isoHandler) {
+		if size > 0 {
+			h.streamBufferSize = size
+		}
+	}
+}
+
+// bufferedResponseWriter copies the content written with ReadFrom, as http.ServeContent
+// does, through a buffer of a fixed size
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	size int
+}
+
+func (w *bufferedResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	// io.CopyBuffer ignores the buffer when the destination implements io.ReaderFrom,
+	// which the underlying writer does
+	return io.CopyBuffer(struct{ io.Writer }{w.ResponseWriter}, src, make([]byte, w.size))
+}
+
+func (w *bufferedResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying connection
+func (w *bufferedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// streamWriter returns the writer images are streamed to
+func (h *isoHandler) streamWriter(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	w = h.throttledWriter(w, r)
+	if h.streamBufferSize == 0 {
+		return w
+	}
+	return &bufferedResponseWriter{ResponseWriter: w, size: h.streamBufferSize}
+}
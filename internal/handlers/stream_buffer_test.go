@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// streamHandler serves content through a buffer of the given size, 0 uses the default copy
+func streamHandler(content []byte, size int) http.Handler {
+	h := &isoHandler{}
+	WithStreamBufferSize(size)(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(h.streamWriter(w, r), r, "image.iso", time.Time{}, bytes.NewReader(content))
+	})
+}
+
+var _ = Describe("WithStreamBufferSize", func() {
+	var (
+		content []byte
+		server  *httptest.Server
+	)
+
+	BeforeEach(func() {
+		content = make([]byte, 100*1024+3)
+		_, err := rand.Read(content)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	get := func(rangeHeader string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		Expect(err).NotTo(HaveOccurred())
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		resp, err := server.Client().Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return resp, body
+	}
+
+	DescribeTable("streams the whole content",
+		func(size int) {
+			server = httptest.NewServer(streamHandler(content, size))
+			resp, body := get("")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(body).To(Equal(content))
+		},
+		Entry("with the default buffer", 0),
+		Entry("with a one byte buffer", 1),
+		Entry("with a buffer that doesn't divide the content", 7),
+		Entry("with a 64KiB buffer", 64*1024),
+		Entry("with a buffer larger than the content", 1024*1024),
+	)
+
+	It("streams ranges through a tiny buffer", func() {
+		server = httptest.NewServer(streamHandler(content, 3))
+		resp, body := get("bytes=1000-70000")
+		Expect(resp.StatusCode).To(Equal(http.StatusPartialContent))
+		Expect(body).To(Equal(content[1000:70001]))
+	})
+
+	It("ignores sizes that aren't positive", func() {
+		h := &isoHandler{}
+		WithStreamBufferSize(-1)(h)
+		w := httptest.NewRecorder()
+		Expect(h.streamWriter(w, httptest.NewRequest(http.MethodGet, "/", nil))).To(BeIdenticalTo(w))
+	})
+})
+
+func BenchmarkStreamBufferSize(b *testing.B) {
+	content := make([]byte, 64*1024*1024)
+	for _, size := range []int{0, 4 * 1024, 64 * 1024, 1024 * 1024} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			server := httptest.NewServer(streamHandler(content, size))
+			defer server.Close()
+			client := server.Client()
+
+			b.SetBytes(int64(len(content)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := client.Get(server.URL)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+					b.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+		})
+	}
+}
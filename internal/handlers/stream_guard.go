@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+)
+
+// errStreamFailed is returned for the image content written after an error response replaced it
+var errStreamFailed = errors.New("image stream failed before the response was sent")
+
+// AbortedStreams counts the image downloads cut off because the image failed to be read after
+// the response status was sent
+type AbortedStreams struct {
+	aborted prometheus.Counter
+}
+
+// NewAbortedStreams creates the aborted streams counter, registered with reg when it is not nil
+func NewAbortedStreams(reg prometheus.Registerer) (*AbortedStreams, error) {
+	s := &AbortedStreams{
+		aborted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "assisted_image_service_aborted_streams_total",
+			Help: "Number of image downloads aborted because the image failed to be read mid-stream",
+		}),
+	}
+	if reg != nil {
+		if err := reg.Register(s.aborted); err != nil {
+			return nil, fmt.Errorf("failed to register aborted streams metrics: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// WithAbortedStreams counts the image downloads aborted mid-stream
+func WithAbortedStreams(streams *AbortedStreams) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.abortedStreams = streams
+	}
+}
+
+func (s *AbortedStreams) inc() {
+	if s == nil {
+		return
+	}
+	s.aborted.Inc()
+}
+
+// maxConsecutiveEmptyReads is the number of reads in a row returning nothing without an error
+// after which the image is considered stalled, as in bufio
+const maxConsecutiveEmptyReads = 100
+
+// guardedReader records the first error reading an image, including the image ending before
+// the size it had when the response was sized and reads that keep making no progress
+type guardedReader struct {
+	isoeditor.ImageReader
+	// -1 until the image is sized by seeking to its end
+	size       int64
+	offset     int64
+	emptyReads int
+	err        error
+}
+
+func newGuardedReader(r isoeditor.ImageReader) *guardedReader {
+	return &guardedReader{ImageReader: r, size: -1}
+}
+
+func (g *guardedReader) Seek(offset int64, whence int) (int64, error) {
+	n, err := g.ImageReader.Seek(offset, whence)
+	if err != nil {
+		return n, err
+	}
+	if whence == io.SeekEnd && offset == 0 && g.size < 0 {
+		g.size = n
+	}
+	g.offset = n
+	return n, nil
+}
+
+func (g *guardedReader) Read(p []byte) (int, error) {
+	n, err := g.ImageReader.Read(p)
+	g.offset += int64(n)
+	if err == nil && n == 0 && len(p) > 0 {
+		g.emptyReads++
+	} else {
+		g.emptyReads = 0
+	}
+	switch {
+	case err == io.EOF && g.offset < g.size:
+		err = fmt.Errorf("image ended after %d of %d bytes: %w", g.offset, g.size, io.ErrUnexpectedEOF)
+	case g.emptyReads >= maxConsecutiveEmptyReads:
+		// a truncated base image leaves overlay readers returning nothing without an error
+		err = fmt.Errorf("image read made no progress after %d bytes: %w", g.offset, io.ErrNoProgress)
+	}
+	if err != nil && err != io.EOF && g.err == nil {
+		g.err = err
+	}
+	return n, err
+}
+
+// guardedResponseWriter replaces the image response with an error when the image failed to
+// be read before the status is sent
+type guardedResponseWriter struct {
+	http.ResponseWriter
+	reader    *guardedReader
+	committed bool
+	// an error response was sent instead of the image
+	failed bool
+}
+
+func (w *guardedResponseWriter) WriteHeader(statusCode int) {
+	if w.committed {
+		return
+	}
+	w.committed = true
+	if w.reader.err != nil && statusCode < http.StatusMultipleChoices {
+		w.failed = true
+		for _, header := range []string{"Content-Length", "Content-Range", "Content-Disposition", "Content-Encoding"} {
+			w.Header().Del(header)
+		}
+		writeHTTPError(w.ResponseWriter, NewHTTPError(http.StatusInternalServerError, ErrorCodeInternal, "Error reading image: %v", w.reader.err))
+		return
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *guardedResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.failed {
+		return 0, errStreamFailed
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *guardedResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying connection
+func (w *guardedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// serveGuardedContent serves the image read from isoReader, returning an error response when
+// it fails to be read before the status is sent. Once the status is sent the connection is
+// closed abruptly instead, so clients see the download is truncated rather than complete.
+func (h *isoHandler) serveGuardedContent(w http.ResponseWriter, r *http.Request, params *imageDownloadParams, fileName string, modTime time.Time, isoReader isoeditor.ImageReader) {
+	reader := newGuardedReader(isoReader)
+	writer := &guardedResponseWriter{ResponseWriter: w, reader: reader}
+	out, endCompression := h.compressedWriter(h.streamWriter(h.quotaWriter(writer, params.imageID), r), r)
+	http.ServeContent(out, r, fileName, modTime, reader)
+	if reader.err == nil || writer.failed {
+		endCompression()
+		return
+	}
+	log.Errorf("Aborting the download of image %s %s %s %s after failing to read it: %v", params.imageID, params.imageType, params.version, params.arch, reader.err)
+	h.abortedStreams.inc()
+	panic(http.ErrAbortHandler)
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+)
+
+// nopCloser adds a no-op Close to the readers used as images in tests
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+// stalledReader returns nothing without an error after its content, as an overlay reader does
+// once its base image is truncated
+type stalledReader struct {
+	io.ReadSeeker
+}
+
+func (r stalledReader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	if err == io.EOF {
+		return n, nil
+	}
+	return n, err
+}
+
+// hesitantReader returns nothing without an error before each read of its content, which the
+// io.Reader contract allows
+type hesitantReader struct {
+	io.ReadSeeker
+	hesitated bool
+}
+
+func (r *hesitantReader) Read(p []byte) (int, error) {
+	if !r.hesitated {
+		r.hesitated = true
+		return 0, nil
+	}
+	r.hesitated = false
+	return r.ReadSeeker.Read(p)
+}
+
+var _ = Describe("stream guard", func() {
+	var (
+		reg            *prometheus.Registry
+		aborted        *AbortedStreams
+		mockImageStore *imagestore.MockImageStore
+		server         *httptest.Server
+		assistedServer *ghttp.Server
+		imageFile      string
+		imageID        = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+	)
+
+	abortedStreams := func() float64 {
+		families, err := reg.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() == "assisted_image_service_aborted_streams_total" {
+				return family.GetMetric()[0].GetCounter().GetValue()
+			}
+		}
+		return 0
+	}
+
+	BeforeEach(func() {
+		reg = prometheus.NewRegistry()
+		var err error
+		aborted, err = NewAbortedStreams(reg)
+		Expect(err).NotTo(HaveOccurred())
+
+		mockImageStore = imagestore.NewMockImageStore(gomock.NewController(GinkgoT()))
+
+		f, err := os.CreateTemp("", "stream_guard")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Truncate(64 * 1024 * 1024)).To(Succeed())
+		Expect(f.Close()).To(Succeed())
+		imageFile = f.Name()
+	})
+
+	AfterEach(func() {
+		os.Remove(imageFile)
+	})
+
+	Context("serving images", func() {
+		BeforeEach(func() {
+			assistedServer = ghttp.NewServer()
+			assistedServer.RouteToHandler("GET", fmt.Sprintf(fileRouteFormat, imageID), ghttp.RespondWith(http.StatusOK, ""))
+			assistedServer.RouteToHandler("GET", fmt.Sprintf(infraEnvPathFormat, imageID), ghttp.RespondWith(http.StatusOK, "{}"))
+			u, err := url.Parse(assistedServer.URL())
+			Expect(err).NotTo(HaveOccurred())
+			asc, err := NewAssistedServiceClient(u.Scheme, u.Host, "")
+			Expect(err).NotTo(HaveOccurred())
+
+			mockImageStore.EXPECT().HaveVersion("4.8", "x86_64").Return(true).AnyTimes()
+			mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, "4.8", "x86_64").Return(imageFile).AnyTimes()
+			h := &isoHandler{
+				ImageStore: mockImageStore,
+				client:     asc,
+				urlParser:  parseShortURL,
+			}
+			WithAbortedStreams(aborted)(h)
+			server = httptest.NewServer((&ImageHandler{byID: h}).router(1))
+		})
+
+		AfterEach(func() {
+			server.Close()
+			assistedServer.Close()
+		})
+
+		It("serves complete images", func() {
+			resp, err := server.Client().Get(fmt.Sprintf("%s/byid/%s/4.8/x86_64/full.iso", server.URL, imageID))
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			n, err := io.Copy(io.Discard, resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(Equal(int64(64 * 1024 * 1024)))
+			Expect(abortedStreams()).To(Equal(float64(0)))
+		})
+
+		It("aborts downloads whose image is truncated mid-stream", func() {
+			resp, err := server.Client().Get(fmt.Sprintf("%s/byid/%s/4.8/x86_64/full.iso", server.URL, imageID))
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			// the image is far larger than the connection buffers, so the server is still streaming it
+			Expect(os.Truncate(imageFile, 1024)).To(Succeed())
+			n, err := io.Copy(io.Discard, resp.Body)
+			Expect(err).To(MatchError(io.ErrUnexpectedEOF))
+			Expect(n).To(BeNumerically("<", 64*1024*1024))
+			Eventually(abortedStreams).Should(Equal(float64(1)))
+		})
+	})
+
+	Describe("guardedReader", func() {
+		It("reports an image ending before its size", func() {
+			reader := newGuardedReader(nopCloser{strings.NewReader("content")})
+			Expect(reader.Seek(0, io.SeekEnd)).To(Equal(int64(7)))
+			Expect(reader.Seek(0, io.SeekStart)).To(Equal(int64(0)))
+			buf := make([]byte, 4)
+			Expect(reader.Read(buf)).To(Equal(4))
+
+			reader.ImageReader = nopCloser{strings.NewReader("")}
+			_, err := reader.Read(buf)
+			Expect(errors.Is(err, io.ErrUnexpectedEOF)).To(BeTrue())
+			Expect(reader.err).To(Equal(err))
+		})
+
+		It("reports reads that make no progress", func() {
+			reader := newGuardedReader(nopCloser{stalledReader{strings.NewReader("content")}})
+			content, err := io.ReadAll(reader)
+			Expect(errors.Is(err, io.ErrNoProgress)).To(BeTrue())
+			Expect(string(content)).To(Equal("content"))
+			Expect(reader.err).To(Equal(err))
+		})
+
+		It("doesn't report reads that make no progress only now and then", func() {
+			reader := newGuardedReader(nopCloser{&hesitantReader{ReadSeeker: strings.NewReader("content")}})
+			Expect(reader.Seek(0, io.SeekEnd)).To(Equal(int64(7)))
+			Expect(reader.Seek(0, io.SeekStart)).To(Equal(int64(0)))
+			content, err := io.ReadAll(reader)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("content"))
+			Expect(reader.err).NotTo(HaveOccurred())
+		})
+
+		It("doesn't report the end of the image", func() {
+			reader := newGuardedReader(nopCloser{strings.NewReader("content")})
+			Expect(reader.Seek(0, io.SeekEnd)).To(Equal(int64(7)))
+			Expect(reader.Seek(0, io.SeekStart)).To(Equal(int64(0)))
+			content, err := io.ReadAll(reader)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("content"))
+			Expect(reader.err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("guardedResponseWriter", func() {
+		It("replaces the image with an error when it failed to be read before the status is sent", func() {
+			reader := newGuardedReader(nopCloser{strings.NewReader("content")})
+			reader.err = errors.New("read failed")
+			recorder := httptest.NewRecorder()
+			writer := &guardedResponseWriter{ResponseWriter: recorder, reader: reader}
+			writer.Header().Set("Content-Length", "7")
+
+			_, err := writer.Write([]byte("content"))
+			Expect(err).To(MatchError(errStreamFailed))
+			Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+			Expect(recorder.Header().Get("Content-Length")).To(BeEmpty())
+			var httpErr HTTPError
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &httpErr)).To(Succeed())
+			Expect(httpErr.Code).To(Equal(ErrorCodeInternal))
+			Expect(httpErr.Message).To(ContainSubstring("read failed"))
+		})
+
+		It("passes through the image once the status is sent", func() {
+			reader := newGuardedReader(nopCloser{strings.NewReader("content")})
+			recorder := httptest.NewRecorder()
+			writer := &guardedResponseWriter{ResponseWriter: recorder, reader: reader}
+
+			writer.WriteHeader(http.StatusPartialContent)
+			reader.err = errors.New("read failed")
+			_, err := writer.Write([]byte("content"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recorder.Code).To(Equal(http.StatusPartialContent))
+			Expect(recorder.Body.String()).To(Equal("content"))
+		})
+	})
+})
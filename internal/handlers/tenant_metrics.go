@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values identifying the tenant of image requests in the per-tenant metrics
+const (
+	TenantLabelInfraEnv  = "infra-env"
+	TenantLabelPrincipal = "principal"
+)
+
+const (
+	// label of the tenants seen after the cap on distinct values was reached
+	tenantOverflow = "other"
+	// label of the requests without credentials when tenants are identified by principal
+	tenantAnonymous = "anonymous"
+	// number of hex characters of the principal hash kept in the label
+	principalHashLength = 16
+)
+
+// TenantMetrics counts image requests by tenant, identified either by infra-env ID or by a
+// hash of the credentials of the request. The number of distinct label values is capped as
+// each of them is a separate time series, tenants past the cap are counted as "other".
+type TenantMetrics struct {
+	label      string
+	maxTenants int
+	requests   *prometheus.CounterVec
+
+	lock    sync.Mutex
+	tenants map[string]struct{}
+}
+
+// NewTenantMetrics creates the per-tenant metrics identifying tenants by label, with at most
+// maxTenants distinct values. The request counter is registered with reg when it is not nil.
+func NewTenantMetrics(label string, maxTenants int, reg prometheus.Registerer) (*TenantMetrics, error) {
+	if label != TenantLabelInfraEnv && label != TenantLabelPrincipal {
+		return nil, fmt.Errorf("invalid tenant label %q: must be %s or %s", label, TenantLabelInfraEnv, TenantLabelPrincipal)
+	}
+	if maxTenants <= 0 {
+		return nil, fmt.Errorf("invalid maximum number of tenants %d: must be positive", maxTenants)
+	}
+	m := &TenantMetrics{
+		label:      label,
+		maxTenants: maxTenants,
+		tenants:    map[string]struct{}{},
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "assisted_image_service_tenant_image_requests_total",
+			Help: "Number of image requests by tenant, tenants past the configured maximum are counted as other",
+		}, []string{"tenant"}),
+	}
+	if reg != nil {
+		if err := reg.Register(m.requests); err != nil {
+			return nil, fmt.Errorf("failed to register tenant metrics: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// WithTenantMetrics counts the image requests by tenant
+func WithTenantMetrics(metrics *TenantMetrics) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.tenantMetrics = metrics
+	}
+}
+
+// observe counts an image request for its tenant
+func (m *TenantMetrics) observe(r *http.Request, params *imageDownloadParams) {
+	if m == nil {
+		return
+	}
+	tenant := params.imageID
+	if m.label == TenantLabelPrincipal {
+		tenant = hashedPrincipal(r)
+	}
+	m.requests.WithLabelValues(m.boundedTenant(tenant)).Inc()
+}
+
+// boundedTenant returns the label value of tenant, which is "other" for tenants first seen
+// after the cap was reached
+func (m *TenantMetrics) boundedTenant(tenant string) string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.tenants[tenant]; ok {
+		return tenant
+	}
+	if len(m.tenants) >= m.maxTenants {
+		return tenantOverflow
+	}
+	m.tenants[tenant] = struct{}{}
+	return tenant
+}
+
+// hashedPrincipal returns a hash of the credentials of the request, looked up in the same
+// order they are passed to assisted service, so tenants can be told apart without exposing them
+func hashedPrincipal(r *http.Request) string {
+	var principal string
+	for _, p := range []string{
+		chi.URLParam(r, "api_key"),
+		r.URL.Query().Get("api_key"),
+		chi.URLParam(r, "token"),
+		r.URL.Query().Get("image_token"),
+		r.Header.Get("Authorization"),
+	} {
+		if p != "" {
+			principal = p
+			break
+		}
+	}
+	if principal == "" {
+		return tenantAnonymous
+	}
+	sum := sha256.Sum256([]byte(principal))
+	return hex.EncodeToString(sum[:])[:principalHashLength]
+}
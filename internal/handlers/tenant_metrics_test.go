@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+)
+
+var _ = Describe("TenantMetrics", func() {
+	var reg *prometheus.Registry
+
+	// tenantRequests returns the request count of each tenant label value
+	tenantRequests := func() map[string]float64 {
+		counts := map[string]float64{}
+		families, err := reg.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		for _, family := range families {
+			if family.GetName() != "assisted_image_service_tenant_image_requests_total" {
+				continue
+			}
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "tenant" {
+						counts[label.GetValue()] = metric.GetCounter().GetValue()
+					}
+				}
+			}
+		}
+		return counts
+	}
+
+	BeforeEach(func() {
+		reg = prometheus.NewRegistry()
+	})
+
+	Context("serving images", func() {
+		var (
+			imageID        = "bf25292a-dddd-49dc-ab9c-3fb4c1f07071"
+			otherImageID   = "a7acfb01-d89f-40c8-82d7-02b20cf00173"
+			assistedServer *ghttp.Server
+			server         *httptest.Server
+			imageFile      string
+		)
+
+		startServer := func(label string, maxTenants int) {
+			metrics, err := NewTenantMetrics(label, maxTenants, reg)
+			Expect(err).NotTo(HaveOccurred())
+
+			u, err := url.Parse(assistedServer.URL())
+			Expect(err).NotTo(HaveOccurred())
+			asc, err := NewAssistedServiceClient(u.Scheme, u.Host, "")
+			Expect(err).NotTo(HaveOccurred())
+
+			ctrl := gomock.NewController(GinkgoT())
+			mockImageStore := imagestore.NewMockImageStore(ctrl)
+			mockImageStore.EXPECT().HaveVersion("4.8", "x86_64").Return(true).AnyTimes()
+			mockImageStore.EXPECT().PathForParams(imagestore.ImageTypeFull, "4.8", "x86_64").Return(imageFile).AnyTimes()
+
+			h := &isoHandler{
+				ImageStore: mockImageStore,
+				GenerateImageStream: func(isoPath string, _ *isoeditor.IgnitionContent, _, _ []byte) (isoeditor.ImageReader, error) {
+					return os.Open(isoPath)
+				},
+				client:    asc,
+				urlParser: parseLongURL,
+			}
+			WithTenantMetrics(metrics)(h)
+			server = httptest.NewServer((&ImageHandler{long: h}).router(1))
+		}
+
+		BeforeEach(func() {
+			f, err := os.CreateTemp("", "tenant_metrics")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = f.WriteString("someisocontent")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(f.Close()).To(Succeed())
+			imageFile = f.Name()
+
+			assistedServer = ghttp.NewServer()
+			for _, id := range []string{imageID, otherImageID} {
+				assistedServer.RouteToHandler("GET", fmt.Sprintf(fileRouteFormat, id), ghttp.RespondWith(http.StatusOK, "someignitioncontent"))
+				assistedServer.RouteToHandler("GET", fmt.Sprintf(infraEnvPathFormat, id), ghttp.RespondWith(http.StatusOK, "{}"))
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+			assistedServer.Close()
+			os.Remove(imageFile)
+		})
+
+		download := func(id, query string) {
+			resp, err := server.Client().Get(fmt.Sprintf("%s/images/%s?version=4.8&type=full-iso%s", server.URL, id, query))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		}
+
+		It("counts the requests by infra-env", func() {
+			startServer(TenantLabelInfraEnv, 10)
+			download(imageID, "")
+			download(imageID, "")
+			download(otherImageID, "")
+			Expect(tenantRequests()).To(Equal(map[string]float64{imageID: 2, otherImageID: 1}))
+		})
+
+		It("counts the requests by hashed credentials", func() {
+			startServer(TenantLabelPrincipal, 10)
+			download(imageID, "&api_key=secret-one")
+			download(otherImageID, "&api_key=secret-one")
+			download(imageID, "&api_key=secret-two")
+			download(imageID, "")
+
+			counts := tenantRequests()
+			Expect(counts).To(HaveLen(3))
+			Expect(counts).To(HaveKeyWithValue(tenantAnonymous, float64(1)))
+			for tenant := range counts {
+				Expect(tenant).NotTo(ContainSubstring("secret"))
+			}
+			Expect(counts).To(ContainElement(float64(2)))
+		})
+
+		It("buckets the tenants past the cap as other", func() {
+			startServer(TenantLabelInfraEnv, 1)
+			download(imageID, "")
+			download(otherImageID, "")
+			download(otherImageID, "")
+			// tenants seen before the cap was reached keep their own label
+			download(imageID, "")
+			Expect(tenantRequests()).To(Equal(map[string]float64{imageID: 2, tenantOverflow: 2}))
+		})
+	})
+
+	It("hashes the credentials consistently wherever they are passed", func() {
+		r := httptest.NewRequest(http.MethodGet, "/images/id?api_key=secret", nil)
+		fromQuery := hashedPrincipal(r)
+		r = httptest.NewRequest(http.MethodGet, "/images/id", nil)
+		r.Header.Set("Authorization", "secret")
+		Expect(hashedPrincipal(r)).To(Equal(fromQuery))
+		Expect(fromQuery).To(HaveLen(principalHashLength))
+
+		r = httptest.NewRequest(http.MethodGet, "/images/id?image_token=other", nil)
+		Expect(hashedPrincipal(r)).NotTo(Equal(fromQuery))
+	})
+
+	It("rejects invalid configurations", func() {
+		_, err := NewTenantMetrics("token", 10, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = NewTenantMetrics(TenantLabelInfraEnv, 0, nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
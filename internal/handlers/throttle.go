@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxThrottleChunk is the most bytes written at once under a throttle, concurrent downloads
+// take turns in chunks of this size at most so they share the bandwidth fairly
+const maxThrottleChunk = 16 * 1024
+
+// Throttle caps the rate at which images are streamed. A single throttle is shared by all
+// the downloads it's set on, so their combined rate stays under the cap.
+type Throttle struct {
+	bytesPerSec int64
+
+	lock sync.Mutex
+	// time at which the bytes reserved so far have been paid for
+	next time.Time
+}
+
+// NewThrottle creates a throttle allowing bytesPerSec bytes per second
+func NewThrottle(bytesPerSec int64) (*Throttle, error) {
+	if bytesPerSec <= 0 {
+		return nil, fmt.Errorf("invalid bandwidth cap %d: must be positive", bytesPerSec)
+	}
+	return &Throttle{bytesPerSec: bytesPerSec}, nil
+}
+
+// chunkSize is the number of bytes written at once, about a twentieth of a second worth
+func (t *Throttle) chunkSize() int {
+	size := t.bytesPerSec / 20
+	if size < 1 {
+		return 1
+	}
+	if size > maxThrottleChunk {
+		return maxThrottleChunk
+	}
+	return int(size)
+}
+
+// wait blocks until n more bytes can be written without going over the cap. Callers are
+// served in turn, which shares the bandwidth across them.
+func (t *Throttle) wait(ctx context.Context, n int) error {
+	t.lock.Lock()
+	now := time.Now()
+	// unused bandwidth isn't saved up, so an idle throttle doesn't allow a burst
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(int64(n) * int64(time.Second) / t.bytesPerSec))
+	delay := t.next.Sub(now)
+	t.lock.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WithThrottle caps the combined rate of the image downloads served by the handler
+func WithThrottle(throttle *Throttle) ImageHandlerOption {
+	return func(h *isoHandler) {
+		h.throttle = throttle
+	}
+}
+
+// WithDownloadThrottle caps the rate of each image download to bytesPerSec bytes per second
+func WithDownloadThrottle(bytesPerSec int64) ImageHandlerOption {
+	return func(h *isoHandler) {
+		if bytesPerSec > 0 {
+			h.downloadBytesPerSec = bytesPerSec
+		}
+	}
+}
+
+// throttledResponseWriter writes the response in chunks, waiting on each throttle before
+// every chunk
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	ctx       context.Context
+	throttles []*Throttle
+	chunk     int
+}
+
+func (w *throttledResponseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), w.chunk)
+		for _, t := range w.throttles {
+			if err := t.wait(w.ctx, n); err != nil {
+				return written, err
+			}
+		}
+		n, err := w.ResponseWriter.Write(p[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *throttledResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying connection
+func (w *throttledResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// throttledWriter wraps w with the throttles of the handler, if any
+func (h *isoHandler) throttledWriter(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	var throttles []*Throttle
+	if h.throttle != nil {
+		throttles = append(throttles, h.throttle)
+	}
+	if h.downloadBytesPerSec > 0 {
+		// a throttle of its own, so the download doesn't share it with any other
+		throttles = append(throttles, &Throttle{bytesPerSec: h.downloadBytesPerSec})
+	}
+	if len(throttles) == 0 {
+		return w
+	}
+	chunk := maxThrottleChunk
+	for _, t := range throttles {
+		chunk = min(chunk, t.chunkSize())
+	}
+	return &throttledResponseWriter{ResponseWriter: w, ctx: r.Context(), throttles: throttles, chunk: chunk}
+}
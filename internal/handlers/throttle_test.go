@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Throttle", func() {
+	var (
+		content []byte
+		server  *httptest.Server
+	)
+
+	BeforeEach(func() {
+		content = make([]byte, 50*1024)
+		_, err := rand.Read(content)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		if server != nil {
+			server.Close()
+			server = nil
+		}
+	})
+
+	serve := func(opts ...ImageHandlerOption) {
+		h := &isoHandler{}
+		for _, opt := range opts {
+			opt(h)
+		}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(h.streamWriter(w, r), r, "image.iso", time.Time{}, bytes.NewReader(content))
+		}))
+	}
+
+	download := func() []byte {
+		resp, err := server.Client().Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		body, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return body
+	}
+
+	It("caps the rate of a download", func() {
+		throttle, err := NewThrottle(100 * 1024)
+		Expect(err).NotTo(HaveOccurred())
+		serve(WithThrottle(throttle), WithStreamBufferSize(64*1024))
+
+		start := time.Now()
+		Expect(download()).To(Equal(content))
+		Expect(time.Since(start)).To(BeNumerically(">=", 500*time.Millisecond))
+	})
+
+	It("shares the cap across concurrent downloads", func() {
+		throttle, err := NewThrottle(200 * 1024)
+		Expect(err).NotTo(HaveOccurred())
+		serve(WithThrottle(throttle))
+
+		start := time.Now()
+		var wg sync.WaitGroup
+		durations := make([]time.Duration, 4)
+		for i := range durations {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(download()).To(Equal(content))
+				durations[i] = time.Since(start)
+			}(i)
+		}
+		wg.Wait()
+
+		// 200KiB at 200KiB/s, with each download getting its share until the end
+		for _, d := range durations {
+			Expect(d).To(BeNumerically(">=", 750*time.Millisecond))
+		}
+	})
+
+	It("caps each download on its own", func() {
+		serve(WithDownloadThrottle(100 * 1024))
+
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(download()).To(Equal(content))
+			}()
+		}
+		wg.Wait()
+
+		elapsed := time.Since(start)
+		Expect(elapsed).To(BeNumerically(">=", 500*time.Millisecond))
+		// the downloads don't wait for each other
+		Expect(elapsed).To(BeNumerically("<", 1*time.Second))
+	})
+
+	It("stops waiting when the request is canceled", func() {
+		throttle, err := NewThrottle(1)
+		Expect(err).NotTo(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		Expect(throttle.wait(ctx, 1024)).To(MatchError(context.Canceled))
+	})
+
+	It("rejects caps that aren't positive", func() {
+		_, err := NewThrottle(0)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("leaves the writer alone when no cap is set", func() {
+		serve(WithDownloadThrottle(0))
+		Expect(download()).To(Equal(content))
+		h := &isoHandler{}
+		w := httptest.NewRecorder()
+		Expect(h.throttledWriter(w, httptest.NewRequest(http.MethodGet, "/", nil))).To(BeIdenticalTo(w))
+	})
+})
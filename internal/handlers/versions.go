@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+	log "github.com/sirupsen/logrus"
+)
+
+// VersionsHandler lists the versions and architectures that can be served
+type VersionsHandler struct {
+	ImageStore imagestore.ImageStore
+}
+
+var _ http.Handler = &VersionsHandler{}
+
+func (h *VersionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodHead}, ", "))
+		httpErrorf(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Only GET and HEAD methods are supported with this endpoint.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(h.ImageStore.AvailableVersions()); err != nil {
+		log.Errorf("Failed to write response: %v", err)
+	}
+}
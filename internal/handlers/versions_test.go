@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+)
+
+var _ = Describe("VersionsHandler", func() {
+	var (
+		mockImageStore *imagestore.MockImageStore
+		server         *httptest.Server
+		versions       = []imagestore.VersionInfo{
+			{OpenshiftVersion: "4.11", CPUArchitecture: "x86_64", SupportsMinimal: true},
+			{OpenshiftVersion: "4.14", CPUArchitecture: "x86_64", SupportsMinimal: true, SupportsNmstate: true, VolumeID: "rhcos-414.92.202310170514-0"},
+			{OpenshiftVersion: "4.14", CPUArchitecture: "s390x"},
+		}
+	)
+
+	BeforeEach(func() {
+		mockImageStore = imagestore.NewMockImageStore(gomock.NewController(GinkgoT()))
+		server = httptest.NewServer(&VersionsHandler{ImageStore: mockImageStore})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("lists the available versions", func() {
+		mockImageStore.EXPECT().AvailableVersions().Return(versions)
+		resp, err := server.Client().Get(server.URL + "/images")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+		Expect(resp.Header.Get("Vary")).To(BeEmpty())
+
+		body, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(body).To(MatchJSON(`[
+			{"openshift_version": "4.11", "cpu_architecture": "x86_64", "supports_minimal": true, "supports_nmstate": false},
+			{"openshift_version": "4.14", "cpu_architecture": "x86_64", "supports_minimal": true, "supports_nmstate": true, "volume_id": "rhcos-414.92.202310170514-0"},
+			{"openshift_version": "4.14", "cpu_architecture": "s390x", "supports_minimal": false, "supports_nmstate": false}
+		]`))
+	})
+
+	It("returns an empty list when no versions are available", func() {
+		mockImageStore.EXPECT().AvailableVersions().Return([]imagestore.VersionInfo{})
+		resp, err := server.Client().Get(server.URL + "/images")
+		Expect(err).NotTo(HaveOccurred())
+		var listed []imagestore.VersionInfo
+		Expect(json.NewDecoder(resp.Body).Decode(&listed)).To(Succeed())
+		Expect(listed).To(BeEmpty())
+	})
+
+	It("supports HEAD requests", func() {
+		resp, err := server.Client().Head(server.URL + "/images")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+	})
+
+	It("fails for unsupported methods", func() {
+		resp, err := server.Client().Post(server.URL+"/images", "application/json", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Header.Get("Allow")).To(Equal("GET, HEAD"))
+		expectJSONError(resp, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed)
+	})
+})
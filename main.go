@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/openshift/assisted-image-service/internal/handlers"
@@ -14,6 +16,7 @@ import (
 	"github.com/openshift/assisted-image-service/pkg/isoeditor"
 	"github.com/openshift/assisted-image-service/pkg/servers"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	metrics "github.com/slok/go-http-metrics/metrics/prometheus"
@@ -33,30 +36,229 @@ var Options struct {
 
 	ListenPort            string `envconfig:"LISTEN_PORT" default:"8080"`
 	HTTPListenPort        string `envconfig:"HTTP_LISTEN_PORT"`
+	UnixSocketPath        string `envconfig:"UNIX_SOCKET_PATH"`
 	MaxConcurrentRequests int64  `envconfig:"MAX_CONCURRENT_REQUESTS" default:"400"`
 	RHCOSVersions         string `envconfig:"RHCOS_VERSIONS"`
 	OSImages              string `envconfig:"OS_IMAGES"`
+	OSImagesFile          string `envconfig:"OS_IMAGES_FILE"`
+	MinOpenshiftVersion   string `envconfig:"MIN_OPENSHIFT_VERSION"`
+	MaxOpenshiftVersion   string `envconfig:"MAX_OPENSHIFT_VERSION"`
 	AllowedDomains        string `envconfig:"ALLOWED_DOMAINS"`
 	InsecureSkipVerify    bool   `envconfig:"INSECURE_SKIP_VERIFY" default:"false"`
 	ImageServiceBaseURL   string `envconfig:"IMAGE_SERVICE_BASE_URL"`
 	LogLevel              string `envconfig:"LOGLEVEL" default:"info"`
 
-	// This is a path to a CA file that will be trusted when fetching OS Images
+	// Architecture used for requests that don't specify one, resolved from the configured versions when unset
+	DefaultArch string `envconfig:"DEFAULT_ARCH"`
+	// Image type used for requests that don't specify one, which are rejected when empty
+	DefaultImageType string `envconfig:"DEFAULT_IMAGE_TYPE"`
+
+	// This is a colon separated list of CA files or directories that will be trusted when fetching OS Images
 	// intended for scenarios where the OS images are served from a service that uses a custom CA
 	OSImageDownloadTrustedCAFile string `envconfig:"OS_IMAGE_DOWNLOAD_TRUSTED_CA_FILE" default:""`
 
-	// This is a path to a CA file that will be trusted for TLS connections to the Assisted Service API
+	// This is a colon separated list of CA files or directories that will be trusted for TLS connections to the Assisted Service API
 	// this will be used for API calls back to the Assisted Service API
-	// Will default to the value held in HTTPS_CA_FILE unless overridden
-
+	// Will default to the value held in HTTPS_CA_FILE unless overridden or the fallback is disabled
 	AssistedServiceApiTrustedCAFile string `envconfig:"ASSISTED_SERVICE_API_TRUSTED_CA_FILE"`
 
+	// Ignore the deprecated HTTPS_CA_FILE, with a warning, when ASSISTED_SERVICE_API_TRUSTED_CA_FILE isn't set
+	DisableHTTPSCAFileFallback bool `envconfig:"DISABLE_HTTPS_CA_FILE_FALLBACK" default:"false"`
+
+	// Path prefix for assisted service requests when it is served under a path by an ingress
+	AssistedServiceBasePath string `envconfig:"ASSISTED_SERVICE_BASE_PATH"`
+
+	// Path of the minimal ISO initrd, with an {image_id} placeholder, the assisted service route when empty
+	AssistedServiceMinimalInitrdPath string `envconfig:"ASSISTED_SERVICE_MINIMAL_INITRD_PATH"`
+
+	// Names of the image request query parameters forwarded to assisted service, none when empty
+	AssistedServiceForwardedQueryParams []string `envconfig:"ASSISTED_SERVICE_FORWARDED_QUERY_PARAMS"`
+
+	// Skip the InfraEnv kernel arguments with operations other than append instead of failing the request
+	SkipUnsupportedKargsOperations bool `envconfig:"SKIP_UNSUPPORTED_KARGS_OPERATIONS" default:"false"`
+
+	// Base64 encoded SHA-256 hashes of the public keys accepted from assisted service over TLS
+	AssistedServiceSPKIPins []string `envconfig:"ASSISTED_SERVICE_SPKI_PINS"`
+
+	// Consecutive assisted service failures within the window that open the circuit breaker, 0 disables it
+	AssistedServiceCircuitBreakerThreshold int           `envconfig:"ASSISTED_SERVICE_CIRCUIT_BREAKER_THRESHOLD" default:"0"`
+	AssistedServiceCircuitBreakerWindow    time.Duration `envconfig:"ASSISTED_SERVICE_CIRCUIT_BREAKER_WINDOW" default:"1m"`
+	AssistedServiceCircuitBreakerCooldown  time.Duration `envconfig:"ASSISTED_SERVICE_CIRCUIT_BREAKER_COOLDOWN" default:"30s"`
+
+	// Requests a single assisted service request may be redirected through, credentials are never sent to other hosts
+	AssistedServiceMaxRedirects int `envconfig:"ASSISTED_SERVICE_MAX_REDIRECTS" default:"10"`
+
+	// Retries of assisted service requests failing with a network error, 429 or 5xx, and the delay before the first one
+	AssistedServiceRetries      int           `envconfig:"ASSISTED_SERVICE_RETRIES" default:"0"`
+	AssistedServiceRetryBackoff time.Duration `envconfig:"ASSISTED_SERVICE_RETRY_BACKOFF" default:"500ms"`
+
 	// OSImagesRequestHeaders contains a JSON encoded representation of any
 	// HTTP headers to be sent with every request to download an OS image.
 	OSImagesRequestHeaders string `envconfig:"OS_IMAGES_REQUEST_HEADERS" default:""`
+	// OSImagesRequestHeadersFile and OSImagesRequestHeadersCommand provide a JSON object of
+	// headers that is read again before each download, for values that expire such as tokens.
+	OSImagesRequestHeadersFile    string `envconfig:"OS_IMAGES_REQUEST_HEADERS_FILE"`
+	OSImagesRequestHeadersCommand string `envconfig:"OS_IMAGES_REQUEST_HEADERS_COMMAND"`
 	// OSImagesRequestQueryParams contains a JSON encoded representation of any
 	// query parameters to be sent with every request to download an OS image.
 	OSImagesRequestQueryParams string `envconfig:"OS_IMAGES_REQUEST_QUERY_PARAMS" default:""`
+
+	MaxConcurrentDownloads   int64 `envconfig:"MAX_CONCURRENT_DOWNLOADS" default:"4"`
+	MaxConcurrentMinimalISOs int64 `envconfig:"MAX_CONCURRENT_MINIMAL_ISOS" default:"1"`
+	MaxConcurrentExtractions int64 `envconfig:"MAX_CONCURRENT_EXTRACTIONS" default:"2"`
+
+	// Maximum number of configured versions, guards against configurations listing far more images than intended
+	MaxVersions int `envconfig:"MAX_VERSIONS" default:"100"`
+
+	// Keep serving the other versions and the full ISO of a version whose minimal ISO can't be created
+	IsolateMinimalISOFailures bool `envconfig:"ISOLATE_MINIMAL_ISO_FAILURES" default:"false"`
+
+	// Keep serving the minimal ISO created by a previous deploy when it can't be created again
+	KeepStaleMinimalOnFailure bool `envconfig:"KEEP_STALE_MINIMAL_ON_FAILURE" default:"false"`
+
+	// Prefix of the image file names in the data directory, for distributions other than RHCOS
+	ISOFilePrefix string `envconfig:"ISO_FILE_PREFIX" default:"rhcos"`
+
+	// Fail startup and reloads when the versions aren't consistent for their architecture and openshift version
+	StrictVersionValidation bool `envconfig:"STRICT_VERSION_VALIDATION" default:"false"`
+
+	// Copy downloaded images into the data directory instead of renaming them, for filesystems without reliable rename
+	DataDirCopyReplace bool `envconfig:"DATA_DIR_COPY_REPLACE" default:"false"`
+
+	// Serve images from base ISOs mapped in memory rather than opened for each request
+	MmapBaseFiles bool `envconfig:"MMAP_BASE_FILES" default:"false"`
+
+	// Maximum random delay before the initial populate, spreads the load on mirrors when replicas start together
+	PopulateStartJitter time.Duration `envconfig:"POPULATE_START_JITTER" default:"0"`
+
+	// Generate the images of every version once populated at startup, failures keep the service not ready when blocking
+	StartupSelfTest               bool `envconfig:"STARTUP_SELFTEST" default:"false"`
+	StartupSelfTestBlockReadiness bool `envconfig:"STARTUP_SELFTEST_BLOCK_READINESS" default:"false"`
+
+	// Report the service as degraded on readiness when only some versions can be served
+	ReadinessReportDegraded bool `envconfig:"READINESS_REPORT_DEGRADED" default:"false"`
+
+	// Fail readiness while assisted service can't be reached, and the interval between the checks
+	ReadinessCheckAssistedService         bool          `envconfig:"READINESS_CHECK_ASSISTED_SERVICE" default:"false"`
+	ReadinessCheckAssistedServiceInterval time.Duration `envconfig:"READINESS_CHECK_ASSISTED_SERVICE_INTERVAL" default:"10s"`
+
+	// Time allowed to read the served files into the page cache before the service is marked ready, 0 disables the warmup
+	WarmupTimeout time.Duration `envconfig:"WARMUP_TIMEOUT" default:"0"`
+
+	// Interval between sweeps of temp files left by interrupted downloads, 0 disables them,
+	// and the time since their last modification after which they are removed
+	TempFileJanitorInterval time.Duration `envconfig:"TEMP_FILE_JANITOR_INTERVAL" default:"10m"`
+	TempFileMaxAge          time.Duration `envconfig:"TEMP_FILE_MAX_AGE" default:"1h"`
+
+	// Proxy settings used only for OS image downloads, these take precedence over
+	// HTTP_PROXY/HTTPS_PROXY/NO_PROXY so assisted-service requests can use a different proxy
+	OSImageHTTPProxy  string `envconfig:"OS_IMAGE_HTTP_PROXY"`
+	OSImageHTTPSProxy string `envconfig:"OS_IMAGE_HTTPS_PROXY"`
+	OSImageNoProxy    string `envconfig:"OS_IMAGE_NO_PROXY"`
+	// Time allowed to download each OS image, versions can override it with "download_timeout"
+	OSImageDownloadTimeout time.Duration `envconfig:"OS_IMAGE_DOWNLOAD_TIMEOUT" default:"0"`
+
+	// When set, full ISO downloads that don't require any embedding are redirected to this URL template
+	ISORedirectURLTemplate string        `envconfig:"ISO_REDIRECT_URL_TEMPLATE"`
+	ISORedirectSigningKey  string        `envconfig:"ISO_REDIRECT_SIGNING_KEY"`
+	ISORedirectURLTTL      time.Duration `envconfig:"ISO_REDIRECT_URL_TTL" default:"1h"`
+
+	// Volume identifier of minimal ISOs, the full ISO one is used when unset
+	MinimalISOVolumeID string `envconfig:"MINIMAL_ISO_VOLUME_ID"`
+
+	// Compression of the nmstatectl ram disk embedded in minimal ISOs
+	NmstateRamDiskCompression string `envconfig:"NMSTATE_RAMDISK_COMPRESSION" default:"gzip"`
+
+	// Maximum number of bytes of generated minimal ISOs kept in memory, 0 disables the cache
+	MinimalISOCacheSize int64 `envconfig:"MINIMAL_ISO_CACHE_SIZE" default:"0"`
+
+	// Image requests taking longer are logged and counted with the phase that took the most time, 0 disables it
+	SlowImageRequestThreshold time.Duration `envconfig:"SLOW_IMAGE_REQUEST_THRESHOLD" default:"0"`
+
+	// Image requests are counted by infra-env or hashed credentials, with at most TenantMetricsMaxTenants label values, disabled when unset
+	TenantMetricsLabel      string `envconfig:"TENANT_METRICS_LABEL"`
+	TenantMetricsMaxTenants int    `envconfig:"TENANT_METRICS_MAX_TENANTS" default:"100"`
+
+	// Size of the buffer used to stream images to clients and to download OS images
+	StreamBufferSize int `envconfig:"STREAM_BUFFER_SIZE" default:"65536"`
+
+	// Bandwidth caps of image downloads, shared by all of them and for each of them, 0 disables them
+	MaxBytesPerSec         int64 `envconfig:"MAX_BYTES_PER_SEC" default:"0"`
+	MaxDownloadBytesPerSec int64 `envconfig:"MAX_DOWNLOAD_BYTES_PER_SEC" default:"0"`
+
+	// Size in bytes above which images are rejected as a misconfiguration, 0 disables it
+	MaxISOSize int64 `envconfig:"MAX_ISO_SIZE" default:"0"`
+
+	// Bytes of images served in each window, in total and for each infra-env, 0 disables them
+	ByteQuota            int64         `envconfig:"BYTE_QUOTA" default:"0"`
+	ByteQuotaPerInfraEnv int64         `envconfig:"BYTE_QUOTA_PER_INFRA_ENV" default:"0"`
+	ByteQuotaWindow      time.Duration `envconfig:"BYTE_QUOTA_WINDOW" default:"1h"`
+
+	// Compress images for the clients accepting gzip encoding
+	GzipImageStreams bool `envconfig:"GZIP_IMAGE_STREAMS" default:"false"`
+
+	// Cache-Control headers, images embed a per-request ignition so they aren't cached by default
+	ISOCacheControl           string `envconfig:"ISO_CACHE_CONTROL" default:"no-store"`
+	BootArtifactsCacheControl string `envconfig:"BOOT_ARTIFACTS_CACHE_CONTROL" default:"public, max-age=3600, immutable"`
+
+	// Maximum time locating a boot artifact in the ISO and reading it until the response starts may take before the request fails
+	BootArtifactExtractionTimeout time.Duration `envconfig:"BOOT_ARTIFACT_EXTRACTION_TIMEOUT" default:"0"`
+
+	// Filename and Content-Type of the s390x ins-file boot artifact
+	InsFileName        string `envconfig:"INS_FILE_NAME" default:"generic.ins"`
+	InsFileContentType string `envconfig:"INS_FILE_CONTENT_TYPE" default:"text/plain; charset=utf-8"`
+
+	// Serve images that can't embed kernel arguments without them rather than failing the request
+	KargsOptional bool `envconfig:"KARGS_OPTIONAL" default:"false"`
+
+	// JSON array of kernel arguments embedded in every image ahead of the InfraEnv ones
+	GlobalKargs string `envconfig:"GLOBAL_KARGS"`
+
+	// Address s390x initrds are loaded at, for z/VM setups that don't use the one in the base ISO
+	S390xInitrdAddress uint64 `envconfig:"S390X_INITRD_ADDRESS" default:"0"`
+
+	// Marker ending the kernel arguments embed area, for CoreOS variants that don't use the default one
+	KargsEmbedAreaMarker string `envconfig:"KARGS_EMBED_AREA_MARKER" default:"COREOS_KARG_EMBED_AREA"`
+
+	// Development only, lets POST requests supply the ignition embedded in the image
+	EnableIgnitionOverride bool `envconfig:"ENABLE_IGNITION_OVERRIDE" default:"false"`
+
+	// Ignition embedded in images when assisted service fails or is unreachable, disabled when unset
+	FallbackIgnitionFile string `envconfig:"FALLBACK_IGNITION_FILE"`
+
+	// Check that the assisted service ignition is a JSON object with an ignition version before embedding it
+	ValidateIgnition bool `envconfig:"VALIDATE_IGNITION" default:"false"`
+
+	// Path of the ignition config in the archive embedded in images, for CoreOS derivatives that expect another name
+	IgnitionConfigFileName string `envconfig:"IGNITION_CONFIG_FILE_NAME" default:"config.ign"`
+
+	// File embedded at BrandingFilePath in the ignition archive of every image, disabled when unset
+	BrandingFile     string `envconfig:"BRANDING_FILE"`
+	BrandingFilePath string `envconfig:"BRANDING_FILE_PATH" default:"etc/issue.d/50-branding.issue"`
+
+	// Shared secret required to call the admin endpoints, these are disabled when unset
+	AdminSecret string `envconfig:"ADMIN_SECRET"`
+
+	// Serve the admin endpoint reporting the assisted service request made for an image URL
+	DebugAssistedRequestEnabled bool `envconfig:"DEBUG_ASSISTED_REQUEST_ENABLED" default:"false"`
+
+	// Serve the admin endpoint returning the ignition embedded in an image
+	DebugIgnitionEnabled bool `envconfig:"DEBUG_IGNITION_ENABLED" default:"false"`
+
+	// Server timeouts, the write timeout does not apply to image and boot artifact downloads
+	HTTPReadHeaderTimeout time.Duration `envconfig:"HTTP_READ_HEADER_TIMEOUT" default:"3s"`
+	HTTPWriteTimeout      time.Duration `envconfig:"HTTP_WRITE_TIMEOUT" default:"60s"`
+	HTTPIdleTimeout       time.Duration `envconfig:"HTTP_IDLE_TIMEOUT" default:"120s"`
+	HTTP2Enabled          bool          `envconfig:"HTTP2_ENABLED" default:"true"`
+
+	// Security headers set on HTTPS image and boot artifact responses
+	SecurityHeadersEnabled bool          `envconfig:"SECURITY_HEADERS_ENABLED" default:"false"`
+	HSTSMaxAge             time.Duration `envconfig:"HSTS_MAX_AGE" default:"24h"`
+	FrameOptions           string        `envconfig:"X_FRAME_OPTIONS" default:"DENY"`
+
+	// Serve the pprof handlers on a separate listener and add the Go runtime and process metrics
+	EnablePprof        bool   `envconfig:"ENABLE_PPROF" default:"false"`
+	PprofListenAddress string `envconfig:"PPROF_LISTEN_ADDRESS" default:"localhost:6060"`
 }
 
 func unmarshallJSONMap(jsonMap string) (map[string]string, error) {
@@ -69,6 +271,44 @@ func unmarshallJSONMap(jsonMap string) (map[string]string, error) {
 	return result, nil
 }
 
+// assistedServiceTrustedCAFile returns the CA file trusted for assisted service API calls,
+// falling back to the deprecated HTTPS_CA_FILE unless the fallback is disabled
+func assistedServiceTrustedCAFile(trustedCAFile, deprecatedCAFile string, disableFallback bool) string {
+	if trustedCAFile != "" || deprecatedCAFile == "" {
+		return trustedCAFile
+	}
+	if disableFallback {
+		log.Warnf("HTTPS_CA_FILE is deprecated and ignored as DISABLE_HTTPS_CA_FILE_FALLBACK is set, use ASSISTED_SERVICE_API_TRUSTED_CA_FILE instead")
+		return ""
+	}
+	return deprecatedCAFile
+}
+
+// resolveDefaultArch returns the architecture used for requests that don't specify one. When configured
+// it must be that of an available version, otherwise x86_64 is used if available and the architecture
+// of the first available version if not.
+func resolveDefaultArch(configured string, versions []imagestore.VersionInfo) (string, error) {
+	haveArch := func(arch string) bool {
+		for _, info := range versions {
+			if info.CPUArchitecture == arch {
+				return true
+			}
+		}
+		return false
+	}
+
+	if configured != "" {
+		if !haveArch(configured) {
+			return "", fmt.Errorf("DEFAULT_ARCH %s is not the architecture of any available version", configured)
+		}
+		return configured, nil
+	}
+	if len(versions) == 0 || haveArch("x86_64") {
+		return "x86_64", nil
+	}
+	return versions[0].CPUArchitecture, nil
+}
+
 func main() {
 	log.SetReportCaller(true)
 	log.SetFormatter(&log.JSONFormatter{})
@@ -76,9 +316,8 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to process config: %v\n", err)
 	}
-	if Options.AssistedServiceApiTrustedCAFile == "" {
-		Options.AssistedServiceApiTrustedCAFile = Options.HTTPSCAFile
-	}
+	Options.AssistedServiceApiTrustedCAFile = assistedServiceTrustedCAFile(
+		Options.AssistedServiceApiTrustedCAFile, Options.HTTPSCAFile, Options.DisableHTTPSCAFileFallback)
 	logLevel, err := log.ParseLevel(Options.LogLevel)
 	if err != nil {
 		log.Fatalf("unknown log level: %s", Options.LogLevel)
@@ -91,50 +330,203 @@ func main() {
 	}
 
 	var versions []map[string]string
-	if versionsJSON == "" {
+	if Options.OSImagesFile != "" {
+		versions, err = imagestore.LoadVersionsFile(Options.OSImagesFile)
+		if err != nil {
+			log.Fatalf("Failed to load versions: %v\n", err)
+		}
+	} else if versionsJSON == "" {
 		versions = imagestore.DefaultVersions
 	} else {
-		err = json.Unmarshal([]byte(versionsJSON), &versions)
+		versions, err = imagestore.ParseVersions([]byte(versionsJSON))
 		if err != nil {
 			log.Fatalf("Failed to unmarshal versions: %v\n", err)
 		}
 	}
 
+	if Options.S390xInitrdAddress != 0 {
+		if err := handlers.ValidateS390xInitrdAddress(Options.S390xInitrdAddress); err != nil {
+			log.Fatalf("Invalid S390X_INITRD_ADDRESS: %v\n", err)
+		}
+	}
+	if t := Options.DefaultImageType; t != "" && t != imagestore.ImageTypeFull && t != imagestore.ImageTypeMinimal {
+		log.Fatalf("DEFAULT_IMAGE_TYPE %s is not a known image type, must be %s or %s\n", t, imagestore.ImageTypeFull, imagestore.ImageTypeMinimal)
+	}
+
 	osImageDownloadHeadersMap, err := unmarshallJSONMap(Options.OSImagesRequestHeaders)
 	if err != nil {
 		log.Fatalf("Failed to unmarshal OSImageDownloadHeaders: %v\n", err)
 	}
 
+	var headerProvider imagestore.HeaderProvider
+	switch {
+	case Options.OSImagesRequestHeadersFile != "" && Options.OSImagesRequestHeadersCommand != "":
+		log.Fatal("Only one of OS_IMAGES_REQUEST_HEADERS_FILE and OS_IMAGES_REQUEST_HEADERS_COMMAND can be set")
+	case Options.OSImagesRequestHeadersFile != "":
+		headerProvider = imagestore.FileHeaderProvider(Options.OSImagesRequestHeadersFile)
+	case Options.OSImagesRequestHeadersCommand != "":
+		headerProvider = imagestore.CommandHeaderProvider(Options.OSImagesRequestHeadersCommand)
+	}
+
 	osImageDownloadQueryParamsMap, err := unmarshallJSONMap(Options.OSImagesRequestQueryParams)
 	if err != nil {
 		log.Fatalf("Failed to unmarshal OSImageDownloadQueryParams: %v\n", err)
 	}
 
+	reg := prometheus.NewRegistry()
+	pprofAddress := ""
+	if Options.EnablePprof {
+		reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		pprofAddress = Options.PprofListenAddress
+	}
+
+	var editorOpts []isoeditor.EditorOption
+	if Options.MinimalISOVolumeID != "" {
+		volumeID, err := isoeditor.NewVolumeIDTemplate(Options.MinimalISOVolumeID)
+		if err != nil {
+			log.Fatalf("Failed to configure the minimal ISO volume ID: %v\n", err)
+		}
+		editorOpts = append(editorOpts, isoeditor.WithMinimalISOVolumeID(volumeID))
+	}
+
+	nmstateCompression, err := isoeditor.ParseCompression(Options.NmstateRamDiskCompression)
+	if err != nil {
+		log.Fatalf("Failed to configure the nmstate ram disk compression: %v\n", err)
+	}
+	nmstateHandler := isoeditor.NewNmstateHandler(Options.DataDir, &isoeditor.CommonExecuter{}, isoeditor.WithRamDiskCompression(nmstateCompression...))
+
+	imageStoreOpts := []imagestore.Option{
+		imagestore.WithMaxConcurrentDownloads(Options.MaxConcurrentDownloads),
+		imagestore.WithMaxConcurrentMinimalISOs(Options.MaxConcurrentMinimalISOs),
+		imagestore.WithMaxConcurrentExtractions(Options.MaxConcurrentExtractions),
+		imagestore.WithProxy(Options.OSImageHTTPProxy, Options.OSImageHTTPSProxy, Options.OSImageNoProxy),
+		imagestore.WithDownloadTimeout(Options.OSImageDownloadTimeout),
+		imagestore.WithVersionRange(Options.MinOpenshiftVersion, Options.MaxOpenshiftVersion),
+		imagestore.WithMetricsRegisterer(reg),
+		imagestore.WithSpaceCheck(),
+		imagestore.WithMaxVersions(Options.MaxVersions),
+		imagestore.WithDownloadBufferSize(Options.StreamBufferSize),
+		imagestore.WithHeaderProvider(headerProvider),
+	}
+	if Options.DataDirCopyReplace {
+		imageStoreOpts = append(imageStoreOpts, imagestore.WithCopyReplace())
+	}
+	if Options.ISOFilePrefix != imagestore.DefaultISOFilePrefix {
+		imageStoreOpts = append(imageStoreOpts, imagestore.WithISOFilePrefix(Options.ISOFilePrefix))
+	}
+	if Options.IsolateMinimalISOFailures {
+		imageStoreOpts = append(imageStoreOpts, imagestore.WithMinimalISOFailureIsolation())
+	}
+	if Options.KeepStaleMinimalOnFailure {
+		imageStoreOpts = append(imageStoreOpts, imagestore.WithStaleMinimalISOs())
+	}
+	if Options.StrictVersionValidation {
+		imageStoreOpts = append(imageStoreOpts, imagestore.WithStrictVersionValidation())
+	}
+
 	is, err := imagestore.NewImageStore(
-		isoeditor.NewEditor(Options.DataDir, isoeditor.NewNmstateHandler(Options.DataDir, &isoeditor.CommonExecuter{})),
+		isoeditor.NewEditor(Options.DataDir, nmstateHandler, editorOpts...),
 		Options.DataDir,
 		Options.ImageServiceBaseURL,
 		Options.InsecureSkipVerify,
 		versions,
 		Options.OSImageDownloadTrustedCAFile,
 		osImageDownloadHeadersMap,
-		osImageDownloadQueryParamsMap)
+		osImageDownloadQueryParamsMap,
+		imageStoreOpts...,
+	)
 
 	if err != nil {
 		log.Fatalf("Failed to create image store: %v\n", err)
 	}
 
-	readinessHandler := handlers.NewReadinessHandler()
+	// checked against the versions left once the version range and disabled versions are applied
+	Options.DefaultArch, err = resolveDefaultArch(Options.DefaultArch, is.AvailableVersions())
+	if err != nil {
+		log.Fatalf("Invalid DEFAULT_ARCH: %v\n", err)
+	}
+
+	// the kernel arguments are embedded into the files configured for the versions, if any
+	kargs, err := isoeditor.NewKargs(
+		isoeditor.WithKargsFiles(is.KargsFiles),
+		isoeditor.WithKargsEmbedAreaMarker(Options.KargsEmbedAreaMarker),
+	)
+	if err != nil {
+		log.Fatalf("Failed to configure the kernel arguments embed area marker: %v\n", err)
+	}
 
-	go func() {
-		err = is.Populate(context.Background())
+	ascOpts := []handlers.AssistedServiceClientOption{
+		handlers.WithBasePath(Options.AssistedServiceBasePath),
+		handlers.WithForwardedQueryParams(Options.AssistedServiceForwardedQueryParams),
+		handlers.WithMaxRedirects(Options.AssistedServiceMaxRedirects),
+		handlers.WithMinimalInitrdPath(Options.AssistedServiceMinimalInitrdPath),
+		handlers.WithRetries(Options.AssistedServiceRetries, Options.AssistedServiceRetryBackoff),
+		handlers.WithSPKIPins(Options.AssistedServiceSPKIPins),
+	}
+	if Options.AssistedServiceCircuitBreakerThreshold > 0 {
+		breaker, err := handlers.NewCircuitBreaker(Options.AssistedServiceCircuitBreakerThreshold,
+			Options.AssistedServiceCircuitBreakerWindow, Options.AssistedServiceCircuitBreakerCooldown, reg)
 		if err != nil {
+			log.Fatalf("Failed to create assisted service circuit breaker: %v\n", err)
+		}
+		ascOpts = append(ascOpts, handlers.WithCircuitBreaker(breaker))
+	}
+	if Options.SkipUnsupportedKargsOperations {
+		ascOpts = append(ascOpts, handlers.WithUnsupportedKargsSkipped())
+	}
+
+	asc, err := handlers.NewAssistedServiceClient(Options.AssistedServiceScheme, Options.AssistedServiceHost, Options.AssistedServiceApiTrustedCAFile, ascOpts...)
+	if err != nil {
+		log.Fatalf("Failed to create AssistedServiceClient: %v\n", err)
+	}
+
+	var readinessOpts []handlers.ReadinessHandlerOption
+	if Options.ReadinessReportDegraded {
+		readinessOpts = append(readinessOpts, handlers.WithDegradedReadiness(is))
+	}
+	if Options.ReadinessCheckAssistedService {
+		if Options.ReadinessCheckAssistedServiceInterval <= 0 {
+			log.Fatalf("READINESS_CHECK_ASSISTED_SERVICE_INTERVAL must be positive, got %s\n", Options.ReadinessCheckAssistedServiceInterval)
+		}
+		readinessOpts = append(readinessOpts, handlers.WithAssistedServiceCheck(asc, Options.ReadinessCheckAssistedServiceInterval))
+	}
+	readinessHandler := handlers.NewReadinessHandler(readinessOpts...)
+	loadVersions := func() ([]map[string]string, error) {
+		return imagestore.LoadVersionsFile(Options.OSImagesFile)
+	}
+	reloadOpts := []handlers.ReloadHandlerOption{handlers.WithWarmup(Options.WarmupTimeout)}
+	var mappedFiles *isoeditor.MappedFiles
+	if Options.MmapBaseFiles {
+		// images copied over in place would change under the mappings
+		if Options.DataDirCopyReplace {
+			log.Fatalf("MMAP_BASE_FILES can't be used with DATA_DIR_COPY_REPLACE\n")
+		}
+		mappedFiles = isoeditor.NewMappedFiles()
+		reloadOpts = append(reloadOpts, handlers.WithMappedFilesPrune(mappedFiles))
+	}
+	if Options.StartupSelfTest {
+		var open isoeditor.BaseFileOpener
+		if mappedFiles != nil {
+			open = mappedFiles.Open
+		}
+		generate := isoeditor.NewRHCOSStreamGenerator(open, isoeditor.WithKargs(kargs))
+		reloadOpts = append(reloadOpts, handlers.WithSelfTest(handlers.NewSelfTest(generate, Options.StartupSelfTestBlockReadiness)))
+	}
+	populateCtx, cancelPopulate := context.WithCancel(context.Background())
+	defer cancelPopulate()
+	reloadOpts = append(reloadOpts, handlers.WithReloadContext(populateCtx))
+	reloadHandler := handlers.NewReloadHandler(is, readinessHandler, loadVersions, Options.AdminSecret, reloadOpts...)
+	go func() {
+		err := reloadHandler.PopulateAfterJitter(populateCtx, Options.PopulateStartJitter)
+		if err != nil && populateCtx.Err() == nil {
 			log.Fatalf("Failed to populate image store: %v\n", err)
 		}
-		readinessHandler.Enable()
 	}()
+	go readinessHandler.RunDependencyChecks(populateCtx)
+	if Options.TempFileJanitorInterval > 0 {
+		go imagestore.RunTempFileJanitor(populateCtx, Options.DataDir, Options.TempFileJanitorInterval, Options.TempFileMaxAge)
+	}
 
-	reg := prometheus.NewRegistry()
 	metricsConfig := metrics.Config{
 		Registry:        reg,
 		Prefix:          "assisted_image_service",
@@ -145,47 +537,235 @@ func main() {
 		Recorder: metrics.NewRecorder(metricsConfig),
 	})
 
-	asc, err := handlers.NewAssistedServiceClient(Options.AssistedServiceScheme, Options.AssistedServiceHost, Options.AssistedServiceApiTrustedCAFile)
+	imageHandlerOpts := []handlers.ImageHandlerOption{
+		handlers.WithDefaultArch(Options.DefaultArch),
+		handlers.WithDefaultImageType(Options.DefaultImageType),
+		handlers.WithS390xInitrdAddress(Options.S390xInitrdAddress),
+		handlers.WithStreamBufferSize(Options.StreamBufferSize),
+		handlers.WithCacheControl(Options.ISOCacheControl),
+		handlers.WithDownloadThrottle(Options.MaxDownloadBytesPerSec),
+		handlers.WithKargs(kargs),
+	}
+	if mappedFiles != nil {
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithMappedBaseFiles(mappedFiles))
+	}
+	if Options.GzipImageStreams {
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithGzipStreams())
+	}
+	if Options.ValidateIgnition {
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithIgnitionValidation())
+	}
+	if Options.MaxBytesPerSec > 0 {
+		throttle, err := handlers.NewThrottle(Options.MaxBytesPerSec)
+		if err != nil {
+			log.Fatalf("Failed to configure the image download bandwidth cap: %v\n", err)
+		}
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithThrottle(throttle))
+	}
+	if Options.ISORedirectURLTemplate != "" {
+		redirect, err := handlers.NewISORedirect(Options.ISORedirectURLTemplate, Options.ISORedirectSigningKey, Options.ISORedirectURLTTL)
+		if err != nil {
+			log.Fatalf("Failed to configure ISO redirect: %v\n", err)
+		}
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithISORedirect(redirect))
+	}
+
+	if Options.MinimalISOCacheSize > 0 {
+		cache, err := handlers.NewISOCache(Options.MinimalISOCacheSize, reg)
+		if err != nil {
+			log.Fatalf("Failed to create minimal ISO cache: %v\n", err)
+		}
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithMinimalISOCache(cache))
+	}
+
+	if Options.TenantMetricsLabel != "" {
+		tenantMetrics, err := handlers.NewTenantMetrics(Options.TenantMetricsLabel, Options.TenantMetricsMaxTenants, reg)
+		if err != nil {
+			log.Fatalf("Failed to create tenant metrics: %v\n", err)
+		}
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithTenantMetrics(tenantMetrics))
+	}
+
+	if Options.ByteQuota > 0 || Options.ByteQuotaPerInfraEnv > 0 {
+		quota, err := handlers.NewByteQuota(Options.ByteQuota, Options.ByteQuotaPerInfraEnv, Options.ByteQuotaWindow, reg)
+		if err != nil {
+			log.Fatalf("Failed to configure the byte quota: %v\n", err)
+		}
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithByteQuota(quota))
+	}
+
+	if Options.MaxISOSize > 0 {
+		limit, err := handlers.NewImageSizeLimit(Options.MaxISOSize, reg)
+		if err != nil {
+			log.Fatalf("Failed to configure the maximum ISO size: %v\n", err)
+		}
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithImageSizeLimit(limit))
+	}
+
+	activeStreams, err := handlers.NewActiveStreams(reg)
 	if err != nil {
-		log.Fatalf("Failed to create AssistedServiceClient: %v\n", err)
+		log.Fatalf("Failed to create active streams metrics: %v\n", err)
+	}
+	imageHandlerOpts = append(imageHandlerOpts, handlers.WithActiveStreams(activeStreams))
+
+	abortedStreams, err := handlers.NewAbortedStreams(reg)
+	if err != nil {
+		log.Fatalf("Failed to create aborted streams metrics: %v\n", err)
+	}
+	imageHandlerOpts = append(imageHandlerOpts, handlers.WithAbortedStreams(abortedStreams))
+
+	if Options.SlowImageRequestThreshold > 0 {
+		tracker, err := handlers.NewSlowRequestTracker(Options.SlowImageRequestThreshold, reg)
+		if err != nil {
+			log.Fatalf("Failed to create slow request tracker: %v\n", err)
+		}
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithSlowRequestTracker(tracker))
+	}
+
+	globalKargs, err := handlers.ParseGlobalKargs(Options.GlobalKargs)
+	if err != nil {
+		log.Fatalf("Failed to parse GLOBAL_KARGS: %v\n", err)
+	}
+	if len(globalKargs) > 0 {
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithGlobalKargs(globalKargs))
+	}
+
+	if Options.KargsOptional {
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithKargsOptional())
+	}
+
+	if Options.EnableIgnitionOverride {
+		log.Warn("ENABLE_IGNITION_OVERRIDE is set, images can be requested with any ignition. This must not be used in production")
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithIgnitionOverride())
+	}
+
+	imageHandlerOpts = append(imageHandlerOpts, handlers.WithAdminSecret(Options.AdminSecret))
+	if Options.DebugIgnitionEnabled {
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithIgnitionEndpoint())
+	}
+
+	if Options.FallbackIgnitionFile != "" {
+		fallbackIgnition, err := os.ReadFile(Options.FallbackIgnitionFile)
+		if err != nil {
+			log.Fatalf("Failed to read fallback ignition: %v\n", err)
+		}
+		if !json.Valid(fallbackIgnition) {
+			log.Fatalf("Fallback ignition %s is not valid JSON\n", Options.FallbackIgnitionFile)
+		}
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithFallbackIgnition(fallbackIgnition))
+	}
+
+	if Options.IgnitionConfigFileName != isoeditor.DefaultIgnitionConfigFileName {
+		if err := isoeditor.ValidateConfigFileName(Options.IgnitionConfigFileName); err != nil {
+			log.Fatalf("Invalid IGNITION_CONFIG_FILE_NAME: %v\n", err)
+		}
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithIgnitionArchiveOptions(isoeditor.WithConfigFileName(Options.IgnitionConfigFileName)))
+	}
+
+	if Options.BrandingFile != "" {
+		branding, err := os.ReadFile(Options.BrandingFile)
+		if err != nil {
+			log.Fatalf("Failed to read branding file: %v\n", err)
+		}
+		if err := isoeditor.ValidateArchivePath(Options.BrandingFilePath); err != nil {
+			log.Fatalf("Invalid BRANDING_FILE_PATH: %v\n", err)
+		}
+		imageHandlerOpts = append(imageHandlerOpts, handlers.WithIgnitionArchiveOptions(isoeditor.WithExtraFile(Options.BrandingFilePath, branding)))
+	}
+
+	securityHeaders := handlers.SecurityHeaders{
+		HSTSMaxAge:   Options.HSTSMaxAge,
+		FrameOptions: Options.FrameOptions,
+	}
+	if Options.SecurityHeadersEnabled {
+		if err := securityHeaders.Validate(); err != nil {
+			log.Fatalf("Invalid security headers: %v\n", err)
+		}
 	}
 
-	imageHandler := handlers.NewImageHandler(is, asc, Options.MaxConcurrentRequests, mdw)
+	imageHandler := handlers.NewImageHandler(is, asc, Options.MaxConcurrentRequests, mdw, imageHandlerOpts...)
 	imageHandler = readinessHandler.WithMiddleware(imageHandler)
 	if Options.AllowedDomains != "" {
 		imageHandler = handlers.WithCORSMiddleware(imageHandler, Options.AllowedDomains)
 	}
+	if Options.SecurityHeadersEnabled {
+		imageHandler = handlers.WithSecurityHeaders(imageHandler, securityHeaders)
+	}
 
-	var bootArtifactsHandler http.Handler = &handlers.BootArtifactsHandler{ImageStore: is}
+	var bootArtifactsHandler http.Handler = &handlers.BootArtifactsHandler{
+		ImageStore:         is,
+		DefaultArch:        Options.DefaultArch,
+		CacheControl:       Options.BootArtifactsCacheControl,
+		InsFileName:        Options.InsFileName,
+		InsFileContentType: Options.InsFileContentType,
+		ActiveStreams:      activeStreams,
+		ExtractionTimeout:  Options.BootArtifactExtractionTimeout,
+		AdminSecret:        Options.AdminSecret,
+		Kargs:              kargs,
+	}
 	bootArtifactsHandler = readinessHandler.WithMiddleware(bootArtifactsHandler)
 	if Options.AllowedDomains != "" {
 		bootArtifactsHandler = handlers.WithCORSMiddleware(bootArtifactsHandler, Options.AllowedDomains)
 	}
+	if Options.SecurityHeadersEnabled {
+		bootArtifactsHandler = handlers.WithSecurityHeaders(bootArtifactsHandler, securityHeaders)
+	}
+
+	var versionsHandler http.Handler = &handlers.VersionsHandler{ImageStore: is}
+	versionsHandler = readinessHandler.WithMiddleware(versionsHandler)
+	if Options.AllowedDomains != "" {
+		versionsHandler = handlers.WithCORSMiddleware(versionsHandler, Options.AllowedDomains)
+	}
+	// the service has its own mux, net/http/pprof registers its handlers on the default one
+	mux := http.NewServeMux()
+	mux.Handle("/images", stdmiddleware.Handler("/images", mdw, versionsHandler))
 
-	http.Handle("/boot-artifacts/", stdmiddleware.Handler("", mdw, bootArtifactsHandler))
+	// Boot artifacts and images are long-running downloads so they are exempt from the server write timeout
+	mux.Handle("/boot-artifacts/", servers.WithoutWriteTimeout(handlers.WithAccessLog(stdmiddleware.Handler("", mdw, bootArtifactsHandler))))
 
-	http.Handle("/health", readinessHandler)
-	http.Handle("/live", handlers.NewLivenessHandler())
-	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mux.Handle("/health", readinessHandler)
+	mux.Handle("/live", handlers.NewLivenessHandler())
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	if Options.OSImagesFile != "" && Options.AdminSecret != "" {
+		mux.Handle("/admin/reload", reloadHandler)
+	}
+	if Options.DebugAssistedRequestEnabled && Options.AdminSecret != "" {
+		mux.Handle(handlers.AssistedRequestDebugPath+"/", handlers.NewAssistedRequestDebugHandler(asc, Options.AdminSecret))
+	}
 
 	// Interrupt servers on SIGINT/SIGTERM
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
 	// Run listen on http and https ports if HTTPSCertFile/HTTPSKeyFile set
-	serverInfo := servers.New(Options.HTTPListenPort, Options.ListenPort, Options.HTTPSKeyFile, Options.HTTPSCertFile)
+	serverInfo := servers.New(Options.HTTPListenPort, Options.ListenPort, Options.HTTPSKeyFile, Options.HTTPSCertFile,
+		servers.WithReadHeaderTimeout(Options.HTTPReadHeaderTimeout),
+		servers.WithWriteTimeout(Options.HTTPWriteTimeout),
+		servers.WithIdleTimeout(Options.HTTPIdleTimeout),
+		servers.WithHTTP2(Options.HTTP2Enabled),
+		servers.WithUnixSocket(Options.UnixSocketPath),
+		servers.WithHandler(mux),
+		servers.WithPprof(pprofAddress),
+	)
 	if serverInfo.HasBothHandlers {
 		// Make sure we filter requests when both http+https ports are open
 		// Allow only pxe-initrd via HTTP in imageHandler
 		imageHandler = handlers.WithInitrdViaHTTP(imageHandler)
 	}
-	http.Handle("/images/", imageHandler)
-	http.Handle("/byapikey/", imageHandler)
-	http.Handle("/byid/", imageHandler)
-	http.Handle("/bytoken/", imageHandler)
-	http.Handle("/s390x-initrd-addrsize", imageHandler)
+	imageHandler = servers.WithoutWriteTimeout(handlers.WithAccessLog(imageHandler))
+	mux.Handle("/images/", imageHandler)
+	mux.Handle("/byapikey/", imageHandler)
+	mux.Handle("/byid/", imageHandler)
+	mux.Handle("/bytoken/", imageHandler)
+	mux.Handle("/s390x-initrd-addrsize", imageHandler)
 
 	serverInfo.ListenAndServe()
 	<-stop
+	cancelPopulate()
 	serverInfo.Shutdown()
+	if mappedFiles != nil {
+		if err := mappedFiles.Close(); err != nil {
+			log.WithError(err).Error("Failed to unmap the base ISOs")
+		}
+	}
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift/assisted-image-service/pkg/imagestore"
+)
+
+func TestImageService(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "main")
+}
+
+var _ = Describe("assistedServiceTrustedCAFile", func() {
+	DescribeTable("resolves the assisted service CA file",
+		func(trustedCAFile, deprecatedCAFile string, disableFallback bool, expected string) {
+			Expect(assistedServiceTrustedCAFile(trustedCAFile, deprecatedCAFile, disableFallback)).To(Equal(expected))
+		},
+		Entry("falls back to the deprecated file", "", "/etc/ca.crt", false, "/etc/ca.crt"),
+		Entry("prefers the trusted file with the fallback enabled", "/etc/trusted.crt", "/etc/ca.crt", false, "/etc/trusted.crt"),
+		Entry("ignores the deprecated file with the fallback disabled", "", "/etc/ca.crt", true, ""),
+		Entry("uses the trusted file with the fallback disabled", "/etc/trusted.crt", "/etc/ca.crt", true, "/etc/trusted.crt"),
+		Entry("returns nothing when neither is set", "", "", false, ""),
+	)
+})
+
+var _ = Describe("resolveDefaultArch", func() {
+	versions := []imagestore.VersionInfo{
+		{OpenshiftVersion: "4.18", CPUArchitecture: "arm64"},
+		{OpenshiftVersion: "4.18", CPUArchitecture: "s390x"},
+	}
+
+	DescribeTable("resolves the default architecture",
+		func(configured string, versions []imagestore.VersionInfo, expected string) {
+			arch, err := resolveDefaultArch(configured, versions)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(arch).To(Equal(expected))
+		},
+		Entry("uses the configured architecture", "s390x", versions, "s390x"),
+		Entry("falls back to the first version without x86_64", "", versions, "arm64"),
+		Entry("prefers x86_64 when available", "", []imagestore.VersionInfo{{CPUArchitecture: "arm64"}, {CPUArchitecture: "x86_64"}}, "x86_64"),
+		Entry("uses x86_64 without versions", "", nil, "x86_64"),
+	)
+
+	It("rejects a configured architecture without available version", func() {
+		_, err := resolveDefaultArch("x86_64", versions)
+		Expect(err).To(MatchError(ContainSubstring("DEFAULT_ARCH x86_64")))
+	})
+})
@@ -0,0 +1,59 @@
+package imagestore
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// AppendCACerts adds the PEM encoded certificates found in caFiles to pool. caFiles is a colon
+// separated list of files or directories, in which case each regular file of the directory is
+// loaded. Every file must contain at least one certificate.
+func AppendCACerts(pool *x509.CertPool, caFiles string) error {
+	files := []string{}
+	for _, path := range strings.Split(caFiles, ":") {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to open CA file %s: %w", path, err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return fmt.Errorf("failed to read CA directory %s: %w", path, err)
+		}
+		dirFiles := []string{}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				dirFiles = append(dirFiles, filepath.Join(path, entry.Name()))
+			}
+		}
+		if len(dirFiles) == 0 {
+			return fmt.Errorf("no CA file found in directory %s", path)
+		}
+		sort.Strings(dirFiles)
+		files = append(files, dirFiles...)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no CA file found in %q", caFiles)
+	}
+
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to open CA file %s: %w", file, err)
+		}
+		if !pool.AppendCertsFromPEM(content) {
+			return fmt.Errorf("failed to load CA file %s: no valid PEM encoded certificate found", file)
+		}
+	}
+	return nil
+}
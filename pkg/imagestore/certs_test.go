@@ -0,0 +1,166 @@
+package imagestore
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+// newCATLSServer starts a TLS server whose certificate is signed by a new CA and returns it
+// with the PEM encoded CA certificate
+func newCATLSServer() (*ghttp.Server, []byte) {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).NotTo(HaveOccurred())
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	Expect(err).NotTo(HaveOccurred())
+
+	serverKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).NotTo(HaveOccurred())
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, caTemplate, &serverKey.PublicKey, caKey)
+	Expect(err).NotTo(HaveOccurred())
+
+	server := ghttp.NewUnstartedServer()
+	server.HTTPTestServer.TLS = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}},
+	}
+	server.HTTPTestServer.StartTLS()
+	server.AllowUnhandledRequests = true
+	return server, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+}
+
+var _ = Describe("AppendCACerts", func() {
+	var (
+		tmpDir           string
+		server1, server2 *ghttp.Server
+		ca1, ca2         []byte
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "cas")
+		Expect(err).NotTo(HaveOccurred())
+		server1, ca1 = newCATLSServer()
+		server2, ca2 = newCATLSServer()
+	})
+
+	AfterEach(func() {
+		server1.Close()
+		server2.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	writeFile := func(name string, content []byte) string {
+		path := filepath.Join(tmpDir, name)
+		Expect(os.MkdirAll(filepath.Dir(path), 0o755)).To(Succeed())
+		Expect(os.WriteFile(path, content, 0o600)).To(Succeed())
+		return path
+	}
+
+	expectTrusted := func(pool *x509.CertPool, servers ...*ghttp.Server) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}}}
+		for _, server := range servers {
+			resp, err := client.Get(server.URL())
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+		}
+	}
+
+	It("loads the CAs of a directory", func() {
+		writeFile("certs/ca1.pem", ca1)
+		writeFile("certs/ca2.pem", ca2)
+
+		pool := x509.NewCertPool()
+		Expect(AppendCACerts(pool, filepath.Join(tmpDir, "certs"))).To(Succeed())
+		expectTrusted(pool, server1, server2)
+	})
+
+	It("loads a colon separated list of files", func() {
+		path1 := writeFile("ca1.pem", ca1)
+		path2 := writeFile("ca2.pem", ca2)
+
+		pool := x509.NewCertPool()
+		Expect(AppendCACerts(pool, path1+":"+path2)).To(Succeed())
+		expectTrusted(pool, server1, server2)
+	})
+
+	It("only trusts the given CAs", func() {
+		pool := x509.NewCertPool()
+		Expect(AppendCACerts(pool, writeFile("ca1.pem", ca1))).To(Succeed())
+		expectTrusted(pool, server1)
+
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}}}
+		_, err := client.Get(server2.URL())
+		Expect(err).To(MatchError(ContainSubstring("certificate")))
+	})
+
+	It("fails for a malformed file", func() {
+		path1 := writeFile("certs/ca1.pem", ca1)
+		writeFile("certs/bad.pem", []byte("not a certificate"))
+
+		err := AppendCACerts(x509.NewCertPool(), filepath.Join(tmpDir, "certs"))
+		Expect(err).To(MatchError(ContainSubstring("bad.pem")))
+		err = AppendCACerts(x509.NewCertPool(), path1+":"+filepath.Join(tmpDir, "certs", "bad.pem"))
+		Expect(err).To(MatchError(ContainSubstring("no valid PEM encoded certificate")))
+	})
+
+	It("fails for a missing file", func() {
+		err := AppendCACerts(x509.NewCertPool(), filepath.Join(tmpDir, "missing.pem"))
+		Expect(err).To(MatchError(ContainSubstring("missing.pem")))
+	})
+
+	It("fails when no file is found", func() {
+		Expect(os.Mkdir(filepath.Join(tmpDir, "empty"), 0o755)).To(Succeed())
+		Expect(AppendCACerts(x509.NewCertPool(), filepath.Join(tmpDir, "empty"))).To(MatchError(ContainSubstring("no CA file found")))
+		Expect(AppendCACerts(x509.NewCertPool(), ":")).To(MatchError(ContainSubstring("no CA file found")))
+	})
+
+	It("is used for OS image downloads", func() {
+		writeFile("certs/ca1.pem", ca1)
+		writeFile("certs/ca2.pem", ca2)
+		server2.AppendHandlers(ghttp.RespondWith(http.StatusOK, "content"))
+
+		is, err := NewImageStore(nil, tmpDir, imageServiceBaseURL, false, []map[string]string{{
+			"openshift_version": "4.8",
+			"cpu_architecture":  "x86_64",
+			"url":               server2.URL() + "/some.iso",
+			"version":           "48.84.202109241901-0",
+		}}, filepath.Join(tmpDir, "certs"), nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		resp, err := is.(*rhcosStore).httpClient.Get(server2.URL())
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	})
+})
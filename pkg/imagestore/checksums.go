@@ -0,0 +1,156 @@
+package imagestore
+
+import (
+	"crypto/md5" //#nosec
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/renameio"
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+	log "github.com/sirupsen/logrus"
+)
+
+// ArtifactChecksum holds the digests of a boot artifact extracted from a full ISO
+type ArtifactChecksum struct {
+	// hex encoded sha256 of the artifact, used as its ETag
+	SHA256 string `json:"sha256"`
+	// base64 encoded md5 of the artifact, as expected in a Content-MD5 header
+	MD5 string `json:"md5"`
+}
+
+type isoChecksums struct {
+	modTime   time.Time
+	artifacts map[string]ArtifactChecksum
+}
+
+// persistedChecksums is the content of the file the checksums of a full ISO are kept in, so
+// they aren't computed again on restart. They only apply to the ISO with the same modification time.
+type persistedChecksums struct {
+	ISOModTime time.Time                   `json:"iso_mod_time"`
+	Artifacts  map[string]ArtifactChecksum `json:"artifacts"`
+}
+
+// checksumsCachePath returns the path in the data directory the checksums of the boot artifacts
+// of the full ISO at fullISOPath are kept at
+func checksumsCachePath(fullISOPath string) string {
+	return strings.TrimSuffix(fullISOPath, filepath.Ext(fullISOPath)) + "-checksums.json"
+}
+
+// loadChecksums returns the checksums kept for the full ISO at fullPath with the given
+// modification time, if they were computed for it and cover all the artifacts of arch
+func loadChecksums(fullPath, arch string, modTime time.Time) (map[string]ArtifactChecksum, bool) {
+	content, err := os.ReadFile(checksumsCachePath(fullPath))
+	if err != nil {
+		return nil, false
+	}
+	var persisted persistedChecksums
+	if err := json.Unmarshal(content, &persisted); err != nil {
+		log.WithError(err).Warnf("Ignoring malformed checksums file %s", checksumsCachePath(fullPath))
+		return nil, false
+	}
+	if !persisted.ISOModTime.Equal(modTime) {
+		return nil, false
+	}
+	for _, path := range bootArtifactPaths(arch) {
+		if _, ok := persisted.Artifacts[path]; !ok {
+			return nil, false
+		}
+	}
+	return persisted.Artifacts, true
+}
+
+func saveChecksums(fullPath string, modTime time.Time, artifacts map[string]ArtifactChecksum) error {
+	content, err := json.Marshal(persistedChecksums{ISOModTime: modTime, Artifacts: artifacts})
+	if err != nil {
+		return err
+	}
+	return renameio.WriteFile(checksumsCachePath(fullPath), content, 0600)
+}
+
+// bootArtifactPaths returns the paths within the full ISO of the files served as boot artifacts
+func bootArtifactPaths(arch string) []string {
+	if arch == "s390x" {
+		return []string{"/images/pxeboot/rootfs.img", "/images/pxeboot/kernel.img", "/generic.ins"}
+	}
+	return []string{"/images/pxeboot/rootfs.img", "/images/pxeboot/vmlinuz"}
+}
+
+func computeArtifactChecksum(isoPath, filePath string) (ArtifactChecksum, error) {
+	f, err := isoeditor.GetFileFromISO(isoPath, filePath)
+	if err != nil {
+		return ArtifactChecksum{}, err
+	}
+	defer f.Close()
+
+	sha := sha256.New()
+	md := md5.New() //#nosec
+	if _, err := io.Copy(io.MultiWriter(sha, md), f); err != nil {
+		return ArtifactChecksum{}, err
+	}
+	return ArtifactChecksum{
+		SHA256: hex.EncodeToString(sha.Sum(nil)),
+		MD5:    base64.StdEncoding.EncodeToString(md.Sum(nil)),
+	}, nil
+}
+
+// cacheArtifactChecksums hashes the boot artifacts of the full ISO at fullPath unless they
+// were already hashed for the current file, by this process or a previous one
+func (s *rhcosStore) cacheArtifactChecksums(fullPath, arch string) error {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return err
+	}
+
+	s.checksumsLock.RLock()
+	cached, ok := s.checksums[fullPath]
+	s.checksumsLock.RUnlock()
+	if ok && cached.modTime.Equal(info.ModTime()) {
+		return nil
+	}
+
+	artifacts, ok := loadChecksums(fullPath, arch, info.ModTime())
+	if !ok {
+		artifacts = map[string]ArtifactChecksum{}
+		for _, path := range bootArtifactPaths(arch) {
+			sum, err := s.artifactChecksum(fullPath, path)
+			if err != nil {
+				return fmt.Errorf("failed to compute checksum of %s: %w", path, err)
+			}
+			artifacts[path] = sum
+		}
+		// they're computed again on the next start when they can't be kept
+		if err := saveChecksums(fullPath, info.ModTime(), artifacts); err != nil {
+			log.WithError(err).Warnf("Failed to save the boot artifact checksums of %s", fullPath)
+		}
+	}
+
+	s.checksumsLock.Lock()
+	s.checksums[fullPath] = isoChecksums{modTime: info.ModTime(), artifacts: artifacts}
+	s.checksumsLock.Unlock()
+	return nil
+}
+
+func (s *rhcosStore) ArtifactChecksum(version, arch, filePath string) (ArtifactChecksum, bool) {
+	fullPath := s.PathForParams(ImageTypeFull, version, arch)
+
+	s.checksumsLock.RLock()
+	defer s.checksumsLock.RUnlock()
+	cached, ok := s.checksums[fullPath]
+	if !ok {
+		return ArtifactChecksum{}, false
+	}
+	// the ISO may have been replaced since it was hashed
+	if info, err := os.Stat(fullPath); err != nil || !info.ModTime().Equal(cached.modTime) {
+		return ArtifactChecksum{}, false
+	}
+	sum, ok := cached.artifacts[filePath]
+	return sum, ok
+}
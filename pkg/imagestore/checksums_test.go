@@ -0,0 +1,115 @@
+package imagestore
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("artifact checksums", func() {
+	var (
+		dataDir  string
+		store    *rhcosStore
+		fullPath string
+		hashed   []string
+		versions = []map[string]string{{
+			"openshift_version": "4.8",
+			"cpu_architecture":  "x86_64",
+			"url":               "http://example.com/image/x86_64-48.iso",
+			"version":           "48.84.202109241901-0",
+		}}
+	)
+
+	BeforeEach(func() {
+		var err error
+		dataDir, err = os.MkdirTemp("", "checksumsTest")
+		Expect(err).NotTo(HaveOccurred())
+
+		is, err := NewImageStore(nil, dataDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		Expect(err).NotTo(HaveOccurred())
+		store = is.(*rhcosStore)
+		hashed = nil
+		store.artifactChecksum = func(isoPath, filePath string) (ArtifactChecksum, error) {
+			hashed = append(hashed, filePath)
+			return ArtifactChecksum{SHA256: "sha-" + filePath, MD5: "md5-" + filePath}, nil
+		}
+
+		fullPath = store.PathForParams(ImageTypeFull, "4.8", "x86_64")
+		Expect(os.WriteFile(fullPath, []byte("iso"), 0600)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dataDir)
+	})
+
+	It("returns the checksums of the boot artifacts", func() {
+		Expect(store.cacheArtifactChecksums(fullPath, "x86_64")).To(Succeed())
+		Expect(hashed).To(ConsistOf("/images/pxeboot/rootfs.img", "/images/pxeboot/vmlinuz"))
+
+		sum, ok := store.ArtifactChecksum("4.8", "x86_64", "/images/pxeboot/rootfs.img")
+		Expect(ok).To(BeTrue())
+		Expect(sum).To(Equal(ArtifactChecksum{SHA256: "sha-/images/pxeboot/rootfs.img", MD5: "md5-/images/pxeboot/rootfs.img"}))
+
+		_, ok = store.ArtifactChecksum("4.8", "x86_64", "/generic.ins")
+		Expect(ok).To(BeFalse())
+		_, ok = store.ArtifactChecksum("4.9", "x86_64", "/images/pxeboot/rootfs.img")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("hashes the s390x ins-file", func() {
+		Expect(store.cacheArtifactChecksums(fullPath, "s390x")).To(Succeed())
+		Expect(hashed).To(ConsistOf("/images/pxeboot/rootfs.img", "/images/pxeboot/kernel.img", "/generic.ins"))
+	})
+
+	It("only hashes an ISO again when it changes", func() {
+		Expect(store.cacheArtifactChecksums(fullPath, "x86_64")).To(Succeed())
+		Expect(store.cacheArtifactChecksums(fullPath, "x86_64")).To(Succeed())
+		Expect(hashed).To(HaveLen(2))
+
+		later := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(fullPath, later, later)).To(Succeed())
+		_, ok := store.ArtifactChecksum("4.8", "x86_64", "/images/pxeboot/rootfs.img")
+		Expect(ok).To(BeFalse())
+
+		Expect(store.cacheArtifactChecksums(fullPath, "x86_64")).To(Succeed())
+		Expect(hashed).To(HaveLen(4))
+		_, ok = store.ArtifactChecksum("4.8", "x86_64", "/images/pxeboot/rootfs.img")
+		Expect(ok).To(BeTrue())
+	})
+
+	It("reuses the checksums saved for the same ISO by a previous store", func() {
+		Expect(store.cacheArtifactChecksums(fullPath, "x86_64")).To(Succeed())
+		Expect(checksumsCachePath(fullPath)).To(BeAnExistingFile())
+
+		is, err := NewImageStore(nil, dataDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		Expect(err).NotTo(HaveOccurred())
+		restarted := is.(*rhcosStore)
+		restarted.artifactChecksum = store.artifactChecksum
+		Expect(restarted.cacheArtifactChecksums(fullPath, "x86_64")).To(Succeed())
+		Expect(hashed).To(HaveLen(2))
+
+		sum, ok := restarted.ArtifactChecksum("4.8", "x86_64", "/images/pxeboot/vmlinuz")
+		Expect(ok).To(BeTrue())
+		Expect(sum).To(Equal(ArtifactChecksum{SHA256: "sha-/images/pxeboot/vmlinuz", MD5: "md5-/images/pxeboot/vmlinuz"}))
+	})
+
+	It("ignores the checksums saved for a previous ISO", func() {
+		Expect(store.cacheArtifactChecksums(fullPath, "x86_64")).To(Succeed())
+		later := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(fullPath, later, later)).To(Succeed())
+
+		is, err := NewImageStore(nil, dataDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		Expect(err).NotTo(HaveOccurred())
+		restarted := is.(*rhcosStore)
+		restarted.artifactChecksum = store.artifactChecksum
+		Expect(restarted.cacheArtifactChecksums(fullPath, "x86_64")).To(Succeed())
+		Expect(hashed).To(HaveLen(4))
+	})
+
+	It("fails when the ISO doesn't exist", func() {
+		Expect(store.cacheArtifactChecksums(filepath.Join(dataDir, "missing.iso"), "x86_64")).NotTo(Succeed())
+	})
+})
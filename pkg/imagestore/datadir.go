@@ -0,0 +1,102 @@
+package imagestore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// checkDataDirWritable fails early when images can't be written to dir
+func checkDataDirWritable(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("data directory %s is not accessible: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("data directory %s is not a directory", dir)
+	}
+
+	probe, err := os.CreateTemp(dir, ".write-probe-")
+	if err != nil {
+		return fmt.Errorf("data directory %s is not writable, make sure the volume is mounted read-write and writable by the service user: %w", dir, err)
+	}
+	probe.Close()
+	if err := os.Remove(probe.Name()); err != nil {
+		return fmt.Errorf("failed to remove write probe %s: %w", probe.Name(), err)
+	}
+	return nil
+}
+
+func availableSpace(dir string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// imageSizeTimeout bounds each request for the size of an image so that an unresponsive
+// mirror doesn't hold up populating the store
+const imageSizeTimeout = 30 * time.Second
+
+// checkAvailableSpace fails when the full ISOs that still need to be downloaded don't fit
+// in the data directory. Images whose size isn't reported by their server are not counted.
+func (s *rhcosStore) checkAvailableSpace(ctx context.Context, versions []map[string]string) error {
+	var required int64
+	var missing int
+	for _, entry := range versions {
+		if versionDisabled(entry) {
+			continue
+		}
+		fullPath := filepath.Join(s.dataDir, s.isoFileName(ImageTypeFull, entry["openshift_version"], entry["version"], entry["cpu_architecture"]))
+		if _, err := os.Stat(fullPath); err == nil {
+			continue
+		}
+
+		size, err := s.imageSize(ctx, entry["url"])
+		if err != nil {
+			log.WithError(err).Warnf("Unable to determine the size of %s, it won't be accounted for in the available space check", entry["url"])
+			continue
+		}
+		required += size
+		missing++
+	}
+	if required == 0 {
+		return nil
+	}
+
+	available, err := s.availableSpace(s.dataDir)
+	if err != nil {
+		log.WithError(err).Warnf("Unable to determine the available space in %s, skipping the available space check", s.dataDir)
+		return nil
+	}
+	if uint64(required) > available {
+		return fmt.Errorf("not enough space in data directory %s to download %d images: %d bytes required, %d bytes available. Increase the size of the volume or reduce the configured OS images",
+			s.dataDir, missing, required, available)
+	}
+	return nil
+}
+
+func (s *rhcosStore) imageSize(ctx context.Context, url string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, imageSizeTimeout)
+	defer cancel()
+	resp, err := s.doHttpRequest(ctx, http.MethodHead, url)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, fmt.Errorf("request to %s returned error code %d", url, resp.StatusCode)
+	}
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("request to %s didn't return a content length", url)
+	}
+	return resp.ContentLength, nil
+}
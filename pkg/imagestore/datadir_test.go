@@ -0,0 +1,137 @@
+package imagestore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+)
+
+var _ = Describe("data directory checks", func() {
+	var (
+		dataDir  string
+		versions = []map[string]string{{
+			"openshift_version": "4.8",
+			"cpu_architecture":  "x86_64",
+			"url":               "http://example.com/image/x86_64-48.iso",
+			"version":           "48.84.202109241901-0",
+		}}
+	)
+
+	BeforeEach(func() {
+		var err error
+		dataDir, err = os.MkdirTemp("", "dataDirTest")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.Chmod(dataDir, 0700)).To(Succeed())
+		os.RemoveAll(dataDir)
+	})
+
+	newStore := func(dir string) error {
+		_, err := NewImageStore(nil, dir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		return err
+	}
+
+	It("accepts a writable data directory and leaves no probe behind", func() {
+		Expect(newStore(dataDir)).To(Succeed())
+		entries, err := os.ReadDir(dataDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+
+	It("fails for a data directory that doesn't exist", func() {
+		Expect(newStore(filepath.Join(dataDir, "missing"))).To(MatchError(ContainSubstring("is not accessible")))
+	})
+
+	It("fails for a data directory that is a file", func() {
+		path := filepath.Join(dataDir, "file")
+		Expect(os.WriteFile(path, []byte{}, 0600)).To(Succeed())
+		Expect(newStore(path)).To(MatchError(ContainSubstring("is not a directory")))
+	})
+
+	It("fails for a read-only data directory", func() {
+		if os.Geteuid() == 0 {
+			Skip("directory permissions don't apply to root")
+		}
+		Expect(os.Chmod(dataDir, 0500)).To(Succeed())
+		Expect(newStore(dataDir)).To(MatchError(ContainSubstring("is not writable")))
+	})
+
+	Context("with the space check", func() {
+		var (
+			ts         *ghttp.Server
+			mockEditor *isoeditor.MockEditor
+			isoSize    = 32840
+		)
+
+		BeforeEach(func() {
+			ts = ghttp.NewServer()
+			mockEditor = isoeditor.NewMockEditor(gomock.NewController(GinkgoT()))
+			versions[0]["url"] = ts.URL() + "/some.iso"
+			ts.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("HEAD", "/some.iso"),
+					ghttp.RespondWith(http.StatusOK, nil, http.Header{"Content-Length": []string{fmt.Sprint(isoSize)}}),
+				),
+			)
+		})
+
+		AfterEach(func() {
+			ts.Close()
+		})
+
+		withAvailableSpace := func(is ImageStore, space uint64) {
+			is.(*rhcosStore).availableSpace = func(string) (uint64, error) {
+				return space, nil
+			}
+		}
+
+		It("fails before downloading when the images don't fit", func() {
+			is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{}, WithSpaceCheck())
+			Expect(err).NotTo(HaveOccurred())
+			withAvailableSpace(is, uint64(isoSize-1))
+
+			err = is.Populate(context.Background())
+			Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("not enough space in data directory %s to download 1 images: %d bytes required", dataDir, isoSize))))
+			Expect(ts.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("doesn't ask for the size of the images once populating is canceled", func() {
+			is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{}, WithSpaceCheck())
+			Expect(err).NotTo(HaveOccurred())
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err = is.(*rhcosStore).imageSize(ctx, versions[0]["url"])
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(ts.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("downloads the images when they fit", func() {
+			content := make([]byte, isoSize)
+			copy(content[32808:], "rhcos-411.86.202210041459-0")
+			ts.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/some.iso"),
+					ghttp.RespondWith(http.StatusOK, content, http.Header{"Content-Length": []string{fmt.Sprint(isoSize)}}),
+				),
+			)
+			is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{}, WithSpaceCheck())
+			Expect(err).NotTo(HaveOccurred())
+			withAvailableSpace(is, uint64(isoSize))
+
+			mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "x86_64", gomock.Any(), "4.8").Return(nil)
+			Expect(is.Populate(context.Background())).To(Succeed())
+			Expect(ts.ReceivedRequests()).To(HaveLen(2))
+		})
+	})
+})
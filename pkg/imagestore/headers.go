@@ -0,0 +1,55 @@
+package imagestore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// HeaderProvider returns headers to send with OS image download requests. It's called before
+// each request so values that expire, such as registry tokens, can be refreshed.
+type HeaderProvider func() (map[string]string, error)
+
+// WithHeaderProvider sends the headers returned by provider with each OS image download request,
+// in addition to the static headers passed to NewImageStore. Provided values take precedence.
+func WithHeaderProvider(provider HeaderProvider) Option {
+	return func(s *rhcosStore) {
+		s.headerProvider = provider
+	}
+}
+
+// FileHeaderProvider reads the headers from the JSON object in the file at path on each call
+func FileHeaderProvider(path string) HeaderProvider {
+	return func() (map[string]string, error) {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request headers file %s: %w", path, err)
+		}
+		return parseHeaders(content, path)
+	}
+}
+
+// CommandHeaderProvider runs command with bash on each call and reads the headers from the
+// JSON object it writes to stdout
+func CommandHeaderProvider(command string) HeaderProvider {
+	return func() (map[string]string, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command("bash", "-c", command)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("request headers command failed: %w: %s", err, stderr.String())
+		}
+		return parseHeaders(stdout.Bytes(), "request headers command output")
+	}
+}
+
+func parseHeaders(content []byte, source string) (map[string]string, error) {
+	headers := map[string]string{}
+	if err := json.Unmarshal(content, &headers); err != nil {
+		return nil, fmt.Errorf("invalid headers in %s: %w", source, err)
+	}
+	return headers, nil
+}
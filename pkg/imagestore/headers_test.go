@@ -0,0 +1,111 @@
+package imagestore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("download header provider", func() {
+	var (
+		ts      *ghttp.Server
+		dataDir string
+		version = map[string]string{
+			"openshift_version": "4.8",
+			"cpu_architecture":  "x86_64",
+			"version":           "48.84.202109241901-0",
+			"url":               "https://example.com/some.iso",
+		}
+	)
+
+	BeforeEach(func() {
+		ts = ghttp.NewServer()
+		var err error
+		dataDir, err = os.MkdirTemp("", "headersTest")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		ts.Close()
+		os.RemoveAll(dataDir)
+	})
+
+	newStore := func(static map[string]string, provider HeaderProvider) *rhcosStore {
+		is, err := NewImageStore(nil, dataDir, "", false, []map[string]string{version}, "", static, map[string]string{}, WithHeaderProvider(provider))
+		Expect(err).NotTo(HaveOccurred())
+		return is.(*rhcosStore)
+	}
+
+	expectToken := func(token string) {
+		ts.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", "/some.iso"),
+			ghttp.VerifyHeader(http.Header{"Authorization": []string{"Bearer " + token}}),
+			ghttp.RespondWith(http.StatusOK, "isocontent"),
+		))
+	}
+
+	download := func(is *rhcosStore) error {
+		return is.downloadURLToFile(context.Background(), ts.URL()+"/some.iso", filepath.Join(dataDir, "some.iso"), 0)
+	}
+
+	It("sends the latest headers with each request", func() {
+		calls := 0
+		is := newStore(map[string]string{"Authorization": "Bearer static", "X-Static": "value"}, func() (map[string]string, error) {
+			calls++
+			return map[string]string{"Authorization": fmt.Sprintf("Bearer token-%d", calls)}, nil
+		})
+
+		expectToken("token-1")
+		expectToken("token-2")
+		Expect(download(is)).To(Succeed())
+		Expect(download(is)).To(Succeed())
+		Expect(ts.ReceivedRequests()).To(HaveLen(2))
+		// the static headers are still sent unless they are provided
+		Expect(ts.ReceivedRequests()[1].Header.Get("X-Static")).To(Equal("value"))
+	})
+
+	It("doesn't send the request when the provider fails", func() {
+		is := newStore(nil, func() (map[string]string, error) {
+			return nil, fmt.Errorf("token expired")
+		})
+		Expect(download(is)).To(MatchError(ContainSubstring("token expired")))
+		Expect(ts.ReceivedRequests()).To(BeEmpty())
+	})
+
+	It("re-reads the headers file before each request", func() {
+		headersFile := filepath.Join(dataDir, "headers.json")
+		is := newStore(nil, FileHeaderProvider(headersFile))
+
+		expectToken("token-1")
+		expectToken("token-2")
+		Expect(os.WriteFile(headersFile, []byte(`{"Authorization": "Bearer token-1"}`), 0600)).To(Succeed())
+		Expect(download(is)).To(Succeed())
+		Expect(os.WriteFile(headersFile, []byte(`{"Authorization": "Bearer token-2"}`), 0600)).To(Succeed())
+		Expect(download(is)).To(Succeed())
+	})
+
+	It("runs the headers command before each request", func() {
+		counterFile := filepath.Join(dataDir, "counter")
+		command := fmt.Sprintf(`echo x >> %[1]s; echo "{\"Authorization\": \"Bearer token-$(wc -l < %[1]s)\"}"`, counterFile)
+		is := newStore(nil, CommandHeaderProvider(command))
+
+		expectToken("token-1")
+		expectToken("token-2")
+		Expect(download(is)).To(Succeed())
+		Expect(download(is)).To(Succeed())
+	})
+
+	It("fails for invalid provider output", func() {
+		headersFile := filepath.Join(dataDir, "headers.json")
+		Expect(os.WriteFile(headersFile, []byte("Authorization: Bearer token"), 0600)).To(Succeed())
+		Expect(download(newStore(nil, FileHeaderProvider(headersFile)))).To(MatchError(ContainSubstring("invalid headers")))
+		Expect(download(newStore(nil, CommandHeaderProvider("exit 1")))).To(MatchError(ContainSubstring("request headers command failed")))
+		Expect(ts.ReceivedRequests()).To(BeEmpty())
+	})
+})
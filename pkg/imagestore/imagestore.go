@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,14 +12,23 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/renameio"
+	"github.com/openshift/assisted-image-service/internal/common"
 	"github.com/openshift/assisted-image-service/pkg/isoeditor"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"github.com/thoas/go-funk"
+	"golang.org/x/net/http/httpproxy"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+	"gopkg.in/yaml.v3"
 )
 
 var DefaultVersions = []map[string]string{
@@ -77,25 +87,238 @@ type ImageStore interface {
 	Populate(ctx context.Context) error
 	PathForParams(imageType, version, arch string) string
 	HaveVersion(version, arch string) bool
+	// HaveMinimalISO returns whether the minimal ISO template of an available version was created,
+	// which it isn't when nmstatectl couldn't be extracted for the version
+	HaveMinimalISO(version, arch string) bool
+	// MinimalISOError returns why the last Populate couldn't create the minimal ISO of a version,
+	// empty when it didn't fail
+	MinimalISOError(version, arch string) string
+	VersionDisabled(version, arch string) bool
+	SetVersions(versions []map[string]string) error
+	// ArtifactChecksum returns the checksum computed during Populate for the file at filePath within the full ISO
+	ArtifactChecksum(version, arch, filePath string) (ArtifactChecksum, bool)
+	AvailableVersions() []VersionInfo
+	// VolumeID returns the volume identifier of the full ISO of a version, which names the OS build
+	// it was built from, as read during Populate
+	VolumeID(version, arch string) (string, bool)
+	// NmstatectlPathForParams returns the path of the nmstatectl ram disk cached for the full ISO of a
+	// version, appended to its minimal ISOs, or false if the version's minimal ISOs don't include nmstatectl
+	NmstatectlPathForParams(version, arch string) (string, bool)
+	// KargsFiles returns the files the kernel arguments of the image at isoPath are patched into as
+	// configured for its version, none when they're discovered from the image
+	KargsFiles(isoPath string) []string
+	// Warmup reads the files served for the available versions so they are in the page cache
+	Warmup(ctx context.Context) error
+}
+
+// VersionInfo describes what can be served for a version without exposing its configuration
+type VersionInfo struct {
+	OpenshiftVersion string `json:"openshift_version"`
+	CPUArchitecture  string `json:"cpu_architecture"`
+	// a minimal ISO template was created for the version
+	SupportsMinimal bool `json:"supports_minimal"`
+	// minimal ISOs for the version include nmstatectl
+	SupportsNmstate bool `json:"supports_nmstate"`
+	// volume identifier of the full ISO, such as rhcos-411.86.202210041459-0, once it's populated
+	VolumeID string `json:"volume_id,omitempty"`
+	// why the minimal ISO of the version couldn't be created by the last Populate, if it failed
+	MinimalISOError string `json:"minimal_iso_error,omitempty"`
+	// filesystem of the root image in the rootfs, squashfs or erofs, once it's populated
+	RootfsFilesystem string `json:"rootfs_filesystem,omitempty"`
 }
 
 type rhcosStore struct {
 	versions                      []map[string]string
+	versionsLock                  sync.RWMutex
+	populateLock                  sync.Mutex
 	isoEditor                     isoeditor.Editor
 	dataDir                       string
 	httpClient                    *http.Client
 	imageServiceBaseURL           string
 	osImageDownloadHeadersMap     map[string]string
+	headerProvider                HeaderProvider
 	osImageDownloadQueryParamsMap map[string]string
+	maxConcurrentDownloads        int64
+	maxConcurrentMinimalISOs      int64
+	maxConcurrentExtractions      int64
+	maxVersions                   int
+	proxyConfig                   *httpproxy.Config
+	minOpenshiftVersion           string
+	maxOpenshiftVersion           string
+	checkSpace                    bool
+	availableSpace                func(dir string) (uint64, error)
+	metricsRegisterer             prometheus.Registerer
+	nmstateExtractions            *prometheus.CounterVec
+	nmstateExtractionDuration     *prometheus.HistogramVec
+	rootfsFilesystem              func(isoPath string) (isoeditor.RootfsFilesystem, error)
+	rootfsFilesystems             map[string]isoeditor.RootfsFilesystem
+	rootfsFilesystemsLock         sync.RWMutex
+	checksums                     map[string]isoChecksums
+	checksumsLock                 sync.RWMutex
+	volumeIDs                     map[string]string
+	volumeIDsLock                 sync.RWMutex
+	isolateMinimalISOFailures     bool
+	minimalISOErrors              map[string]string
+	minimalISOErrorsLock          sync.RWMutex
+	artifactChecksum              func(isoPath, filePath string) (ArtifactChecksum, error)
+	downloadBufferSize            int
+	downloadTimeout               time.Duration
+	copyReplace                   bool
+	closeAtomicallyReplace        func(t *renameio.PendingFile) error
+	isoFileInfo                   func(filePath, isoPath string) (int64, int64, error)
+	isoFilePrefix                 string
+	strictVersionValidation       bool
+	keepStaleMinimalISOs          bool
 }
 
 const (
 	ImageTypeFull    = "full-iso"
 	ImageTypeMinimal = "minimal-iso"
+
+	DefaultMaxConcurrentDownloads   = 4
+	DefaultMaxConcurrentMinimalISOs = 1
+	DefaultMaxConcurrentExtractions = 2
+	DefaultMaxVersions              = 100
+	DefaultISOFilePrefix            = "rhcos"
 )
 
+// Option configures optional behavior of the image store
+type Option func(*rhcosStore)
+
+// WithMaxConcurrentDownloads bounds the number of OS images downloaded in parallel during Populate
+func WithMaxConcurrentDownloads(limit int64) Option {
+	return func(s *rhcosStore) {
+		if limit > 0 {
+			s.maxConcurrentDownloads = limit
+		}
+	}
+}
+
+// WithMaxConcurrentMinimalISOs bounds the number of minimal ISO templates created in parallel during Populate
+func WithMaxConcurrentMinimalISOs(limit int64) Option {
+	return func(s *rhcosStore) {
+		if limit > 0 {
+			s.maxConcurrentMinimalISOs = limit
+		}
+	}
+}
+
+// WithMaxConcurrentExtractions bounds the number of downloaded OS images whose content, such as
+// nmstatectl, is extracted in parallel during Populate. Extractions don't take download slots.
+func WithMaxConcurrentExtractions(limit int64) Option {
+	return func(s *rhcosStore) {
+		if limit > 0 {
+			s.maxConcurrentExtractions = limit
+		}
+	}
+}
+
+// WithMaxVersions bounds the number of configured versions, a guard against configurations
+// listing far more images than intended
+func WithMaxVersions(limit int) Option {
+	return func(s *rhcosStore) {
+		if limit > 0 {
+			s.maxVersions = limit
+		}
+	}
+}
+
+// WithDownloadBufferSize copies downloaded OS images to the data directory through a buffer
+// of the given size rather than the default 32KiB one
+func WithDownloadBufferSize(size int) Option {
+	return func(s *rhcosStore) {
+		if size > 0 {
+			s.downloadBufferSize = size
+		}
+	}
+}
+
+// WithDownloadTimeout bounds the time spent downloading each OS image, including reading the
+// response body. Versions can override it with a "download_timeout" value.
+func WithDownloadTimeout(timeout time.Duration) Option {
+	return func(s *rhcosStore) {
+		if timeout > 0 {
+			s.downloadTimeout = timeout
+		}
+	}
+}
+
+// WithMinimalISOFailureIsolation keeps a version whose minimal ISO can't be created from failing
+// Populate. The error is recorded for the version, which is still served as a full ISO, and the
+// minimal ISOs of the other versions are created as usual.
+func WithMinimalISOFailureIsolation() Option {
+	return func(s *rhcosStore) {
+		s.isolateMinimalISOFailures = true
+	}
+}
+
+// WithStaleMinimalISOs keeps serving the minimal ISO created by a previous deploy when it can't
+// be created again. Minimal ISOs are then kept in the data directory across deploys and only
+// replaced once the new one is created.
+func WithStaleMinimalISOs() Option {
+	return func(s *rhcosStore) {
+		s.keepStaleMinimalISOs = true
+	}
+}
+
+// WithISOFilePrefix names the images stored in the data directory <prefix>-<type>-... instead of
+// rhcos-<type>-..., for distributions other than RHCOS. Images stored with another prefix are
+// removed from the data directory by Populate.
+func WithISOFilePrefix(prefix string) Option {
+	return func(s *rhcosStore) {
+		s.isoFilePrefix = prefix
+	}
+}
+
+// WithStrictVersionValidation additionally checks that the configured versions are consistent
+// for their architecture and openshift version, see validateVersionsStrict
+func WithStrictVersionValidation() Option {
+	return func(s *rhcosStore) {
+		s.strictVersionValidation = true
+	}
+}
+
+// WithVersionRange limits the served versions to those with an openshift version between
+// min and max inclusive. An empty bound leaves that side of the range open.
+func WithVersionRange(min, max string) Option {
+	return func(s *rhcosStore) {
+		s.minOpenshiftVersion = min
+		s.maxOpenshiftVersion = max
+	}
+}
+
+// WithSpaceCheck verifies before downloading that the images that are missing from
+// the data directory fit in it, based on the sizes reported by the image servers
+func WithSpaceCheck() Option {
+	return func(s *rhcosStore) {
+		s.checkSpace = true
+	}
+}
+
+// WithMetricsRegisterer registers the image store metrics with the given registerer
+func WithMetricsRegisterer(reg prometheus.Registerer) Option {
+	return func(s *rhcosStore) {
+		s.metricsRegisterer = reg
+	}
+}
+
+// WithProxy routes OS image downloads through the given proxies. When any value is set
+// the proxy environment variables of the process are ignored for image downloads.
+func WithProxy(httpProxy, httpsProxy, noProxy string) Option {
+	return func(s *rhcosStore) {
+		if httpProxy == "" && httpsProxy == "" && noProxy == "" {
+			return
+		}
+		s.proxyConfig = &httpproxy.Config{
+			HTTPProxy:  httpProxy,
+			HTTPSProxy: httpsProxy,
+			NoProxy:    noProxy,
+		}
+	}
+}
+
 func NewImageStore(ed isoeditor.Editor, dataDir, imageServiceBaseURL string, insecureSkipVerify bool, versions []map[string]string,
-	osImageDownloadTrustedCAFile string, osImageDownloadHeadersMap map[string]string, osImageDownloadQueryParamsMap map[string]string) (ImageStore, error) {
+	osImageDownloadTrustedCAFile string, osImageDownloadHeadersMap map[string]string, osImageDownloadQueryParamsMap map[string]string, opts ...Option) (ImageStore, error) {
 	if err := validateVersions(versions); err != nil {
 		return nil, err
 	}
@@ -114,12 +337,8 @@ func NewImageStore(ed isoeditor.Editor, dataDir, imageServiceBaseURL string, ins
 		if err != nil {
 			return nil, fmt.Errorf("failed to obtain system cert pool: %w", err)
 		}
-		additionalTLSCert, err := os.ReadFile(osImageDownloadTrustedCAFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open additional certificate file %s, %w", osImageDownloadTrustedCAFile, err)
-		}
-		if !caCertPool.AppendCertsFromPEM(additionalTLSCert) {
-			return nil, fmt.Errorf("failed to append additional certificate %s to pool", osImageDownloadTrustedCAFile)
+		if err := AppendCACerts(caCertPool, osImageDownloadTrustedCAFile); err != nil {
+			return nil, fmt.Errorf("failed to add additional certificates to pool: %w", err)
 		}
 		myTransport.TLSClientConfig = &tls.Config{
 			RootCAs:    caCertPool,
@@ -129,7 +348,7 @@ func NewImageStore(ed isoeditor.Editor, dataDir, imageServiceBaseURL string, ins
 
 	httpClient := &http.Client{Transport: myTransport}
 
-	return &rhcosStore{
+	store := &rhcosStore{
 		versions:                      versions,
 		isoEditor:                     ed,
 		dataDir:                       dataDir,
@@ -137,7 +356,170 @@ func NewImageStore(ed isoeditor.Editor, dataDir, imageServiceBaseURL string, ins
 		imageServiceBaseURL:           imageServiceBaseURL,
 		osImageDownloadHeadersMap:     osImageDownloadHeadersMap,
 		osImageDownloadQueryParamsMap: osImageDownloadQueryParamsMap,
-	}, nil
+		maxConcurrentDownloads:        DefaultMaxConcurrentDownloads,
+		maxConcurrentMinimalISOs:      DefaultMaxConcurrentMinimalISOs,
+		maxConcurrentExtractions:      DefaultMaxConcurrentExtractions,
+		maxVersions:                   DefaultMaxVersions,
+		availableSpace:                availableSpace,
+		checksums:                     map[string]isoChecksums{},
+		volumeIDs:                     map[string]string{},
+		minimalISOErrors:              map[string]string{},
+		artifactChecksum:              computeArtifactChecksum,
+		closeAtomicallyReplace:        (*renameio.PendingFile).CloseAtomicallyReplace,
+		isoFileInfo:                   isoeditor.GetISOFileInfo,
+		isoFilePrefix:                 DefaultISOFilePrefix,
+		nmstateExtractions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "assisted_image_service_nmstatectl_extractions_total",
+			Help: "Number of nmstatectl extractions attempted while populating the image store",
+		}, []string{"openshift_version", "cpu_architecture", "result"}),
+		nmstateExtractionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "assisted_image_service_nmstatectl_extraction_duration_seconds",
+			Help:    "Time spent extracting nmstatectl while populating the image store, by the filesystem of the rootfs",
+			Buckets: []float64{1, 5, 10, 30, 60, 120, 300},
+		}, []string{"openshift_version", "cpu_architecture", "rootfs_filesystem"}),
+		rootfsFilesystem:  isoeditor.DetectISORootfsFilesystem,
+		rootfsFilesystems: map[string]isoeditor.RootfsFilesystem{},
+	}
+	for _, opt := range opts {
+		opt(store)
+	}
+
+	if err := validateISOFilePrefix(store.isoFilePrefix); err != nil {
+		return nil, err
+	}
+	if err := store.validateVersionsStrict(versions); err != nil {
+		return nil, err
+	}
+	if err := store.checkVersionCount(versions); err != nil {
+		return nil, err
+	}
+	filtered, err := store.filterVersions(versions)
+	if err != nil {
+		return nil, err
+	}
+	store.versions = filtered
+	if err := checkDataDirWritable(dataDir); err != nil {
+		return nil, err
+	}
+
+	if store.metricsRegisterer != nil {
+		if err := store.metricsRegisterer.Register(store.nmstateExtractions); err != nil {
+			return nil, fmt.Errorf("failed to register image store metrics: %w", err)
+		}
+		if err := store.metricsRegisterer.Register(store.nmstateExtractionDuration); err != nil {
+			return nil, fmt.Errorf("failed to register image store metrics: %w", err)
+		}
+	}
+
+	if store.proxyConfig != nil {
+		proxyFunc := store.proxyConfig.ProxyFunc()
+		myTransport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		}
+	}
+
+	return store, nil
+}
+
+// ParseVersions decodes a JSON encoded list of versions. Scalar values that
+// are not strings, such as `"disabled": true`, are converted to strings.
+func ParseVersions(data []byte) ([]map[string]string, error) {
+	var entries []map[string]interface{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	versions := make([]map[string]string, 0, len(entries))
+	for _, entry := range entries {
+		version := make(map[string]string, len(entry))
+		for key, value := range entry {
+			switch v := value.(type) {
+			case string:
+				version[key] = v
+			case bool, float64:
+				version[key] = fmt.Sprint(v)
+			default:
+				return nil, fmt.Errorf("invalid value %v for key %s in version entry %+v", value, key, entry)
+			}
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// ParseVersionsYAML decodes a YAML encoded list of versions. Scalar values are
+// kept as written, so an unquoted `openshift_version: 4.10` stays "4.10".
+func ParseVersionsYAML(data []byte) ([]map[string]string, error) {
+	var entries []map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	versions := make([]map[string]string, 0, len(entries))
+	for _, entry := range entries {
+		version := make(map[string]string, len(entry))
+		for key, value := range entry {
+			if value.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("invalid value for key %s at line %d: must be a scalar", key, value.Line)
+			}
+			version[key] = value.Value
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// LoadVersionsFile reads and validates a list of versions from path, YAML encoded
+// when the file has a .yaml or .yml extension and JSON encoded otherwise
+func LoadVersionsFile(path string) ([]map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read versions file %s: %w", path, err)
+	}
+	parse := ParseVersions
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		parse = ParseVersionsYAML
+	}
+	versions, err := parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal versions file %s: %w", path, err)
+	}
+	if err := validateVersions(versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// versionDisabled reports if the entry has been disabled with the "disabled" key.
+// Disabled versions are not served, but their files are kept in the data directory.
+func versionDisabled(entry map[string]string) bool {
+	disabled, _ := strconv.ParseBool(entry["disabled"])
+	return disabled
+}
+
+// versionDownloadTimeout returns the time allowed to download the image of the entry, configured
+// with the "download_timeout" key or the store default otherwise. Zero means no timeout.
+func (s *rhcosStore) versionDownloadTimeout(entry map[string]string) time.Duration {
+	if value, ok := entry["download_timeout"]; ok {
+		// validated by validateVersions
+		if timeout, err := time.ParseDuration(value); err == nil {
+			return timeout
+		}
+	}
+	return s.downloadTimeout
+}
+
+// versionKargsFiles returns the files the kernel arguments are patched into configured with the
+// comma separated "kargs_files" key, or nil when the files are discovered from the ISO
+func versionKargsFiles(entry map[string]string) []string {
+	var files []string
+	for _, file := range strings.Split(entry["kargs_files"], ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
 }
 
 func validateVersions(versions []map[string]string) error {
@@ -158,19 +540,84 @@ func validateVersions(versions []map[string]string) error {
 		if _, ok := entry["version"]; !ok {
 			return fmt.Errorf(missingKeyFmt, entry, "version")
 		}
+		if kargsFiles, ok := entry["kargs_files"]; ok {
+			files := versionKargsFiles(entry)
+			if len(files) == 0 {
+				return fmt.Errorf("invalid version entry %+v: kargs_files must not be empty", entry)
+			}
+			for _, file := range files {
+				if !strings.HasPrefix(file, "/") {
+					return fmt.Errorf("invalid version entry %+v: kargs_files path %s in %s must be absolute", entry, file, kargsFiles)
+				}
+			}
+		}
+		if downloadTimeout, ok := entry["download_timeout"]; ok {
+			timeout, err := time.ParseDuration(downloadTimeout)
+			if err != nil {
+				return fmt.Errorf("invalid version entry %+v: download_timeout %s is not a valid duration: %w", entry, downloadTimeout, err)
+			}
+			if timeout <= 0 {
+				return fmt.Errorf("invalid version entry %+v: download_timeout %s must be positive", entry, downloadTimeout)
+			}
+		}
 	}
 
 	return nil
 }
 
-func (s *rhcosStore) doHttpRequest(url string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", url, nil)
+// validateVersionsStrict checks, when strict version validation is enabled, that the entries
+// accepted by validateVersions can all be served: openshift versions are valid, urls can be
+// downloaded, an architecture has a single entry per openshift version, and the entries meet
+// the requirements of their architecture and of the features of their openshift version
+func (s *rhcosStore) validateVersionsStrict(versions []map[string]string) error {
+	if !s.strictVersionValidation {
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, entry := range versions {
+		openshiftVersion, arch := entry["openshift_version"], entry["cpu_architecture"]
+		nmstate, err := common.VersionGreaterOrEqual(openshiftVersion, isoeditor.MinimalVersionForNmstatectl)
+		if err != nil {
+			return fmt.Errorf("invalid version entry %+v: openshift_version %s is not a valid version: %w", entry, openshiftVersion, err)
+		}
+		u, err := url.Parse(entry["url"])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid version entry %+v: url %s must be an absolute http or https URL", entry, entry["url"])
+		}
+		key := openshiftVersion + "-" + arch
+		if seen[key] {
+			return fmt.Errorf("invalid version entry %+v: another entry is configured for %s", entry, key)
+		}
+		seen[key] = true
+		// the kernel arguments of s390x images can't be modified
+		if _, ok := entry["kargs_files"]; ok && arch == "s390x" {
+			return fmt.Errorf("invalid version entry %+v: kargs_files is not supported for s390x", entry)
+		}
+		// minimal ISOs, which aren't created for s390x, embed nmstatectl from these versions on
+		if nmstate && arch != "s390x" && !isoeditor.NmstatectlSupported(arch) {
+			return fmt.Errorf("invalid version entry %+v: openshift version %s embeds nmstatectl, which is not available for %s", entry, openshiftVersion, arch)
+		}
+	}
+	return nil
+}
+
+func (s *rhcosStore) doHttpRequest(ctx context.Context, method, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to make http request due to error: %s", err.Error())
 	}
 	for key, value := range s.osImageDownloadHeadersMap {
 		req.Header.Set(key, value)
 	}
+	if s.headerProvider != nil {
+		headers, err := s.headerProvider()
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+	}
 	if len(s.osImageDownloadQueryParamsMap) > 0 {
 		query := req.URL.Query()
 		for key, value := range s.osImageDownloadQueryParamsMap {
@@ -185,8 +632,20 @@ func (s *rhcosStore) doHttpRequest(url string) (*http.Response, error) {
 	return resp, nil
 }
 
-func (s *rhcosStore) downloadURLToFile(url string, path string) error {
-	resp, err := s.doHttpRequest(url)
+// downloadURLToFile downloads url to path, failing when it takes longer than timeout unless it is zero
+func (s *rhcosStore) downloadURLToFile(ctx context.Context, url string, path string, timeout time.Duration) (err error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		defer func() {
+			if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("download of %s timed out after %s: %w", url, timeout, err)
+			}
+		}()
+	}
+
+	resp, err := s.doHttpRequest(ctx, http.MethodGet, url)
 	if err != nil {
 		return fmt.Errorf("http request to %s failed: %w", url, err)
 	}
@@ -207,18 +666,22 @@ func (s *rhcosStore) downloadURLToFile(url string, path string) error {
 		}
 	}()
 
-	count, err := io.Copy(t, resp.Body)
+	var count int64
+	if s.downloadBufferSize > 0 {
+		// the buffer is ignored when the destination implements io.ReaderFrom, as files do
+		count, err = io.CopyBuffer(struct{ io.Writer }{t}, resp.Body, make([]byte, s.downloadBufferSize))
+	} else {
+		count, err = io.Copy(t, resp.Body)
+	}
 	if err != nil {
 		return err
-	} else if count != resp.ContentLength {
+	} else if resp.ContentLength >= 0 && count != resp.ContentLength {
+		// chunked responses don't announce their length, truncated ones are caught by the
+		// volume ID and checksum validation instead
 		return fmt.Errorf("wrote %d bytes, but expected to write %d", count, resp.ContentLength)
 	}
 
-	if err := t.CloseAtomicallyReplace(); err != nil {
-		return fmt.Errorf("unable to atomically replace %s with temp file %s: %v", path, t.Name(), err)
-	}
-
-	return nil
+	return s.replaceFile(t, path)
 }
 
 func validateISOID(path string) error {
@@ -234,38 +697,147 @@ func validateISOID(path string) error {
 	return nil
 }
 
+// SetVersions replaces the configured versions. The new versions are not
+// downloaded until the next call to Populate.
+func (s *rhcosStore) SetVersions(versions []map[string]string) error {
+	if err := validateVersions(versions); err != nil {
+		return err
+	}
+	if err := s.validateVersionsStrict(versions); err != nil {
+		return err
+	}
+	if err := s.checkVersionCount(versions); err != nil {
+		return err
+	}
+	filtered, err := s.filterVersions(versions)
+	if err != nil {
+		return err
+	}
+	s.versionsLock.Lock()
+	defer s.versionsLock.Unlock()
+	s.versions = filtered
+	return nil
+}
+
+func (s *rhcosStore) checkVersionCount(versions []map[string]string) error {
+	if len(versions) > s.maxVersions {
+		return fmt.Errorf("invalid versions: %d versions configured, more than the maximum of %d", len(versions), s.maxVersions)
+	}
+	return nil
+}
+
+// filterVersions drops the versions outside of the configured openshift version range
+func (s *rhcosStore) filterVersions(versions []map[string]string) ([]map[string]string, error) {
+	if s.minOpenshiftVersion == "" && s.maxOpenshiftVersion == "" {
+		return versions, nil
+	}
+
+	var filtered []map[string]string
+	for _, entry := range versions {
+		openshiftVersion := entry["openshift_version"]
+		if s.minOpenshiftVersion != "" {
+			ok, err := common.VersionGreaterOrEqual(openshiftVersion, s.minOpenshiftVersion)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compare version %s to minimum %s: %w", openshiftVersion, s.minOpenshiftVersion, err)
+			}
+			if !ok {
+				log.Infof("Ignoring version %s-%s below minimum version %s", openshiftVersion, entry["cpu_architecture"], s.minOpenshiftVersion)
+				continue
+			}
+		}
+		if s.maxOpenshiftVersion != "" {
+			ok, err := common.VersionGreaterOrEqual(s.maxOpenshiftVersion, openshiftVersion)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compare version %s to maximum %s: %w", openshiftVersion, s.maxOpenshiftVersion, err)
+			}
+			if !ok {
+				log.Infof("Ignoring version %s-%s above maximum version %s", openshiftVersion, entry["cpu_architecture"], s.maxOpenshiftVersion)
+				continue
+			}
+		}
+		filtered = append(filtered, entry)
+	}
+
+	if len(filtered) == 0 {
+		return nil, fmt.Errorf("invalid versions: none within the allowed range [%s, %s]", s.minOpenshiftVersion, s.maxOpenshiftVersion)
+	}
+	return filtered, nil
+}
+
+func (s *rhcosStore) getVersions() []map[string]string {
+	s.versionsLock.RLock()
+	defer s.versionsLock.RUnlock()
+	return s.versions
+}
+
 func (s *rhcosStore) Populate(ctx context.Context) error {
-	if err := s.cleanDataDir(); err != nil {
+	// concurrent calls would race on the files in the data directory
+	s.populateLock.Lock()
+	defer s.populateLock.Unlock()
+
+	versions := s.getVersions()
+	if err := s.cleanDataDir(versions); err != nil {
 		return err
 	}
+	if s.checkSpace {
+		if err := s.checkAvailableSpace(ctx, versions); err != nil {
+			return err
+		}
+	}
+
+	downloads := semaphore.NewWeighted(s.maxConcurrentDownloads)
+	extractions := semaphore.NewWeighted(s.maxConcurrentExtractions)
+	errs, errsCtx := errgroup.WithContext(ctx)
 
-	errs, _ := errgroup.WithContext(ctx)
+	// versions whose nmstatectl extraction failed can't produce a minimal ISO
+	var nmstateFailuresLock sync.Mutex
+	nmstateFailures := map[string]bool{}
 
-	for i := range s.versions {
-		imageInfo := s.versions[i]
+	for i := range versions {
+		imageInfo := versions[i]
+		if versionDisabled(imageInfo) {
+			log.Infof("Skipping disabled version %s-%s", imageInfo["openshift_version"], imageInfo["cpu_architecture"])
+			continue
+		}
 		errs.Go(func() error {
+			fullPath, err := s.downloadISO(errsCtx, downloads, imageInfo)
+			if err != nil {
+				return err
+			}
+
+			// the download slot is free by now so other images download while this one is processed
+			if err := extractions.Acquire(errsCtx, 1); err != nil {
+				return err
+			}
+			defer extractions.Release(1)
+
 			openshiftVersion := imageInfo["openshift_version"]
 			imageVersion := imageInfo["version"]
 			arch := imageInfo["cpu_architecture"]
 
-			fullPath := filepath.Join(s.dataDir, isoFileName(ImageTypeFull, openshiftVersion, imageVersion, arch))
-			if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-				url := imageInfo["url"]
-				log.Infof("Downloading iso from %s to %s", url, fullPath)
+			if volumeID, err := isoeditor.VolumeIdentifier(fullPath); err == nil {
+				s.volumeIDsLock.Lock()
+				s.volumeIDs[fullPath] = strings.TrimRight(volumeID, "\x00")
+				s.volumeIDsLock.Unlock()
+			} else {
+				log.WithError(err).Warnf("Failed to read the volume identifier of %s", fullPath)
+			}
 
-				err = s.downloadURLToFile(url, fullPath)
-				if err != nil {
-					return fmt.Errorf("failed to download %s: %v", url, err)
-				}
-				log.Infof("Finished downloading for %s-%s (%s)", openshiftVersion, arch, imageVersion)
-				if err := validateISOID(fullPath); err != nil {
-					message := fmt.Sprintf("failed to validate %s: %v", fullPath, err)
-					if err = os.Remove(fullPath); err != nil {
-						log.WithError(err).Errorf("failed to remove invalid ISO %s", fullPath)
-					}
-					log.Error(message)
-					return fmt.Errorf(message)
-				}
+			if err := s.checkKargsFiles(imageInfo, fullPath); err != nil {
+				return err
+			}
+
+			filesystem := s.detectRootfsFilesystem(fullPath)
+			if err := s.cacheNmstateRamDisk(imageInfo, fullPath, filesystem); err != nil {
+				log.WithError(err).Errorf("Failed to extract nmstatectl for %s-%s (%s), its minimal ISO won't be created", openshiftVersion, arch, imageVersion)
+				nmstateFailuresLock.Lock()
+				nmstateFailures[fullPath] = true
+				nmstateFailuresLock.Unlock()
+			}
+
+			// boot artifacts are still served without checksums, so this isn't fatal
+			if err := s.cacheArtifactChecksums(fullPath, arch); err != nil {
+				log.WithError(err).Warnf("Failed to compute boot artifact checksums for %s-%s (%s)", openshiftVersion, arch, imageVersion)
 			}
 
 			return nil
@@ -276,51 +848,235 @@ func (s *rhcosStore) Populate(ctx context.Context) error {
 		return err
 	}
 
-	for i := range s.versions {
-		imageInfo := s.versions[i]
-		openshiftVersion := imageInfo["openshift_version"]
-		imageVersion := imageInfo["version"]
-		arch := imageInfo["cpu_architecture"]
+	minimalISOs := semaphore.NewWeighted(s.maxConcurrentMinimalISOs)
+	minimalErrs, minimalCtx := errgroup.WithContext(ctx)
+	minimalISOErrors := map[string]string{}
+	var minimalISOErrorsLock sync.Mutex
 
+	for i := range versions {
+		imageInfo := versions[i]
 		// Don't attempt to create a minimal ISO for s390x because there's no easy way to edit the kernel parameters
 		// This means that the rootfs URL can't be added which makes it impossible for us to create a minimal ISO
-		if arch == "s390x" {
+		if imageInfo["cpu_architecture"] == "s390x" || versionDisabled(imageInfo) {
 			continue
 		}
-		minimalPath := filepath.Join(s.dataDir, isoFileName(ImageTypeMinimal, openshiftVersion, imageVersion, arch))
-		if _, err := os.Stat(minimalPath); os.IsNotExist(err) {
-			log.Infof("Creating minimal iso for %s-%s-%s", openshiftVersion, imageVersion, arch)
+		minimalPath := filepath.Join(s.dataDir, s.isoFileName(ImageTypeMinimal, imageInfo["openshift_version"], imageInfo["version"], imageInfo["cpu_architecture"]))
+		if nmstateFailures[filepath.Join(s.dataDir, s.isoFileName(ImageTypeFull, imageInfo["openshift_version"], imageInfo["version"], imageInfo["cpu_architecture"]))] {
+			if s.haveStaleMinimalISO(minimalPath) {
+				log.Warnf("Serving the previously created minimal iso for %s-%s (%s) as nmstatectl failed to be extracted",
+					imageInfo["openshift_version"], imageInfo["cpu_architecture"], imageInfo["version"])
+				continue
+			}
+			minimalISOErrors[minimalPath] = "failed to extract nmstatectl"
+			continue
+		}
+		minimalErrs.Go(func() error {
+			if err := minimalISOs.Acquire(minimalCtx, 1); err != nil {
+				return err
+			}
+			defer minimalISOs.Release(1)
 
-			fullPath := filepath.Join(s.dataDir, isoFileName(ImageTypeFull, openshiftVersion, imageVersion, arch))
-			rootfsURL, err := buildRootfsURL(s.imageServiceBaseURL, arch, openshiftVersion)
-			if err != nil {
-				return fmt.Errorf("failed to build rootfs URL: %v", err)
+			err := s.createMinimalISO(imageInfo)
+			if err != nil && s.haveStaleMinimalISO(minimalPath) {
+				log.WithError(err).Warnf("Failed to create the minimal iso for %s-%s (%s), serving the one created previously",
+					imageInfo["openshift_version"], imageInfo["cpu_architecture"], imageInfo["version"])
+				return nil
 			}
+			if err != nil && s.isolateMinimalISOFailures {
+				log.WithError(err).Errorf("Failed to create the minimal iso for %s-%s (%s), only its full iso will be served",
+					imageInfo["openshift_version"], imageInfo["cpu_architecture"], imageInfo["version"])
+				minimalISOErrorsLock.Lock()
+				minimalISOErrors[minimalPath] = err.Error()
+				minimalISOErrorsLock.Unlock()
+				return nil
+			}
+			return err
+		})
+	}
+	defer func() {
+		s.minimalISOErrorsLock.Lock()
+		s.minimalISOErrors = minimalISOErrors
+		s.minimalISOErrorsLock.Unlock()
+	}()
 
-			err = s.isoEditor.CreateMinimalISOTemplate(fullPath, rootfsURL, arch, minimalPath, openshiftVersion)
-			if err != nil {
-				return fmt.Errorf("failed to create minimal iso template for version %s: %v", imageInfo, err)
+	return minimalErrs.Wait()
+}
+
+// downloadISO downloads the full ISO of a version unless it's already in the data directory,
+// holding a download slot only while downloading and validating it, and returns its path
+func (s *rhcosStore) downloadISO(ctx context.Context, downloads *semaphore.Weighted, imageInfo map[string]string) (string, error) {
+	if err := downloads.Acquire(ctx, 1); err != nil {
+		return "", err
+	}
+	defer downloads.Release(1)
+
+	openshiftVersion := imageInfo["openshift_version"]
+	imageVersion := imageInfo["version"]
+	arch := imageInfo["cpu_architecture"]
+
+	fullPath := filepath.Join(s.dataDir, s.isoFileName(ImageTypeFull, openshiftVersion, imageVersion, arch))
+	if _, err := os.Stat(fullPath); !os.IsNotExist(err) {
+		return fullPath, nil
+	}
+
+	url := imageInfo["url"]
+	log.Infof("Downloading iso from %s to %s", url, fullPath)
+	if err := s.downloadURLToFile(ctx, url, fullPath, s.versionDownloadTimeout(imageInfo)); err != nil {
+		return "", fmt.Errorf("failed to download %s: %v", url, err)
+	}
+	log.Infof("Finished downloading for %s-%s (%s)", openshiftVersion, arch, imageVersion)
+	if err := validateISOID(fullPath); err != nil {
+		message := fmt.Sprintf("failed to validate %s: %v", fullPath, err)
+		if err = os.Remove(fullPath); err != nil {
+			log.WithError(err).Errorf("failed to remove invalid ISO %s", fullPath)
+		}
+		log.Error(message)
+		return "", fmt.Errorf(message)
+	}
+	return fullPath, nil
+}
+
+// checkKargsFiles checks the files the kernel arguments of the version images are patched into,
+// when set with "kargs_files", exist in the full ISO
+func (s *rhcosStore) checkKargsFiles(imageInfo map[string]string, fullPath string) error {
+	for _, file := range versionKargsFiles(imageInfo) {
+		if _, _, err := s.isoFileInfo(file, fullPath); err != nil {
+			return fmt.Errorf("kargs file %s of version %s-%s not found in %s: %w", file, imageInfo["openshift_version"], imageInfo["cpu_architecture"], fullPath, err)
+		}
+	}
+	return nil
+}
+
+func (s *rhcosStore) KargsFiles(isoPath string) []string {
+	for _, entry := range s.getVersions() {
+		for _, imageType := range []string{ImageTypeFull, ImageTypeMinimal} {
+			if filepath.Join(s.dataDir, s.isoFileName(imageType, entry["openshift_version"], entry["version"], entry["cpu_architecture"])) == isoPath {
+				return versionKargsFiles(entry)
 			}
+		}
+	}
+	return nil
+}
+
+// detectRootfsFilesystem records the filesystem of the rootfs of the full ISO at fullPath,
+// returning "unknown" when it can't be detected
+func (s *rhcosStore) detectRootfsFilesystem(fullPath string) isoeditor.RootfsFilesystem {
+	filesystem, err := s.rootfsFilesystem(fullPath)
+	if err != nil {
+		log.WithError(err).Warnf("Failed to detect the rootfs filesystem of %s", fullPath)
+		filesystem = "unknown"
+	}
+	s.rootfsFilesystemsLock.Lock()
+	defer s.rootfsFilesystemsLock.Unlock()
+	if err != nil {
+		delete(s.rootfsFilesystems, fullPath)
+	} else {
+		s.rootfsFilesystems[fullPath] = filesystem
+	}
+	return filesystem
+}
+
+// cacheNmstateRamDisk extracts nmstatectl ahead of the minimal ISO creation for versions that embed it
+func (s *rhcosStore) cacheNmstateRamDisk(imageInfo map[string]string, fullPath string, filesystem isoeditor.RootfsFilesystem) error {
+	openshiftVersion := imageInfo["openshift_version"]
+	arch := imageInfo["cpu_architecture"]
+
+	// minimal ISOs aren't created for s390x so there's nothing to embed nmstatectl into
+	if arch == "s390x" {
+		return nil
+	}
+	// an invalid version is reported when creating the minimal ISO
+	versionOK, err := common.VersionGreaterOrEqual(openshiftVersion, isoeditor.MinimalVersionForNmstatectl)
+	if err != nil || !versionOK {
+		return nil
+	}
+	if _, err = os.Stat(isoeditor.NmstateRamDiskPath(fullPath)); err == nil {
+		return nil
+	}
+
+	log.Infof("Extracting nmstatectl for %s-%s from its %s rootfs", openshiftVersion, arch, filesystem)
+	start := time.Now()
+	err = s.isoEditor.CacheNmstateRamDisk(fullPath, arch)
+	s.nmstateExtractionDuration.WithLabelValues(openshiftVersion, arch, string(filesystem)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		s.nmstateExtractions.WithLabelValues(openshiftVersion, arch, "failure").Inc()
+		return err
+	}
+	s.nmstateExtractions.WithLabelValues(openshiftVersion, arch, "success").Inc()
+	return nil
+}
+
+func (s *rhcosStore) createMinimalISO(imageInfo map[string]string) error {
+	openshiftVersion := imageInfo["openshift_version"]
+	imageVersion := imageInfo["version"]
+	arch := imageInfo["cpu_architecture"]
+
+	minimalPath := filepath.Join(s.dataDir, s.isoFileName(ImageTypeMinimal, openshiftVersion, imageVersion, arch))
+	if _, err := os.Stat(minimalPath); os.IsNotExist(err) || s.keepStaleMinimalISOs {
+		log.Infof("Creating minimal iso for %s-%s-%s", openshiftVersion, imageVersion, arch)
 
-			log.Infof("Finished creating minimal iso for %s-%s (%s)", openshiftVersion, arch, imageVersion)
+		// the minimal iso kept from a previous deploy is only replaced once the new one is complete
+		templatePath := minimalPath
+		if s.keepStaleMinimalISOs {
+			templatePath = minimalPath + ".new"
+		}
+
+		fullPath := filepath.Join(s.dataDir, s.isoFileName(ImageTypeFull, openshiftVersion, imageVersion, arch))
+		rootfsURL, err := buildRootfsURL(s.imageServiceBaseURL, arch, openshiftVersion)
+		if err != nil {
+			return fmt.Errorf("failed to build rootfs URL: %v", err)
+		}
+
+		err = s.isoEditor.CreateMinimalISOTemplate(fullPath, rootfsURL, arch, templatePath, openshiftVersion)
+		if err != nil {
+			if templatePath != minimalPath {
+				os.Remove(templatePath)
+			}
+			return fmt.Errorf("failed to create minimal iso template for version %s: %v", imageInfo, err)
+		}
+		if templatePath != minimalPath {
+			if err = os.Rename(templatePath, minimalPath); err != nil {
+				return fmt.Errorf("failed to replace minimal iso %s: %v", minimalPath, err)
+			}
 		}
+
+		log.Infof("Finished creating minimal iso for %s-%s (%s)", openshiftVersion, arch, imageVersion)
 	}
 
 	return nil
 }
 
+// haveStaleMinimalISO reports if the minimal iso created by a previous deploy is kept at minimalPath
+func (s *rhcosStore) haveStaleMinimalISO(minimalPath string) bool {
+	if !s.keepStaleMinimalISOs {
+		return false
+	}
+	_, err := os.Stat(minimalPath)
+	return err == nil
+}
+
 func (s *rhcosStore) PathForParams(imageType, openshiftVersion, arch string) string {
 	var version string
-	for _, entry := range s.versions {
+	for _, entry := range s.getVersions() {
 		if entry["openshift_version"] == openshiftVersion && entry["cpu_architecture"] == arch {
 			version = entry["version"]
 		}
 	}
-	return filepath.Join(s.dataDir, isoFileName(imageType, openshiftVersion, version, arch))
+	return filepath.Join(s.dataDir, s.isoFileName(imageType, openshiftVersion, version, arch))
+}
+
+func (s *rhcosStore) isoFileName(imageType, openshiftVersion, version, arch string) string {
+	return fmt.Sprintf("%s-%s-%s-%s-%s.iso", s.isoFilePrefix, imageType, openshiftVersion, version, arch)
 }
 
-func isoFileName(imageType, openshiftVersion, version, arch string) string {
-	return fmt.Sprintf("rhcos-%s-%s-%s-%s.iso", imageType, openshiftVersion, version, arch)
+// isoFilePrefixRegexp matches the prefixes that keep the image file names in the data directory
+var isoFilePrefixRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+func validateISOFilePrefix(prefix string) error {
+	if !isoFilePrefixRegexp.MatchString(prefix) {
+		return fmt.Errorf("invalid ISO file prefix %q, it must start with a letter or digit and contain only letters, digits, '.', '_' and '-'", prefix)
+	}
+	return nil
 }
 
 func buildRootfsURL(baseURL, arch, version string) (string, error) {
@@ -341,13 +1097,27 @@ func buildRootfsURL(baseURL, arch, version string) (string, error) {
 	return downloadURL.String(), nil
 }
 
-func (s *rhcosStore) cleanDataDir() error {
+func (s *rhcosStore) cleanDataDir(versions []map[string]string) error {
 	var expectedFiles []string
-	for _, version := range s.versions {
-		// Only add full isos here as we want to regenerate the minimal image on each deploy
-		expectedFiles = append(expectedFiles, isoFileName(ImageTypeFull, version["openshift_version"], version["version"], version["cpu_architecture"]))
+	for _, version := range versions {
+		// Only add full isos and the files extracted from them here as we want to regenerate the minimal image on each deploy
+		fullISO := s.isoFileName(ImageTypeFull, version["openshift_version"], version["version"], version["cpu_architecture"])
+		expectedFiles = append(expectedFiles, fullISO, isoeditor.NmstateRamDiskPath(fullISO), checksumsCachePath(fullISO))
+		// unless the previous minimal image is served when it fails to be regenerated
+		if s.keepStaleMinimalISOs {
+			expectedFiles = append(expectedFiles, s.isoFileName(ImageTypeMinimal, version["openshift_version"], version["version"], version["cpu_architecture"]))
+		}
 	}
 
+	// the volume identifiers of the removed versions are dropped along with their files
+	s.volumeIDsLock.Lock()
+	for fullPath := range s.volumeIDs {
+		if !funk.ContainsString(expectedFiles, filepath.Base(fullPath)) {
+			delete(s.volumeIDs, fullPath)
+		}
+	}
+	s.volumeIDsLock.Unlock()
+
 	dataDirFiles, err := os.ReadDir(s.dataDir)
 	if err != nil {
 		return err
@@ -367,10 +1137,81 @@ func (s *rhcosStore) cleanDataDir() error {
 }
 
 func (s *rhcosStore) HaveVersion(version, arch string) bool {
-	for _, entry := range s.versions {
+	for _, entry := range s.getVersions() {
 		v, versionPresent := entry["openshift_version"]
 		a, archPresent := entry["cpu_architecture"]
-		if versionPresent && v == version && archPresent && a == arch {
+		if versionPresent && v == version && archPresent && a == arch && !versionDisabled(entry) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *rhcosStore) HaveMinimalISO(version, arch string) bool {
+	if !s.HaveVersion(version, arch) || s.MinimalISOError(version, arch) != "" {
+		return false
+	}
+	_, err := os.Stat(s.PathForParams(ImageTypeMinimal, version, arch))
+	return err == nil
+}
+
+func (s *rhcosStore) MinimalISOError(version, arch string) string {
+	s.minimalISOErrorsLock.RLock()
+	defer s.minimalISOErrorsLock.RUnlock()
+	return s.minimalISOErrors[s.PathForParams(ImageTypeMinimal, version, arch)]
+}
+
+// AvailableVersions returns the versions that are not disabled, in the configured order
+func (s *rhcosStore) AvailableVersions() []VersionInfo {
+	infos := []VersionInfo{}
+	for _, entry := range s.getVersions() {
+		if versionDisabled(entry) {
+			continue
+		}
+		info := VersionInfo{
+			OpenshiftVersion: entry["openshift_version"],
+			CPUArchitecture:  entry["cpu_architecture"],
+		}
+		minimalPath := filepath.Join(s.dataDir, s.isoFileName(ImageTypeMinimal, info.OpenshiftVersion, entry["version"], info.CPUArchitecture))
+		if _, err := os.Stat(minimalPath); err == nil {
+			info.SupportsMinimal = true
+			nmstate, err := common.VersionGreaterOrEqual(info.OpenshiftVersion, isoeditor.MinimalVersionForNmstatectl)
+			info.SupportsNmstate = err == nil && nmstate
+		}
+		s.minimalISOErrorsLock.RLock()
+		info.MinimalISOError = s.minimalISOErrors[minimalPath]
+		s.minimalISOErrorsLock.RUnlock()
+		info.VolumeID, _ = s.VolumeID(info.OpenshiftVersion, info.CPUArchitecture)
+		s.rootfsFilesystemsLock.RLock()
+		info.RootfsFilesystem = string(s.rootfsFilesystems[filepath.Join(s.dataDir, s.isoFileName(ImageTypeFull, info.OpenshiftVersion, entry["version"], info.CPUArchitecture))])
+		s.rootfsFilesystemsLock.RUnlock()
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func (s *rhcosStore) VolumeID(version, arch string) (string, bool) {
+	s.volumeIDsLock.RLock()
+	defer s.volumeIDsLock.RUnlock()
+	volumeID, ok := s.volumeIDs[s.PathForParams(ImageTypeFull, version, arch)]
+	return volumeID, ok
+}
+
+func (s *rhcosStore) NmstatectlPathForParams(version, arch string) (string, bool) {
+	// like cacheNmstateRamDisk, s390x has no minimal ISO to append nmstatectl to
+	if arch == "s390x" {
+		return "", false
+	}
+	nmstate, err := common.VersionGreaterOrEqual(version, isoeditor.MinimalVersionForNmstatectl)
+	if err != nil || !nmstate {
+		return "", false
+	}
+	return isoeditor.NmstateRamDiskPath(s.PathForParams(ImageTypeFull, version, arch)), true
+}
+
+func (s *rhcosStore) VersionDisabled(version, arch string) bool {
+	for _, entry := range s.getVersions() {
+		if entry["openshift_version"] == version && entry["cpu_architecture"] == arch && versionDisabled(entry) {
 			return true
 		}
 	}
@@ -14,17 +14,23 @@ import (
 	"math/big"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/google/renameio"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
 	"github.com/openshift/assisted-image-service/pkg/isoeditor"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
@@ -37,6 +43,19 @@ func TestImageStore(t *testing.T) {
 	RunSpecs(t, "imagestore")
 }
 
+// storeDir is the data directory of the stores that don't populate it
+var storeDir string
+
+var _ = BeforeSuite(func() {
+	var err error
+	storeDir, err = os.MkdirTemp("", "imageStoreDir")
+	Expect(err).NotTo(HaveOccurred())
+})
+
+var _ = AfterSuite(func() {
+	os.RemoveAll(storeDir)
+})
+
 var _ = Context("with a data directory configured", func() {
 	var (
 		dataDir string
@@ -303,6 +322,203 @@ var _ = Context("with a data directory configured", func() {
 				Expect(content).To(Equal(isoContent))
 			})
 
+			It("records the volume ID of the downloaded image", func() {
+				isoContent, isoHeader := isoInfo(validVolumeID)
+				ts.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/some.iso"),
+						ghttp.RespondWith(http.StatusOK, isoContent, isoHeader),
+					),
+				)
+				version["url"] = ts.URL() + "/some.iso"
+				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, ok := is.VolumeID("4.8", "x86_64")
+				Expect(ok).To(BeFalse())
+
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "x86_64", gomock.Any(), version["openshift_version"]).Return(nil)
+				Expect(is.Populate(ctx)).To(Succeed())
+
+				volumeID, ok := is.VolumeID("4.8", "x86_64")
+				Expect(ok).To(BeTrue())
+				Expect(volumeID).To(Equal(validVolumeID))
+				Expect(is.AvailableVersions()[0].VolumeID).To(Equal(validVolumeID))
+			})
+
+			It("forgets the volume ID of a removed version", func() {
+				isoContent, isoHeader := isoInfo(validVolumeID)
+				ts.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/some.iso"),
+						ghttp.RespondWith(http.StatusOK, isoContent, isoHeader),
+					),
+				)
+				version["url"] = ts.URL() + "/some.iso"
+				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
+				Expect(err).NotTo(HaveOccurred())
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "x86_64", gomock.Any(), gomock.Any()).Return(nil).Times(2)
+				Expect(is.Populate(ctx)).To(Succeed())
+				fullPath := is.PathForParams(ImageTypeFull, "4.8", "x86_64")
+				Expect(is.(*rhcosStore).volumeIDs).To(HaveKey(fullPath))
+
+				Expect(os.WriteFile(filepath.Join(dataDir, "rhcos-full-iso-4.9-49.84.202110081407-0-x86_64.iso"), []byte("moreisocontent"), 0600)).To(Succeed())
+				Expect(is.SetVersions([]map[string]string{{
+					"openshift_version": "4.9",
+					"cpu_architecture":  "x86_64",
+					"url":               ts.URL() + "/dontcallthis.iso",
+					"version":           "49.84.202110081407-0",
+				}})).To(Succeed())
+				Expect(is.Populate(ctx)).To(Succeed())
+				Expect(is.(*rhcosStore).volumeIDs).NotTo(HaveKey(fullPath))
+			})
+
+			It("downloads an image correctly through a small buffer", func() {
+				isoContent, isoHeader := isoInfo(validVolumeID)
+				ts.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/some.iso"),
+						ghttp.RespondWith(http.StatusOK, isoContent, isoHeader),
+					),
+				)
+				version["url"] = ts.URL() + "/some.iso"
+				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap,
+					WithDownloadBufferSize(7))
+				Expect(err).NotTo(HaveOccurred())
+
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "x86_64", gomock.Any(), version["openshift_version"]).Return(nil)
+				Expect(is.Populate(ctx)).To(Succeed())
+
+				content, err := os.ReadFile(filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(content).To(Equal(isoContent))
+			})
+
+			// respondChunked flushes the headers before the body so the response has no Content-Length
+			respondChunked := func(content []byte) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+					w.(http.Flusher).Flush()
+					_, _ = w.Write(content)
+				}
+			}
+
+			It("downloads a chunked image without a Content-Length", func() {
+				isoContent, _ := isoInfo(validVolumeID)
+				ts.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/some.iso"),
+						respondChunked(isoContent),
+					),
+				)
+				version["url"] = ts.URL() + "/some.iso"
+				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
+				Expect(err).NotTo(HaveOccurred())
+
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "x86_64", gomock.Any(), version["openshift_version"]).Return(nil)
+				Expect(is.Populate(ctx)).To(Succeed())
+
+				content, err := os.ReadFile(filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(content).To(Equal(isoContent))
+			})
+
+			It("copies the image into place when it can't be renamed across devices", func() {
+				isoContent, _ := isoInfo(validVolumeID)
+				ts.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/some.iso"),
+						ghttp.RespondWith(http.StatusOK, isoContent),
+					),
+				)
+				version["url"] = ts.URL() + "/some.iso"
+				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
+				Expect(err).NotTo(HaveOccurred())
+				var tempFile string
+				is.(*rhcosStore).closeAtomicallyReplace = func(t *renameio.PendingFile) error {
+					tempFile = t.Name()
+					Expect(t.Close()).To(Succeed())
+					return &os.LinkError{Op: "rename", Old: t.Name(), New: "", Err: syscall.EXDEV}
+				}
+
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "x86_64", gomock.Any(), version["openshift_version"]).Return(nil)
+				Expect(is.Populate(ctx)).To(Succeed())
+
+				fullPath := filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso")
+				content, err := os.ReadFile(fullPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(content).To(Equal(isoContent))
+				volumeID, ok := is.VolumeID("4.8", "x86_64")
+				Expect(ok).To(BeTrue())
+				Expect(volumeID).To(Equal(validVolumeID))
+				_, err = os.Stat(tempFile)
+				Expect(err).To(MatchError(fs.ErrNotExist))
+			})
+
+			It("fails when the image can't be renamed for other reasons", func() {
+				isoContent, _ := isoInfo(validVolumeID)
+				ts.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/some.iso"),
+						ghttp.RespondWith(http.StatusOK, isoContent),
+					),
+				)
+				version["url"] = ts.URL() + "/some.iso"
+				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
+				Expect(err).NotTo(HaveOccurred())
+				is.(*rhcosStore).closeAtomicallyReplace = func(t *renameio.PendingFile) error {
+					Expect(t.Close()).To(Succeed())
+					return &os.LinkError{Op: "rename", Old: t.Name(), New: "", Err: syscall.EACCES}
+				}
+
+				Expect(is.Populate(ctx)).NotTo(Succeed())
+				_, err = os.Stat(filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso"))
+				Expect(err).To(MatchError(fs.ErrNotExist))
+			})
+
+			It("copies the image into place when configured to", func() {
+				isoContent, _ := isoInfo(validVolumeID)
+				ts.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/some.iso"),
+						ghttp.RespondWith(http.StatusOK, isoContent),
+					),
+				)
+				version["url"] = ts.URL() + "/some.iso"
+				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap, WithCopyReplace())
+				Expect(err).NotTo(HaveOccurred())
+				is.(*rhcosStore).closeAtomicallyReplace = func(*renameio.PendingFile) error {
+					Fail("the image should not be renamed")
+					return nil
+				}
+
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "x86_64", gomock.Any(), version["openshift_version"]).Return(nil)
+				Expect(is.Populate(ctx)).To(Succeed())
+
+				content, err := os.ReadFile(filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(content).To(Equal(isoContent))
+			})
+
+			It("fails and removes the file when a chunked download is truncated", func() {
+				isoContent, _ := isoInfo(validVolumeID)
+				ts.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/some.iso"),
+						// cut before the volume ID
+						respondChunked(isoContent[:32000]),
+					),
+				)
+				version["url"] = ts.URL() + "/some.iso"
+				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(is.Populate(ctx)).NotTo(Succeed())
+
+				_, err = os.Stat(filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso"))
+				Expect(err).To(MatchError(fs.ErrNotExist))
+			})
+
 			It("fails when the download fails", func() {
 				ts.AppendHandlers(
 					ghttp.CombineHandlers(
@@ -317,6 +533,70 @@ var _ = Context("with a data directory configured", func() {
 				Expect(is.Populate(ctx)).NotTo(Succeed())
 			})
 
+			Context("with download timeouts", func() {
+				// respondSlowly stalls in the middle of the image, after the headers were sent
+				respondSlowly := func(content []byte) http.HandlerFunc {
+					return func(w http.ResponseWriter, _ *http.Request) {
+						w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+						_, _ = w.Write(content[:1024])
+						w.(http.Flusher).Flush()
+						time.Sleep(500 * time.Millisecond)
+						_, _ = w.Write(content[1024:])
+					}
+				}
+
+				slowVersion := func(downloadTimeout string) map[string]string {
+					entry := map[string]string{"url": ts.URL() + "/slow.iso"}
+					for key, value := range version {
+						if key != "url" {
+							entry[key] = value
+						}
+					}
+					if downloadTimeout != "" {
+						entry["download_timeout"] = downloadTimeout
+					}
+					return entry
+				}
+
+				It("fails a download taking longer than the default timeout", func() {
+					isoContent, _ := isoInfo(validVolumeID)
+					ts.AppendHandlers(respondSlowly(isoContent))
+					is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{slowVersion("")}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap,
+						WithDownloadTimeout(100*time.Millisecond))
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(is.Populate(ctx)).To(MatchError(ContainSubstring("timed out after 100ms")))
+					_, err = os.Stat(filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso"))
+					Expect(err).To(MatchError(fs.ErrNotExist))
+				})
+
+				It("lets a version with a longer timeout finish a slow download", func() {
+					isoContent, _ := isoInfo(validVolumeID)
+					ts.AppendHandlers(respondSlowly(isoContent))
+					entry := slowVersion("10s")
+					is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{entry}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap,
+						WithDownloadTimeout(100*time.Millisecond))
+					Expect(err).NotTo(HaveOccurred())
+
+					rootfs := fmt.Sprintf(rootfsURL, entry["openshift_version"])
+					mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), rootfs, "x86_64", gomock.Any(), entry["openshift_version"]).Return(nil)
+					Expect(is.Populate(ctx)).To(Succeed())
+
+					content, err := os.ReadFile(filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(content).To(Equal(isoContent))
+				})
+
+				It("fails a version whose timeout is shorter than its download", func() {
+					isoContent, _ := isoInfo(validVolumeID)
+					ts.AppendHandlers(respondSlowly(isoContent))
+					is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{slowVersion("100ms")}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(is.Populate(ctx)).To(MatchError(ContainSubstring("timed out after 100ms")))
+				})
+			})
+
 			It("fails and removes the file when the downloaded iso has an invalid volume ID", func() {
 				isoContent, isoHeader := isoInfo("Fedora-S-dvd-x86_64-37")
 				ts.AppendHandlers(
@@ -351,6 +631,110 @@ var _ = Context("with a data directory configured", func() {
 				Expect(is.Populate(ctx)).NotTo(Succeed())
 			})
 
+			It("creates the other minimal isos when one fails with failure isolation", func() {
+				otherVersion := map[string]string{
+					"openshift_version": "4.9",
+					"cpu_architecture":  "x86_64",
+					"version":           "49.84.202110081407-0",
+					"url":               ts.URL() + "/dontcallthis.iso",
+				}
+				version["url"] = ts.URL() + "/dontcallthis.iso"
+				Expect(os.WriteFile(filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso"), []byte("moreisocontent"), 0600)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(dataDir, "rhcos-full-iso-4.9-49.84.202110081407-0-x86_64.iso"), []byte("moreisocontent"), 0600)).To(Succeed())
+				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version, otherVersion}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap, WithMinimalISOFailureIsolation())
+				Expect(err).NotTo(HaveOccurred())
+
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "x86_64", gomock.Any(), "4.8").Return(fmt.Errorf("minimal iso creation failed"))
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "x86_64", gomock.Any(), "4.9").DoAndReturn(
+					func(_, _, _, minimalPath, _ string) error {
+						return os.WriteFile(minimalPath, []byte("minimalisocontent"), 0600)
+					})
+				Expect(is.Populate(ctx)).To(Succeed())
+
+				versions := is.AvailableVersions()
+				Expect(versions).To(HaveLen(2))
+				Expect(versions[0].SupportsMinimal).To(BeFalse())
+				Expect(versions[0].MinimalISOError).To(ContainSubstring("minimal iso creation failed"))
+				Expect(versions[1].SupportsMinimal).To(BeTrue())
+				Expect(versions[1].MinimalISOError).To(BeEmpty())
+				Expect(is.HaveVersion("4.8", "x86_64")).To(BeTrue())
+				Expect(is.HaveMinimalISO("4.8", "x86_64")).To(BeFalse())
+				Expect(is.MinimalISOError("4.8", "x86_64")).To(ContainSubstring("minimal iso creation failed"))
+				Expect(is.HaveMinimalISO("4.9", "x86_64")).To(BeTrue())
+
+				// the error is cleared once the minimal iso is created
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "x86_64", gomock.Any(), gomock.Any()).Return(nil).Times(2)
+				Expect(is.Populate(ctx)).To(Succeed())
+				Expect(is.AvailableVersions()[0].MinimalISOError).To(BeEmpty())
+			})
+
+			Context("with stale minimal isos", func() {
+				var (
+					fullPath    string
+					minimalPath string
+				)
+
+				BeforeEach(func() {
+					version["url"] = ts.URL() + "/dontcallthis.iso"
+					fullPath = filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso")
+					minimalPath = filepath.Join(dataDir, "rhcos-minimal-iso-4.8-48.84.202109241901-0-x86_64.iso")
+					Expect(os.WriteFile(fullPath, []byte("moreisocontent"), 0600)).To(Succeed())
+				})
+
+				It("serves the previous minimal iso when it fails to be created again", func() {
+					Expect(os.WriteFile(minimalPath, []byte("staleminimalcontent"), 0600)).To(Succeed())
+					is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap, WithStaleMinimalISOs())
+					Expect(err).NotTo(HaveOccurred())
+
+					mockEditor.EXPECT().CreateMinimalISOTemplate(fullPath, gomock.Any(), "x86_64", gomock.Any(), "4.8").DoAndReturn(
+						func(_, _, _, templatePath, _ string) error {
+							Expect(os.WriteFile(templatePath, []byte("partial"), 0600)).To(Succeed())
+							return fmt.Errorf("minimal iso creation failed")
+						})
+					Expect(is.Populate(ctx)).To(Succeed())
+
+					content, err := os.ReadFile(minimalPath)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(content)).To(Equal("staleminimalcontent"))
+					_, err = os.Stat(minimalPath + ".new")
+					Expect(err).To(MatchError(fs.ErrNotExist))
+					versions := is.AvailableVersions()
+					Expect(versions).To(HaveLen(1))
+					Expect(versions[0].SupportsMinimal).To(BeTrue())
+					Expect(versions[0].MinimalISOError).To(BeEmpty())
+				})
+
+				It("replaces the previous minimal iso once it's created again", func() {
+					Expect(os.WriteFile(minimalPath, []byte("staleminimalcontent"), 0600)).To(Succeed())
+					is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap, WithStaleMinimalISOs())
+					Expect(err).NotTo(HaveOccurred())
+
+					mockEditor.EXPECT().CreateMinimalISOTemplate(fullPath, gomock.Any(), "x86_64", gomock.Any(), "4.8").DoAndReturn(
+						func(_, _, _, templatePath, _ string) error {
+							return os.WriteFile(templatePath, []byte("minimalisocontent"), 0600)
+						})
+					Expect(is.Populate(ctx)).To(Succeed())
+
+					content, err := os.ReadFile(minimalPath)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(content)).To(Equal("minimalisocontent"))
+				})
+
+				It("fails when there's no previous minimal iso", func() {
+					is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap, WithStaleMinimalISOs())
+					Expect(err).NotTo(HaveOccurred())
+
+					mockEditor.EXPECT().CreateMinimalISOTemplate(fullPath, gomock.Any(), "x86_64", gomock.Any(), "4.8").DoAndReturn(
+						func(_, _, _, templatePath, _ string) error {
+							Expect(os.WriteFile(templatePath, []byte("partial"), 0600)).To(Succeed())
+							return fmt.Errorf("minimal iso creation failed")
+						})
+					Expect(is.Populate(ctx)).NotTo(Succeed())
+					_, err = os.Stat(minimalPath)
+					Expect(err).To(MatchError(fs.ErrNotExist))
+				})
+			})
+
 			It("doesn't download if the file already exists", func() {
 				ts.AppendHandlers(
 					ghttp.CombineHandlers(
@@ -369,6 +753,246 @@ var _ = Context("with a data directory configured", func() {
 				Expect(is.Populate(ctx)).To(Succeed())
 			})
 
+			It("skips disabled versions and keeps their files", func() {
+				ts.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/dontcallthis.iso"),
+						http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { Fail("endpoint should not be queried") }),
+					),
+				)
+				disabledVersion := map[string]string{"disabled": "true", "url": ts.URL() + "/dontcallthis.iso"}
+				for k, v := range version {
+					if k != "url" {
+						disabledVersion[k] = v
+					}
+				}
+				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{disabledVersion}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
+				Expect(err).NotTo(HaveOccurred())
+
+				fullPath := filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso")
+				Expect(os.WriteFile(fullPath, []byte("moreisocontent"), 0600)).To(Succeed())
+
+				Expect(is.Populate(ctx)).To(Succeed())
+				Expect(fullPath).To(BeAnExistingFile())
+			})
+
+			Context("with configured kargs files", func() {
+				var (
+					fullPath    string
+					minimalPath string
+					kargsFiles  []string
+				)
+
+				newStore := func(files string) ImageStore {
+					entry := map[string]string{"kargs_files": files, "url": ts.URL() + "/dontcallthis.iso"}
+					for k, v := range version {
+						if k != "url" {
+							entry[k] = v
+						}
+					}
+					is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{entry}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
+					Expect(err).NotTo(HaveOccurred())
+					is.(*rhcosStore).isoFileInfo = func(filePath, isoPath string) (int64, int64, error) {
+						Expect(isoPath).To(Equal(fullPath))
+						for _, file := range kargsFiles {
+							if file == filePath {
+								return 0, 0, nil
+							}
+						}
+						return 0, 0, fmt.Errorf("file %s not found", filePath)
+					}
+					return is
+				}
+
+				BeforeEach(func() {
+					fullPath = filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso")
+					minimalPath = filepath.Join(dataDir, "rhcos-minimal-iso-4.8-48.84.202109241901-0-x86_64.iso")
+					kargsFiles = []string{"/EFI/custom/grub.cfg", "/isolinux/custom.cfg"}
+					Expect(os.WriteFile(fullPath, []byte("moreisocontent"), 0600)).To(Succeed())
+				})
+
+				It("patches the kernel arguments into the configured files", func() {
+					is := newStore("/EFI/custom/grub.cfg, /isolinux/custom.cfg")
+					mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "x86_64", gomock.Any(), version["openshift_version"]).Return(nil)
+					Expect(is.Populate(ctx)).To(Succeed())
+
+					for _, path := range []string{fullPath, minimalPath} {
+						Expect(is.KargsFiles(path)).To(Equal(kargsFiles))
+					}
+					Expect(is.KargsFiles(filepath.Join(dataDir, "rhcos-full-iso-4.9-49.84.202110081407-0-x86_64.iso"))).To(BeEmpty())
+				})
+
+				It("discovers the files when none are configured", func() {
+					is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
+					Expect(err).NotTo(HaveOccurred())
+					mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "x86_64", gomock.Any(), version["openshift_version"]).Return(nil)
+					Expect(is.Populate(ctx)).To(Succeed())
+
+					// the files are left to isoeditor, which discovers them from the ISO
+					Expect(is.KargsFiles(fullPath)).To(BeEmpty())
+				})
+
+				It("fails when a configured file isn't in the ISO", func() {
+					is := newStore("/EFI/custom/grub.cfg,/EFI/missing/grub.cfg")
+					Expect(is.Populate(ctx)).To(MatchError(ContainSubstring("kargs file /EFI/missing/grub.cfg of version 4.8-x86_64 not found")))
+				})
+			})
+
+			Context("with a version that embeds nmstatectl", func() {
+				var (
+					nmstateVersion map[string]string
+					fullPath       string
+					reg            *prometheus.Registry
+				)
+
+				BeforeEach(func() {
+					isoContent, isoHeader := isoInfo(validVolumeID)
+					ts.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", "/nmstate.iso"),
+							ghttp.RespondWith(http.StatusOK, isoContent, isoHeader),
+						),
+					)
+					nmstateVersion = map[string]string{
+						"openshift_version": "4.18",
+						"cpu_architecture":  "x86_64",
+						"version":           "418.94.202410090804-0",
+						"url":               ts.URL() + "/nmstate.iso",
+					}
+					fullPath = filepath.Join(dataDir, "rhcos-full-iso-4.18-418.94.202410090804-0-x86_64.iso")
+					reg = prometheus.NewRegistry()
+				})
+
+				extractions := func(result string) float64 {
+					families, err := reg.Gather()
+					Expect(err).NotTo(HaveOccurred())
+					for _, family := range families {
+						if family.GetName() != "assisted_image_service_nmstatectl_extractions_total" {
+							continue
+						}
+						for _, metric := range family.GetMetric() {
+							for _, label := range metric.GetLabel() {
+								if label.GetName() == "result" && label.GetValue() == result {
+									return metric.GetCounter().GetValue()
+								}
+							}
+						}
+					}
+					return 0
+				}
+
+				It("extracts nmstatectl before creating the minimal iso", func() {
+					is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{nmstateVersion}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap, WithMetricsRegisterer(reg))
+					Expect(err).NotTo(HaveOccurred())
+
+					cache := mockEditor.EXPECT().CacheNmstateRamDisk(fullPath, "x86_64").Return(nil)
+					mockEditor.EXPECT().CreateMinimalISOTemplate(fullPath, gomock.Any(), "x86_64", gomock.Any(), "4.18").Return(nil).After(cache)
+					Expect(is.Populate(ctx)).To(Succeed())
+					Expect(extractions("success")).To(Equal(float64(1)))
+					Expect(extractions("failure")).To(Equal(float64(0)))
+				})
+
+				It("skips only the minimal iso of a version that fails extraction", func() {
+					is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{nmstateVersion, version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap, WithMetricsRegisterer(reg))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(os.WriteFile(filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso"), []byte("moreisocontent"), 0600)).To(Succeed())
+
+					mockEditor.EXPECT().CacheNmstateRamDisk(fullPath, "x86_64").Return(fmt.Errorf("extraction failed"))
+					mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "x86_64", gomock.Any(), version["openshift_version"]).DoAndReturn(
+						func(_, _, _, minimalISOPath, _ string) error {
+							return os.WriteFile(minimalISOPath, []byte("minimalisocontent"), 0600)
+						})
+					Expect(is.Populate(ctx)).To(Succeed())
+					Expect(extractions("failure")).To(Equal(float64(1)))
+					Expect(is.HaveVersion(nmstateVersion["openshift_version"], "x86_64")).To(BeTrue())
+					Expect(is.HaveMinimalISO(nmstateVersion["openshift_version"], "x86_64")).To(BeFalse())
+					Expect(is.HaveMinimalISO(version["openshift_version"], "x86_64")).To(BeTrue())
+				})
+
+				It("extracts nmstatectl while other images download", func() {
+					isoContent, isoHeader := isoInfo(validVolumeID)
+					Expect(os.WriteFile(fullPath, isoContent, 0600)).To(Succeed())
+					downloading := make(chan struct{})
+					ts.RouteToHandler("GET", "/other.iso", func(w http.ResponseWriter, r *http.Request) {
+						close(downloading)
+						for k, v := range isoHeader {
+							w.Header()[k] = v
+						}
+						_, _ = w.Write(isoContent)
+					})
+					version["url"] = ts.URL() + "/other.iso"
+					is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{nmstateVersion, version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap,
+						WithMaxConcurrentDownloads(1))
+					Expect(err).NotTo(HaveOccurred())
+
+					mockEditor.EXPECT().CacheNmstateRamDisk(fullPath, "x86_64").DoAndReturn(func(string, string) error {
+						// the extraction must not take the only download slot
+						select {
+						case <-downloading:
+							return nil
+						case <-time.After(5 * time.Second):
+							return fmt.Errorf("the other image wasn't downloaded during the extraction")
+						}
+					})
+					mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "x86_64", gomock.Any(), gomock.Any()).Return(nil).Times(2)
+					Expect(is.Populate(ctx)).To(Succeed())
+				})
+
+				It("reports the detected rootfs filesystem", func() {
+					is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{nmstateVersion}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap, WithMetricsRegisterer(reg))
+					Expect(err).NotTo(HaveOccurred())
+					is.(*rhcosStore).rootfsFilesystem = func(isoPath string) (isoeditor.RootfsFilesystem, error) {
+						Expect(isoPath).To(Equal(fullPath))
+						return isoeditor.RootfsErofs, nil
+					}
+
+					mockEditor.EXPECT().CacheNmstateRamDisk(fullPath, "x86_64").Return(nil)
+					mockEditor.EXPECT().CreateMinimalISOTemplate(fullPath, gomock.Any(), "x86_64", gomock.Any(), "4.18").Return(nil)
+					Expect(is.Populate(ctx)).To(Succeed())
+					Expect(is.AvailableVersions()[0].RootfsFilesystem).To(Equal("erofs"))
+
+					families, err := reg.Gather()
+					Expect(err).NotTo(HaveOccurred())
+					var filesystems []string
+					for _, family := range families {
+						if family.GetName() != "assisted_image_service_nmstatectl_extraction_duration_seconds" {
+							continue
+						}
+						for _, metric := range family.GetMetric() {
+							for _, label := range metric.GetLabel() {
+								if label.GetName() == "rootfs_filesystem" {
+									filesystems = append(filesystems, label.GetValue())
+								}
+							}
+						}
+					}
+					Expect(filesystems).To(Equal([]string{"erofs"}))
+				})
+
+				It("leaves out a rootfs filesystem that can't be detected", func() {
+					is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{nmstateVersion}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
+					Expect(err).NotTo(HaveOccurred())
+					is.(*rhcosStore).rootfsFilesystem = func(string) (isoeditor.RootfsFilesystem, error) {
+						return "", fmt.Errorf("rootfs contains neither root.squashfs nor root.erofs")
+					}
+
+					mockEditor.EXPECT().CacheNmstateRamDisk(fullPath, "x86_64").Return(nil)
+					mockEditor.EXPECT().CreateMinimalISOTemplate(fullPath, gomock.Any(), "x86_64", gomock.Any(), "4.18").Return(nil)
+					Expect(is.Populate(ctx)).To(Succeed())
+					Expect(is.AvailableVersions()[0].RootfsFilesystem).To(BeEmpty())
+				})
+
+				It("reuses a previously extracted nmstatectl", func() {
+					Expect(os.WriteFile(isoeditor.NmstateRamDiskPath(fullPath), []byte("ramdisk"), 0600)).To(Succeed())
+					is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{nmstateVersion}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
+					Expect(err).NotTo(HaveOccurred())
+
+					mockEditor.EXPECT().CreateMinimalISOTemplate(fullPath, gomock.Any(), "x86_64", gomock.Any(), "4.18").Return(nil)
+					Expect(is.Populate(ctx)).To(Succeed())
+					Expect(isoeditor.NmstateRamDiskPath(fullPath)).To(BeAnExistingFile())
+				})
+			})
+
 			It("recreates the minimal iso even when it's already present", func() {
 				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
 				Expect(err).NotTo(HaveOccurred())
@@ -449,15 +1073,51 @@ var _ = Context("with a data directory configured", func() {
 				Expect(err).To(MatchError(fs.ErrNotExist))
 			})
 
-			It("cleans up corrupted downloads", func() {
-				ts.AppendHandlers(
-					ghttp.CombineHandlers(
-						ghttp.VerifyRequest("GET", "/some.iso"),
-						ghttp.RespondWith(http.StatusOK, "someisocontenthere", http.Header{"Content-Length": []string{"1"}}),
-					),
-				)
-				version["url"] = ts.URL() + "/some.iso"
-				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
+			It("stores the images with a custom file prefix and keeps them when cleaning up", func() {
+				oldISOPath := filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso")
+				Expect(os.WriteFile(oldISOPath, []byte("oldisocontent"), 0600)).To(Succeed())
+				fullPath := filepath.Join(dataDir, "scos-full-iso-4.8-48.84.202109241901-0-x86_64.iso")
+				Expect(os.WriteFile(fullPath, []byte("someisocontent"), 0600)).To(Succeed())
+				ramDiskPath := filepath.Join(dataDir, "scos-full-iso-4.8-48.84.202109241901-0-x86_64-nmstate.img")
+				Expect(os.WriteFile(ramDiskPath, []byte("someramdisk"), 0600)).To(Succeed())
+
+				version["url"] = ts.URL() + "/dontcallthis.iso"
+				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap,
+					WithISOFilePrefix("scos"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(is.PathForParams(ImageTypeFull, "4.8", "x86_64")).To(Equal(fullPath))
+				Expect(is.PathForParams(ImageTypeMinimal, "4.8", "x86_64")).To(Equal(filepath.Join(dataDir, "scos-minimal-iso-4.8-48.84.202109241901-0-x86_64.iso")))
+
+				rootfs := fmt.Sprintf(rootfsURL, version["openshift_version"])
+				mockEditor.EXPECT().CreateMinimalISOTemplate(fullPath, rootfs, "x86_64", filepath.Join(dataDir, "scos-minimal-iso-4.8-48.84.202109241901-0-x86_64.iso"), version["openshift_version"]).Return(nil)
+				Expect(is.Populate(ctx)).To(Succeed())
+
+				_, err = os.Stat(oldISOPath)
+				Expect(err).To(MatchError(fs.ErrNotExist))
+				content, err := os.ReadFile(fullPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("someisocontent"))
+				_, err = os.Stat(ramDiskPath)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("rejects file prefixes that aren't valid file name components", func() {
+				for _, prefix := range []string{"", "../rhcos", "sc/os", ".scos"} {
+					_, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap,
+						WithISOFilePrefix(prefix))
+					Expect(err).To(HaveOccurred(), prefix)
+				}
+			})
+
+			It("cleans up corrupted downloads", func() {
+				ts.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/some.iso"),
+						ghttp.RespondWith(http.StatusOK, "someisocontenthere", http.Header{"Content-Length": []string{"1"}}),
+					),
+				)
+				version["url"] = ts.URL() + "/some.iso"
+				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
 				Expect(err).NotTo(HaveOccurred())
 
 				err = is.Populate(ctx)
@@ -468,6 +1128,133 @@ var _ = Context("with a data directory configured", func() {
 				Expect(err).To(MatchError(fs.ErrNotExist))
 			})
 
+			It("limits the number of concurrent downloads", func() {
+				isoContent, isoHeader := isoInfo(validVolumeID)
+				var (
+					lock      sync.Mutex
+					active    int
+					maxActive int
+				)
+				gate := make(chan struct{})
+				ts.RouteToHandler("GET", "/some.iso", func(w http.ResponseWriter, r *http.Request) {
+					lock.Lock()
+					active++
+					if active > maxActive {
+						maxActive = active
+					}
+					lock.Unlock()
+
+					<-gate
+
+					lock.Lock()
+					active--
+					lock.Unlock()
+					for k, v := range isoHeader {
+						w.Header()[k] = v
+					}
+					_, _ = w.Write(isoContent)
+				})
+
+				var versions []map[string]string
+				for _, v := range []string{"4.8", "4.9", "4.10", "4.11"} {
+					versions = append(versions, map[string]string{
+						"openshift_version": v,
+						"cpu_architecture":  "x86_64",
+						"url":               ts.URL() + "/some.iso",
+						"version":           "48.84.202109241901-0",
+					})
+				}
+				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, versions, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap,
+					WithMaxConcurrentDownloads(2))
+				Expect(err).NotTo(HaveOccurred())
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "x86_64", gomock.Any(), gomock.Any()).Return(nil).Times(len(versions))
+
+				populateErr := make(chan error)
+				go func() {
+					populateErr <- is.Populate(ctx)
+				}()
+
+				currentActive := func() int {
+					lock.Lock()
+					defer lock.Unlock()
+					return active
+				}
+				Eventually(currentActive).Should(Equal(2))
+				Consistently(currentActive, "200ms").Should(Equal(2))
+				close(gate)
+
+				Eventually(populateErr).Should(Receive(BeNil()))
+				Expect(maxActive).To(Equal(2))
+			})
+
+			It("creates minimal isos in parallel when configured", func() {
+				var versions []map[string]string
+				for _, v := range []string{"4.8", "4.9", "4.10"} {
+					versions = append(versions, map[string]string{
+						"openshift_version": v,
+						"cpu_architecture":  "x86_64",
+						"url":               ts.URL() + "/dontcallthis.iso",
+						"version":           "48.84.202109241901-0",
+					})
+					Expect(os.WriteFile(filepath.Join(dataDir, fmt.Sprintf("rhcos-full-iso-%s-48.84.202109241901-0-x86_64.iso", v)), []byte("moreisocontent"), 0600)).To(Succeed())
+				}
+				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, versions, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap,
+					WithMaxConcurrentMinimalISOs(3))
+				Expect(err).NotTo(HaveOccurred())
+
+				var wg sync.WaitGroup
+				wg.Add(len(versions))
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "x86_64", gomock.Any(), gomock.Any()).DoAndReturn(
+					func(fullISOPath, rootFSURL, arch, minimalISOPath, openshiftVersion string) error {
+						// every creation must be in flight at the same time for this to return
+						wg.Done()
+						wg.Wait()
+						return nil
+					}).Times(len(versions))
+
+				Expect(is.Populate(ctx)).To(Succeed())
+			})
+
+			It("downloads images through the configured http proxy", func() {
+				isoContent, isoHeader := isoInfo(validVolumeID)
+				ts.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/some.iso"),
+						ghttp.RespondWith(http.StatusOK, isoContent, isoHeader),
+					),
+				)
+				version["url"] = "http://os-images.example.com/some.iso"
+				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap,
+					WithProxy(ts.URL(), "", ""))
+				Expect(err).NotTo(HaveOccurred())
+
+				rootfs := fmt.Sprintf(rootfsURL, version["openshift_version"])
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), rootfs, "x86_64", gomock.Any(), version["openshift_version"]).Return(nil)
+				Expect(is.Populate(ctx)).To(Succeed())
+
+				Expect(ts.ReceivedRequests()).To(HaveLen(1))
+				Expect(ts.ReceivedRequests()[0].Host).To(Equal("os-images.example.com"))
+				content, err := os.ReadFile(filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(content).To(Equal(isoContent))
+			})
+
+			It("tunnels https downloads through the configured https proxy", func() {
+				ts.AppendHandlers(func(w http.ResponseWriter, r *http.Request) {
+					defer GinkgoRecover()
+					Expect(r.Method).To(Equal(http.MethodConnect))
+					Expect(r.Host).To(Equal("os-images.example.com:443"))
+					w.WriteHeader(http.StatusForbidden)
+				})
+				version["url"] = "https://os-images.example.com/some.iso"
+				is, err := NewImageStore(mockEditor, dataDir, imageServiceBaseURL, false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap,
+					WithProxy("", ts.URL(), ""))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(is.Populate(ctx)).NotTo(Succeed())
+				Expect(ts.ReceivedRequests()).To(HaveLen(1))
+			})
+
 			It("fails when imageServiceBaseURL is not set", func() {
 				is, err := NewImageStore(mockEditor, dataDir, "", false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
 				Expect(err).NotTo(HaveOccurred())
@@ -507,13 +1294,44 @@ var _ = Describe("PathForParams", func() {
 			"url":               "http://example.com/image/x86_64-48.iso",
 			"version":           "48.84.202109241901-0",
 		}}
-		is, err := NewImageStore(nil, "/tmp/some/dir", imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		is, err := NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
 		Expect(err).NotTo(HaveOccurred())
-		expected := "/tmp/some/dir/rhcos-full-4.8-48.84.202109241901-0-x86_64.iso"
+		expected := filepath.Join(storeDir, "rhcos-full-4.8-48.84.202109241901-0-x86_64.iso")
 		Expect(is.PathForParams("full", "4.8", "x86_64")).To(Equal(expected))
 	})
 })
 
+var _ = Describe("NmstatectlPathForParams", func() {
+	var is ImageStore
+
+	BeforeEach(func() {
+		versions := []map[string]string{
+			{"openshift_version": "4.8", "cpu_architecture": "x86_64", "url": "http://example.com/image/x86_64-48.iso", "version": "48.84.202109241901-0"},
+			{"openshift_version": "4.18", "cpu_architecture": "x86_64", "url": "http://example.com/image/x86_64-418.iso", "version": "418.94.202410090804-0"},
+			{"openshift_version": "4.18", "cpu_architecture": "s390x", "url": "http://example.com/image/s390x-418.iso", "version": "418.94.202410090804-0"},
+		}
+		var err error
+		is, err = NewImageStore(nil, "/tmp/some/dir", imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("returns the cached nmstate ram disk of a version including nmstatectl", func() {
+		path, ok := is.NmstatectlPathForParams("4.18", "x86_64")
+		Expect(ok).To(BeTrue())
+		Expect(path).To(Equal("/tmp/some/dir/rhcos-full-4.18-418.94.202410090804-0-x86_64-nmstate.img"))
+	})
+
+	It("returns false for a version predating nmstatectl", func() {
+		_, ok := is.NmstatectlPathForParams("4.8", "x86_64")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("returns false for s390x, which has no minimal ISO", func() {
+		_, ok := is.NmstatectlPathForParams("4.18", "s390x")
+		Expect(ok).To(BeFalse())
+	})
+})
+
 var _ = Describe("HaveVersion", func() {
 	var (
 		versions = []map[string]string{
@@ -541,7 +1359,7 @@ var _ = Describe("HaveVersion", func() {
 
 	BeforeEach(func() {
 		var err error
-		store, err = NewImageStore(nil, "", imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		store, err = NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
 		Expect(err).NotTo(HaveOccurred())
 	})
 	AfterEach(func() {
@@ -560,6 +1378,374 @@ var _ = Describe("HaveVersion", func() {
 		Expect(store.HaveVersion("4.8", "aarch64")).To(BeFalse())
 		Expect(store.HaveVersion("4.11", "s390x")).To(BeFalse())
 	})
+
+	It("is false for versions that are disabled", func() {
+		disabled := []map[string]string{
+			{
+				"openshift_version": "4.10",
+				"cpu_architecture":  "x86_64",
+				"url":               "http://example.com/image/x86_64-410.iso",
+				"version":           "410.84.202201251210-0",
+				"disabled":          "true",
+			},
+		}
+		store, err := NewImageStore(nil, storeDir, imageServiceBaseURL, false, disabled, "", map[string]string{}, map[string]string{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.HaveVersion("4.10", "x86_64")).To(BeFalse())
+		Expect(store.VersionDisabled("4.10", "x86_64")).To(BeTrue())
+		Expect(store.VersionDisabled("4.8", "x86_64")).To(BeFalse())
+	})
+})
+
+var _ = Describe("AvailableVersions", func() {
+	versionEntry := func(openshiftVersion, arch string) map[string]string {
+		return map[string]string{
+			"openshift_version": openshiftVersion,
+			"cpu_architecture":  arch,
+			"url":               fmt.Sprintf("http://example.com/image/%s-%s.iso", arch, openshiftVersion),
+			"version":           "1",
+		}
+	}
+
+	It("lists the capabilities of the versions that aren't disabled", func() {
+		dataDir, err := os.MkdirTemp("", "availableVersionsTest")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dataDir)
+
+		disabled := versionEntry("4.17", "x86_64")
+		disabled["disabled"] = "true"
+		versions := []map[string]string{
+			versionEntry("4.16", "x86_64"),
+			versionEntry("4.18", "x86_64"),
+			versionEntry("4.18", "arm64"),
+			versionEntry("4.18", "s390x"),
+			disabled,
+		}
+		store, err := NewImageStore(nil, dataDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		Expect(err).NotTo(HaveOccurred())
+
+		// no minimal ISO was created for 4.18 arm64
+		for _, v := range []string{"4.16", "4.18"} {
+			Expect(os.WriteFile(store.PathForParams(ImageTypeMinimal, v, "x86_64"), []byte{}, 0600)).To(Succeed())
+		}
+
+		Expect(store.AvailableVersions()).To(Equal([]VersionInfo{
+			{OpenshiftVersion: "4.16", CPUArchitecture: "x86_64", SupportsMinimal: true},
+			{OpenshiftVersion: "4.18", CPUArchitecture: "x86_64", SupportsMinimal: true, SupportsNmstate: true},
+			{OpenshiftVersion: "4.18", CPUArchitecture: "arm64"},
+			{OpenshiftVersion: "4.18", CPUArchitecture: "s390x"},
+		}))
+	})
+})
+
+var _ = Describe("WithVersionRange", func() {
+	versionEntry := func(openshiftVersion string) map[string]string {
+		return map[string]string{
+			"openshift_version": openshiftVersion,
+			"cpu_architecture":  "x86_64",
+			"url":               fmt.Sprintf("http://example.com/image/x86_64-%s.iso", openshiftVersion),
+			"version":           "1",
+		}
+	}
+	versions := []map[string]string{
+		versionEntry("4.15"),
+		versionEntry("4.16"),
+		versionEntry("4.17"),
+		versionEntry("4.18.0-ec.0"),
+		versionEntry("4.18"),
+	}
+
+	It("includes both boundaries", func() {
+		store, err := NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{}, WithVersionRange("4.16", "4.17"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.HaveVersion("4.15", "x86_64")).To(BeFalse())
+		Expect(store.HaveVersion("4.16", "x86_64")).To(BeTrue())
+		Expect(store.HaveVersion("4.17", "x86_64")).To(BeTrue())
+		Expect(store.HaveVersion("4.18.0-ec.0", "x86_64")).To(BeFalse())
+		Expect(store.HaveVersion("4.18", "x86_64")).To(BeFalse())
+	})
+
+	It("orders pre-release versions before the release", func() {
+		store, err := NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{}, WithVersionRange("4.18.0-ec.0", ""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.HaveVersion("4.17", "x86_64")).To(BeFalse())
+		Expect(store.HaveVersion("4.18.0-ec.0", "x86_64")).To(BeTrue())
+		Expect(store.HaveVersion("4.18", "x86_64")).To(BeTrue())
+
+		store, err = NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{}, WithVersionRange("", "4.18.0-ec.0"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.HaveVersion("4.17", "x86_64")).To(BeTrue())
+		Expect(store.HaveVersion("4.18.0-ec.0", "x86_64")).To(BeTrue())
+		Expect(store.HaveVersion("4.18", "x86_64")).To(BeFalse())
+	})
+
+	It("fails when no versions are within the range", func() {
+		_, err := NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{}, WithVersionRange("4.19", ""))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails for an invalid boundary", func() {
+		_, err := NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{}, WithVersionRange("latest", ""))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("filters versions that are set later", func() {
+		store, err := NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{}, WithVersionRange("4.17", ""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.SetVersions([]map[string]string{versionEntry("4.16"), versionEntry("4.17")})).To(Succeed())
+		Expect(store.HaveVersion("4.16", "x86_64")).To(BeFalse())
+		Expect(store.HaveVersion("4.17", "x86_64")).To(BeTrue())
+		Expect(store.SetVersions([]map[string]string{versionEntry("4.16")})).NotTo(Succeed())
+		Expect(store.HaveVersion("4.17", "x86_64")).To(BeTrue())
+	})
+})
+
+var _ = Describe("WithMaxVersions", func() {
+	versionEntries := func(count int) []map[string]string {
+		versions := []map[string]string{}
+		for i := 0; i < count; i++ {
+			versions = append(versions, map[string]string{
+				"openshift_version": fmt.Sprintf("4.%d", i),
+				"cpu_architecture":  "x86_64",
+				"url":               fmt.Sprintf("http://example.com/image/x86_64-4.%d.iso", i),
+				"version":           "1",
+			})
+		}
+		return versions
+	}
+
+	It("rejects more versions than the default maximum", func() {
+		_, err := NewImageStore(nil, "", imageServiceBaseURL, false, versionEntries(DefaultMaxVersions+1), "", map[string]string{}, map[string]string{})
+		Expect(err).To(MatchError("invalid versions: 101 versions configured, more than the maximum of 100"))
+	})
+
+	It("accepts up to the configured maximum", func() {
+		store, err := NewImageStore(nil, "", imageServiceBaseURL, false, versionEntries(150), "", map[string]string{}, map[string]string{}, WithMaxVersions(150))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.AvailableVersions()).To(HaveLen(150))
+	})
+
+	It("rejects more versions than the configured maximum when they are set later", func() {
+		store, err := NewImageStore(nil, "", imageServiceBaseURL, false, versionEntries(2), "", map[string]string{}, map[string]string{}, WithMaxVersions(2))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(store.SetVersions(versionEntries(3))).To(MatchError(ContainSubstring("3 versions configured")))
+		Expect(store.AvailableVersions()).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("WithStrictVersionValidation", func() {
+	entry := func(openshiftVersion, arch string) map[string]string {
+		return map[string]string{
+			"openshift_version": openshiftVersion,
+			"cpu_architecture":  arch,
+			"url":               fmt.Sprintf("http://example.com/image/%s-%s.iso", arch, openshiftVersion),
+			"version":           "1",
+		}
+	}
+
+	newStore := func(versions ...map[string]string) (ImageStore, error) {
+		return NewImageStore(nil, "", imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{}, WithStrictVersionValidation())
+	}
+
+	It("accepts consistent versions", func() {
+		_, err := newStore(entry("4.8", "x86_64"), entry("4.18", "x86_64"), entry("4.18", "ppc64le"), entry("4.18", "s390x"), entry("4.8", "riscv64"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("rejects an invalid openshift version", func() {
+		_, err := newStore(entry("four", "x86_64"))
+		Expect(err).To(MatchError(ContainSubstring("openshift_version four is not a valid version")))
+	})
+
+	It("rejects a url that can't be downloaded", func() {
+		invalid := entry("4.8", "x86_64")
+		invalid["url"] = "/images/x86_64-4.8.iso"
+		_, err := newStore(invalid)
+		Expect(err).To(MatchError(ContainSubstring("url /images/x86_64-4.8.iso must be an absolute http or https URL")))
+	})
+
+	It("rejects entries for the same version and architecture", func() {
+		_, err := newStore(entry("4.8", "x86_64"), entry("4.8", "arm64"), entry("4.8", "x86_64"))
+		Expect(err).To(MatchError(ContainSubstring("another entry is configured for 4.8-x86_64")))
+	})
+
+	It("rejects kargs files for s390x", func() {
+		s390x := entry("4.8", "s390x")
+		s390x["kargs_files"] = "/EFI/redhat/grub.cfg"
+		_, err := newStore(s390x)
+		Expect(err).To(MatchError(ContainSubstring("kargs_files is not supported for s390x")))
+	})
+
+	It("rejects versions embedding nmstatectl on architectures it's not available for", func() {
+		_, err := newStore(entry("4.18", "riscv64"))
+		Expect(err).To(MatchError(ContainSubstring("openshift version 4.18 embeds nmstatectl, which is not available for riscv64")))
+	})
+
+	It("rejects inconsistent versions when they are set later", func() {
+		store, err := newStore(entry("4.8", "x86_64"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(store.SetVersions([]map[string]string{entry("4.18", "riscv64")})).To(MatchError(ContainSubstring("embeds nmstatectl")))
+		Expect(store.HaveVersion("4.8", "x86_64")).To(BeTrue())
+	})
+
+	It("doesn't check the versions unless enabled", func() {
+		_, err := NewImageStore(nil, "", imageServiceBaseURL, false, []map[string]string{entry("4.18", "riscv64")}, "", map[string]string{}, map[string]string{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("SetVersions", func() {
+	var versions = []map[string]string{
+		{
+			"openshift_version": "4.8",
+			"cpu_architecture":  "x86_64",
+			"url":               "http://example.com/image/x86_64-48.iso",
+			"version":           "48.84.202109241901-0",
+		},
+	}
+
+	It("replaces the configured versions", func() {
+		is, err := NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		Expect(err).NotTo(HaveOccurred())
+
+		newVersions := []map[string]string{
+			{
+				"openshift_version": "4.9",
+				"cpu_architecture":  "arm64",
+				"url":               "http://example.com/image/arm64-49.iso",
+				"version":           "49.84.202110080947-0",
+			},
+		}
+		Expect(is.SetVersions(newVersions)).To(Succeed())
+		Expect(is.HaveVersion("4.8", "x86_64")).To(BeFalse())
+		Expect(is.HaveVersion("4.9", "arm64")).To(BeTrue())
+	})
+
+	It("keeps the current versions when the new versions are invalid", func() {
+		is, err := NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(is.SetVersions([]map[string]string{{"openshift_version": "4.9"}})).NotTo(Succeed())
+		Expect(is.HaveVersion("4.8", "x86_64")).To(BeTrue())
+	})
+})
+
+var _ = Describe("LoadVersionsFile", func() {
+	var versionsFile string
+
+	BeforeEach(func() {
+		f, err := os.CreateTemp("", "versions.json")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+		versionsFile = f.Name()
+	})
+
+	AfterEach(func() {
+		os.Remove(versionsFile)
+	})
+
+	It("loads versions from the file", func() {
+		content := `[{"openshift_version": "4.8", "cpu_architecture": "x86_64", "url": "http://example.com/image/x86_64-48.iso", "version": "48.84.202109241901-0"}]`
+		Expect(os.WriteFile(versionsFile, []byte(content), 0600)).To(Succeed())
+
+		versions, err := LoadVersionsFile(versionsFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(Equal([]map[string]string{{
+			"openshift_version": "4.8",
+			"cpu_architecture":  "x86_64",
+			"url":               "http://example.com/image/x86_64-48.iso",
+			"version":           "48.84.202109241901-0",
+		}}))
+	})
+
+	It("accepts boolean values", func() {
+		versions, err := ParseVersions([]byte(`[{"openshift_version": "4.8", "cpu_architecture": "x86_64", "url": "http://example.com/image/x86_64-48.iso", "version": "48.84.202109241901-0", "disabled": true}]`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions[0]["disabled"]).To(Equal("true"))
+	})
+
+	It("fails for nested values", func() {
+		_, err := ParseVersions([]byte(`[{"openshift_version": {"major": 4}}]`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails for invalid JSON", func() {
+		Expect(os.WriteFile(versionsFile, []byte("not json"), 0600)).To(Succeed())
+		_, err := LoadVersionsFile(versionsFile)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails for invalid versions", func() {
+		Expect(os.WriteFile(versionsFile, []byte(`[{"openshift_version": "4.8"}]`), 0600)).To(Succeed())
+		_, err := LoadVersionsFile(versionsFile)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails when the file doesn't exist", func() {
+		_, err := LoadVersionsFile(filepath.Join(os.TempDir(), "does-not-exist.json"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("with a YAML file", func() {
+		var yamlFile string
+
+		BeforeEach(func() {
+			f, err := os.CreateTemp("", "versions-*.yaml")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(f.Close()).To(Succeed())
+			yamlFile = f.Name()
+		})
+
+		AfterEach(func() {
+			os.Remove(yamlFile)
+		})
+
+		It("loads the same versions as the JSON file", func() {
+			jsonContent := `[
+				{"openshift_version": "4.10", "cpu_architecture": "x86_64", "url": "http://example.com/image/x86_64-410.iso", "version": "410.84.202201251210-0", "disabled": true},
+				{"openshift_version": "4.9", "cpu_architecture": "arm64", "url": "http://example.com/image/arm64-49.iso", "version": "49.84.202110081407-0"}
+			]`
+			yamlContent := `
+- openshift_version: 4.10
+  cpu_architecture: x86_64
+  url: http://example.com/image/x86_64-410.iso
+  version: 410.84.202201251210-0
+  disabled: true
+- openshift_version: "4.9"
+  cpu_architecture: arm64
+  url: http://example.com/image/arm64-49.iso
+  version: 49.84.202110081407-0
+`
+			Expect(os.WriteFile(versionsFile, []byte(jsonContent), 0600)).To(Succeed())
+			Expect(os.WriteFile(yamlFile, []byte(yamlContent), 0600)).To(Succeed())
+
+			fromJSON, err := LoadVersionsFile(versionsFile)
+			Expect(err).NotTo(HaveOccurred())
+			fromYAML, err := LoadVersionsFile(yamlFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fromYAML).To(Equal(fromJSON))
+			Expect(fromYAML[0]["openshift_version"]).To(Equal("4.10"))
+		})
+
+		It("fails for nested values", func() {
+			_, err := ParseVersionsYAML([]byte("- openshift_version:\n    major: 4\n"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails for invalid YAML", func() {
+			Expect(os.WriteFile(yamlFile, []byte("openshift_version: 4.8"), 0600)).To(Succeed())
+			_, err := LoadVersionsFile(yamlFile)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails for invalid versions", func() {
+			Expect(os.WriteFile(yamlFile, []byte(`- openshift_version: "4.8"`), 0600)).To(Succeed())
+			_, err := LoadVersionsFile(yamlFile)
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
 
 var _ = Describe("NewImageStore", func() {
@@ -572,18 +1758,69 @@ var _ = Describe("NewImageStore", func() {
 				"version":           "48.84.202109241901-0",
 			},
 		}
-		_, err := NewImageStore(nil, "", imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		_, err := NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should only proxy hosts not matched by no proxy", func() {
+		versions := []map[string]string{
+			{
+				"openshift_version": "4.8",
+				"cpu_architecture":  "x86_64",
+				"url":               "http://example.com/image/x86_64-48.iso",
+				"version":           "48.84.202109241901-0",
+			},
+		}
+		is, err := NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{},
+			WithProxy("http://proxy.example.com:3128", "http://secure-proxy.example.com:3128", "mirror.internal"))
 		Expect(err).NotTo(HaveOccurred())
+		transport, ok := is.(*rhcosStore).httpClient.Transport.(*http.Transport)
+		Expect(ok).To(BeTrue())
+
+		proxyFor := func(rawURL string) *url.URL {
+			req, err := http.NewRequest("GET", rawURL, nil)
+			Expect(err).NotTo(HaveOccurred())
+			proxyURL, err := transport.Proxy(req)
+			Expect(err).NotTo(HaveOccurred())
+			return proxyURL
+		}
+		Expect(proxyFor("http://example.com/image.iso").String()).To(Equal("http://proxy.example.com:3128"))
+		Expect(proxyFor("https://example.com/image.iso").String()).To(Equal("http://secure-proxy.example.com:3128"))
+		Expect(proxyFor("https://mirror.internal/image.iso")).To(BeNil())
 	})
 
 	It("should error when RHCOS_IMAGES are not set i.e. versions is an empty slice", func() {
 		versions := []map[string]string{}
-		_, err := NewImageStore(nil, "", imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		_, err := NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
 		Expect(err).To(HaveOccurred())
 		Expect(err.Error()).To(Equal("invalid versions: must not be empty"))
 
 	})
 
+	DescribeTable("download_timeout validation",
+		func(downloadTimeout string, valid bool) {
+			versions := []map[string]string{
+				{
+					"openshift_version": "4.8",
+					"cpu_architecture":  "x86_64",
+					"url":               "http://example.com/image/x86_64-48.iso",
+					"version":           "48.84.202109241901-0",
+					"download_timeout":  downloadTimeout,
+				},
+			}
+			_, err := NewImageStore(nil, "", imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+			if valid {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring("download_timeout")))
+			}
+		},
+		Entry("accepts a duration", "1h30m", true),
+		Entry("rejects a number without unit", "60", false),
+		Entry("rejects a zero duration", "0s", false),
+		Entry("rejects a negative duration", "-5m", false),
+	)
+
 	It("should error when openshift_version is not set", func() {
 		versions := []map[string]string{
 			{
@@ -592,7 +1829,7 @@ var _ = Describe("NewImageStore", func() {
 				"version":          "48.84.202109241901-0",
 			},
 		}
-		_, err := NewImageStore(nil, "", imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		_, err := NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
 		Expect(err).To(HaveOccurred())
 	})
 
@@ -604,7 +1841,7 @@ var _ = Describe("NewImageStore", func() {
 				"version":           "48.84.202109241901-0",
 			},
 		}
-		_, err := NewImageStore(nil, "", imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		_, err := NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
 		Expect(err).To(HaveOccurred())
 	})
 
@@ -616,19 +1853,47 @@ var _ = Describe("NewImageStore", func() {
 				"version":           "48.84.202109241901-0",
 			},
 		}
-		_, err := NewImageStore(nil, "", imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		_, err := NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
 		Expect(err).To(HaveOccurred())
 	})
 
-	It("should error when version is not set", func() {
+	It("should error when kargs_files is empty", func() {
 		versions := []map[string]string{
 			{
 				"openshift_version": "4.8",
 				"cpu_architecture":  "x86_64",
 				"url":               "http://example.com/image/x86_64-48.iso",
+				"version":           "48.84.202109241901-0",
+				"kargs_files":       " , ",
 			},
 		}
 		_, err := NewImageStore(nil, "", imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		Expect(err).To(MatchError(ContainSubstring("kargs_files must not be empty")))
+	})
+
+	It("should error when a kargs_files path is relative", func() {
+		versions := []map[string]string{
+			{
+				"openshift_version": "4.8",
+				"cpu_architecture":  "x86_64",
+				"url":               "http://example.com/image/x86_64-48.iso",
+				"version":           "48.84.202109241901-0",
+				"kargs_files":       "/EFI/custom/grub.cfg,isolinux/custom.cfg",
+			},
+		}
+		_, err := NewImageStore(nil, "", imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		Expect(err).To(MatchError(ContainSubstring("must be absolute")))
+	})
+
+	It("should error when version is not set", func() {
+		versions := []map[string]string{
+			{
+				"openshift_version": "4.8",
+				"cpu_architecture":  "x86_64",
+				"url":               "http://example.com/image/x86_64-48.iso",
+			},
+		}
+		_, err := NewImageStore(nil, storeDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
 		Expect(err).To(HaveOccurred())
 	})
 })
@@ -0,0 +1,91 @@
+package imagestore
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// renameioTempFileRegexp matches the temp files renameio creates while the images and the
+// files extracted from them are written, a dot followed by the file name and random digits.
+// The file names start with the configured prefix, followed by the image type.
+var renameioTempFileRegexp = regexp.MustCompile(`^\.[a-zA-Z0-9][a-zA-Z0-9_.-]*-(full|minimal)-iso-.+\.(iso|img)[0-9]+$`)
+
+// scratchRegexp matches the temporary files and directories created in the data directory
+// by the ISO editor and the write check
+var scratchRegexp = regexp.MustCompile(`^(isoutil|nmstate-rootfs|\.write-probe-)[0-9]+$`)
+
+// RunTempFileJanitor removes the temporary files left behind in dataDir and in the system temp
+// directory by interrupted downloads and extractions. It sweeps them every interval, removing
+// those that haven't been modified for maxAge so files still being written are kept, and
+// returns when ctx is done.
+func RunTempFileJanitor(ctx context.Context, dataDir string, interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			removeStaleTempFiles(dataDir, maxAge, time.Now())
+		}
+	}
+}
+
+func removeStaleTempFiles(dataDir string, maxAge time.Duration, now time.Time) {
+	sweep := func(dir string, match func(name string) bool) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			log.WithError(err).Warnf("Failed to list %s for stale temp files", dir)
+			return
+		}
+		for _, entry := range entries {
+			if !match(entry.Name()) {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			modTime, err := latestModTime(path)
+			if err != nil || now.Sub(modTime) < maxAge {
+				continue
+			}
+			log.Infof("Removing stale temp file %s", path)
+			if err := os.RemoveAll(path); err != nil {
+				log.WithError(err).Warnf("Failed to remove stale temp file %s", path)
+			}
+		}
+	}
+
+	sweep(dataDir, func(name string) bool {
+		return renameioTempFileRegexp.MatchString(name) || scratchRegexp.MatchString(name)
+	})
+	// renameio writes to the system temp directory when it's on the same filesystem as the
+	// data directory, only the files named after images are ours there
+	if tmpDir := os.TempDir(); filepath.Clean(tmpDir) != filepath.Clean(dataDir) {
+		sweep(tmpDir, renameioTempFileRegexp.MatchString)
+	}
+}
+
+// latestModTime returns the most recent modification time of path or, for a directory, of
+// anything in it
+func latestModTime(path string) (time.Time, error) {
+	var latest time.Time
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	return latest, err
+}
@@ -0,0 +1,105 @@
+package imagestore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("temp file janitor", func() {
+	var (
+		dataDir string
+		stale   time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		dataDir, err = os.MkdirTemp("", "janitorTest")
+		Expect(err).NotTo(HaveOccurred())
+		stale = time.Now().Add(-2 * time.Hour)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dataDir)
+	})
+
+	create := func(name string, modTime time.Time) string {
+		path := filepath.Join(dataDir, name)
+		Expect(os.WriteFile(path, []byte("partial"), 0600)).To(Succeed())
+		Expect(os.Chtimes(path, modTime, modTime)).To(Succeed())
+		return path
+	}
+
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	It("removes stale temp files from interrupted downloads", func() {
+		download := create(".rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso123456", stale)
+		extraction := create(".rhcos-full-iso-4.8-48.84.202109241901-0-x86_64-rootfs.img987", stale)
+
+		removeStaleTempFiles(dataDir, time.Hour, time.Now())
+		Expect(exists(download)).To(BeFalse())
+		Expect(exists(extraction)).To(BeFalse())
+	})
+
+	It("removes stale temp files of images stored with another prefix", func() {
+		download := create(".scos-minimal-iso-4.8-48.84.202109241901-0-x86_64.iso123456", stale)
+
+		removeStaleTempFiles(dataDir, time.Hour, time.Now())
+		Expect(exists(download)).To(BeFalse())
+	})
+
+	It("keeps temp files that are still being written", func() {
+		inProgress := create(".rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso123456", time.Now())
+
+		removeStaleTempFiles(dataDir, time.Hour, time.Now())
+		Expect(exists(inProgress)).To(BeTrue())
+	})
+
+	It("removes stale scratch directories unless something in them is recent", func() {
+		scratch := filepath.Join(dataDir, "isoutil42")
+		Expect(os.MkdirAll(filepath.Join(scratch, "images"), 0755)).To(Succeed())
+		Expect(os.Chtimes(scratch, stale, stale)).To(Succeed())
+		active := filepath.Join(scratch, "images", "rootfs.img")
+		Expect(os.WriteFile(active, []byte("partial"), 0600)).To(Succeed())
+		Expect(os.Chtimes(filepath.Join(scratch, "images"), stale, stale)).To(Succeed())
+
+		removeStaleTempFiles(dataDir, time.Hour, time.Now())
+		Expect(exists(scratch)).To(BeTrue())
+
+		Expect(os.Chtimes(active, stale, stale)).To(Succeed())
+		removeStaleTempFiles(dataDir, time.Hour, time.Now())
+		Expect(exists(scratch)).To(BeFalse())
+	})
+
+	It("never removes the images or other files", func() {
+		image := create("rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso", stale)
+		ramDisk := create("rhcos-full-iso-4.8-48.84.202109241901-0-x86_64-nmstate.img", stale)
+		other := create(".other123", stale)
+
+		removeStaleTempFiles(dataDir, time.Hour, time.Now())
+		Expect(exists(image)).To(BeTrue())
+		Expect(exists(ramDisk)).To(BeTrue())
+		Expect(exists(other)).To(BeTrue())
+	})
+
+	It("sweeps periodically until cancelled", func() {
+		download := create(".rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso123456", stale)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			RunTempFileJanitor(ctx, dataDir, 10*time.Millisecond, time.Hour)
+		}()
+
+		Eventually(func() bool { return exists(download) }).Should(BeFalse())
+		cancel()
+		Eventually(done).Should(BeClosed())
+	})
+})
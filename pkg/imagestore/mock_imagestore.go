@@ -34,6 +34,49 @@ func (m *MockImageStore) EXPECT() *MockImageStoreMockRecorder {
 	return m.recorder
 }
 
+// ArtifactChecksum mocks base method.
+func (m *MockImageStore) ArtifactChecksum(arg0, arg1, arg2 string) (ArtifactChecksum, bool) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ArtifactChecksum", arg0, arg1, arg2)
+	ret0, _ := ret[0].(ArtifactChecksum)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// ArtifactChecksum indicates an expected call of ArtifactChecksum.
+func (mr *MockImageStoreMockRecorder) ArtifactChecksum(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ArtifactChecksum", reflect.TypeOf((*MockImageStore)(nil).ArtifactChecksum), arg0, arg1, arg2)
+}
+
+// AvailableVersions mocks base method.
+func (m *MockImageStore) AvailableVersions() []VersionInfo {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AvailableVersions")
+	ret0, _ := ret[0].([]VersionInfo)
+	return ret0
+}
+
+// AvailableVersions indicates an expected call of AvailableVersions.
+func (mr *MockImageStoreMockRecorder) AvailableVersions() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AvailableVersions", reflect.TypeOf((*MockImageStore)(nil).AvailableVersions))
+}
+
+// HaveMinimalISO mocks base method.
+func (m *MockImageStore) HaveMinimalISO(arg0, arg1 string) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HaveMinimalISO", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// HaveMinimalISO indicates an expected call of HaveMinimalISO.
+func (mr *MockImageStoreMockRecorder) HaveMinimalISO(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HaveMinimalISO", reflect.TypeOf((*MockImageStore)(nil).HaveMinimalISO), arg0, arg1)
+}
+
 // HaveVersion mocks base method.
 func (m *MockImageStore) HaveVersion(arg0, arg1 string) bool {
 	m.ctrl.T.Helper()
@@ -48,6 +91,49 @@ func (mr *MockImageStoreMockRecorder) HaveVersion(arg0, arg1 interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HaveVersion", reflect.TypeOf((*MockImageStore)(nil).HaveVersion), arg0, arg1)
 }
 
+// KargsFiles mocks base method.
+func (m *MockImageStore) KargsFiles(arg0 string) []string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "KargsFiles", arg0)
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// KargsFiles indicates an expected call of KargsFiles.
+func (mr *MockImageStoreMockRecorder) KargsFiles(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "KargsFiles", reflect.TypeOf((*MockImageStore)(nil).KargsFiles), arg0)
+}
+
+// MinimalISOError mocks base method.
+func (m *MockImageStore) MinimalISOError(arg0, arg1 string) string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MinimalISOError", arg0, arg1)
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// MinimalISOError indicates an expected call of MinimalISOError.
+func (mr *MockImageStoreMockRecorder) MinimalISOError(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MinimalISOError", reflect.TypeOf((*MockImageStore)(nil).MinimalISOError), arg0, arg1)
+}
+
+// NmstatectlPathForParams mocks base method.
+func (m *MockImageStore) NmstatectlPathForParams(arg0, arg1 string) (string, bool) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NmstatectlPathForParams", arg0, arg1)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// NmstatectlPathForParams indicates an expected call of NmstatectlPathForParams.
+func (mr *MockImageStoreMockRecorder) NmstatectlPathForParams(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NmstatectlPathForParams", reflect.TypeOf((*MockImageStore)(nil).NmstatectlPathForParams), arg0, arg1)
+}
+
 // PathForParams mocks base method.
 func (m *MockImageStore) PathForParams(arg0, arg1, arg2 string) string {
 	m.ctrl.T.Helper()
@@ -75,3 +161,60 @@ func (mr *MockImageStoreMockRecorder) Populate(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Populate", reflect.TypeOf((*MockImageStore)(nil).Populate), arg0)
 }
+
+// SetVersions mocks base method.
+func (m *MockImageStore) SetVersions(arg0 []map[string]string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetVersions", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetVersions indicates an expected call of SetVersions.
+func (mr *MockImageStoreMockRecorder) SetVersions(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetVersions", reflect.TypeOf((*MockImageStore)(nil).SetVersions), arg0)
+}
+
+// VersionDisabled mocks base method.
+func (m *MockImageStore) VersionDisabled(arg0, arg1 string) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VersionDisabled", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// VersionDisabled indicates an expected call of VersionDisabled.
+func (mr *MockImageStoreMockRecorder) VersionDisabled(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VersionDisabled", reflect.TypeOf((*MockImageStore)(nil).VersionDisabled), arg0, arg1)
+}
+
+// VolumeID mocks base method.
+func (m *MockImageStore) VolumeID(arg0, arg1 string) (string, bool) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VolumeID", arg0, arg1)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// VolumeID indicates an expected call of VolumeID.
+func (mr *MockImageStoreMockRecorder) VolumeID(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VolumeID", reflect.TypeOf((*MockImageStore)(nil).VolumeID), arg0, arg1)
+}
+
+// Warmup mocks base method.
+func (m *MockImageStore) Warmup(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Warmup", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Warmup indicates an expected call of Warmup.
+func (mr *MockImageStoreMockRecorder) Warmup(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Warmup", reflect.TypeOf((*MockImageStore)(nil).Warmup), arg0)
+}
@@ -0,0 +1,68 @@
+package imagestore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/google/renameio"
+	log "github.com/sirupsen/logrus"
+)
+
+// WithCopyReplace replaces downloaded images by copying the complete temp file over them
+// rather than renaming it into place, for data directories on filesystems where rename is
+// unsupported or unreliable. The copy is not atomic, an image is only served once its copy
+// completed and it was validated.
+func WithCopyReplace() Option {
+	return func(s *rhcosStore) {
+		s.copyReplace = true
+	}
+}
+
+// replaceFile replaces path with the complete temp file t. The file is renamed into place
+// unless copy replace is configured, or the rename fails because t is on another device.
+func (s *rhcosStore) replaceFile(t *renameio.PendingFile, path string) error {
+	if !s.copyReplace {
+		err := s.closeAtomicallyReplace(t)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("unable to atomically replace %s with temp file %s: %v", path, t.Name(), err)
+		}
+		log.Warnf("Temp file %s can't be renamed to %s on another device, copying it instead: %v", t.Name(), path, err)
+	} else if err := t.Sync(); err != nil {
+		return err
+	}
+
+	// the temp file is still removed by its cleanup once it's copied
+	if err := copyFile(t.Name(), path); err != nil {
+		return fmt.Errorf("unable to copy temp file %s to %s: %v", t.Name(), path, err)
+	}
+	return nil
+}
+
+// copyFile copies src over dest and syncs it
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,81 @@
+package imagestore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// warmupBufferSize is the size of the reads done by Warmup, the context is checked between them
+const warmupBufferSize = 1024 * 1024
+
+// Warmup reads the boot artifacts and minimal ISO templates of the available versions once so the
+// first requests for them are served from the page cache rather than from disk. It returns the
+// context error as soon as ctx is done.
+func (s *rhcosStore) Warmup(ctx context.Context) error {
+	buf := make([]byte, warmupBufferSize)
+	for _, entry := range s.getVersions() {
+		if versionDisabled(entry) {
+			continue
+		}
+		openshiftVersion, arch := entry["openshift_version"], entry["cpu_architecture"]
+		fullPath := filepath.Join(s.dataDir, s.isoFileName(ImageTypeFull, openshiftVersion, entry["version"], arch))
+		for _, filePath := range bootArtifactPaths(arch) {
+			if err := s.warmArtifact(ctx, fullPath, filePath, buf); err != nil {
+				return err
+			}
+		}
+
+		minimalPath := filepath.Join(s.dataDir, s.isoFileName(ImageTypeMinimal, openshiftVersion, entry["version"], arch))
+		if _, err := os.Stat(minimalPath); err != nil {
+			// not every version has a minimal ISO
+			continue
+		}
+		if err := warmFile(ctx, minimalPath, buf); err != nil {
+			return err
+		}
+	}
+	log.Info("Finished warming up the image store")
+	return nil
+}
+
+// warmArtifact reads the extent of the file at filePath within the full ISO, which is what the
+// boot artifacts are served from
+func (s *rhcosStore) warmArtifact(ctx context.Context, fullPath, filePath string, buf []byte) error {
+	offset, size, err := s.isoFileInfo(filePath, fullPath)
+	if err != nil {
+		return fmt.Errorf("failed to find %s in %s: %w", filePath, fullPath, err)
+	}
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return warmReader(ctx, io.NewSectionReader(f, offset, size), buf)
+}
+
+func warmFile(ctx context.Context, path string, buf []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return warmReader(ctx, f, buf)
+}
+
+func warmReader(ctx context.Context, r io.Reader, buf []byte) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := r.Read(buf); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
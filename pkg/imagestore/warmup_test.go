@@ -0,0 +1,61 @@
+package imagestore
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Warmup", func() {
+	var (
+		dataDir  string
+		store    *rhcosStore
+		versions = []map[string]string{{
+			"openshift_version": "4.8",
+			"cpu_architecture":  "x86_64",
+			"url":               "http://example.com/image/x86_64-48.iso",
+			"version":           "48.84.202109241901-0",
+		}}
+	)
+
+	BeforeEach(func() {
+		var err error
+		dataDir, err = os.MkdirTemp("", "warmupTest")
+		Expect(err).NotTo(HaveOccurred())
+
+		is, err := NewImageStore(nil, dataDir, imageServiceBaseURL, false, versions, "", map[string]string{}, map[string]string{})
+		Expect(err).NotTo(HaveOccurred())
+		store = is.(*rhcosStore)
+		store.isoFileInfo = func(filePath, isoPath string) (int64, int64, error) {
+			return 0, 3, nil
+		}
+
+		fullPath := store.PathForParams(ImageTypeFull, "4.8", "x86_64")
+		Expect(os.WriteFile(fullPath, []byte("iso"), 0600)).To(Succeed())
+		Expect(os.WriteFile(store.PathForParams(ImageTypeMinimal, "4.8", "x86_64"), []byte("minimal"), 0600)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dataDir)
+	})
+
+	It("reads the artifacts and the minimal ISO", func() {
+		Expect(store.Warmup(context.Background())).To(Succeed())
+	})
+
+	It("fails when an artifact isn't found in the ISO", func() {
+		store.isoFileInfo = func(filePath, isoPath string) (int64, int64, error) {
+			return 0, 0, errors.New("not found")
+		}
+		Expect(store.Warmup(context.Background())).To(MatchError(ContainSubstring("failed to find /images/pxeboot/rootfs.img")))
+	})
+
+	It("stops when the context is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		Expect(store.Warmup(ctx)).To(MatchError(context.Canceled))
+	})
+})
@@ -2,16 +2,156 @@ package isoeditor
 
 import (
 	"bytes"
+	"compress/gzip"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
 )
 
+// DefaultIgnitionConfigFileName is the name of the ignition config in the archive, where
+// CoreOS looks for it
+const DefaultIgnitionConfigFileName = "config.ign"
+
 type IgnitionContent struct {
 	Config []byte
+	// ExtraFiles are added to the ignition archive alongside the config,
+	// keyed by their path within the archive
+	ExtraFiles map[string][]byte
+	// ArchiveOptions are applied whenever the content is archived for embedding
+	ArchiveOptions []ArchiveOption
+}
+
+type archiveConfig struct {
+	compress       bool
+	gzipLevel      int
+	configFileName string
+	extraFiles     map[string][]byte
+}
+
+// ArchiveOption configures how the ignition cpio archive is built
+type ArchiveOption func(*archiveConfig)
+
+// WithCompression enables or disables gzip compression of the archive.
+// The kernel accepts both compressed and uncompressed cpio archives.
+func WithCompression(enabled bool) ArchiveOption {
+	return func(c *archiveConfig) {
+		c.compress = enabled
+	}
+}
+
+// WithGzipLevel sets the gzip compression level, see compress/gzip for valid values
+func WithGzipLevel(level int) ArchiveOption {
+	return func(c *archiveConfig) {
+		c.gzipLevel = level
+	}
+}
+
+// WithConfigFileName sets the path of the ignition config within the archive, for CoreOS
+// derivatives that look for it under another name
+func WithConfigFileName(name string) ArchiveOption {
+	return func(c *archiveConfig) {
+		c.configFileName = name
+	}
+}
+
+// WithExtraFile adds a file to the archive alongside the config and the content ExtraFiles,
+// for files that are embedded in every image regardless of their ignition
+func WithExtraFile(filePath string, content []byte) ArchiveOption {
+	return func(c *archiveConfig) {
+		if c.extraFiles == nil {
+			c.extraFiles = map[string][]byte{}
+		}
+		c.extraFiles[filePath] = content
+	}
+}
+
+// ValidateArchivePath checks that name can be used as the path of a file within the archive,
+// a clean relative path that stays within the archive root
+func ValidateArchivePath(name string) error {
+	if name == "" {
+		return fmt.Errorf("archive path must not be empty")
+	}
+	if path.IsAbs(name) || path.Clean(name) != name || name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("archive path %s must be a clean relative path", name)
+	}
+	if strings.ContainsRune(name, 0) {
+		return fmt.Errorf("archive path %q must not contain NUL characters", name)
+	}
+	return nil
+}
+
+// ValidateConfigFileName checks that name can be used as the path of the ignition config
+// within the archive
+func ValidateConfigFileName(name string) error {
+	if err := ValidateArchivePath(name); err != nil {
+		return fmt.Errorf("invalid ignition config file name: %w", err)
+	}
+	return nil
+}
+
+// Empty returns whether there is nothing to embed, neither a config nor any extra file
+func (ic *IgnitionContent) Empty() bool {
+	if len(ic.Config) > 0 || len(ic.ExtraFiles) > 0 {
+		return false
+	}
+	config := archiveConfig{}
+	for _, opt := range ic.ArchiveOptions {
+		opt(&config)
+	}
+	return len(config.extraFiles) == 0
 }
 
-func (ic *IgnitionContent) Archive() (*bytes.Reader, error) {
-	compressedCpio, err := generateCompressedCPIO(ic.Config, "config.ign", 0o100_644)
+// Archive returns the content as a cpio archive, gzip compressed with the default level
+// unless configured otherwise. The options given are applied after ic.ArchiveOptions.
+func (ic *IgnitionContent) Archive(opts ...ArchiveOption) (*bytes.Reader, error) {
+	config := archiveConfig{
+		compress:       true,
+		gzipLevel:      gzip.DefaultCompression,
+		configFileName: DefaultIgnitionConfigFileName,
+	}
+	for _, opt := range append(append([]ArchiveOption{}, ic.ArchiveOptions...), opts...) {
+		opt(&config)
+	}
+	if err := ValidateConfigFileName(config.configFileName); err != nil {
+		return nil, err
+	}
+
+	files := []cpioFile{{Path: config.configFileName, Content: ic.Config, Mode: 0o100_644}}
+
+	extraFiles := make(map[string][]byte, len(ic.ExtraFiles)+len(config.extraFiles))
+	for path, content := range ic.ExtraFiles {
+		if err := ValidateArchivePath(path); err != nil {
+			return nil, err
+		}
+		extraFiles[path] = content
+	}
+	for path, content := range config.extraFiles {
+		if err := ValidateArchivePath(path); err != nil {
+			return nil, err
+		}
+		if _, ok := extraFiles[path]; ok {
+			return nil, fmt.Errorf("extra ignition archive file %s is set twice", path)
+		}
+		extraFiles[path] = content
+	}
+
+	paths := make([]string, 0, len(extraFiles))
+	for path := range extraFiles {
+		if path == config.configFileName {
+			return nil, fmt.Errorf("extra ignition archive file conflicts with %s", config.configFileName)
+		}
+		paths = append(paths, path)
+	}
+	// sort so the archive content is deterministic
+	sort.Strings(paths)
+	for _, path := range paths {
+		files = append(files, cpioFile{Path: path, Content: extraFiles[path], Mode: 0o100_644})
+	}
+
+	archive, err := generateCPIOArchive(files, config.compress, config.gzipLevel)
 	if err != nil {
 		return nil, err
 	}
-	return bytes.NewReader(compressedCpio), nil
+	return bytes.NewReader(archive), nil
 }
@@ -1,9 +1,13 @@
 package isoeditor
 
 import (
+	"bytes"
+	"compress/gzip"
 	"io"
 
+	"github.com/cavaliercoder/go-cpio"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -21,7 +25,7 @@ var _ = Describe("IgnitionContent.Archive", func() {
 	)
 
 	It("converts the ignition to a compressed CPIO archive", func() {
-		content := IgnitionContent{ignitionContent}
+		content := IgnitionContent{Config: ignitionContent}
 
 		data, err := content.Archive()
 		Expect(err).NotTo(HaveOccurred())
@@ -31,4 +35,234 @@ var _ = Describe("IgnitionContent.Archive", func() {
 		Expect(ignitionBytes).To(Equal(ignitionArchiveBytes))
 		Expect(len(ignitionBytes) % 4).To(Equal(0))
 	})
+
+	It("adds extra files to the archive", func() {
+		content := IgnitionContent{
+			Config: ignitionContent,
+			ExtraFiles: map[string][]byte{
+				"etc/nmstate/static.yml": []byte("somenetworkconfig"),
+				"etc/motd":               []byte("somemotd"),
+			},
+		}
+
+		data, err := content.Archive()
+		Expect(err).NotTo(HaveOccurred())
+
+		gzipReader, err := gzip.NewReader(data)
+		Expect(err).NotTo(HaveOccurred())
+		cpioReader := cpio.NewReader(gzipReader)
+
+		files := map[string][]byte{}
+		for {
+			hdr, err := cpioReader.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+			fileContent, err := io.ReadAll(cpioReader)
+			Expect(err).NotTo(HaveOccurred())
+			files[hdr.Name] = fileContent
+		}
+		Expect(files).To(Equal(map[string][]byte{
+			"config.ign":             ignitionContent,
+			"etc/nmstate/static.yml": []byte("somenetworkconfig"),
+			"etc/motd":               []byte("somemotd"),
+		}))
+	})
+
+	It("adds the extra files of the archive options", func() {
+		content := IgnitionContent{
+			Config:     ignitionContent,
+			ExtraFiles: map[string][]byte{"etc/nmstate/static.yml": []byte("somenetworkconfig")},
+		}
+
+		data, err := content.Archive(WithCompression(false), WithExtraFile("etc/issue.d/branding.issue", []byte("somebranding")))
+		Expect(err).NotTo(HaveOccurred())
+
+		cpioReader := cpio.NewReader(data)
+		files := map[string][]byte{}
+		for {
+			hdr, err := cpioReader.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+			fileContent, err := io.ReadAll(cpioReader)
+			Expect(err).NotTo(HaveOccurred())
+			files[hdr.Name] = fileContent
+		}
+		Expect(files).To(Equal(map[string][]byte{
+			"config.ign":                 ignitionContent,
+			"etc/nmstate/static.yml":     []byte("somenetworkconfig"),
+			"etc/issue.d/branding.issue": []byte("somebranding"),
+		}))
+	})
+
+	It("fails when an archive option extra file is also a content extra file", func() {
+		content := IgnitionContent{
+			Config:     ignitionContent,
+			ExtraFiles: map[string][]byte{"etc/motd": []byte("somemotd")},
+		}
+
+		_, err := content.Archive(WithExtraFile("etc/motd", []byte("othermotd")))
+		Expect(err).To(MatchError(ContainSubstring("set twice")))
+	})
+
+	It("fails for an invalid archive option extra file path", func() {
+		content := IgnitionContent{Config: ignitionContent}
+
+		_, err := content.Archive(WithExtraFile("/etc/motd", []byte("somemotd")))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails for an invalid content extra file path", func() {
+		content := IgnitionContent{Config: ignitionContent, ExtraFiles: map[string][]byte{"../etc/x": []byte("x")}}
+
+		_, err := content.Archive()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("produces the same archive regardless of extra file ordering", func() {
+		extraFiles := map[string][]byte{}
+		for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+			extraFiles[name] = []byte(name)
+		}
+		content := IgnitionContent{Config: ignitionContent, ExtraFiles: extraFiles}
+
+		first, err := content.Archive()
+		Expect(err).NotTo(HaveOccurred())
+		firstBytes, err := io.ReadAll(first)
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 5; i++ {
+			next, err := content.Archive()
+			Expect(err).NotTo(HaveOccurred())
+			nextBytes, err := io.ReadAll(next)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bytes.Equal(firstBytes, nextBytes)).To(BeTrue())
+		}
+	})
+
+	readConfig := func(archive io.Reader) []byte {
+		cpioReader := cpio.NewReader(archive)
+		hdr, err := cpioReader.Next()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hdr.Name).To(Equal("config.ign"))
+		config, err := io.ReadAll(cpioReader)
+		Expect(err).NotTo(HaveOccurred())
+		return config
+	}
+
+	It("creates an uncompressed CPIO archive when compression is disabled", func() {
+		content := IgnitionContent{Config: ignitionContent}
+
+		data, err := content.Archive(WithCompression(false))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data.Size() % 4).To(BeZero())
+		Expect(readConfig(data)).To(Equal(ignitionContent))
+	})
+
+	It("applies the archive options set on the content", func() {
+		content := IgnitionContent{Config: ignitionContent, ArchiveOptions: []ArchiveOption{WithCompression(false)}}
+
+		data, err := content.Archive()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(readConfig(data)).To(Equal(ignitionContent))
+	})
+
+	It("produces smaller archives for compressible content when compressed", func() {
+		content := IgnitionContent{Config: bytes.Repeat([]byte(`{"ignition": {"version": "3.1.0"}}`), 1000)}
+
+		uncompressed, err := content.Archive(WithCompression(false))
+		Expect(err).NotTo(HaveOccurred())
+		storedOnly, err := content.Archive(WithGzipLevel(gzip.NoCompression))
+		Expect(err).NotTo(HaveOccurred())
+		compressed, err := content.Archive(WithGzipLevel(gzip.BestCompression))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(compressed.Size()).To(BeNumerically("<", uncompressed.Size()))
+		Expect(compressed.Size()).To(BeNumerically("<", storedOnly.Size()))
+
+		for _, archive := range []*bytes.Reader{storedOnly, compressed} {
+			gzipReader, err := gzip.NewReader(archive)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readConfig(gzipReader)).To(Equal(content.Config))
+		}
+		Expect(readConfig(uncompressed)).To(Equal(content.Config))
+	})
+
+	It("fails for an invalid gzip level", func() {
+		content := IgnitionContent{Config: ignitionContent}
+
+		_, err := content.Archive(WithGzipLevel(42))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails when an extra file conflicts with the ignition config", func() {
+		content := IgnitionContent{
+			Config:     ignitionContent,
+			ExtraFiles: map[string][]byte{"config.ign": []byte("other")},
+		}
+
+		_, err := content.Archive()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("names the ignition config as configured", func() {
+		content := IgnitionContent{
+			Config:         ignitionContent,
+			ExtraFiles:     map[string][]byte{"config.ign": []byte("other")},
+			ArchiveOptions: []ArchiveOption{WithConfigFileName("usr/lib/ignition/custom.ign")},
+		}
+
+		data, err := content.Archive()
+		Expect(err).NotTo(HaveOccurred())
+		gzipReader, err := gzip.NewReader(data)
+		Expect(err).NotTo(HaveOccurred())
+		cpioReader := cpio.NewReader(gzipReader)
+		hdr, err := cpioReader.Next()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hdr.Name).To(Equal("usr/lib/ignition/custom.ign"))
+		Expect(io.ReadAll(cpioReader)).To(Equal(ignitionContent))
+	})
+
+	It("fails when an extra file conflicts with the configured ignition config name", func() {
+		content := IgnitionContent{
+			Config:     ignitionContent,
+			ExtraFiles: map[string][]byte{"custom.ign": []byte("other")},
+		}
+
+		_, err := content.Archive(WithConfigFileName("custom.ign"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	DescribeTable("rejects invalid ignition config names",
+		func(name string) {
+			content := IgnitionContent{Config: ignitionContent}
+			_, err := content.Archive(WithConfigFileName(name))
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("empty", ""),
+		Entry("absolute", "/config.ign"),
+		Entry("outside the archive", "../config.ign"),
+		Entry("parent", ".."),
+		Entry("not clean", "ignition//config.ign"),
+		Entry("directory", "ignition/"),
+		Entry("NUL", "config\x00.ign"),
+	)
+})
+
+var _ = Describe("IgnitionContent.Empty", func() {
+	It("is empty without a config or extra files", func() {
+		Expect((&IgnitionContent{}).Empty()).To(BeTrue())
+		Expect((&IgnitionContent{ArchiveOptions: []ArchiveOption{WithCompression(false)}}).Empty()).To(BeTrue())
+	})
+
+	It("isn't empty with a config", func() {
+		Expect((&IgnitionContent{Config: []byte("{}")}).Empty()).To(BeFalse())
+	})
+
+	It("isn't empty with extra files", func() {
+		Expect((&IgnitionContent{ExtraFiles: map[string][]byte{"file": []byte("content")}}).Empty()).To(BeFalse())
+		Expect((&IgnitionContent{ArchiveOptions: []ArchiveOption{WithExtraFile("file", []byte("content"))}}).Empty()).To(BeFalse())
+	})
 })
@@ -29,6 +29,25 @@ func NewInitrdAddrsizeReaderFromISO(isoPath string, initrdFile overlay.OverlayRe
 }
 
 func NewInitrdAddrsizeReaderFromStream(irfsReader io.ReadSeekCloser, initrdFile overlay.OverlayReader) (*bytes.Reader, error) {
+	initrdPSW := make([]byte, 8)
+	m, err := irfsReader.Read(initrdPSW)
+	if err != nil || m != 8 {
+		return nil, fmt.Errorf("failed to read initrd.addrsize: %v", err)
+	}
+
+	return initrdAddrsizeReader(initrdPSW, initrdFile)
+}
+
+// NewInitrdAddrsizeReaderWithAddress returns the initrd.addrsize loading initrdFile at address,
+// instead of the address of the base initrd.addrsize
+func NewInitrdAddrsizeReaderWithAddress(address uint64, initrdFile overlay.OverlayReader) (*bytes.Reader, error) {
+	initrdPSW := make([]byte, 8)
+	binary.BigEndian.PutUint64(initrdPSW, address)
+	return initrdAddrsizeReader(initrdPSW, initrdFile)
+}
+
+// initrdAddrsizeReader returns the initrd.addrsize made of the 8 bytes initrdPSW followed by the size of initrdFile
+func initrdAddrsizeReader(initrdPSW []byte, initrdFile overlay.OverlayReader) (*bytes.Reader, error) {
 	// get the size of the initrd including the embedded ignition
 	sizeOfInitrd, err := initrdFile.Seek(0, io.SeekEnd)
 	if err != nil {
@@ -40,11 +59,6 @@ func NewInitrdAddrsizeReaderFromStream(irfsReader io.ReadSeekCloser, initrdFile
 	if err != nil {
 		return nil, fmt.Errorf("error during write buffer: %v", err)
 	}
-	initrdPSW := make([]byte, 8)
-	m, err := irfsReader.Read(initrdPSW)
-	if err != nil || m != 8 {
-		return nil, fmt.Errorf("failed to read initrd.addrsize: %v", err)
-	}
 
 	return bytes.NewReader(append(initrdPSW, addrsizeBytes.Bytes()...)), nil
 }
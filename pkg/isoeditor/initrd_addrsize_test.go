@@ -2,12 +2,24 @@ package isoeditor
 
 import (
 	"bytes"
+	"encoding/binary"
+	"io"
 	"path/filepath"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+
+	"github.com/openshift/assisted-image-service/pkg/overlay"
 )
 
+// nopCloser adds a no-op Close to a ReadSeeker
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
 var _ = Describe("NewInitrdAddrsizeReader", func() {
 	var (
 		ignitionContent = []byte("someignitioncontent")
@@ -19,7 +31,7 @@ var _ = Describe("NewInitrdAddrsizeReader", func() {
 	initrdPath := filepath.Join(filesDir, "images/ignition.img")
 	addrsizePath := filepath.Join(filesDir, "images/initrd.addrsize")
 	It("Get initrd.addrsize file", func() {
-		streamReader, err := NewInitRamFSStreamReader(initrdPath, &IgnitionContent{ignitionContent})
+		streamReader, err := NewInitRamFSStreamReader(initrdPath, &IgnitionContent{Config: ignitionContent})
 		Expect(err).NotTo(HaveOccurred())
 
 		addrsizeFile, err := NewInitrdAddrsizeReader(addrsizePath, streamReader)
@@ -30,4 +42,45 @@ var _ = Describe("NewInitrdAddrsizeReader", func() {
 		Expect(initrdAddrsize).To(Equal(buf.Bytes()))
 
 	})
+
+	It("Get initrd.addrsize file loading the initrd at an address", func() {
+		streamReader, err := NewInitRamFSStreamReader(initrdPath, &IgnitionContent{Config: ignitionContent})
+		Expect(err).NotTo(HaveOccurred())
+
+		addrsizeFile, err := NewInitrdAddrsizeReaderWithAddress(0x04000000, streamReader)
+		Expect(err).NotTo(HaveOccurred())
+		addrsize, err := io.ReadAll(addrsizeFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(addrsize).To(Equal(append([]byte{0, 0, 0, 0, 4, 0, 0, 0}, initrdAddrsize[8:]...)))
+	})
 })
+
+var _ = DescribeTable("NewInitrdAddrsizeReaderFromStream with an appended ram disk",
+	func(compression string) {
+		opts, err := ParseCompression(compression)
+		Expect(err).NotTo(HaveOccurred())
+		config := archiveConfig{}
+		for _, opt := range opts {
+			opt(&config)
+		}
+		ramDisk, err := generateCPIOArchive([]cpioFile{{Path: NmstatectlPathInRamdisk, Content: bytes.Repeat([]byte("nmstatectl"), 10000), Mode: 0o100_755}}, config.compress, config.gzipLevel)
+		Expect(err).NotTo(HaveOccurred())
+
+		initrd := bytes.Repeat([]byte{1}, 4096)
+		initrdReader, err := overlay.NewAppendReader(bytes.NewReader(initrd), bytes.NewReader(ramDisk))
+		Expect(err).NotTo(HaveOccurred())
+
+		psw := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+		addrsizeFile, err := NewInitrdAddrsizeReaderFromStream(nopCloser{bytes.NewReader(append(psw, make([]byte, 8)...))}, initrdReader)
+		Expect(err).NotTo(HaveOccurred())
+		addrsize, err := io.ReadAll(addrsizeFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(addrsize[:8]).To(Equal(psw))
+		Expect(binary.BigEndian.Uint64(addrsize[8:])).To(Equal(uint64(len(initrd) + len(ramDisk))))
+	},
+	Entry("gzip", "gzip"),
+	Entry("fastest gzip", "gzip-1"),
+	Entry("smallest gzip", "gzip-9"),
+	Entry("no compression", "none"),
+)
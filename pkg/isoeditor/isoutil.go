@@ -355,29 +355,48 @@ func GetISO9660FileSystem(d *disk.Disk) (filesystem.FileSystem, error) {
 	return iso9660.Read(d.File, d.Size, 0, 0)
 }
 
-func generateCompressedCPIO(fileContent []byte, filePath string, mode cpio.FileMode) ([]byte, error) {
-	// Run gzip compression
-	compressedBuffer := new(bytes.Buffer)
-	gzipWriter := gzip.NewWriter(compressedBuffer)
-	// Create CPIO archive
-	cpioWriter := cpio.NewWriter(gzipWriter)
+type cpioFile struct {
+	Path    string
+	Content []byte
+	Mode    cpio.FileMode
+}
 
-	if err := cpioWriter.WriteHeader(&cpio.Header{
-		Name: filePath,
-		Mode: mode,
-		Size: int64(len(fileContent)),
-	}); err != nil {
-		return nil, errors.Wrap(err, "Failed to write CPIO header")
+func generateCPIOArchive(files []cpioFile, compress bool, gzipLevel int) ([]byte, error) {
+	compressedBuffer := new(bytes.Buffer)
+	var archiveWriter io.Writer = compressedBuffer
+	var gzipWriter *gzip.Writer
+	if compress {
+		// Run gzip compression
+		var err error
+		gzipWriter, err = gzip.NewWriterLevel(compressedBuffer, gzipLevel)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to create gzip writer")
+		}
+		archiveWriter = gzipWriter
 	}
-	if _, err := cpioWriter.Write(fileContent); err != nil {
-		return nil, errors.Wrap(err, "Failed to write CPIO archive")
+	// Create CPIO archive
+	cpioWriter := cpio.NewWriter(archiveWriter)
+
+	for _, file := range files {
+		if err := cpioWriter.WriteHeader(&cpio.Header{
+			Name: file.Path,
+			Mode: file.Mode,
+			Size: int64(len(file.Content)),
+		}); err != nil {
+			return nil, errors.Wrap(err, "Failed to write CPIO header")
+		}
+		if _, err := cpioWriter.Write(file.Content); err != nil {
+			return nil, errors.Wrap(err, "Failed to write CPIO archive")
+		}
 	}
 
 	if err := cpioWriter.Close(); err != nil {
 		return nil, errors.Wrap(err, "Failed to close CPIO archive")
 	}
-	if err := gzipWriter.Close(); err != nil {
-		return nil, errors.Wrap(err, "Failed to gzip ignition config")
+	if gzipWriter != nil {
+		if err := gzipWriter.Close(); err != nil {
+			return nil, errors.Wrap(err, "Failed to gzip ignition config")
+		}
 	}
 
 	padSize := (4 - (compressedBuffer.Len() % 4)) % 4
@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"regexp"
+	"strings"
 
 	"github.com/openshift/assisted-image-service/pkg/overlay"
 )
@@ -16,11 +18,81 @@ const (
 	defaultGrubFilePath     = "/EFI/redhat/grub.cfg"
 	defaultIsolinuxFilePath = "/isolinux/isolinux.cfg"
 	kargsConfigFilePath     = "/coreos/kargs.json"
+
+	// DefaultKargsEmbedAreaMarker ends the kernel arguments embed area of CoreOS ISOs
+	DefaultKargsEmbedAreaMarker = "COREOS_KARG_EMBED_AREA"
 )
 
 type FileReader func(isoPath, filePath string) ([]byte, error)
 
-func kargsFiles(isoPath string, fileReader FileReader) ([]string, error) {
+// ErrKargsEmbedAreaNotFound is returned for ISOs that have no room to embed kernel arguments,
+// such as older or non-standard images, wrapped in an error naming the marker searched for
+var ErrKargsEmbedAreaNotFound = errors.New("kernel arguments embed area not found")
+
+// ErrKargsTooLong is returned when kernel arguments don't fit in an ISO's embed area,
+// along with the *EmbedAreaTooSmallError reporting the sizes
+var ErrKargsTooLong = errors.New("kernel arguments exceed the embed area size")
+
+// defaultKargsEmbedArea matches the kernel arguments embed area ending with the default marker,
+// the padding before it
+var defaultKargsEmbedArea = kargsEmbedAreaRegexp(DefaultKargsEmbedAreaMarker)
+
+func kargsEmbedAreaRegexp(marker string) *regexp.Regexp {
+	return regexp.MustCompile(`(\n#*)# ` + regexp.QuoteMeta(marker))
+}
+
+// Kargs locates the files the kernel arguments of ISOs are embedded in and their embed areas.
+// A nil or zero Kargs discovers the files from the kargs.json of each ISO and finds the areas by
+// COREOS_KARG_EMBED_AREA.
+type Kargs struct {
+	files     func(isoPath string) []string
+	marker    string
+	embedArea *regexp.Regexp
+}
+
+// KargsOption configures where the kernel arguments of ISOs are embedded
+type KargsOption func(*Kargs)
+
+// WithKargsFiles makes the kernel arguments of ISOs be patched into the files returned by files
+// instead of the ones listed in their kargs.json, for custom ISOs that use nonstandard paths.
+// The files of ISOs for which it returns none are discovered from kargs.json.
+func WithKargsFiles(files func(isoPath string) []string) KargsOption {
+	return func(k *Kargs) {
+		k.files = files
+	}
+}
+
+// WithKargsEmbedAreaMarker makes the kernel arguments embed area be found by marker instead of
+// COREOS_KARG_EMBED_AREA, for CoreOS variants that mark it differently
+func WithKargsEmbedAreaMarker(marker string) KargsOption {
+	return func(k *Kargs) {
+		k.marker = marker
+	}
+}
+
+// NewKargs creates a Kargs locating the kernel arguments files and embed areas as configured by opts
+func NewKargs(opts ...KargsOption) (*Kargs, error) {
+	k := &Kargs{marker: DefaultKargsEmbedAreaMarker}
+	for _, opt := range opts {
+		opt(k)
+	}
+	if k.marker == "" || strings.ContainsAny(k.marker, " \t\r\n#") {
+		return nil, fmt.Errorf("invalid kernel arguments embed area marker %q: must be non-empty without whitespace or '#'", k.marker)
+	}
+	k.embedArea = kargsEmbedAreaRegexp(k.marker)
+	return k, nil
+}
+
+// defaultKargs is used by the package functions that don't take a Kargs
+var defaultKargs = &Kargs{}
+
+func (k *Kargs) kargsFiles(isoPath string, fileReader FileReader) ([]string, error) {
+	if k != nil && k.files != nil {
+		if files := k.files(isoPath); len(files) > 0 {
+			return files, nil
+		}
+	}
+
 	kargsData, err := fileReader(isoPath, kargsConfigFilePath)
 	if err != nil {
 		// If the kargs file is not found, it is probably iso for old iso version which the file does not exist.  Therefore,
@@ -44,17 +116,171 @@ func kargsFiles(isoPath string, fileReader FileReader) ([]string, error) {
 	return ret, nil
 }
 
+// KargsFiles returns the files the kernel arguments of the ISO at isoPath are embedded in, as
+// listed in its kargs.json
 func KargsFiles(isoPath string) ([]string, error) {
-	return kargsFiles(isoPath, ReadFileFromISO)
+	return defaultKargs.Files(isoPath)
+}
+
+// Files returns the files the kernel arguments of the ISO at isoPath are embedded in
+func (k *Kargs) Files(isoPath string) ([]string, error) {
+	return k.kargsFiles(isoPath, ReadFileFromISO)
 }
 
-func kargsFileData(isoPath string, file string, appendKargs []byte) (FileData, error) {
+// KargsFileInfo describes the kernel arguments embed area of a file of an ISO
+type KargsFileInfo struct {
+	Path string `json:"path"`
+	// bytes available for appended kernel arguments, 0 when the file has no embed area
+	EmbedAreaSize int64 `json:"embed_area_size"`
+}
+
+// KargsInfo describes where the kernel arguments of an ISO are embedded
+type KargsInfo struct {
+	// kernel arguments the ISO boots with, from kargs.json
+	DefaultKargs string          `json:"default_kargs"`
+	Files        []KargsFileInfo `json:"files"`
+}
+
+// GetKargsInfo returns the kernel arguments files of an ISO with the size of their embed areas
+func GetKargsInfo(isoPath string) (*KargsInfo, error) {
+	return defaultKargs.Info(isoPath)
+}
+
+// Info returns the kernel arguments files of an ISO with the size of their embed areas
+func (k *Kargs) Info(isoPath string) (*KargsInfo, error) {
+	return k.kargsInfo(isoPath, ReadFileFromISO, GetISOFileInfo)
+}
+
+func (k *Kargs) kargsInfo(isoPath string, fileReader FileReader, fileBoundariesFinder BoundariesFinder) (*KargsInfo, error) {
+	info := &KargsInfo{Files: []KargsFileInfo{}}
+	// older ISOs have no kargs.json, and so no default kernel arguments to report
+	if kargsData, err := fileReader(isoPath, kargsConfigFilePath); err == nil {
+		var kargsConfig struct {
+			Default string
+		}
+		if err := json.Unmarshal(kargsData, &kargsConfig); err != nil {
+			return nil, err
+		}
+		info.DefaultKargs = kargsConfig.Default
+	}
+
+	files, err := k.kargsFiles(isoPath, fileReader)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		_, length, err := k.kargsEmbedAreaBoundariesFinder(isoPath, file, fileBoundariesFinder, fileReader)
+		if err != nil && !errors.Is(err, ErrKargsEmbedAreaNotFound) {
+			return nil, fmt.Errorf("failed to find the kernel arguments embed area in file \"%s\": %w", file, err)
+		}
+		info.Files = append(info.Files, KargsFileInfo{Path: file, EmbedAreaSize: length})
+	}
+	return info, nil
+}
+
+// Names of the boot configuration files BootConfig finds among the kernel arguments files
+const (
+	GrubConfigFileName     = "grub.cfg"
+	IsolinuxConfigFileName = "isolinux.cfg"
+)
+
+// ErrBootConfigNotFound is returned for ISOs that don't have the requested boot configuration file
+var ErrBootConfigNotFound = errors.New("boot configuration file not found")
+
+// BootConfig returns the path and content of the boot configuration file of the ISO named fileName,
+// such as grub.cfg, as found among the files its kernel arguments are embedded in
+func BootConfig(isoPath, fileName string) (string, []byte, error) {
+	return defaultKargs.BootConfig(isoPath, fileName)
+}
+
+// BootConfig returns the boot configuration file of the ISO named fileName among its kernel arguments files
+func (k *Kargs) BootConfig(isoPath, fileName string) (string, []byte, error) {
+	return k.bootConfig(isoPath, fileName, ReadFileFromISO)
+}
+
+func (k *Kargs) bootConfig(isoPath, fileName string, fileReader FileReader) (string, []byte, error) {
+	files, err := k.kargsFiles(isoPath, fileReader)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, file := range files {
+		if path.Base(file) != fileName {
+			continue
+		}
+		config, err := fileReader(isoPath, file)
+		if err != nil {
+			// older ISOs list the default files, which may not all exist
+			return "", nil, fmt.Errorf("%w: failed to read %s: %v", ErrBootConfigNotFound, file, err)
+		}
+		return file, config, nil
+	}
+	return "", nil, fmt.Errorf("%w: no %s among %s", ErrBootConfigNotFound, fileName, strings.Join(files, ", "))
+}
+
+var (
+	grubLinuxRegexp      = regexp.MustCompile(`(?m)^[ \t]*linux[ \t]+\S+(.*)$`)
+	isolinuxAppendRegexp = regexp.MustCompile(`(?m)^[ \t]*append[ \t]+(.*)$`)
+)
+
+// KernelCmdline returns the kernel command line the ISO boots with, as found in the
+// first of its kernel arguments files that contains one. When rootFSURL is set the
+// rootfs URL argument used by minimal ISOs is appended.
+func KernelCmdline(isoPath, rootFSURL string) (string, error) {
+	return defaultKargs.KernelCmdline(isoPath, rootFSURL)
+}
+
+// KernelCmdline returns the kernel command line the ISO boots with, found among its kernel arguments files
+func (k *Kargs) KernelCmdline(isoPath, rootFSURL string) (string, error) {
+	return k.kernelCmdline(isoPath, rootFSURL, ReadFileFromISO)
+}
+
+func (k *Kargs) kernelCmdline(isoPath, rootFSURL string, fileReader FileReader) (string, error) {
+	files, err := k.kargsFiles(isoPath, fileReader)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		config, err := fileReader(isoPath, file)
+		if err != nil {
+			return "", err
+		}
+		args, found := cmdlineFromConfig(string(config))
+		if !found {
+			continue
+		}
+		if rootFSURL != "" {
+			args = append(args, fmt.Sprintf("coreos.live.rootfs_url=%s", rootFSURL))
+		}
+		return strings.Join(args, " "), nil
+	}
+	return "", fmt.Errorf("no kernel command line found in %s", strings.Join(files, ", "))
+}
+
+// cmdlineFromConfig extracts the kernel arguments from a grub linux line or an isolinux append line
+func cmdlineFromConfig(config string) ([]string, bool) {
+	if match := grubLinuxRegexp.FindStringSubmatch(config); match != nil {
+		return strings.Fields(match[1]), true
+	}
+	if match := isolinuxAppendRegexp.FindStringSubmatch(config); match != nil {
+		var args []string
+		for _, arg := range strings.Fields(match[1]) {
+			// the initrds are loaded by isolinux rather than passed to the kernel
+			if !strings.HasPrefix(arg, "initrd=") {
+				args = append(args, arg)
+			}
+		}
+		return args, true
+	}
+	return nil, false
+}
+
+func (k *Kargs) kargsFileData(isoPath string, file string, appendKargs []byte) (FileData, error) {
 	baseISO, err := os.Open(isoPath)
 	if err != nil {
 		return FileData{}, err
 	}
 
-	iso, err := readerForKargsContent(isoPath, file, baseISO, bytes.NewReader(appendKargs))
+	iso, err := k.readerForKargsContent(isoPath, file, baseISO, bytes.NewReader(appendKargs))
 	if err != nil {
 		baseISO.Close()
 		return FileData{}, err
@@ -73,6 +299,12 @@ func kargsFileData(isoPath string, file string, appendKargs []byte) (FileData, e
 // the file(s) containing the kernel arguments, with additional arguments
 // appended.
 func NewKargsReader(isoPath string, appendKargs string) ([]FileData, error) {
+	return defaultKargs.NewReader(isoPath, appendKargs)
+}
+
+// NewReader returns the filename within an ISO and the new content of the file(s) containing
+// the kernel arguments, with additional arguments appended
+func (k *Kargs) NewReader(isoPath string, appendKargs string) ([]FileData, error) {
 	if appendKargs == "" || appendKargs == "\n" {
 		return nil, nil
 	}
@@ -81,14 +313,14 @@ func NewKargsReader(isoPath string, appendKargs string) ([]FileData, error) {
 		appendData = append(appendData, '\n')
 	}
 
-	files, err := KargsFiles(isoPath)
+	files, err := k.Files(isoPath)
 	if err != nil {
 		return nil, err
 	}
 
 	output := []FileData{}
 	for i, f := range files {
-		data, err := kargsFileData(isoPath, f, appendData)
+		data, err := k.kargsFileData(isoPath, f, appendData)
 		if err != nil {
 			for _, fd := range output[:i] {
 				fd.Data.Close()
@@ -101,7 +333,16 @@ func NewKargsReader(isoPath string, appendKargs string) ([]FileData, error) {
 	return output, nil
 }
 
-func kargsEmbedAreaBoundariesFinder(isoPath, filePath string, fileBoundariesFinder BoundariesFinder, fileReader FileReader) (int64, int64, error) {
+// embedAreaRegexp returns the regexp matching the embed area of the kernel arguments files and
+// the marker it ends with
+func (k *Kargs) embedAreaRegexp() (*regexp.Regexp, string) {
+	if k == nil || k.embedArea == nil {
+		return defaultKargsEmbedArea, DefaultKargsEmbedAreaMarker
+	}
+	return k.embedArea, k.marker
+}
+
+func (k *Kargs) kargsEmbedAreaBoundariesFinder(isoPath, filePath string, fileBoundariesFinder BoundariesFinder, fileReader FileReader) (int64, int64, error) {
 	start, _, err := fileBoundariesFinder(filePath, isoPath)
 	if err != nil {
 		return 0, 0, err
@@ -112,22 +353,27 @@ func kargsEmbedAreaBoundariesFinder(isoPath, filePath string, fileBoundariesFind
 		return 0, 0, err
 	}
 
-	re := regexp.MustCompile(`(\n#*)# COREOS_KARG_EMBED_AREA`)
-	submatchIndexes := re.FindSubmatchIndex(b)
-	if len(submatchIndexes) != 4 {
-		return 0, 0, errors.New("failed to find COREOS_KARG_EMBED_AREA")
+	re, marker := k.embedAreaRegexp()
+	matches := re.FindAllSubmatchIndex(b, -1)
+	switch {
+	case len(matches) == 0:
+		return 0, 0, fmt.Errorf("failed to find %s: %w", marker, ErrKargsEmbedAreaNotFound)
+	case len(matches) > 1:
+		// the arguments would only be embedded in the first area
+		return 0, 0, fmt.Errorf("found %d kernel arguments embed areas, expected one", len(matches))
 	}
+	submatchIndexes := matches[0]
 	return start + int64(submatchIndexes[2]), int64(submatchIndexes[3] - submatchIndexes[2]), nil
 }
 
-func createKargsEmbedAreaBoundariesFinder() BoundariesFinder {
+func (k *Kargs) createKargsEmbedAreaBoundariesFinder() BoundariesFinder {
 	return func(filePath, isoPath string) (int64, int64, error) {
-		return kargsEmbedAreaBoundariesFinder(isoPath, filePath, GetISOFileInfo, ReadFileFromISO)
+		return k.kargsEmbedAreaBoundariesFinder(isoPath, filePath, GetISOFileInfo, ReadFileFromISO)
 	}
 }
 
-func readerForKargsContent(isoPath string, filePath string, base io.ReadSeeker, contentReader *bytes.Reader) (overlay.OverlayReader, error) {
-	return readerForContent(isoPath, filePath, base, contentReader, createKargsEmbedAreaBoundariesFinder())
+func (k *Kargs) readerForKargsContent(isoPath string, filePath string, base io.ReadSeeker, contentReader *bytes.Reader) (overlay.OverlayReader, error) {
+	return readerForContent(isoPath, filePath, base, contentReader, k.createKargsEmbedAreaBoundariesFinder())
 }
 
 type kernelArgument struct {
@@ -161,17 +407,44 @@ func KargsToStr(args []string) (string, error) {
 	return string(b), nil
 }
 
+// UnsupportedKargsOperationError is returned for a kernel argument with an operation other than append
+type UnsupportedKargsOperationError struct {
+	Operation string
+	Value     string
+}
+
+func (e *UnsupportedKargsOperationError) Error() string {
+	return fmt.Sprintf("only 'append' operation is allowed.  got %s for %q", e.Operation, e.Value)
+}
+
+// StrToKargs returns the kernel arguments appended by kargsStr, failing with an
+// *UnsupportedKargsOperationError when another operation is used
 func StrToKargs(kargsStr string) ([]string, error) {
+	args, unsupported, err := StrToAppendKargs(kargsStr)
+	if err != nil {
+		return nil, err
+	}
+	if len(unsupported) > 0 {
+		return nil, unsupported[0]
+	}
+	return args, nil
+}
+
+// StrToAppendKargs returns the kernel arguments appended by kargsStr, skipping the arguments
+// with other operations, which are returned as unsupported
+func StrToAppendKargs(kargsStr string) ([]string, []*UnsupportedKargsOperationError, error) {
 	var kargs kernelArguments
 	if err := json.Unmarshal([]byte(kargsStr), &kargs); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal kernel arguments %v", err)
+		return nil, nil, fmt.Errorf("failed to unmarshal kernel arguments %v", err)
 	}
 	var args []string
+	var unsupported []*UnsupportedKargsOperationError
 	for _, arg := range kargs {
 		if arg.Operation != "append" {
-			return nil, fmt.Errorf("only 'append' operation is allowed.  got %s", arg.Operation)
+			unsupported = append(unsupported, &UnsupportedKargsOperationError{Operation: arg.Operation, Value: arg.Value})
+			continue
 		}
 		args = append(args, arg.Value)
 	}
-	return args, nil
+	return args, unsupported, nil
 }
@@ -105,51 +105,305 @@ menuentry 'Fedora CoreOS (Live)' --class fedora --class gnu-linux --class gnu --
 
 	Describe("kargsFiles", func() {
 		It("fails to read kargs file", func() {
-			files, err := kargsFiles("isoPath", mockFileReaderFailure())
+			files, err := defaultKargs.kargsFiles("isoPath", mockFileReaderFailure())
 			Expect(err).ToNot(HaveOccurred())
 			Expect(files).To(Equal([]string{defaultGrubFilePath, defaultIsolinuxFilePath}))
 		})
 		It("kargs file is malformed", func() {
-			files, err := kargsFiles("isoPath", mockFileReaderSuccess("malformedData"))
+			files, err := defaultKargs.kargsFiles("isoPath", mockFileReaderSuccess("malformedData"))
 			Expect(err).To(HaveOccurred())
 			Expect(files).To(BeNil())
 		})
 		It("empty kargs file", func() {
 			fileData := `{"files": []}`
-			files, err := kargsFiles("isoPath", mockFileReaderSuccess(fileData))
+			files, err := defaultKargs.kargsFiles("isoPath", mockFileReaderSuccess(fileData))
 			Expect(err).ToNot(HaveOccurred())
 			Expect(files).To(HaveLen(0))
 		})
 		It("non empty kargs file", func() {
-			files, err := kargsFiles("isoPath", mockFileReaderSuccess(kargsConfileFile))
+			files, err := defaultKargs.kargsFiles("isoPath", mockFileReaderSuccess(kargsConfileFile))
 			Expect(err).ToNot(HaveOccurred())
 			Expect(files).To(Equal([]string{"EFI/fedora/grub.cfg", "isolinux/isolinux.cfg"}))
 		})
 		It("works with centos", func() {
-			files, err := kargsFiles("isoPath", mockFileReaderSuccess(kargsCentOSConfileFile))
+			files, err := defaultKargs.kargsFiles("isoPath", mockFileReaderSuccess(kargsCentOSConfileFile))
 			Expect(err).ToNot(HaveOccurred())
 			Expect(files).To(Equal([]string{"EFI/centos/grub.cfg", "isolinux/isolinux.cfg"}))
 		})
+		Context("with configured files", func() {
+			configured := func(isoPath string) []string {
+				if isoPath == "isoPath" {
+					return []string{"/EFI/custom/grub.cfg", "/isolinux/custom.cfg"}
+				}
+				return nil
+			}
+			var kargs *Kargs
+
+			BeforeEach(func() {
+				var err error
+				kargs, err = NewKargs(WithKargsFiles(configured))
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("uses the configured files instead of kargs.json", func() {
+				files, err := kargs.kargsFiles("isoPath", mockFileReaderSuccess(kargsConfileFile))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(files).To(Equal([]string{"/EFI/custom/grub.cfg", "/isolinux/custom.cfg"}))
+			})
+			It("uses the configured files instead of the defaults", func() {
+				files, err := kargs.kargsFiles("isoPath", mockFileReaderFailure())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(files).To(Equal([]string{"/EFI/custom/grub.cfg", "/isolinux/custom.cfg"}))
+			})
+			It("discovers the files of ISOs without configured files", func() {
+				files, err := kargs.kargsFiles("otherISOPath", mockFileReaderSuccess(kargsConfileFile))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(files).To(Equal([]string{"EFI/fedora/grub.cfg", "isolinux/isolinux.cfg"}))
+			})
+			It("discovers the files without Kargs", func() {
+				var none *Kargs
+				files, err := none.kargsFiles("isoPath", mockFileReaderSuccess(kargsConfileFile))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(files).To(Equal([]string{"EFI/fedora/grub.cfg", "isolinux/isolinux.cfg"}))
+			})
+		})
+	})
+	Describe("kernelCmdline", func() {
+		isolinuxFile := `
+label linux
+  menu label ^Fedora CoreOS (Live)
+  kernel /images/pxeboot/vmlinuz
+  append initrd=/images/pxeboot/initrd.img,/images/ignition.img mitigations=auto,nosmt ignition.firstboot
+`
+		fileReader := func(files map[string]string) FileReader {
+			return func(_, filePath string) ([]byte, error) {
+				if content, ok := files[filePath]; ok {
+					return []byte(content), nil
+				}
+				return nil, errors.New("file not found")
+			}
+		}
+
+		It("reads the grub linux line", func() {
+			cmdline, err := defaultKargs.kernelCmdline("isoPath", "", fileReader(map[string]string{
+				kargsConfigFilePath:   kargsConfileFile,
+				"EFI/fedora/grub.cfg": grubFileWithEmbedArea,
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cmdline).To(Equal("mitigations=auto,nosmt coreos.liveiso=fedora-coreos-35.20220103.3.0 ignition.firstboot ignition.platform.id=metal"))
+		})
+		It("appends the rootfs url", func() {
+			cmdline, err := defaultKargs.kernelCmdline("isoPath", "https://example.com/rootfs.img", fileReader(map[string]string{
+				defaultGrubFilePath: grubFileWithoutEmbedArea,
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cmdline).To(HaveSuffix(" ignition.platform.id=metal coreos.live.rootfs_url=https://example.com/rootfs.img"))
+		})
+		It("falls back to the isolinux append line without the initrds", func() {
+			cmdline, err := defaultKargs.kernelCmdline("isoPath", "", fileReader(map[string]string{
+				kargsConfigFilePath:     kargsConfileFile,
+				"EFI/fedora/grub.cfg":   "set timeout=5\n",
+				"isolinux/isolinux.cfg": isolinuxFile,
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cmdline).To(Equal("mitigations=auto,nosmt ignition.firstboot"))
+		})
+		It("fails when no file contains a command line", func() {
+			_, err := defaultKargs.kernelCmdline("isoPath", "", fileReader(map[string]string{
+				kargsConfigFilePath: `{"files": []}`,
+			}))
+			Expect(err).To(HaveOccurred())
+		})
+		It("fails when a kargs file can't be read", func() {
+			_, err := defaultKargs.kernelCmdline("isoPath", "", fileReader(map[string]string{
+				kargsConfigFilePath: kargsConfileFile,
+			}))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Describe("bootConfig", func() {
+		fileReader := func(files map[string]string) FileReader {
+			return func(_, filePath string) ([]byte, error) {
+				if content, ok := files[filePath]; ok {
+					return []byte(content), nil
+				}
+				return nil, errors.New("file not found")
+			}
+		}
+
+		It("reads the config among the kargs files", func() {
+			path, config, err := defaultKargs.bootConfig("isoPath", GrubConfigFileName, fileReader(map[string]string{
+				kargsConfigFilePath:   kargsConfileFile,
+				"EFI/fedora/grub.cfg": grubFileWithEmbedArea,
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(path).To(Equal("EFI/fedora/grub.cfg"))
+			Expect(string(config)).To(Equal(grubFileWithEmbedArea))
+		})
+		It("reads the default config without kargs.json", func() {
+			path, _, err := defaultKargs.bootConfig("isoPath", IsolinuxConfigFileName, fileReader(map[string]string{
+				defaultIsolinuxFilePath: "default vesamenu.c32\n",
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(path).To(Equal(defaultIsolinuxFilePath))
+		})
+		It("fails when the config isn't a kargs file", func() {
+			_, _, err := defaultKargs.bootConfig("isoPath", IsolinuxConfigFileName, fileReader(map[string]string{
+				kargsConfigFilePath: `{"files": [{"path": "EFI/redhat/grub.cfg"}]}`,
+			}))
+			Expect(err).To(MatchError(ErrBootConfigNotFound))
+		})
+		It("fails when the config is missing from the ISO", func() {
+			_, _, err := defaultKargs.bootConfig("isoPath", IsolinuxConfigFileName, fileReader(map[string]string{
+				defaultGrubFilePath: grubFileWithoutEmbedArea,
+			}))
+			Expect(err).To(MatchError(ErrBootConfigNotFound))
+		})
+	})
+	Describe("kargsInfo", func() {
+		fileReader := func(files map[string]string) FileReader {
+			return func(_, filePath string) ([]byte, error) {
+				if content, ok := files[filePath]; ok {
+					return []byte(content), nil
+				}
+				return nil, errors.New("file not found")
+			}
+		}
+
+		It("reports the default kargs and the embed area of each file", func() {
+			info, err := defaultKargs.kargsInfo("isoPath", fileReader(map[string]string{
+				kargsConfigFilePath:     kargsConfileFile,
+				"EFI/fedora/grub.cfg":   grubFileWithEmbedArea,
+				"isolinux/isolinux.cfg": grubFileWithEmbedArea,
+			}), mockBoundariesFinderSuccess(1000, int64(len(grubFileWithEmbedArea))))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.DefaultKargs).To(Equal("mitigations=auto,nosmt coreos.liveiso=fedora-coreos-35.20220103.3.0 ignition.firstboot ignition.platform.id=metal"))
+			Expect(info.Files).To(Equal([]KargsFileInfo{
+				{Path: "EFI/fedora/grub.cfg", EmbedAreaSize: 1024},
+				{Path: "isolinux/isolinux.cfg", EmbedAreaSize: 1024},
+			}))
+		})
+		It("reports files without an embed area with a zero size", func() {
+			info, err := defaultKargs.kargsInfo("isoPath", fileReader(map[string]string{
+				kargsConfigFilePath:     kargsConfileFile,
+				"EFI/fedora/grub.cfg":   grubFileWithEmbedArea,
+				"isolinux/isolinux.cfg": grubFileWithoutEmbedArea,
+			}), mockBoundariesFinderSuccess(1000, int64(len(grubFileWithEmbedArea))))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Files).To(Equal([]KargsFileInfo{
+				{Path: "EFI/fedora/grub.cfg", EmbedAreaSize: 1024},
+				{Path: "isolinux/isolinux.cfg", EmbedAreaSize: 0},
+			}))
+		})
+		It("reports no default kargs without kargs.json", func() {
+			info, err := defaultKargs.kargsInfo("isoPath", fileReader(map[string]string{
+				defaultGrubFilePath:     grubFileWithEmbedArea,
+				defaultIsolinuxFilePath: grubFileWithoutEmbedArea,
+			}), mockBoundariesFinderSuccess(1000, int64(len(grubFileWithEmbedArea))))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.DefaultKargs).To(BeEmpty())
+			Expect(info.Files).To(Equal([]KargsFileInfo{
+				{Path: defaultGrubFilePath, EmbedAreaSize: 1024},
+				{Path: defaultIsolinuxFilePath, EmbedAreaSize: 0},
+			}))
+		})
+		It("fails when a kargs file can't be read", func() {
+			_, err := defaultKargs.kargsInfo("isoPath", fileReader(map[string]string{
+				kargsConfigFilePath: kargsConfileFile,
+			}), mockBoundariesFinderSuccess(1000, 1000))
+			Expect(err).To(HaveOccurred())
+		})
 	})
 	Describe("kargsEmbedAreaBoundariesFinder", func() {
 		It("fail finding file boundaries", func() {
-			_, _, err := kargsEmbedAreaBoundariesFinder("isoPath", "filePath", mockBoundariesFinderFailure(), mockFileReaderSuccess(grubFileWithEmbedArea))
+			_, _, err := defaultKargs.kargsEmbedAreaBoundariesFinder("isoPath", "filePath", mockBoundariesFinderFailure(), mockFileReaderSuccess(grubFileWithEmbedArea))
 			Expect(err).To(HaveOccurred())
 		})
 		It("fail reading file", func() {
-			_, _, err := kargsEmbedAreaBoundariesFinder("isoPath", "filePath", mockBoundariesFinderSuccess(100, 100), mockFileReaderFailure())
+			_, _, err := defaultKargs.kargsEmbedAreaBoundariesFinder("isoPath", "filePath", mockBoundariesFinderSuccess(100, 100), mockFileReaderFailure())
 			Expect(err).To(HaveOccurred())
 		})
 		It("no embed area found", func() {
-			_, _, err := kargsEmbedAreaBoundariesFinder("isoPath", "filePath", mockBoundariesFinderSuccess(100, 100), mockFileReaderSuccess(grubFileWithoutEmbedArea))
+			_, _, err := defaultKargs.kargsEmbedAreaBoundariesFinder("isoPath", "filePath", mockBoundariesFinderSuccess(100, 100), mockFileReaderSuccess(grubFileWithoutEmbedArea))
 			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, ErrKargsEmbedAreaNotFound)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("failed to find COREOS_KARG_EMBED_AREA")))
 		})
 		It("embed area found", func() {
-			start, length, err := kargsEmbedAreaBoundariesFinder("isoPath", "filePath", mockBoundariesFinderSuccess(1000, int64(len(grubFileWithEmbedArea))),
+			start, length, err := defaultKargs.kargsEmbedAreaBoundariesFinder("isoPath", "filePath", mockBoundariesFinderSuccess(1000, int64(len(grubFileWithEmbedArea))),
 				mockFileReaderSuccess(grubFileWithEmbedArea))
 			Expect(err).ToNot(HaveOccurred())
 			Expect(start).To(Equal(int64(1375)))
 			Expect(length).To(Equal(int64(1024)))
 		})
+		It("fails with several embed areas", func() {
+			config := "linux /vmlinuz\n#### COREOS_KARG_EMBED_AREA\nlinux /vmlinuz\n#### COREOS_KARG_EMBED_AREA\n"
+			_, _, err := defaultKargs.kargsEmbedAreaBoundariesFinder("isoPath", "filePath", mockBoundariesFinderSuccess(1000, int64(len(config))), mockFileReaderSuccess(config))
+			Expect(err).To(MatchError("found 2 kernel arguments embed areas, expected one"))
+		})
+
+		Context("with a custom marker", func() {
+			const customConfig = "linux /vmlinuz quiet\n######## CUSTOM_KARG_AREA\ninitrd /initrd.img\n"
+
+			var kargs *Kargs
+
+			BeforeEach(func() {
+				var err error
+				kargs, err = NewKargs(WithKargsEmbedAreaMarker("CUSTOM_KARG_AREA"))
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("finds the embed area ending with the marker", func() {
+				start, length, err := kargs.kargsEmbedAreaBoundariesFinder("isoPath", "filePath", mockBoundariesFinderSuccess(1000, int64(len(customConfig))),
+					mockFileReaderSuccess(customConfig))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(start).To(Equal(int64(1020)))
+				Expect(length).To(Equal(int64(8)))
+			})
+
+			It("doesn't find the default marker", func() {
+				_, _, err := kargs.kargsEmbedAreaBoundariesFinder("isoPath", "filePath", mockBoundariesFinderSuccess(1000, int64(len(grubFileWithEmbedArea))),
+					mockFileReaderSuccess(grubFileWithEmbedArea))
+				Expect(errors.Is(err, ErrKargsEmbedAreaNotFound)).To(BeTrue())
+				Expect(err).To(MatchError(ContainSubstring("failed to find CUSTOM_KARG_AREA")))
+			})
+		})
+
+		It("rejects invalid markers", func() {
+			for _, marker := range []string{"", "CUSTOM KARG AREA", "#CUSTOM", "CUSTOM\n"} {
+				_, err := NewKargs(WithKargsEmbedAreaMarker(marker))
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+})
+
+var _ = Describe("StrToKargs", func() {
+	const kargsStr = `[{"operation": "append", "value": "p1"}, {"operation": "replace", "value": "p2=v2"}, {"operation": "append", "value": "p3"}]`
+
+	It("returns the appended kernel arguments", func() {
+		args, err := StrToKargs(`[{"operation": "append", "value": "p1"}, {"operation": "append", "value": "p2=v2"}]`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(args).To(Equal([]string{"p1", "p2=v2"}))
+	})
+
+	It("fails for other operations naming the operation", func() {
+		_, err := StrToKargs(kargsStr)
+		var unsupported *UnsupportedKargsOperationError
+		Expect(errors.As(err, &unsupported)).To(BeTrue())
+		Expect(unsupported.Operation).To(Equal("replace"))
+		Expect(unsupported.Value).To(Equal("p2=v2"))
+	})
+
+	It("skips other operations with StrToAppendKargs", func() {
+		args, unsupported, err := StrToAppendKargs(kargsStr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(args).To(Equal([]string{"p1", "p3"}))
+		Expect(unsupported).To(Equal([]*UnsupportedKargsOperationError{{Operation: "replace", Value: "p2=v2"}}))
+	})
+
+	It("fails for invalid JSON", func() {
+		_, _, err := StrToAppendKargs(`[{"operation": `)
+		Expect(err).To(HaveOccurred())
 	})
 })
@@ -0,0 +1,185 @@
+package isoeditor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// BaseFileOpener opens the base ISO an image stream is read from
+type BaseFileOpener func(isoPath string) (ImageReader, error)
+
+// openBaseFile opens the base ISO from the filesystem for each stream
+func openBaseFile(isoPath string) (ImageReader, error) {
+	return os.Open(isoPath)
+}
+
+// errMappedFilesClosed is returned when opening a file after its mappings were released
+var errMappedFilesClosed = errors.New("mapped files are closed")
+
+// MappedFiles keeps the base ISOs that streams are read from mapped in memory, so reading them
+// doesn't take a system call per read. A file replaced, by renaming a new version into place,
+// or removed since it was mapped is mapped again or dropped on the next open. The mapping of a
+// file that is no longer current is released once the last stream reading it is closed.
+// Files must not be truncated or rewritten in place while they are mapped.
+type MappedFiles struct {
+	lock   sync.Mutex
+	files  map[string]*mappedFile
+	closed bool
+}
+
+type mappedFile struct {
+	info os.FileInfo
+	data []byte
+	// streams reading the mapping
+	refs int
+	// the file is no longer current, the mapping is released with its last stream
+	stale bool
+}
+
+// NewMappedFiles creates an empty set of mapped files
+func NewMappedFiles() *MappedFiles {
+	return &MappedFiles{files: map[string]*mappedFile{}}
+}
+
+// Open returns a reader of the current content of the file at path, mapping it when it isn't
+// mapped yet or was replaced since it was mapped
+func (m *MappedFiles) Open(path string) (ImageReader, error) {
+	info, err := os.Stat(path)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return nil, errMappedFilesClosed
+	}
+
+	file := m.files[path]
+	if file != nil && (err != nil || !sameFile(file.info, info)) {
+		log.Infof("Dropping the mapping of %s, the file was replaced or removed", path)
+		m.drop(path, file)
+		file = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		if file, err = mapFile(path); err != nil {
+			return nil, err
+		}
+		m.files[path] = file
+	}
+
+	file.refs++
+	return &mappedReader{Reader: bytes.NewReader(file.data), files: m, file: file}, nil
+}
+
+// Prune drops the mappings of the files replaced or removed since they were mapped, without
+// waiting for them to be opened again
+func (m *MappedFiles) Prune() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for path, file := range m.files {
+		if info, err := os.Stat(path); err != nil || !sameFile(file.info, info) {
+			log.Infof("Dropping the mapping of %s, the file was replaced or removed", path)
+			m.drop(path, file)
+		}
+	}
+}
+
+// Close releases the mappings, those still read by streams are released when the streams are
+// closed. Files can't be opened once it's called.
+func (m *MappedFiles) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.closed = true
+	var errs []error
+	for path, file := range m.files {
+		if err := m.drop(path, file); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// drop removes file from the current files and releases its mapping once nothing reads it,
+// called with the lock held
+func (m *MappedFiles) drop(path string, file *mappedFile) error {
+	delete(m.files, path)
+	file.stale = true
+	if file.refs > 0 {
+		return nil
+	}
+	return file.unmap()
+}
+
+// release is called when a stream reading file is closed
+func (m *MappedFiles) release(file *mappedFile) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	file.refs--
+	if file.refs > 0 || !file.stale {
+		return nil
+	}
+	return file.unmap()
+}
+
+// sameFile reports if info describes the same unchanged file as mapped
+func sameFile(mapped, info os.FileInfo) bool {
+	return os.SameFile(mapped, info) && mapped.Size() == info.Size() && mapped.ModTime().Equal(info.ModTime())
+}
+
+func mapFile(path string) (*mappedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// stat the open file so the mapping matches the file it was checked against
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	file := &mappedFile{info: info}
+	// empty files can't be mapped and have nothing to read anyway
+	if info.Size() == 0 {
+		return file, nil
+	}
+	file.data, err = unix.Mmap(int(f.Fd()), 0, int(info.Size()), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map %s: %w", path, err)
+	}
+	return file, nil
+}
+
+func (f *mappedFile) unmap() error {
+	if f.data == nil {
+		return nil
+	}
+	data := f.data
+	f.data = nil
+	return unix.Munmap(data)
+}
+
+// mappedReader reads a mapped file until it's closed
+type mappedReader struct {
+	*bytes.Reader
+	files  *MappedFiles
+	file   *mappedFile
+	closed bool
+}
+
+func (r *mappedReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	// the mapping may be released once closed, so reading it must fail instead
+	r.Reader = bytes.NewReader(nil)
+	return r.files.release(r.file)
+}
@@ -0,0 +1,183 @@
+package isoeditor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MappedFiles", func() {
+	var (
+		dir   string
+		path  string
+		files *MappedFiles
+	)
+
+	// replace renames a new file with content into place, as the image store does
+	replace := func(content string) {
+		tmp := filepath.Join(dir, "tmp")
+		Expect(os.WriteFile(tmp, []byte(content), 0600)).To(Succeed())
+		Expect(os.Rename(tmp, path)).To(Succeed())
+	}
+
+	read := func(r ImageReader) string {
+		_, err := r.Seek(0, io.SeekStart)
+		Expect(err).NotTo(HaveOccurred())
+		content, err := io.ReadAll(r)
+		Expect(err).NotTo(HaveOccurred())
+		return string(content)
+	}
+
+	open := func() ImageReader {
+		r, err := files.Open(path)
+		Expect(err).NotTo(HaveOccurred())
+		return r
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "mmap")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "rhcos-full-iso-4.8-48-x86_64.iso")
+		replace("original content")
+		files = NewMappedFiles()
+	})
+
+	AfterEach(func() {
+		Expect(files.Close()).To(Succeed())
+		os.RemoveAll(dir)
+	})
+
+	It("reads and seeks in the file", func() {
+		r := open()
+		defer r.Close()
+		Expect(r.Seek(0, io.SeekEnd)).To(Equal(int64(len("original content"))))
+		Expect(r.Seek(9, io.SeekStart)).To(Equal(int64(9)))
+		content, err := io.ReadAll(r)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("content"))
+	})
+
+	It("shares the mapping between streams", func() {
+		r1, r2 := open(), open()
+		Expect(read(r1)).To(Equal("original content"))
+		Expect(read(r2)).To(Equal("original content"))
+		Expect(files.files).To(HaveLen(1))
+		Expect(files.files[path].refs).To(Equal(2))
+		Expect(r1.Close()).To(Succeed())
+		Expect(r2.Close()).To(Succeed())
+		Expect(files.files[path].refs).To(Equal(0))
+		Expect(files.files[path].data).NotTo(BeNil())
+	})
+
+	It("reads the new content once the file is replaced", func() {
+		old := open()
+		mapping := files.files[path]
+
+		replace("replaced content!")
+		r := open()
+		defer r.Close()
+		Expect(read(r)).To(Equal("replaced content!"))
+
+		// streams started before the replacement keep reading the original content
+		Expect(read(old)).To(Equal("original content"))
+		Expect(mapping.data).NotTo(BeNil())
+		Expect(old.Close()).To(Succeed())
+		Expect(mapping.data).To(BeNil())
+	})
+
+	It("drops the mapping of a removed file", func() {
+		r := open()
+		Expect(r.Close()).To(Succeed())
+		mapping := files.files[path]
+
+		Expect(os.Remove(path)).To(Succeed())
+		_, err := files.Open(path)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		Expect(files.files).To(BeEmpty())
+		Expect(mapping.data).To(BeNil())
+	})
+
+	It("prunes the mappings of replaced files without opening them", func() {
+		r := open()
+		Expect(r.Close()).To(Succeed())
+		mapping := files.files[path]
+
+		replace("replaced content!")
+		files.Prune()
+		Expect(files.files).To(BeEmpty())
+		Expect(mapping.data).To(BeNil())
+	})
+
+	It("maps empty files", func() {
+		replace("")
+		r := open()
+		defer r.Close()
+		Expect(read(r)).To(BeEmpty())
+	})
+
+	It("releases the mappings read by streams once they are closed", func() {
+		r := open()
+		mapping := files.files[path]
+		Expect(files.Close()).To(Succeed())
+		Expect(mapping.data).NotTo(BeNil())
+		Expect(read(r)).To(Equal("original content"))
+		Expect(r.Close()).To(Succeed())
+		Expect(mapping.data).To(BeNil())
+
+		_, err := files.Open(path)
+		Expect(err).To(MatchError(errMappedFilesClosed))
+	})
+
+	It("streams images read from the mapping", func() {
+		filesDir, isoFile := createTestFiles("Assisted123")
+		defer os.RemoveAll(filesDir)
+		defer os.Remove(isoFile)
+		ignition := &IgnitionContent{Config: []byte("someignitioncontent")}
+
+		expected, err := NewRHCOSStreamReader(isoFile, ignition, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		defer expected.Close()
+		r, err := NewRHCOSStreamGenerator(files.Open)(isoFile, ignition, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		defer r.Close()
+		Expect(read(r)).To(Equal(read(expected)))
+	})
+})
+
+func BenchmarkBaseFileOpener(b *testing.B) {
+	f, err := os.CreateTemp("", "mmap_benchmark")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if err = f.Truncate(64 * 1024 * 1024); err != nil {
+		b.Fatal(err)
+	}
+	f.Close()
+
+	files := NewMappedFiles()
+	defer files.Close()
+	for name, open := range map[string]BaseFileOpener{"open": openBaseFile, "mmap": files.Open} {
+		b.Run(fmt.Sprintf("opener=%s", name), func(b *testing.B) {
+			buf := make([]byte, 32*1024)
+			b.SetBytes(64 * 1024 * 1024)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				r, err := open(f.Name())
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.CopyBuffer(io.Discard, struct{ io.Reader }{r}, buf); err != nil {
+					b.Fatal(err)
+				}
+				r.Close()
+			}
+		})
+	}
+}
@@ -33,6 +33,20 @@ func (m *MockEditor) EXPECT() *MockEditorMockRecorder {
 	return m.recorder
 }
 
+// CacheNmstateRamDisk mocks base method.
+func (m *MockEditor) CacheNmstateRamDisk(arg0, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CacheNmstateRamDisk", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CacheNmstateRamDisk indicates an expected call of CacheNmstateRamDisk.
+func (mr *MockEditorMockRecorder) CacheNmstateRamDisk(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CacheNmstateRamDisk", reflect.TypeOf((*MockEditor)(nil).CacheNmstateRamDisk), arg0, arg1)
+}
+
 // CreateMinimalISOTemplate mocks base method.
 func (m *MockEditor) CreateMinimalISOTemplate(arg0, arg1, arg2, arg3, arg4 string) error {
 	m.ctrl.T.Helper()
@@ -2,12 +2,17 @@ package isoeditor
 
 import (
 	"bytes"
+	"compress/gzip"
+	"debug/elf"
 	"fmt"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -15,22 +20,90 @@ import (
 
 //go:generate mockgen -package=isoeditor -destination=mock_nmstate_handler.go . NmstateHandler
 type NmstateHandler interface {
-	CreateNmstateRamDisk(rootfsPath, ramDiskPath string) error
+	CreateNmstateRamDisk(rootfsPath, ramDiskPath, arch string) error
+}
+
+// minNmstatectlSize is far below the size of any nmstatectl build, smaller files are the
+// result of a truncated or failed extraction
+const minNmstatectlSize = 256 * 1024
+
+// nmstatectlMachines maps the supported architectures to the ELF machine of their binaries
+var nmstatectlMachines = map[string]elf.Machine{
+	X86CPUArchitecture:     elf.EM_X86_64,
+	AMD64CPUArchitecture:   elf.EM_X86_64,
+	ARM64CPUArchitecture:   elf.EM_AARCH64,
+	AARCH64CPUArchitecture: elf.EM_AARCH64,
+	"ppc64le":              elf.EM_PPC64,
+	"s390x":                elf.EM_S390,
+}
+
+// NmstatectlSupported reports if nmstatectl can be extracted for images of arch
+func NmstatectlSupported(arch string) bool {
+	_, ok := nmstatectlMachines[arch]
+	return ok
 }
 
 type nmstateHandler struct {
 	workDir  string
 	executer Executer
+	// extraction uses a fixed directory under workDir, so only one may run at a time
+	lock sync.Mutex
+	// how the ram disk archive is compressed
+	compress  bool
+	gzipLevel int
+}
+
+// NmstateHandlerOption configures optional behavior of the nmstate handler
+type NmstateHandlerOption func(*nmstateHandler)
+
+// WithRamDiskCompression sets how the nmstate ram disk cpio archive is compressed, with the
+// compression options used for the ignition archive. Other archive options are ignored.
+func WithRamDiskCompression(opts ...ArchiveOption) NmstateHandlerOption {
+	return func(n *nmstateHandler) {
+		config := archiveConfig{compress: n.compress, gzipLevel: n.gzipLevel}
+		for _, opt := range opts {
+			opt(&config)
+		}
+		n.compress = config.compress
+		n.gzipLevel = config.gzipLevel
+	}
+}
+
+func NewNmstateHandler(workDir string, executer Executer, opts ...NmstateHandlerOption) NmstateHandler {
+	n := &nmstateHandler{
+		workDir:   workDir,
+		executer:  executer,
+		compress:  true,
+		gzipLevel: gzip.DefaultCompression,
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n
 }
 
-func NewNmstateHandler(workDir string, executer Executer) NmstateHandler {
-	return &nmstateHandler{
-		workDir:  workDir,
-		executer: executer,
+// ParseCompression parses a cpio archive compression setting: "gzip" for the default gzip
+// level, "gzip-1" to "gzip-9" for a given level, or "none"
+func ParseCompression(value string) ([]ArchiveOption, error) {
+	switch value {
+	case "none":
+		return []ArchiveOption{WithCompression(false)}, nil
+	case "gzip":
+		return []ArchiveOption{WithCompression(true), WithGzipLevel(gzip.DefaultCompression)}, nil
+	}
+	if levelStr, ok := strings.CutPrefix(value, "gzip-"); ok {
+		level, err := strconv.Atoi(levelStr)
+		if err == nil && level >= gzip.BestSpeed && level <= gzip.BestCompression {
+			return []ArchiveOption{WithCompression(true), WithGzipLevel(level)}, nil
+		}
 	}
+	return nil, fmt.Errorf("invalid compression %q, must be none, gzip, or gzip-1 to gzip-9", value)
 }
 
-func (n *nmstateHandler) CreateNmstateRamDisk(rootfsPath, ramDiskPath string) error {
+func (n *nmstateHandler) CreateNmstateRamDisk(rootfsPath, ramDiskPath, arch string) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
 	// Extract nmstatectl binary
 	var err error
 	nmstateDir := filepath.Join(n.workDir, "nmstate")
@@ -42,7 +115,7 @@ func (n *nmstateHandler) CreateNmstateRamDisk(rootfsPath, ramDiskPath string) er
 	if err != nil {
 		return err
 	}
-	nmstatectlPath := filepath.Join(nmstateDir, "squashfs-root", binaryPath)
+	nmstatectlPath := filepath.Join(nmstateDir, extractedRootDir, binaryPath)
 
 	// Check if nmstatectl binary file exists
 	if _, err = os.Stat(nmstatectlPath); os.IsNotExist(err) {
@@ -54,15 +127,18 @@ func (n *nmstateHandler) CreateNmstateRamDisk(rootfsPath, ramDiskPath string) er
 	if err != nil {
 		return err
 	}
+	if err = validateNmstatectl(nmstateBinContent, arch); err != nil {
+		return fmt.Errorf("invalid nmstatectl extracted from %s: %w", rootfsPath, err)
+	}
 
-	// Create a compressed RAM disk image with the nmstatectl binary
-	compressedCpio, err := generateCompressedCPIO(nmstateBinContent, NmstatectlPathInRamdisk, 0o100_755)
+	// Create a RAM disk image with the nmstatectl binary
+	ramDisk, err := generateCPIOArchive([]cpioFile{{Path: NmstatectlPathInRamdisk, Content: nmstateBinContent, Mode: 0o100_755}}, n.compress, n.gzipLevel)
 	if err != nil {
 		return err
 	}
 
 	// Write RAM disk file
-	err = os.WriteFile(ramDiskPath, compressedCpio, 0755) //nolint:gosec
+	err = os.WriteFile(ramDiskPath, ramDisk, 0755) //nolint:gosec
 	if err != nil {
 		return err
 	}
@@ -78,20 +154,83 @@ func (n *nmstateHandler) CreateNmstateRamDisk(rootfsPath, ramDiskPath string) er
 	return err
 }
 
-// TODO: Update the code to utilize go-diskfs's squashfs instead of unsquashfs once go-diskfs supports the zstd compression format used by CoreOS - MGMT-19227
+// validateNmstatectl checks that content is a plausible nmstatectl executable for arch, the
+// machine isn't checked for architectures without a known ELF machine
+func validateNmstatectl(content []byte, arch string) error {
+	if len(content) < minNmstatectlSize {
+		return fmt.Errorf("binary is %d bytes, expected at least %d", len(content), minNmstatectlSize)
+	}
+	f, err := elf.NewFile(bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("binary is not an ELF file: %w", err)
+	}
+	defer f.Close()
+	if f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN {
+		return fmt.Errorf("binary is not an executable: %s", f.Type)
+	}
+	if machine, ok := nmstatectlMachines[arch]; ok && f.Machine != machine {
+		return fmt.Errorf("binary is built for %s, expected %s for %s", f.Machine, machine, arch)
+	}
+	return nil
+}
+
+// extractedRootDir is the directory nmstatectl is extracted to under the nmstate work directory,
+// where unsquashfs extracts files by default
+const extractedRootDir = "squashfs-root"
+
+// nmstatectlExtractor extracts nmstatectl from the root image of a rootfs
+type nmstatectlExtractor interface {
+	// tool returns the command the extraction requires
+	tool() string
+	// extract extracts nmstatectl from the root image in nmstateDir to extractedRootDir and
+	// returns its path within the root image
+	extract(nmstateDir string) (string, error)
+}
+
+// newNmstatectlExtractor returns the extractor for root images of filesystem
+func (n *nmstateHandler) newNmstatectlExtractor(filesystem RootfsFilesystem) nmstatectlExtractor {
+	if filesystem == RootfsErofs {
+		return &erofsExtractor{executer: n.executer}
+	}
+	return &squashfsExtractor{executer: n.executer}
+}
+
 func (n *nmstateHandler) extractNmstatectl(rootfsPath, nmstateDir string) (string, error) {
 	_, err := n.executer.Execute(fmt.Sprintf("cat %s | cpio -i", rootfsPath), nmstateDir)
 	if err != nil {
 		log.Errorf("failed to extract rootfs.img using cpio command: %v", err.Error())
 		return "", err
 	}
+
+	filesystem, err := detectExtractedRootfsFilesystem(nmstateDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect the filesystem of %s: %w", rootfsPath, err)
+	}
+	extractor := n.newNmstatectlExtractor(filesystem)
+	if _, err = n.executer.Execute(fmt.Sprintf("command -v %s", extractor.tool()), nmstateDir); err != nil {
+		return "", fmt.Errorf("%s is required to extract nmstatectl from the %s rootfs %s but it isn't installed", extractor.tool(), filesystem, rootfsPath)
+	}
+	log.Infof("Extracting nmstatectl from the %s rootfs %s", filesystem, rootfsPath)
+	return extractor.extract(nmstateDir)
+}
+
+type squashfsExtractor struct {
+	executer Executer
+}
+
+func (e *squashfsExtractor) tool() string {
+	return "unsquashfs"
+}
+
+// TODO: Update the code to utilize go-diskfs's squashfs instead of unsquashfs once go-diskfs supports the zstd compression format used by CoreOS - MGMT-19227
+func (e *squashfsExtractor) extract(nmstateDir string) (string, error) {
 	// limiting files is needed on el<=9 due to https://github.com/plougher/squashfs-tools/issues/125
 	ulimit := "ulimit -n 1024"
 
 	// Listing the filesystem concisely, displaying only files (using `-lc` option).
 	// Each file in the output won't include any prefix before `/ostree` (by using `-dest ''` option),
 	// which is useful when invoking `-extract-file` (after finding the `nmstatectl` binary path).
-	list, err := n.executer.Execute(fmt.Sprintf("%s ; unsquashfs -dest '' -lc %s", ulimit, "root.squashfs"), nmstateDir)
+	list, err := e.executer.Execute(fmt.Sprintf("%s ; unsquashfs -dest '' -lc %s", ulimit, "root.squashfs"), nmstateDir)
 	if err != nil {
 		log.Errorf("failed to unsquashfs root.squashfs: %v", err.Error())
 		return "", err
@@ -104,7 +243,7 @@ func (n *nmstateHandler) extractNmstatectl(rootfsPath, nmstateDir string) (strin
 	}
 	binaryPath := r.FindString(list)
 
-	_, err = n.executer.Execute(fmt.Sprintf("%s ; unsquashfs -no-xattrs %s -extract-file %s", ulimit, "root.squashfs", binaryPath), nmstateDir)
+	_, err = e.executer.Execute(fmt.Sprintf("%s ; unsquashfs -no-xattrs %s -extract-file %s", ulimit, "root.squashfs", binaryPath), nmstateDir)
 	if err != nil {
 		log.Errorf("failed to unsquashfs root.squashfs: %v", err.Error())
 		return "", err
@@ -112,6 +251,65 @@ func (n *nmstateHandler) extractNmstatectl(rootfsPath, nmstateDir string) (strin
 	return binaryPath, nil
 }
 
+// ostreeDeploymentRegexp matches the directories of ostree deployments, named after their commit and serial
+var ostreeDeploymentRegexp = regexp.MustCompile(`^[0-9a-f]+\.[0-9]+$`)
+
+type erofsExtractor struct {
+	executer Executer
+}
+
+func (e *erofsExtractor) tool() string {
+	return "dump.erofs"
+}
+
+// extract looks up nmstatectl in the ostree deployments of the root image, as dump.erofs
+// can't list the image recursively, and copies it out with dump.erofs --cat
+func (e *erofsExtractor) extract(nmstateDir string) (string, error) {
+	stateroots, err := e.list(nmstateDir, "/ostree/deploy")
+	if err != nil {
+		return "", err
+	}
+	for _, stateroot := range stateroots {
+		deployments, err := e.list(nmstateDir, path.Join("/ostree/deploy", stateroot, "deploy"))
+		if err != nil {
+			return "", err
+		}
+		for _, deployment := range deployments {
+			if !ostreeDeploymentRegexp.MatchString(deployment) {
+				continue
+			}
+			binaryPath := path.Join("/ostree/deploy", stateroot, "deploy", deployment, "usr/bin/nmstatectl")
+			dest := filepath.Join(extractedRootDir, binaryPath)
+			_, err = e.executer.Execute(fmt.Sprintf("mkdir -p %s && dump.erofs --cat --path=%s %s > %s", filepath.Dir(dest), binaryPath, "root.erofs", dest), nmstateDir)
+			if err != nil {
+				log.Errorf("failed to extract %s from root.erofs: %v", binaryPath, err.Error())
+				return "", err
+			}
+			return binaryPath, nil
+		}
+	}
+	return "", fmt.Errorf("no ostree deployment found in root.erofs")
+}
+
+// list returns the names of the entries of the directory at dir in the root image
+func (e *erofsExtractor) list(nmstateDir, dir string) ([]string, error) {
+	out, err := e.executer.Execute(fmt.Sprintf("dump.erofs --ls --path=%s %s", dir, "root.erofs"), nmstateDir)
+	if err != nil {
+		log.Errorf("failed to list %s in root.erofs: %v", dir, err.Error())
+		return nil, err
+	}
+	// each entry is listed as "<nid> <type> <name>" after a header line
+	var names []string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] == "NID" || fields[2] == "." || fields[2] == ".." {
+			continue
+		}
+		names = append(names, fields[2])
+	}
+	return names, nil
+}
+
 //go:generate mockgen -package=isoeditor -destination=mock_executer.go . Executer
 type Executer interface {
 	Execute(command, workDir string) (string, error)
@@ -1,6 +1,10 @@
 package isoeditor
 
 import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -10,6 +14,26 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+// nmstatectlStandIn returns an ELF executable header for machine padded to size bytes
+func nmstatectlStandIn(machine elf.Machine, size int) []byte {
+	header := elf.Header64{
+		Type:    uint16(elf.ET_EXEC),
+		Machine: uint16(machine),
+		Version: uint32(elf.EV_CURRENT),
+		Ehsize:  64,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	buf := &bytes.Buffer{}
+	_ = binary.Write(buf, binary.LittleEndian, header)
+	content := make([]byte, size)
+	copy(content, buf.Bytes())
+	return content
+}
+
 var _ = Context("with test files", func() {
 	var (
 		isoFile  string
@@ -35,6 +59,7 @@ var _ = Context("with test files", func() {
 	Describe("CreateNmstateRamDisk", func() {
 		var (
 			extractDir, ramDiskPath string
+			nmstatectlPath          string
 			err                     error
 			nmstateHandler          NmstateHandler
 			ctrl                    *gomock.Controller
@@ -46,10 +71,10 @@ var _ = Context("with test files", func() {
 			nmstateDir := filepath.Join(extractDir, "nmstate", "squashfs-root")
 			err = os.MkdirAll(nmstateDir, os.ModePerm)
 			Expect(err).ToNot(HaveOccurred())
+			// stands in for the root image extracted from the rootfs
+			Expect(os.WriteFile(filepath.Join(extractDir, "nmstate", "root.squashfs"), nil, 0600)).To(Succeed())
 
-			nmstatectlPath := filepath.Join(nmstateDir, "nmstatectl")
-			_, err := os.Create(nmstatectlPath)
-			Expect(err).ToNot(HaveOccurred())
+			nmstatectlPath = filepath.Join(nmstateDir, "nmstatectl")
 
 			ramDisk, err := os.CreateTemp(extractDir, "nmstate.img")
 			Expect(err).ToNot(HaveOccurred())
@@ -58,21 +83,157 @@ var _ = Context("with test files", func() {
 
 			ctrl = gomock.NewController(GinkgoT())
 			mockExecuter = NewMockExecuter(ctrl)
-			mockExecuter.EXPECT().Execute(gomock.Any(), gomock.Any()).Return("nmstatectl", nil).Times(3)
 			nmstateHandler = NewNmstateHandler(os.TempDir(), mockExecuter)
 		})
 
 		AfterEach(func() {
-			os.Remove(extractDir)
+			os.RemoveAll(filepath.Join(extractDir, "nmstate"))
+			os.Remove(ramDiskPath)
 		})
 
-		It("ram disk created successfully", func() {
-			err := nmstateHandler.CreateNmstateRamDisk("", ramDiskPath)
-			Expect(err).ToNot(HaveOccurred())
+		Context("with a squashfs rootfs", func() {
+			BeforeEach(func() {
+				mockExecuter.EXPECT().Execute(gomock.Any(), gomock.Any()).Return("nmstatectl", nil).Times(4)
+			})
+
+			It("ram disk created successfully", func() {
+				Expect(os.WriteFile(nmstatectlPath, nmstatectlStandIn(elf.EM_X86_64, minNmstatectlSize), 0600)).To(Succeed())
+				err := nmstateHandler.CreateNmstateRamDisk("", ramDiskPath, "x86_64")
+				Expect(err).ToNot(HaveOccurred())
+
+				exists, err := fileExists(ramDiskPath)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(exists).To(BeTrue())
+			})
+
+			It("compresses the ram disk with gzip by default", func() {
+				Expect(os.WriteFile(nmstatectlPath, nmstatectlStandIn(elf.EM_X86_64, minNmstatectlSize), 0600)).To(Succeed())
+				Expect(nmstateHandler.CreateNmstateRamDisk("", ramDiskPath, "x86_64")).To(Succeed())
+
+				ramDisk, err := os.ReadFile(ramDiskPath)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ramDisk[:2]).To(Equal([]byte{0x1f, 0x8b}))
+			})
+
+			It("leaves the ram disk uncompressed when configured to", func() {
+				nmstateHandler = NewNmstateHandler(os.TempDir(), mockExecuter, WithRamDiskCompression(WithCompression(false)))
+				Expect(os.WriteFile(nmstatectlPath, nmstatectlStandIn(elf.EM_X86_64, minNmstatectlSize), 0600)).To(Succeed())
+				Expect(nmstateHandler.CreateNmstateRamDisk("", ramDiskPath, "x86_64")).To(Succeed())
+
+				ramDisk, err := os.ReadFile(ramDiskPath)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(ramDisk[:6])).To(Equal("070701"))
+				Expect(len(ramDisk)).To(BeNumerically(">", minNmstatectlSize))
+			})
+
+			It("fails for an empty nmstatectl", func() {
+				Expect(os.WriteFile(nmstatectlPath, []byte{}, 0600)).To(Succeed())
+				err := nmstateHandler.CreateNmstateRamDisk("", ramDiskPath, "x86_64")
+				Expect(err).To(MatchError(ContainSubstring("binary is 0 bytes")))
+			})
+
+			It("fails for a nmstatectl built for another architecture", func() {
+				Expect(os.WriteFile(nmstatectlPath, nmstatectlStandIn(elf.EM_AARCH64, minNmstatectlSize), 0600)).To(Succeed())
+				err := nmstateHandler.CreateNmstateRamDisk("", ramDiskPath, "x86_64")
+				Expect(err).To(MatchError(ContainSubstring("binary is built for EM_AARCH64, expected EM_X86_64 for x86_64")))
+			})
+		})
 
-			exists, err := fileExists(ramDiskPath)
-			Expect(err).ToNot(HaveOccurred())
-			Expect(exists).To(BeTrue())
+		Context("with an erofs rootfs", func() {
+			binaryPath := "/ostree/deploy/rhcos/deploy/0123abcd.0/usr/bin/nmstatectl"
+
+			BeforeEach(func() {
+				Expect(os.Rename(filepath.Join(extractDir, "nmstate", "root.squashfs"), filepath.Join(extractDir, "nmstate", "root.erofs"))).To(Succeed())
+			})
+
+			It("extracts nmstatectl with dump.erofs", func() {
+				mockExecuter.EXPECT().Execute(gomock.Any(), gomock.Any()).DoAndReturn(func(command, workDir string) (string, error) {
+					switch command {
+					case "dump.erofs --ls --path=/ostree/deploy root.erofs":
+						return "       NID TYPE  FILENAME\n        36    2  .\n        36    2  ..\n       112    2  rhcos", nil
+					case "dump.erofs --ls --path=/ostree/deploy/rhcos/deploy root.erofs":
+						return "       NID TYPE  FILENAME\n       112    2  .\n        36    2  ..\n       140    2  0123abcd.0\n       150    1  0123abcd.0.origin", nil
+					case fmt.Sprintf("mkdir -p squashfs-root%s && dump.erofs --cat --path=%s root.erofs > squashfs-root%s", filepath.Dir(binaryPath), binaryPath, binaryPath):
+						dest := filepath.Join(workDir, "squashfs-root", binaryPath)
+						Expect(os.MkdirAll(filepath.Dir(dest), 0700)).To(Succeed())
+						return "", os.WriteFile(dest, nmstatectlStandIn(elf.EM_X86_64, minNmstatectlSize), 0600)
+					}
+					return "", nil
+				}).Times(5)
+
+				Expect(nmstateHandler.CreateNmstateRamDisk("", ramDiskPath, "x86_64")).To(Succeed())
+				exists, err := fileExists(ramDiskPath)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(exists).To(BeTrue())
+			})
+
+			It("names dump.erofs when it isn't installed", func() {
+				mockExecuter.EXPECT().Execute(gomock.Any(), gomock.Any()).Return("", nil)
+				mockExecuter.EXPECT().Execute("command -v dump.erofs", gomock.Any()).Return("", fmt.Errorf("exit status 1"))
+				err := nmstateHandler.CreateNmstateRamDisk("", ramDiskPath, "x86_64")
+				Expect(err).To(MatchError(ContainSubstring("dump.erofs is required to extract nmstatectl from the erofs rootfs")))
+			})
+		})
+
+		It("fails when the rootfs has no root image", func() {
+			Expect(os.Remove(filepath.Join(extractDir, "nmstate", "root.squashfs"))).To(Succeed())
+			mockExecuter.EXPECT().Execute(gomock.Any(), gomock.Any()).Return("", nil)
+			err := nmstateHandler.CreateNmstateRamDisk("", ramDiskPath, "x86_64")
+			Expect(err).To(MatchError(ContainSubstring("neither root.squashfs nor root.erofs")))
 		})
 	})
 })
+
+var _ = Describe("validateNmstatectl", func() {
+	It("accepts binaries for the target architecture", func() {
+		Expect(validateNmstatectl(nmstatectlStandIn(elf.EM_X86_64, minNmstatectlSize), "x86_64")).To(Succeed())
+		Expect(validateNmstatectl(nmstatectlStandIn(elf.EM_AARCH64, minNmstatectlSize), "arm64")).To(Succeed())
+		Expect(validateNmstatectl(nmstatectlStandIn(elf.EM_AARCH64, minNmstatectlSize), "aarch64")).To(Succeed())
+		Expect(validateNmstatectl(nmstatectlStandIn(elf.EM_PPC64, minNmstatectlSize), "ppc64le")).To(Succeed())
+	})
+
+	It("doesn't check the machine of unknown architectures", func() {
+		Expect(validateNmstatectl(nmstatectlStandIn(elf.EM_RISCV, minNmstatectlSize), "riscv64")).To(Succeed())
+	})
+
+	It("fails for a truncated binary", func() {
+		err := validateNmstatectl(nmstatectlStandIn(elf.EM_X86_64, 1024), "x86_64")
+		Expect(err).To(MatchError(ContainSubstring("binary is 1024 bytes")))
+	})
+
+	It("fails for a file that isn't ELF", func() {
+		err := validateNmstatectl(bytes.Repeat([]byte("#!/bin/sh\n"), minNmstatectlSize), "x86_64")
+		Expect(err).To(MatchError(ContainSubstring("not an ELF file")))
+	})
+
+	It("fails for a binary built for another architecture", func() {
+		err := validateNmstatectl(nmstatectlStandIn(elf.EM_S390, minNmstatectlSize), "x86_64")
+		Expect(err).To(MatchError(ContainSubstring("built for EM_S390")))
+	})
+})
+
+var _ = Describe("ParseCompression", func() {
+	parse := func(value string) archiveConfig {
+		opts, err := ParseCompression(value)
+		Expect(err).NotTo(HaveOccurred())
+		config := archiveConfig{}
+		for _, opt := range opts {
+			opt(&config)
+		}
+		return config
+	}
+
+	It("parses the supported settings", func() {
+		Expect(parse("gzip")).To(Equal(archiveConfig{compress: true, gzipLevel: -1}))
+		Expect(parse("gzip-1")).To(Equal(archiveConfig{compress: true, gzipLevel: 1}))
+		Expect(parse("gzip-9")).To(Equal(archiveConfig{compress: true, gzipLevel: 9}))
+		Expect(parse("none")).To(Equal(archiveConfig{compress: false}))
+	})
+
+	It("fails for unsupported settings", func() {
+		for _, value := range []string{"", "zstd", "gzip-0", "gzip-10", "gzip-x"} {
+			_, err := ParseCompression(value)
+			Expect(err).To(HaveOccurred(), value)
+		}
+	})
+})
@@ -2,9 +2,11 @@ package isoeditor
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/openshift/assisted-image-service/internal/common"
 	log "github.com/sirupsen/logrus"
@@ -21,18 +23,36 @@ const (
 //go:generate mockgen -package=isoeditor -destination=mock_editor.go . Editor
 type Editor interface {
 	CreateMinimalISOTemplate(fullISOPath, rootFSURL, arch, minimalISOPath, openshiftVersion string) error
+	CacheNmstateRamDisk(fullISOPath, arch string) error
 }
 
 type rhcosEditor struct {
 	workDir        string
 	nmstateHandler NmstateHandler
+	// when set, the volume identifier of minimal ISOs, inherited from the full ISO otherwise
+	minimalVolumeID *VolumeIDTemplate
 }
 
-func NewEditor(dataDir string, nmstateHandler NmstateHandler) Editor {
-	return &rhcosEditor{
+// EditorOption configures the ISO editor
+type EditorOption func(*rhcosEditor)
+
+// WithMinimalISOVolumeID sets the volume identifier of the minimal ISOs from template
+// instead of reusing the volume identifier of the full ISO
+func WithMinimalISOVolumeID(template *VolumeIDTemplate) EditorOption {
+	return func(e *rhcosEditor) {
+		e.minimalVolumeID = template
+	}
+}
+
+func NewEditor(dataDir string, nmstateHandler NmstateHandler, opts ...EditorOption) Editor {
+	e := &rhcosEditor{
 		workDir:        dataDir,
 		nmstateHandler: nmstateHandler,
 	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
 }
 
 // CreateMinimalISO Creates the minimal iso by removing the rootfs and adding the url
@@ -85,6 +105,9 @@ func (e *rhcosEditor) CreateMinimalISOTemplate(fullISOPath, rootFSURL, arch, min
 	if err != nil {
 		return err
 	}
+	if e.minimalVolumeID != nil {
+		volumeID = e.minimalVolumeID.Expand(volumeID, openshiftVersion, arch)
+	}
 
 	ramDiskPath := filepath.Join(extractDir, nmstateDiskImagePath)
 
@@ -94,8 +117,14 @@ func (e *rhcosEditor) CreateMinimalISOTemplate(fullISOPath, rootFSURL, arch, min
 	}
 
 	if versionOK {
-		rootfsPath := filepath.Join(extractDir, "images/pxeboot/rootfs.img")
-		err = e.nmstateHandler.CreateNmstateRamDisk(rootfsPath, ramDiskPath)
+		cachedRamDiskPath := NmstateRamDiskPath(fullISOPath)
+		if _, err = os.Stat(cachedRamDiskPath); err == nil {
+			err = copyFile(cachedRamDiskPath, ramDiskPath)
+		} else {
+			log.Infof("No cached nmstate ram disk found at %s, extracting nmstatectl", cachedRamDiskPath)
+			rootfsPath := filepath.Join(extractDir, "images/pxeboot/rootfs.img")
+			err = e.nmstateHandler.CreateNmstateRamDisk(rootfsPath, ramDiskPath, arch)
+		}
 		if err != nil {
 			return fmt.Errorf("failed to create nmstate ram disk for arch %s: %v", arch, err)
 		}
@@ -109,6 +138,64 @@ func (e *rhcosEditor) CreateMinimalISOTemplate(fullISOPath, rootFSURL, arch, min
 	return nil
 }
 
+// NmstateRamDiskPath returns the location of the cached nmstate ram disk for a full ISO
+func NmstateRamDiskPath(fullISOPath string) string {
+	return strings.TrimSuffix(fullISOPath, filepath.Ext(fullISOPath)) + "-nmstate.img"
+}
+
+// CacheNmstateRamDisk extracts nmstatectl from the rootfs of the full ISO and stores the
+// resulting ram disk at NmstateRamDiskPath so minimal ISO templates don't need to extract it again
+func (e *rhcosEditor) CacheNmstateRamDisk(fullISOPath, arch string) error {
+	workDir, err := os.MkdirTemp(e.workDir, "nmstate-rootfs")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if removeErr := os.RemoveAll(workDir); removeErr != nil {
+			log.WithError(removeErr).Error("failed to remove nmstate rootfs temp dir")
+		}
+	}()
+
+	rootfs, err := GetFileFromISO(fullISOPath, "/images/pxeboot/rootfs.img")
+	if err != nil {
+		return fmt.Errorf("failed to open rootfs in %s: %v", fullISOPath, err)
+	}
+	defer rootfs.Close()
+
+	rootfsPath := filepath.Join(workDir, "rootfs.img")
+	if err = writeFile(rootfsPath, rootfs); err != nil {
+		return err
+	}
+
+	// build the ram disk next to the rootfs and move it in place so a partial file is never cached
+	ramDiskPath := filepath.Join(workDir, "nmstate.img")
+	if err = e.nmstateHandler.CreateNmstateRamDisk(rootfsPath, ramDiskPath, arch); err != nil {
+		return err
+	}
+	return os.Rename(ramDiskPath, NmstateRamDiskPath(fullISOPath))
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFile(dst, in)
+}
+
+func writeFile(path string, r io.Reader) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
 func embedInitrdPlaceholders(extractDir string) error {
 	f, err := os.Create(filepath.Join(extractDir, ramDiskImagePath))
 	if err != nil {
@@ -147,22 +234,22 @@ func fixGrubConfig(rootFSURL, extractDir string, includeNmstateRamDisk bool) err
 
 	// Add the rootfs url
 	replacement := fmt.Sprintf("$1 $2 'coreos.live.rootfs_url=%s'", rootFSURL)
-	if err := editFile(foundGrubPath, `(?m)^(\s+linux) (.+| )+$`, replacement); err != nil {
+	if err := editFileMatching(foundGrubPath, `(?m)^(\s+linux) (.+| )+$`, replacement, "linux"); err != nil {
 		return err
 	}
 
-	// Remove the coreos.liveiso parameter
+	// Remove the coreos.liveiso parameter, respun ISOs may not have it
 	if err := editFile(foundGrubPath, ` coreos.liveiso=\S+`, ""); err != nil {
 		return err
 	}
 
 	// Edit config to add custom ramdisk image to initrd
 	if includeNmstateRamDisk {
-		if err := editFile(foundGrubPath, `(?m)^(\s+initrd) (.+| )+$`, fmt.Sprintf("$1 $2 %s %s", ramDiskImagePath, nmstateDiskImagePath)); err != nil {
+		if err := editFileMatching(foundGrubPath, `(?m)^(\s+initrd) (.+| )+$`, fmt.Sprintf("$1 $2 %s %s", ramDiskImagePath, nmstateDiskImagePath), "initrd"); err != nil {
 			return err
 		}
 	} else {
-		if err := editFile(foundGrubPath, `(?m)^(\s+initrd) (.+| )+$`, fmt.Sprintf("$1 $2 %s", ramDiskImagePath)); err != nil {
+		if err := editFileMatching(foundGrubPath, `(?m)^(\s+initrd) (.+| )+$`, fmt.Sprintf("$1 $2 %s", ramDiskImagePath), "initrd"); err != nil {
 			return err
 		}
 	}
@@ -171,21 +258,23 @@ func fixGrubConfig(rootFSURL, extractDir string, includeNmstateRamDisk bool) err
 }
 
 func fixIsolinuxConfig(rootFSURL, extractDir string, includeNmstateRamDisk bool) error {
+	isolinuxPath := filepath.Join(extractDir, "isolinux/isolinux.cfg")
 	replacement := fmt.Sprintf("$1 $2 coreos.live.rootfs_url=%s", rootFSURL)
-	if err := editFile(filepath.Join(extractDir, "isolinux/isolinux.cfg"), `(?m)^(\s+append) (.+| )+$`, replacement); err != nil {
+	if err := editFileMatching(isolinuxPath, `(?m)^(\s+append) (.+| )+$`, replacement, "append"); err != nil {
 		return err
 	}
 
-	if err := editFile(filepath.Join(extractDir, "isolinux/isolinux.cfg"), ` coreos.liveiso=\S+`, ""); err != nil {
+	// respun ISOs may not have the coreos.liveiso parameter
+	if err := editFile(isolinuxPath, ` coreos.liveiso=\S+`, ""); err != nil {
 		return err
 	}
 
 	if includeNmstateRamDisk {
-		if err := editFile(filepath.Join(extractDir, "isolinux/isolinux.cfg"), `(?m)^(\s+append.*initrd=\S+) (.*)$`, fmt.Sprintf("${1},%s,%s ${2}", ramDiskImagePath, nmstateDiskImagePath)); err != nil {
+		if err := editFileMatching(isolinuxPath, `(?m)^(\s+append.*initrd=\S+) (.*)$`, fmt.Sprintf("${1},%s,%s ${2}", ramDiskImagePath, nmstateDiskImagePath), "append initrd="); err != nil {
 			return err
 		}
 	} else {
-		if err := editFile(filepath.Join(extractDir, "isolinux/isolinux.cfg"), `(?m)^(\s+append.*initrd=\S+) (.*)$`, fmt.Sprintf("${1},%s ${2}", ramDiskImagePath)); err != nil {
+		if err := editFileMatching(isolinuxPath, `(?m)^(\s+append.*initrd=\S+) (.*)$`, fmt.Sprintf("${1},%s ${2}", ramDiskImagePath), "append initrd="); err != nil {
 			return err
 		}
 	}
@@ -193,6 +282,19 @@ func fixIsolinuxConfig(rootFSURL, extractDir string, includeNmstateRamDisk bool)
 	return nil
 }
 
+// editFileMatching is editFile for edits the boot configuration can't do without, it fails
+// when no line of the file matches reString rather than leaving the file unbootable
+func editFileMatching(fileName string, reString string, replacement string, line string) error {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	if !regexp.MustCompile(reString).Match(content) {
+		return fmt.Errorf("no %s line found in %s, unable to add the boot parameters", line, fileName)
+	}
+	return editFile(fileName, reString, replacement)
+}
+
 func editFile(fileName string, reString string, replacement string) error {
 	content, err := os.ReadFile(fileName)
 	if err != nil {
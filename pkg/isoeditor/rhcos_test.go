@@ -55,7 +55,7 @@ var _ = Context("with test files", func() {
 		mockNmstateHandler = NewMockNmstateHandler(ctrl)
 		mockExecuter = NewMockExecuter(ctrl)
 		mockExecuter.EXPECT().Execute(gomock.Any(), gomock.Any()).Return("some string", nil).Times(3)
-		mockNmstateHandler.EXPECT().CreateNmstateRamDisk(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+		mockNmstateHandler.EXPECT().CreateNmstateRamDisk(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
 	})
 
 	AfterEach(func() {
@@ -76,6 +76,59 @@ var _ = Context("with test files", func() {
 			err := editor.CreateMinimalISOTemplate("invalid", testRootFSURL, "x86_64", minimalISOPath, "4.18.0-ec.0")
 			Expect(err).To(HaveOccurred())
 		})
+
+		It("inherits the volume ID of the full iso", func() {
+			editor := NewEditor(workDir, mockNmstateHandler)
+			Expect(editor.CreateMinimalISOTemplate(isoFile, testRootFSURL, "x86_64", minimalISOPath, "4.17")).To(Succeed())
+			Expect(VolumeIdentifier(minimalISOPath)).To(Equal(volumeID))
+		})
+
+		It("uses the configured volume ID", func() {
+			template, err := NewVolumeIDTemplate("{volume_id}-min-{arch}")
+			Expect(err).NotTo(HaveOccurred())
+			editor := NewEditor(workDir, mockNmstateHandler, WithMinimalISOVolumeID(template))
+			Expect(editor.CreateMinimalISOTemplate(isoFile, testRootFSURL, "x86_64", minimalISOPath, "4.17")).To(Succeed())
+			Expect(VolumeIdentifier(minimalISOPath)).To(Equal(volumeID + "-min-x86_64"))
+		})
+	})
+
+	Describe("CacheNmstateRamDisk", func() {
+		AfterEach(func() {
+			os.Remove(NmstateRamDiskPath(isoFile))
+		})
+
+		It("caches the ram disk next to the full iso", func() {
+			nmstateHandler := NewMockNmstateHandler(ctrl)
+			nmstateHandler.EXPECT().CreateNmstateRamDisk(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(rootfsPath, ramDiskPath, arch string) error {
+				Expect(arch).To(Equal("x86_64"))
+				rootfs, err := os.ReadFile(rootfsPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(rootfs)).To(Equal("this is rootfs"))
+				return os.WriteFile(ramDiskPath, []byte("ramdisk"), 0600)
+			})
+			editor := NewEditor(workDir, nmstateHandler)
+			Expect(editor.CacheNmstateRamDisk(isoFile, "x86_64")).To(Succeed())
+
+			content, err := os.ReadFile(NmstateRamDiskPath(isoFile))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("ramdisk"))
+		})
+
+		It("doesn't cache anything when extraction fails", func() {
+			nmstateHandler := NewMockNmstateHandler(ctrl)
+			nmstateHandler.EXPECT().CreateNmstateRamDisk(gomock.Any(), gomock.Any(), gomock.Any()).Return(fmt.Errorf("extraction failed"))
+			editor := NewEditor(workDir, nmstateHandler)
+			Expect(editor.CacheNmstateRamDisk(isoFile, "x86_64")).NotTo(Succeed())
+			Expect(NmstateRamDiskPath(isoFile)).NotTo(BeAnExistingFile())
+		})
+
+		It("uses the cached ram disk when creating the minimal iso template", func() {
+			Expect(os.WriteFile(NmstateRamDiskPath(isoFile), []byte("ramdisk"), 0600)).To(Succeed())
+			// no calls are expected on the handler since nmstatectl was already extracted
+			editor := NewEditor(workDir, NewMockNmstateHandler(ctrl))
+			err := editor.CreateMinimalISOTemplate(isoFile, testRootFSURL, "x86_64", minimalISOPath, "4.18.0-ec.0")
+			Expect(err).ToNot(HaveOccurred())
+		})
 	})
 
 	Describe("CreateFCOSMinimalISOTemplate", func() {
@@ -142,3 +195,61 @@ var _ = Context("with test files", func() {
 		})
 	})
 })
+
+var _ = Describe("Fix Config with incomplete boot configs", func() {
+	var extractDir string
+
+	BeforeEach(func() {
+		var err error
+		extractDir, err = os.MkdirTemp("", "fixconfig")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.MkdirAll(filepath.Join(extractDir, "EFI/redhat"), 0755)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(extractDir, "isolinux"), 0755)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(extractDir)).To(Succeed())
+	})
+
+	writeConfig := func(path, content string) string {
+		path = filepath.Join(extractDir, path)
+		Expect(os.WriteFile(path, []byte(content), 0600)).To(Succeed())
+		return path
+	}
+
+	It("adds the rootfs url to a grub.cfg without coreos.liveiso", func() {
+		grubPath := writeConfig("EFI/redhat/grub.cfg", strings.ReplaceAll(testGrubConfig, " coreos.liveiso=rhcos-46.82.202010091720-0", ""))
+		Expect(fixGrubConfig(testRootFSURL, extractDir, false)).To(Succeed())
+
+		content, err := os.ReadFile(grubPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(ContainSubstring(fmt.Sprintf("ignition.platform.id=metal 'coreos.live.rootfs_url=%s'\n", testRootFSURL)))
+		Expect(string(content)).To(ContainSubstring(fmt.Sprintf("/images/ignition.img %s\n", ramDiskImagePath)))
+	})
+
+	It("fails for a grub.cfg without a linux line", func() {
+		grubPath := writeConfig("EFI/redhat/grub.cfg", strings.ReplaceAll(testGrubConfig, "\tlinux /images/pxeboot/vmlinuz", "\tlinuxefi /images/pxeboot/vmlinuz"))
+		err := fixGrubConfig(testRootFSURL, extractDir, false)
+		Expect(err).To(MatchError(ContainSubstring("no linux line found in " + grubPath)))
+
+		// the config is left untouched
+		content, err := os.ReadFile(grubPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).NotTo(ContainSubstring("coreos.live.rootfs_url"))
+	})
+
+	It("adds the rootfs url to an isolinux.cfg without coreos.liveiso", func() {
+		isolinuxPath := writeConfig("isolinux/isolinux.cfg", strings.ReplaceAll(testISOLinuxConfig, " coreos.liveiso=rhcos-46.82.202010091720-0", ""))
+		Expect(fixIsolinuxConfig(testRootFSURL, extractDir, false)).To(Succeed())
+
+		content, err := os.ReadFile(isolinuxPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(ContainSubstring(fmt.Sprintf("ignition.platform.id=metal coreos.live.rootfs_url=%s\n", testRootFSURL)))
+	})
+
+	It("fails for an isolinux.cfg without an append line", func() {
+		isolinuxPath := writeConfig("isolinux/isolinux.cfg", strings.ReplaceAll(testISOLinuxConfig, "  append ", "  # "))
+		err := fixIsolinuxConfig(testRootFSURL, extractDir, false)
+		Expect(err).To(MatchError(ContainSubstring("no append line found in " + isolinuxPath)))
+	})
+})
@@ -0,0 +1,67 @@
+package isoeditor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/cavaliercoder/go-cpio"
+)
+
+// RootfsFilesystem is the filesystem of the root image in the rootfs of an ISO
+type RootfsFilesystem string
+
+const (
+	RootfsSquashfs RootfsFilesystem = "squashfs"
+	RootfsErofs    RootfsFilesystem = "erofs"
+)
+
+// rootfsFilesystems lists the supported filesystems of the root image, the first one found is used
+var rootfsFilesystems = []RootfsFilesystem{RootfsSquashfs, RootfsErofs}
+
+// rootfsImageName returns the file name of the root image of filesystem in the rootfs
+func rootfsImageName(filesystem RootfsFilesystem) string {
+	return "root." + string(filesystem)
+}
+
+// DetectRootfsFilesystem reads the rootfs cpio archive until it finds the root image and
+// returns its filesystem. RHCOS moves from squashfs to erofs, so both are found in the wild.
+func DetectRootfsFilesystem(rootfs io.Reader) (RootfsFilesystem, error) {
+	r := cpio.NewReader(rootfs)
+	for {
+		hdr, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("rootfs contains neither root.squashfs nor root.erofs")
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read rootfs archive: %w", err)
+		}
+		for _, filesystem := range rootfsFilesystems {
+			if filepath.Base(hdr.Name) == rootfsImageName(filesystem) {
+				return filesystem, nil
+			}
+		}
+	}
+}
+
+// DetectISORootfsFilesystem returns the filesystem of the root image in the rootfs of the ISO at isoPath
+func DetectISORootfsFilesystem(isoPath string) (RootfsFilesystem, error) {
+	rootfs, err := GetFileFromISO(isoPath, "/images/pxeboot/rootfs.img")
+	if err != nil {
+		return "", fmt.Errorf("failed to open rootfs in %s: %w", isoPath, err)
+	}
+	defer rootfs.Close()
+	return DetectRootfsFilesystem(rootfs)
+}
+
+// detectExtractedRootfsFilesystem returns the filesystem of the root image extracted from the rootfs into dir
+func detectExtractedRootfsFilesystem(dir string) (RootfsFilesystem, error) {
+	for _, filesystem := range rootfsFilesystems {
+		if _, err := os.Stat(filepath.Join(dir, rootfsImageName(filesystem))); err == nil {
+			return filesystem, nil
+		}
+	}
+	return "", fmt.Errorf("rootfs contains neither root.squashfs nor root.erofs")
+}
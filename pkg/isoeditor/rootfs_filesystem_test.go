@@ -0,0 +1,42 @@
+package isoeditor
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DetectRootfsFilesystem", func() {
+	rootfs := func(files ...string) *bytes.Reader {
+		var cpioFiles []cpioFile
+		for _, file := range files {
+			cpioFiles = append(cpioFiles, cpioFile{Path: file, Content: []byte("content"), Mode: 0o100_644})
+		}
+		archive, err := generateCPIOArchive(cpioFiles, false, 0)
+		Expect(err).NotTo(HaveOccurred())
+		return bytes.NewReader(archive)
+	}
+
+	It("detects a squashfs root image", func() {
+		filesystem, err := DetectRootfsFilesystem(rootfs("root.squashfs"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filesystem).To(Equal(RootfsSquashfs))
+	})
+
+	It("detects an erofs root image after other files", func() {
+		filesystem, err := DetectRootfsFilesystem(rootfs("etc/os-release", "root.erofs"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filesystem).To(Equal(RootfsErofs))
+	})
+
+	It("fails for a rootfs without root image", func() {
+		_, err := DetectRootfsFilesystem(rootfs("etc/os-release"))
+		Expect(err).To(MatchError(ContainSubstring("neither root.squashfs nor root.erofs")))
+	})
+
+	It("fails for a rootfs that isn't a cpio archive", func() {
+		_, err := DetectRootfsFilesystem(bytes.NewReader([]byte("not a cpio archive")))
+		Expect(err).To(HaveOccurred())
+	})
+})
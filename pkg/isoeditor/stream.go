@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"os"
 
 	"github.com/openshift/assisted-image-service/pkg/overlay"
 	"github.com/pkg/errors"
@@ -18,6 +17,20 @@ type ImageReader = overlay.OverlayReader
 
 type BoundariesFinder func(filePath, isoPath string) (int64, int64, error)
 
+// EmbedAreaTooSmallError is returned when content doesn't fit in the area reserved for it in the ISO
+type EmbedAreaTooSmallError struct {
+	ContentLength int64
+	AreaLength    int64
+}
+
+func (e *EmbedAreaTooSmallError) Error() string {
+	return fmt.Sprintf("content length (%d) exceeds embed area size (%d)", e.ContentLength, e.AreaLength)
+}
+
+// ErrIgnitionTooLarge is returned when the ignition archive doesn't fit in an ISO's embed area,
+// along with the *EmbedAreaTooSmallError reporting the sizes
+var ErrIgnitionTooLarge = errors.New("ignition exceeds the embed area size")
+
 type StreamGeneratorFunc func(isoPath string, ignitionContent *IgnitionContent, ramdiskContent, kargs []byte) (ImageReader, error)
 
 type ignitionInfo struct {
@@ -27,10 +40,50 @@ type ignitionInfo struct {
 }
 
 func NewRHCOSStreamReader(isoPath string, ignitionContent *IgnitionContent, ramdiskContent []byte, kargs []byte) (ImageReader, error) {
-	_, r, err := ignitionOverlay(isoPath, ignitionContent, false)
+	return newRHCOSStreamReader(openBaseFile, defaultKargs, isoPath, ignitionContent, ramdiskContent, kargs)
+}
+
+// StreamGeneratorOption configures the images generated by NewRHCOSStreamGenerator
+type StreamGeneratorOption func(*streamGenerator)
+
+type streamGenerator struct {
+	kargs *Kargs
+}
+
+// WithKargs patches the kernel arguments of the generated images into the files located by kargs
+func WithKargs(kargs *Kargs) StreamGeneratorOption {
+	return func(g *streamGenerator) {
+		g.kargs = kargs
+	}
+}
+
+// NewRHCOSStreamGenerator returns a StreamGeneratorFunc like NewRHCOSStreamReader that reads
+// the base ISOs opened with open, or opens them directly when it's nil
+func NewRHCOSStreamGenerator(open BaseFileOpener, opts ...StreamGeneratorOption) StreamGeneratorFunc {
+	if open == nil {
+		open = openBaseFile
+	}
+	g := &streamGenerator{kargs: defaultKargs}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return func(isoPath string, ignitionContent *IgnitionContent, ramdiskContent, kargs []byte) (ImageReader, error) {
+		return newRHCOSStreamReader(open, g.kargs, isoPath, ignitionContent, ramdiskContent, kargs)
+	}
+}
+
+func newRHCOSStreamReader(open BaseFileOpener, kargsConfig *Kargs, isoPath string, ignitionContent *IgnitionContent, ramdiskContent []byte, kargs []byte) (_ ImageReader, err error) {
+	_, r, err := ignitionOverlay(open, isoPath, ignitionContent, false)
 	if err != nil {
 		return nil, err
 	}
+	// the ignition overlay closes the base ISO, which must be released on errors
+	base := r
+	defer func() {
+		if err != nil {
+			base.Close()
+		}
+	}()
 
 	if ramdiskContent != nil {
 		r, err = readerForContent(isoPath, ramDiskImagePath, r, bytes.NewReader(ramdiskContent), GetISOFileInfo)
@@ -40,12 +93,16 @@ func NewRHCOSStreamReader(isoPath string, ignitionContent *IgnitionContent, ramd
 	}
 
 	if kargs != nil {
-		files, err := KargsFiles(isoPath)
+		files, err := kargsConfig.Files(isoPath)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to read files to patch for kernel arguments")
 		}
 		for _, file := range files {
-			r, err = readerForKargsContent(isoPath, file, r, bytes.NewReader(kargs))
+			r, err = kargsConfig.readerForKargsContent(isoPath, file, r, bytes.NewReader(kargs))
+			var tooSmall *EmbedAreaTooSmallError
+			if errors.As(err, &tooSmall) {
+				return nil, fmt.Errorf("%w: %w in file \"%s\"", ErrKargsTooLong, tooSmall, file)
+			}
 			if err != nil {
 				return nil, errors.Wrapf(err, "failed to create overwrite reader for kernel arguments in file \"%s\"", file)
 			}
@@ -55,14 +112,27 @@ func NewRHCOSStreamReader(isoPath string, ignitionContent *IgnitionContent, ramd
 	return r, nil
 }
 
-func ignitionOverlay(isoPath string, ignitionContent *IgnitionContent, allowOverflow bool) (*ignitionInfo, overlay.OverlayReader, error) {
-	isoReader, err := os.Open(isoPath)
+// WriteImage writes the ISO at isoPath with the given content embedded to w
+// and returns the number of bytes written
+func WriteImage(w io.Writer, isoPath string, ignitionContent *IgnitionContent, ramdiskContent, kargs []byte) (int64, error) {
+	r, err := NewRHCOSStreamReader(isoPath, ignitionContent, ramdiskContent, kargs)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	return io.Copy(w, r)
+}
+
+func ignitionOverlay(open BaseFileOpener, isoPath string, ignitionContent *IgnitionContent, allowOverflow bool) (*ignitionInfo, overlay.OverlayReader, error) {
+	isoReader, err := open(isoPath)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	ignitionReader, err := ignitionContent.Archive()
 	if err != nil {
+		isoReader.Close()
 		return nil, nil, err
 	}
 
@@ -72,6 +142,14 @@ func ignitionOverlay(isoPath string, ignitionContent *IgnitionContent, allowOver
 	}
 
 	r, err := readerForContent(isoPath, ignitionImagePath, isoReader, ignitionReader, ibf.findBoundaries)
+	if err != nil {
+		// the reader streams of mapped files must be closed to release the mapping
+		isoReader.Close()
+	}
+	var tooSmall *EmbedAreaTooSmallError
+	if errors.As(err, &tooSmall) {
+		return nil, nil, fmt.Errorf("%w: %w", ErrIgnitionTooLarge, tooSmall)
+	}
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "failed to create overwrite reader for ignition")
 	}
@@ -150,7 +228,7 @@ func readerForContent(isoPath, filePath string, base io.ReadSeeker, contentReade
 	}
 
 	if length < contentReader.Size() {
-		return nil, errors.New(fmt.Sprintf("content length (%d) exceeds embed area size (%d)", contentReader.Size(), length))
+		return nil, &EmbedAreaTooSmallError{ContentLength: contentReader.Size(), AreaLength: length}
 	}
 
 	rdOverlay := overlay.Overlay{
@@ -2,10 +2,15 @@ package isoeditor
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"io"
 	"os"
+	"strings"
 
+	"github.com/cavaliercoder/go-cpio"
 	diskfs "github.com/diskfs/go-diskfs"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -54,7 +59,7 @@ var _ = Describe("NewRHCOSStreamReader", func() {
 	}
 
 	It("embeds the ignition with no ramdisk content", func() {
-		streamReader, err := NewRHCOSStreamReader(isoFile, &IgnitionContent{ignitionContent}, nil, nil)
+		streamReader, err := NewRHCOSStreamReader(isoFile, &IgnitionContent{Config: ignitionContent}, nil, nil)
 		Expect(err).NotTo(HaveOccurred())
 
 		f, err := os.CreateTemp(filesDir, "streamed*.iso")
@@ -67,9 +72,36 @@ var _ = Describe("NewRHCOSStreamReader", func() {
 		Expect(isoFileContent(f.Name(), ignitionImagePath)).To(Equal(ignitionArchiveBytes))
 	})
 
+	It("writes the image with the embedded ignition", func() {
+		var buf bytes.Buffer
+		written, err := WriteImage(&buf, isoFile, &IgnitionContent{Config: ignitionContent}, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(written).To(Equal(int64(buf.Len())))
+
+		info, err := os.Stat(isoFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(written).To(Equal(info.Size()))
+
+		f, err := os.CreateTemp(filesDir, "written*.iso")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = f.Write(buf.Bytes())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		Expect(isoFileContent(f.Name(), ignitionImagePath)).To(Equal(ignitionArchiveBytes))
+	})
+
+	It("fails to write an image that doesn't exist", func() {
+		var buf bytes.Buffer
+		written, err := WriteImage(&buf, "/does/not/exist.iso", &IgnitionContent{Config: ignitionContent}, nil, nil)
+		Expect(err).To(HaveOccurred())
+		Expect(written).To(BeZero())
+		Expect(buf.Len()).To(BeZero())
+	})
+
 	It("embeds the ignition and ramdisk content", func() {
 		initrdContent := []byte("someramdiskcontent")
-		streamReader, err := NewRHCOSStreamReader(isoFile, &IgnitionContent{ignitionContent}, initrdContent, nil)
+		streamReader, err := NewRHCOSStreamReader(isoFile, &IgnitionContent{Config: ignitionContent}, initrdContent, nil)
 		Expect(err).NotTo(HaveOccurred())
 
 		f, err := os.CreateTemp(filesDir, "streamed*.iso")
@@ -84,7 +116,7 @@ var _ = Describe("NewRHCOSStreamReader", func() {
 	})
 	It("embeds the ignition and kargs content", func() {
 		kargs := []byte(" p1 p2 p3 p4\n")
-		streamReader, err := NewRHCOSStreamReader(isoFile, &IgnitionContent{ignitionContent}, nil, kargs)
+		streamReader, err := NewRHCOSStreamReader(isoFile, &IgnitionContent{Config: ignitionContent}, nil, kargs)
 		Expect(err).NotTo(HaveOccurred())
 
 		f, err := os.CreateTemp(filesDir, "streamed*.iso")
@@ -102,6 +134,137 @@ var _ = Describe("NewRHCOSStreamReader", func() {
 		}
 	})
 
+	It("fails when the kargs don't fit the embed area", func() {
+		kargs := []byte(" " + strings.Repeat("p", 4096) + "\n")
+		_, err := NewRHCOSStreamReader(isoFile, &IgnitionContent{Config: ignitionContent}, nil, kargs)
+		Expect(errors.Is(err, ErrKargsTooLong)).To(BeTrue())
+		var tooSmall *EmbedAreaTooSmallError
+		Expect(errors.As(err, &tooSmall)).To(BeTrue())
+		Expect(tooSmall.ContentLength).To(Equal(int64(len(kargs))))
+		Expect(tooSmall.AreaLength).To(BeNumerically("<", len(kargs)))
+	})
+
+	It("embeds extra ignition archive files", func() {
+		ignition := &IgnitionContent{
+			Config:     ignitionContent,
+			ExtraFiles: map[string][]byte{"etc/nmstate/static.yml": []byte("somenetworkconfig")},
+		}
+		streamReader, err := NewRHCOSStreamReader(isoFile, ignition, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		f, err := os.CreateTemp(filesDir, "streamed*.iso")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = io.Copy(f, streamReader)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Sync()).To(Succeed())
+		Expect(f.Close()).To(Succeed())
+
+		gzipReader, err := gzip.NewReader(bytes.NewReader(isoFileContent(f.Name(), ignitionImagePath)))
+		Expect(err).NotTo(HaveOccurred())
+		cpioReader := cpio.NewReader(gzipReader)
+		var names []string
+		for {
+			hdr, err := cpioReader.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+			names = append(names, hdr.Name)
+			if hdr.Name == "etc/nmstate/static.yml" {
+				Expect(io.ReadAll(cpioReader)).To(Equal([]byte("somenetworkconfig")))
+			}
+		}
+		Expect(names).To(Equal([]string{"config.ign", "etc/nmstate/static.yml"}))
+	})
+
+	It("embeds an uncompressed ignition archive", func() {
+		ignition := &IgnitionContent{
+			Config:         ignitionContent,
+			ArchiveOptions: []ArchiveOption{WithCompression(false)},
+		}
+		streamReader, err := NewRHCOSStreamReader(isoFile, ignition, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		f, err := os.CreateTemp(filesDir, "streamed*.iso")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = io.Copy(f, streamReader)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Sync()).To(Succeed())
+		Expect(f.Close()).To(Succeed())
+
+		cpioReader := cpio.NewReader(bytes.NewReader(isoFileContent(f.Name(), ignitionImagePath)))
+		hdr, err := cpioReader.Next()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hdr.Name).To(Equal("config.ign"))
+		Expect(io.ReadAll(cpioReader)).To(Equal(ignitionContent))
+	})
+
+	It("embeds the ignition config under the configured name", func() {
+		ignition := &IgnitionContent{
+			Config:         ignitionContent,
+			ArchiveOptions: []ArchiveOption{WithCompression(false), WithConfigFileName("custom.ign")},
+		}
+		streamReader, err := NewRHCOSStreamReader(isoFile, ignition, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		f, err := os.CreateTemp(filesDir, "streamed*.iso")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = io.Copy(f, streamReader)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Sync()).To(Succeed())
+		Expect(f.Close()).To(Succeed())
+
+		cpioReader := cpio.NewReader(bytes.NewReader(isoFileContent(f.Name(), ignitionImagePath)))
+		hdr, err := cpioReader.Next()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hdr.Name).To(Equal("custom.ign"))
+		Expect(io.ReadAll(cpioReader)).To(Equal(ignitionContent))
+	})
+
+	It("embeds the archive option extra files alongside the ignition", func() {
+		ignition := &IgnitionContent{
+			Config:         ignitionContent,
+			ArchiveOptions: []ArchiveOption{WithCompression(false), WithExtraFile("etc/issue.d/branding.issue", []byte("somebranding"))},
+		}
+		streamReader, err := NewRHCOSStreamReader(isoFile, ignition, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		f, err := os.CreateTemp(filesDir, "streamed*.iso")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = io.Copy(f, streamReader)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Sync()).To(Succeed())
+		Expect(f.Close()).To(Succeed())
+
+		cpioReader := cpio.NewReader(bytes.NewReader(isoFileContent(f.Name(), ignitionImagePath)))
+		hdr, err := cpioReader.Next()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hdr.Name).To(Equal("config.ign"))
+		hdr, err = cpioReader.Next()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hdr.Name).To(Equal("etc/issue.d/branding.issue"))
+		Expect(io.ReadAll(cpioReader)).To(Equal([]byte("somebranding")))
+	})
+
+	It("fails when the extra ignition archive files don't fit the embed area", func() {
+		largeFile := make([]byte, ignitionPaddingLength)
+		_, err := rand.Read(largeFile)
+		Expect(err).NotTo(HaveOccurred())
+		ignition := &IgnitionContent{
+			Config:     ignitionContent,
+			ExtraFiles: map[string][]byte{"large": largeFile},
+		}
+
+		_, err = NewRHCOSStreamReader(isoFile, ignition, nil, nil)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("exceeds embed area size"))
+		Expect(errors.Is(err, ErrIgnitionTooLarge)).To(BeTrue())
+		var tooSmall *EmbedAreaTooSmallError
+		Expect(errors.As(err, &tooSmall)).To(BeTrue())
+		Expect(tooSmall.ContentLength).To(BeNumerically(">", ignitionPaddingLength))
+		Expect(tooSmall.AreaLength).To(Equal(int64(ignitionPaddingLength)))
+	})
+
 	It("Embeds the ignition in a ISO that uses the 'igninfo.json' file", func() {
 		// Create input ISO:
 		tmpDir, inputFile := createS390TestFiles("Assisted123", 0)
@@ -111,7 +274,7 @@ var _ = Describe("NewRHCOSStreamReader", func() {
 		}()
 
 		// Copy the output ISO to a file:
-		outputReader, err := NewRHCOSStreamReader(inputFile, &IgnitionContent{ignitionContent}, nil, nil)
+		outputReader, err := NewRHCOSStreamReader(inputFile, &IgnitionContent{Config: ignitionContent}, nil, nil)
 		Expect(err).ToNot(HaveOccurred())
 		defer func() {
 			Expect(outputReader.Close()).To(Succeed())
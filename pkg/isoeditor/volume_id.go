@@ -0,0 +1,60 @@
+package isoeditor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxVolumeIDLength is the size of the volume identifier field of the ISO9660 primary volume descriptor
+const maxVolumeIDLength = 32
+
+var (
+	volumeIDPlaceholderRegexp = regexp.MustCompile(`\{[^}]*\}`)
+	// ISO9660 restricts volume identifiers to upper case letters, digits and underscores,
+	// lower case letters, dots and dashes are accepted as RHCOS volume identifiers use them
+	invalidVolumeIDCharsRegexp = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+	volumeIDPlaceholders       = map[string]bool{"{volume_id}": true, "{version}": true, "{arch}": true}
+)
+
+// VolumeIDTemplate builds the volume identifier of minimal ISOs so they can be told apart
+// from the full ISOs. It supports the {volume_id} placeholder, the full ISO volume identifier,
+// and the {version} and {arch} placeholders.
+type VolumeIDTemplate struct {
+	template string
+}
+
+// NewVolumeIDTemplate validates that template only uses the supported placeholders and
+// characters that are valid in a volume identifier
+func NewVolumeIDTemplate(template string) (*VolumeIDTemplate, error) {
+	if template == "" {
+		return nil, fmt.Errorf("invalid volume ID template: must not be empty")
+	}
+	for _, placeholder := range volumeIDPlaceholderRegexp.FindAllString(template, -1) {
+		if !volumeIDPlaceholders[placeholder] {
+			return nil, fmt.Errorf("invalid volume ID template %q: unknown placeholder %s", template, placeholder)
+		}
+	}
+	if literal := volumeIDPlaceholderRegexp.ReplaceAllString(template, ""); invalidVolumeIDCharsRegexp.MatchString(literal) {
+		return nil, fmt.Errorf("invalid volume ID template %q: only letters, digits, '_', '.', and '-' are allowed", template)
+	}
+	return &VolumeIDTemplate{template: template}, nil
+}
+
+// Expand returns the volume identifier for the given full ISO volume identifier, version and
+// architecture. Invalid characters in the values are replaced with underscores and the result
+// is truncated to the 32 characters a volume identifier can hold.
+func (t *VolumeIDTemplate) Expand(volumeID, version, arch string) string {
+	sanitize := func(value string) string {
+		return invalidVolumeIDCharsRegexp.ReplaceAllString(value, "_")
+	}
+	expanded := strings.NewReplacer(
+		"{volume_id}", sanitize(volumeID),
+		"{version}", sanitize(version),
+		"{arch}", sanitize(arch),
+	).Replace(t.template)
+	if len(expanded) > maxVolumeIDLength {
+		expanded = expanded[:maxVolumeIDLength]
+	}
+	return expanded
+}
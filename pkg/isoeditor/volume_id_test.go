@@ -0,0 +1,47 @@
+package isoeditor
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VolumeIDTemplate", func() {
+	expand := func(template, volumeID, version, arch string) string {
+		t, err := NewVolumeIDTemplate(template)
+		Expect(err).NotTo(HaveOccurred())
+		return t.Expand(volumeID, version, arch)
+	}
+
+	It("substitutes the placeholders", func() {
+		Expect(expand("{volume_id}-min", "rhcos-411", "4.11", "x86_64")).To(Equal("rhcos-411-min"))
+		Expect(expand("MINIMAL_{version}_{arch}", "rhcos-411", "4.11", "x86_64")).To(Equal("MINIMAL_4.11_x86_64"))
+		Expect(expand("minimal", "rhcos-411", "4.11", "x86_64")).To(Equal("minimal"))
+	})
+
+	It("truncates the volume ID to 32 characters", func() {
+		volumeID := expand("{volume_id}-minimal", "rhcos-411.86.202210041459-0", "4.11", "x86_64")
+		Expect(volumeID).To(Equal("rhcos-411.86.202210041459-0-mini"))
+		Expect(volumeID).To(HaveLen(32))
+	})
+
+	It("replaces invalid characters in the substituted values", func() {
+		Expect(expand("min-{version}", "rhcos", "4.11+custom build", "x86_64")).To(Equal("min-4.11_custom_build"))
+	})
+
+	It("rejects unknown placeholders", func() {
+		_, err := NewVolumeIDTemplate("{volume_id}-{image_id}")
+		Expect(err).To(MatchError(ContainSubstring("unknown placeholder {image_id}")))
+	})
+
+	It("rejects invalid characters", func() {
+		_, err := NewVolumeIDTemplate("minimal {arch}")
+		Expect(err).To(MatchError(ContainSubstring("only letters, digits")))
+		_, err = NewVolumeIDTemplate("minimal/{arch}")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects an empty template", func() {
+		_, err := NewVolumeIDTemplate("")
+		Expect(err).To(HaveOccurred())
+	})
+})
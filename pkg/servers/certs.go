@@ -0,0 +1,62 @@
+package servers
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// certReloader serves the certificate currently on disk so certificates can be
+// rotated in place. The files are parsed again only when their mtime changes.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	lock        sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) *certReloader {
+	return &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+}
+
+// GetCertificate is used as the tls.Config callback of the HTTPS server
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	certInfo, certErr := os.Stat(c.certFile)
+	keyInfo, keyErr := os.Stat(c.keyFile)
+	if c.cert != nil && certErr == nil && keyErr == nil &&
+		certInfo.ModTime().Equal(c.certModTime) && keyInfo.ModTime().Equal(c.keyModTime) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			// the files may be in the middle of being replaced, keep serving the last good pair
+			log.WithError(err).Warnf("Failed to reload TLS certificate %s, using the previous one", c.certFile)
+			return c.cert, nil
+		}
+		return nil, err
+	}
+	if c.cert != nil {
+		log.Infof("Reloaded TLS certificate %s", c.certFile)
+	}
+
+	c.cert = &cert
+	if certErr == nil && keyErr == nil {
+		c.certModTime = certInfo.ModTime()
+		c.keyModTime = keyInfo.ModTime()
+	}
+	return c.cert, nil
+}
@@ -0,0 +1,138 @@
+package servers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// writeKeyPair writes a self-signed certificate for organization to certFile and keyFile
+func writeKeyPair(certFile, keyFile, organization string) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1234),
+		Subject:      pkix.Name{Organization: []string{organization}},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	privatekey, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &privatekey.PublicKey, privatekey)
+	Expect(err).NotTo(HaveOccurred())
+
+	keyData := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privatekey)})
+	Expect(os.WriteFile(keyFile, keyData, 0600)).To(Succeed())
+	certData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+	Expect(os.WriteFile(certFile, certData, 0600)).To(Succeed())
+}
+
+// touch moves the modification time of the files forward so the change is
+// noticed even on filesystems with a coarse mtime resolution
+func touch(offset time.Duration, files ...string) {
+	for _, file := range files {
+		t := time.Now().Add(offset)
+		Expect(os.Chtimes(file, t, t)).To(Succeed())
+	}
+}
+
+func organizationOf(cert *tls.Certificate) string {
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	Expect(err).NotTo(HaveOccurred())
+	return parsed.Subject.Organization[0]
+}
+
+var _ = Describe("certReloader", func() {
+	var (
+		dir      string
+		certFile string
+		keyFile  string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "certs")
+		Expect(err).NotTo(HaveOccurred())
+		certFile = filepath.Join(dir, "tls.crt")
+		keyFile = filepath.Join(dir, "tls.key")
+		writeKeyPair(certFile, keyFile, "first")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("loads the certificate again when the files change", func() {
+		reloader := newCertReloader(certFile, keyFile)
+		cert, err := reloader.GetCertificate(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(organizationOf(cert)).To(Equal("first"))
+
+		again, err := reloader.GetCertificate(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).To(BeIdenticalTo(cert))
+
+		writeKeyPair(certFile, keyFile, "second")
+		touch(time.Minute, certFile, keyFile)
+		cert, err = reloader.GetCertificate(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(organizationOf(cert)).To(Equal("second"))
+	})
+
+	It("keeps the previous certificate when the new files are invalid", func() {
+		reloader := newCertReloader(certFile, keyFile)
+		_, err := reloader.GetCertificate(nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		// only the certificate was replaced, so it doesn't match the key
+		otherKey := filepath.Join(dir, "other.key")
+		writeKeyPair(certFile, otherKey, "second")
+		touch(time.Minute, certFile)
+		cert, err := reloader.GetCertificate(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(organizationOf(cert)).To(Equal("first"))
+
+		Expect(os.WriteFile(certFile, []byte("garbage"), 0600)).To(Succeed())
+		touch(2*time.Minute, certFile)
+		cert, err = reloader.GetCertificate(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(organizationOf(cert)).To(Equal("first"))
+	})
+
+	It("fails when no certificate was ever loaded", func() {
+		reloader := newCertReloader(filepath.Join(dir, "missing.crt"), keyFile)
+		_, err := reloader.GetCertificate(nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("serves a replaced certificate without restarting the server", func() {
+		listeners := NewServer("", "8450", keyFile, certFile)
+		listeners.HTTPS.Handler = mux
+		listeners.ListenAndServe()
+		Expect(awaitConnection(8450)).To(BeTrue())
+
+		servedOrganization := func() string {
+			//nolint:gosec
+			conn, err := tls.Dial("tcp", "localhost:8450", &tls.Config{InsecureSkipVerify: true})
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+			return conn.ConnectionState().PeerCertificates[0].Subject.Organization[0]
+		}
+		Expect(servedOrganization()).To(Equal("first"))
+
+		writeKeyPair(certFile, keyFile, "second")
+		touch(time.Minute, certFile, keyFile)
+		Expect(servedOrganization()).To(Equal("second"))
+
+		Expect(listeners.Shutdown()).To(BeTrue())
+	})
+})
@@ -0,0 +1,28 @@
+package servers
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pprofHandler returns a mux serving the net/http/pprof handlers under /debug/pprof/.
+// Importing net/http/pprof also registers them on http.DefaultServeMux, so the service
+// must be served from its own mux (see WithHandler) to keep them off the public listeners.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+func (s *ServerInfo) pprofListen() {
+	log.Infof("Starting pprof handler on %s...", s.Pprof.Addr)
+	if err := s.Pprof.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatalf("pprof listener closed: %v", err)
+	}
+}
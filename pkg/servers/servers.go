@@ -2,52 +2,179 @@ package servers
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	DefaultReadHeaderTimeout = 3 * time.Second
+	DefaultWriteTimeout      = 60 * time.Second
+	DefaultIdleTimeout       = 120 * time.Second
+)
+
 type ServerInfo struct {
 	HTTP            *http.Server
 	HTTPS           *http.Server
+	Unix            *http.Server
+	UnixSocketPath  string
+	Pprof           *http.Server
 	HTTPSKeyFile    string
 	HTTPSCertFile   string
 	HasBothHandlers bool
 	FastShutdown    bool
+
+	certs *certReloader
+}
+
+type options struct {
+	readHeaderTimeout time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	disableHTTP2      bool
+	unixSocket        string
+	handler           http.Handler
+	pprofAddress      string
+}
+
+// Option configures the http.Server instances created by New
+type Option func(*options)
+
+// WithReadHeaderTimeout sets the time allowed to read request headers
+func WithReadHeaderTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.readHeaderTimeout = timeout
+	}
+}
+
+// WithWriteTimeout sets the time allowed to write a response. Long running
+// responses such as image downloads must be wrapped with WithoutWriteTimeout
+// as this applies to the whole response, not to individual writes.
+// A zero timeout disables the limit.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.writeTimeout = timeout
+	}
+}
+
+// WithIdleTimeout sets the time a keep-alive connection may remain idle
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.idleTimeout = timeout
+	}
+}
+
+// WithHTTP2 enables or disables HTTP/2 on the HTTPS listener
+func WithHTTP2(enabled bool) Option {
+	return func(o *options) {
+		o.disableHTTP2 = !enabled
+	}
+}
+
+// WithUnixSocket also serves plain HTTP on a Unix domain socket at path. When neither
+// port is set, the socket is the only listener.
+func WithUnixSocket(path string) Option {
+	return func(o *options) {
+		o.unixSocket = path
+	}
+}
+
+// WithHandler sets the handler served by the HTTP, HTTPS and Unix socket listeners instead
+// of http.DefaultServeMux
+func WithHandler(handler http.Handler) Option {
+	return func(o *options) {
+		o.handler = handler
+	}
+}
+
+// WithPprof serves the net/http/pprof handlers on a separate plain HTTP listener at address
+// so they aren't exposed with the service. An empty address disables it.
+func WithPprof(address string) Option {
+	return func(o *options) {
+		o.pprofAddress = address
+	}
+}
+
+func newServer(port string, o *options) *http.Server {
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%s", port),
+		Handler:           o.handler,
+		ReadHeaderTimeout: o.readHeaderTimeout,
+		WriteTimeout:      o.writeTimeout,
+		IdleTimeout:       o.idleTimeout,
+	}
+	if o.disableHTTP2 {
+		// a non-nil empty map prevents the server from negotiating h2
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	return server
 }
 
-func New(httpPort, httpsPort, HTTPSKeyFile, HTTPSCertFile string) *ServerInfo {
+func New(httpPort, httpsPort, HTTPSKeyFile, HTTPSCertFile string, opts ...Option) *ServerInfo {
+	o := &options{
+		readHeaderTimeout: DefaultReadHeaderTimeout,
+		writeTimeout:      DefaultWriteTimeout,
+		idleTimeout:       DefaultIdleTimeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
 	servers := ServerInfo{}
 	if httpsPort != "" && HTTPSKeyFile != "" && HTTPSCertFile != "" {
 		// Run HTTPS listener when port, key and cert are specified
 		// This is default in operator deployments
-		servers.HTTPS = &http.Server{
-			Addr:              fmt.Sprintf(":%s", httpsPort),
-			ReadHeaderTimeout: 3 * time.Second,
-		}
+		servers.HTTPS = newServer(httpsPort, o)
 		servers.HTTPSCertFile = HTTPSCertFile
 		servers.HTTPSKeyFile = HTTPSKeyFile
-	} else if httpPort == "" {
+		// the certificate is read from disk on handshakes so it can be rotated without a restart
+		servers.certs = newCertReloader(HTTPSCertFile, HTTPSKeyFile)
+		servers.HTTPS.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: servers.certs.GetCertificate,
+		}
+	} else if httpPort == "" && (httpsPort != "" || o.unixSocket == "") {
 		// Run HTTP listener on HTTPS port if httpPort is not set
 		// This is default in podman deployment
-		servers.HTTP = &http.Server{
-			Addr:              fmt.Sprintf(":%s", httpsPort),
-			ReadHeaderTimeout: 3 * time.Second,
-		}
+		servers.HTTP = newServer(httpsPort, o)
 	}
 	if httpPort != "" {
 		// Run HTTP listener if httpPort is set
-		servers.HTTP = &http.Server{
-			Addr:              fmt.Sprintf(":%s", httpPort),
-			ReadHeaderTimeout: 3 * time.Second,
+		servers.HTTP = newServer(httpPort, o)
+	}
+	if o.unixSocket != "" {
+		servers.Unix = newServer("", o)
+		servers.Unix.Addr = ""
+		servers.UnixSocketPath = o.unixSocket
+	}
+	if o.pprofAddress != "" {
+		servers.Pprof = &http.Server{
+			Addr:              o.pprofAddress,
+			Handler:           pprofHandler(),
+			ReadHeaderTimeout: o.readHeaderTimeout,
 		}
 	}
 	servers.HasBothHandlers = servers.HTTP != nil && servers.HTTPS != nil
 	return &servers
 }
 
+// WithoutWriteTimeout exempts the wrapped handler from the server write
+// timeout. It must be applied directly to the handler registered with the
+// server so the deadline can be cleared on the underlying connection.
+func WithoutWriteTimeout(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			log.WithError(err).Warn("Failed to clear write deadline")
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
 func shutdown(name string, server *http.Server) {
 	if err := server.Shutdown(context.TODO()); err != nil {
 		log.Infof("%s shutdown failed: %v", name, err)
@@ -67,6 +194,14 @@ func (s *ServerInfo) ListenAndServe() {
 	if s.HTTPS != nil {
 		go s.httpsListen()
 	}
+
+	if s.Unix != nil {
+		go s.unixListen()
+	}
+
+	if s.Pprof != nil {
+		go s.pprofListen()
+	}
 }
 
 func (s *ServerInfo) Shutdown() bool {
@@ -84,6 +219,21 @@ func (s *ServerInfo) Shutdown() bool {
 			shutdown("HTTP", s.HTTP)
 		}
 	}
+	if s.Unix != nil {
+		if s.FastShutdown {
+			s.Unix.Close()
+		} else {
+			shutdown("Unix socket", s.Unix)
+		}
+		// closing the listener normally removes the socket, make sure it isn't left behind
+		if err := os.Remove(s.UnixSocketPath); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).Warnf("Failed to remove unix socket %s", s.UnixSocketPath)
+		}
+	}
+	if s.Pprof != nil {
+		// profiles may take a while to collect, there is no point waiting for them
+		s.Pprof.Close()
+	}
 	return true
 }
 
@@ -96,7 +246,36 @@ func (s *ServerInfo) httpListen() {
 
 func (s *ServerInfo) httpsListen() {
 	log.Infof("Starting https handler on %s...", s.HTTPS.Addr)
-	if err := s.HTTPS.ListenAndServeTLS(s.HTTPSCertFile, s.HTTPSKeyFile); err != http.ErrServerClosed {
+	if _, err := s.certs.GetCertificate(nil); err != nil {
+		log.Fatalf("Failed to load TLS certificate: %v", err)
+	}
+	// the certificate is provided by the TLS config
+	if err := s.HTTPS.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
 		log.Fatalf("HTTPS listener closed: %v", err)
 	}
 }
+
+func (s *ServerInfo) unixListen() {
+	log.Infof("Starting http handler on unix socket %s...", s.UnixSocketPath)
+	listener, err := listenUnix(s.UnixSocketPath)
+	if err != nil {
+		log.Fatalf("Failed to listen on unix socket %s: %v", s.UnixSocketPath, err)
+	}
+	if err := s.Unix.Serve(listener); err != http.ErrServerClosed {
+		log.Fatalf("Unix socket listener closed: %v", err)
+	}
+}
+
+// listenUnix listens on a Unix domain socket at path, replacing a socket left behind by
+// a previous process that didn't shut down cleanly
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
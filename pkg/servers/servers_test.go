@@ -1,6 +1,7 @@
 package servers
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -241,6 +242,182 @@ var _ = Describe("HTTPListeners", func() {
 
 		Expect(listeners.Shutdown()).To(BeTrue())
 	})
+
+	It("applies timeout and HTTP/2 options", func() {
+		listeners := New("8089", "8449", httpsKeyFile.Name(), httpsCertFile.Name(),
+			WithReadHeaderTimeout(time.Second),
+			WithWriteTimeout(2*time.Second),
+			WithIdleTimeout(3*time.Second),
+			WithHTTP2(false),
+		)
+
+		for _, server := range []*http.Server{listeners.HTTP, listeners.HTTPS} {
+			Expect(server.ReadHeaderTimeout).To(Equal(time.Second))
+			Expect(server.WriteTimeout).To(Equal(2 * time.Second))
+			Expect(server.IdleTimeout).To(Equal(3 * time.Second))
+			Expect(server.TLSNextProto).NotTo(BeNil())
+			Expect(server.TLSNextProto).To(BeEmpty())
+		}
+	})
+
+	It("uses default timeouts and leaves HTTP/2 enabled", func() {
+		listeners := NewServer("8089", "", "", "")
+
+		Expect(listeners.HTTP.ReadHeaderTimeout).To(Equal(DefaultReadHeaderTimeout))
+		Expect(listeners.HTTP.WriteTimeout).To(Equal(DefaultWriteTimeout))
+		Expect(listeners.HTTP.IdleTimeout).To(Equal(DefaultIdleTimeout))
+		Expect(listeners.HTTP.TLSNextProto).To(BeNil())
+	})
+
+	It("exempts wrapped handlers from the write timeout", func() {
+		listeners := New("8090", "", "", "", WithWriteTimeout(100*time.Millisecond))
+		listeners.FastShutdown = true
+
+		slow := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			time.Sleep(300 * time.Millisecond)
+			_, _ = rw.Write([]byte("done"))
+		})
+		slowMux := http.NewServeMux()
+		slowMux.Handle("/slow", slow)
+		slowMux.Handle("/exempt", WithoutWriteTimeout(slow))
+		listeners.HTTP.Handler = slowMux
+		listeners.ListenAndServe()
+		Expect(awaitConnection(8090)).To(BeTrue())
+
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		_, err := client.Get("http://localhost:8090/slow")
+		Expect(err).To(HaveOccurred())
+
+		resp, err := client.Get("http://localhost:8090/exempt")
+		Expect(err).NotTo(HaveOccurred())
+		body, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(string(body)).To(Equal("done"))
+
+		Expect(listeners.Shutdown()).To(BeTrue())
+	})
+
+	Context("with a unix socket", func() {
+		var socketPath string
+
+		BeforeEach(func() {
+			socketPath = fmt.Sprintf("%s/image-service-%d.sock", tmpDir, GinkgoParallelNode())
+		})
+
+		unixClient := func() *http.Client {
+			return &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+				},
+			}}
+		}
+
+		getReady := func() {
+			Eventually(func() error {
+				conn, err := net.Dial("unix", socketPath)
+				if err == nil {
+					conn.Close()
+				}
+				return err
+			}, portConnectionRetrySeconds, portConnectionRetryInterval).Should(Succeed())
+
+			resp, err := unixClient().Get("http://unix/ready")
+			Expect(err).NotTo(HaveOccurred())
+			body, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(string(body)).To(Equal("hello"))
+		}
+
+		It("serves on the socket in addition to the ports", func() {
+			listeners := New("8091", "", "", "", WithUnixSocket(socketPath))
+			listeners.FastShutdown = true
+
+			Expect(listeners.HTTP).NotTo(BeNil())
+			Expect(listeners.Unix).NotTo(BeNil())
+			Expect(listeners.UnixSocketPath).To(Equal(socketPath))
+			Expect(listeners.HasBothHandlers).To(BeFalse())
+
+			listeners.HTTP.Handler = mux
+			listeners.Unix.Handler = mux
+			listeners.ListenAndServe()
+			Expect(awaitConnection(8091)).To(BeTrue())
+			getReady()
+
+			Expect(listeners.Shutdown()).To(BeTrue())
+			_, err := os.Stat(socketPath)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("only serves on the socket when no port is set", func() {
+			listeners := New("", "", "", "", WithUnixSocket(socketPath))
+			Expect(listeners.HTTP).To(BeNil())
+			Expect(listeners.HTTPS).To(BeNil())
+			Expect(listeners.Unix).NotTo(BeNil())
+		})
+
+		It("replaces a stale socket", func() {
+			stale, err := net.Listen("unix", socketPath)
+			Expect(err).NotTo(HaveOccurred())
+			// leave the socket file behind as a crashed process would
+			stale.(*net.UnixListener).SetUnlinkOnClose(false)
+			Expect(stale.Close()).To(Succeed())
+
+			listeners := New("", "", "", "", WithUnixSocket(socketPath))
+			listeners.FastShutdown = true
+			listeners.Unix.Handler = mux
+			listeners.ListenAndServe()
+			getReady()
+			Expect(listeners.Shutdown()).To(BeTrue())
+		})
+
+		It("doesn't replace a file that isn't a socket", func() {
+			Expect(os.WriteFile(socketPath, []byte("data"), 0600)).To(Succeed())
+			defer os.Remove(socketPath)
+
+			_, err := listenUnix(socketPath)
+			Expect(err).To(MatchError(ContainSubstring("is not a socket")))
+			Expect(os.ReadFile(socketPath)).To(Equal([]byte("data")))
+		})
+	})
+
+	Context("with pprof", func() {
+		get := func(url string) int {
+			resp, err := httpClient.Get(url)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			return resp.StatusCode
+		}
+
+		It("serves the pprof index on the separate listener only", func() {
+			listeners := New("8092", "", "", "", WithHandler(mux), WithPprof("localhost:8093"))
+			listeners.FastShutdown = true
+			Expect(listeners.Pprof).NotTo(BeNil())
+			Expect(listeners.HTTP.Handler).To(Equal(mux))
+
+			listeners.ListenAndServe()
+			Expect(awaitConnection(8092)).To(BeTrue())
+			Expect(awaitConnection(8093)).To(BeTrue())
+			Expect(get("http://localhost:8093/debug/pprof/")).To(Equal(http.StatusOK))
+			Expect(get("http://localhost:8092/debug/pprof/")).To(Equal(http.StatusNotFound))
+			Expect(listeners.Shutdown()).To(BeTrue())
+		})
+
+		It("doesn't serve pprof when disabled", func() {
+			listeners := New("8092", "", "", "", WithHandler(mux), WithPprof(""))
+			listeners.FastShutdown = true
+			Expect(listeners.Pprof).To(BeNil())
+
+			listeners.ListenAndServe()
+			Expect(awaitConnection(8092)).To(BeTrue())
+			Expect(get("http://localhost:8092/debug/pprof/")).To(Equal(http.StatusNotFound))
+			Expect(get("http://localhost:8092/ready")).To(Equal(http.StatusOK))
+			Expect(listeners.Shutdown()).To(BeTrue())
+		})
+	})
 })
 
 func TestServers(t *testing.T) {